@@ -0,0 +1,84 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// abuseDetection tracks clients reusing keys for different requests.
+type abuseDetection struct {
+	threshold int64
+	window    time.Duration
+	client    func(r *http.Request) string
+	block     bool
+}
+
+// WithMismatchDetection counts the requests of a client, as identified by the
+// client function, that reuse a key for a different request. When a client
+// reaches threshold mismatches within window, an Event with OutcomeAbuse is
+// reported to the hooks, as this usually indicates a buggy or malicious
+// client. If block is true, all further requests of the client are rejected
+// with a 403 Forbidden for window. It requires a storage implementing Counter,
+// and blocking also requires StatusStorage.
+func WithMismatchDetection(threshold int, window time.Duration, client func(r *http.Request) string, block bool) Option {
+	return func(s *State) {
+		s.abuse = &abuseDetection{
+			threshold: int64(threshold),
+			window:    window,
+			client:    client,
+			block:     block,
+		}
+	}
+}
+
+// countMismatch counts a mismatch for the client of r, reporting the client
+// and blocking it if it reached the threshold.
+func (s *State) countMismatch(ctx context.Context, r *http.Request, idempotencyKey string) {
+	counter, ok := s.storage.(Counter)
+	if s.abuse == nil || !ok || !s.caps.Counter {
+		return
+	}
+
+	client := s.abuse.client(r)
+	if client == "" {
+		return
+	}
+
+	count, err := counter.Increment(ctx, internalKey("mismatch", client), s.abuse.window)
+	if err != nil {
+		s.emit(r, Event{Key: idempotencyKey, Outcome: OutcomeError, Err: fmt.Errorf("could not count mismatches: %w", err)})
+		return
+	}
+	if count != s.abuse.threshold {
+		return
+	}
+
+	err = fmt.Errorf("client %q reused Idempotency-Keys for different requests %d times", client, count)
+	s.emit(r, Event{Key: idempotencyKey, Outcome: OutcomeAbuse, Err: err})
+
+	if ss, ok := s.storage.(StatusStorage); ok && s.abuse.block {
+		if _, err := ss.AddStatus(ctx, internalKey("abuse", client), &RequestStatus{}, s.abuse.window); err != nil {
+			s.emit(r, Event{Key: idempotencyKey, Outcome: OutcomeError, Err: fmt.Errorf("could not block client: %w", err)})
+		}
+	}
+}
+
+// blocked reports whether the client of r is blocked for abuse.
+func (s *State) blocked(ctx context.Context, r *http.Request) (bool, error) {
+	if s.abuse == nil || !s.abuse.block {
+		return false, nil
+	}
+
+	client := s.abuse.client(r)
+	if client == "" {
+		return false, nil
+	}
+
+	status, err := s.storage.Get(ctx, internalKey("abuse", client))
+	if err != nil {
+		return false, fmt.Errorf("could not check for blocked client: %w", err)
+	}
+	return status != nil, nil
+}
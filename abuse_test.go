@@ -0,0 +1,91 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMismatchDetection(t *testing.T) {
+	var reported []string
+	s := New(NewMemoryStorage(),
+		WithFingerprint(BodyFingerprint),
+		WithMismatchDetection(2, time.Minute, func(r *http.Request) string {
+			return r.Header.Get("X-Client")
+		}, true),
+		WithHook(func(r *http.Request, e Event) {
+			if e.Outcome == OutcomeAbuse && e.Key == "1" {
+				reported = append(reported, r.Header.Get("X-Client"))
+			}
+		}),
+	)
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name           string
+		client         string
+		key            string
+		body           string
+		wantHTTPStatus int
+	}{
+		{name: "First request", client: "a", key: "1", body: "a", wantHTTPStatus: http.StatusOK},
+		{name: "First mismatch", client: "a", key: "1", body: "b", wantHTTPStatus: http.StatusUnprocessableEntity},
+		{name: "Second mismatch reaches threshold", client: "a", key: "1", body: "c", wantHTTPStatus: http.StatusUnprocessableEntity},
+		{name: "Client is blocked", client: "a", key: "2", body: "a", wantHTTPStatus: http.StatusForbidden},
+		{name: "Other client is not blocked", client: "b", key: "2", body: "a", wantHTTPStatus: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://example.com/foo", strings.NewReader(test.body))
+			req.Header.Set(HeaderName, test.key)
+			req.Header.Set("X-Client", test.client)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != test.wantHTTPStatus {
+				t.Errorf("want status code %v, got %v", test.wantHTTPStatus, w.Code)
+			}
+		})
+	}
+
+	if len(reported) != 1 || reported[0] != "a" {
+		t.Errorf("want client a reported once, got %v", reported)
+	}
+}
+
+func TestMismatchDetectionReservedKeys(t *testing.T) {
+	s := New(NewMemoryStorage(),
+		WithMismatchDetection(1, time.Minute, func(r *http.Request) string {
+			return r.Header.Get("X-Client")
+		}, true),
+	)
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name           string
+		client         string
+		key            string
+		wantHTTPStatus int
+	}{
+		{name: "Key of a block record", client: "mallory", key: internalKey("abuse", "victim"), wantHTTPStatus: http.StatusBadRequest},
+		{name: "Key like a block record", client: "mallory", key: "abuse:victim", wantHTTPStatus: http.StatusOK},
+		{name: "Victim is not blocked", client: "victim", key: "1", wantHTTPStatus: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+		req.Header.Set(HeaderName, test.key)
+		req.Header.Set("X-Client", test.client)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != test.wantHTTPStatus {
+			t.Errorf("%s: want status code %v, got %v", test.name, test.wantHTTPStatus, w.Code)
+		}
+	}
+}
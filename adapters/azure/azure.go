@@ -0,0 +1,148 @@
+// Package azure adapts the idempotency middleware to Azure Functions custom
+// handlers, where HTTP triggers are delivered to the handler as invocation
+// payloads instead of plain HTTP requests.
+//
+// Functions using enableForwardingHttpRequest receive the original request
+// and can use State.Verify directly.
+package azure
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// HTTPRequest is the HTTP trigger binding of an invocation.
+type HTTPRequest struct {
+	URL     string              `json:"Url"`
+	Method  string              `json:"Method"`
+	Query   map[string]string   `json:"Query,omitempty"`
+	Headers map[string][]string `json:"Headers,omitempty"`
+	Params  map[string]string   `json:"Params,omitempty"`
+	Body    string              `json:"Body,omitempty"`
+}
+
+// HTTPResponse is the HTTP output binding of an invocation.
+type HTTPResponse struct {
+	StatusCode int               `json:"statusCode"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       string            `json:"body,omitempty"`
+}
+
+// InvokeRequest is the payload sent by the Functions host to the custom
+// handler.
+type InvokeRequest struct {
+	Data     map[string]json.RawMessage `json:"Data"`
+	Metadata map[string]json.RawMessage `json:"Metadata,omitempty"`
+}
+
+// InvokeResponse is the payload returned by the custom handler to the
+// Functions host.
+type InvokeResponse struct {
+	Outputs     map[string]any `json:"Outputs"`
+	Logs        []string       `json:"Logs,omitempty"`
+	ReturnValue any            `json:"ReturnValue,omitempty"`
+}
+
+// Handler returns an http.Handler serving invocations of a function with an
+// HTTP trigger binding named trigger and an HTTP output binding named output.
+// The trigger is converted to an *http.Request that is verified by s before
+// reaching next, and the response written by either of them is returned as
+// the output binding.
+func Handler(s *idempotency.State, trigger, output string, next http.Handler) http.Handler {
+	verify := s.Verify(next)
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		var invoke InvokeRequest
+		if err := json.NewDecoder(r.Body).Decode(&invoke); err != nil {
+			http.Error(w, fmt.Sprintf("could not decode invocation: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		req, err := newRequest(r, invoke.Data[trigger])
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not decode trigger %q: %v", trigger, err), http.StatusBadRequest)
+			return
+		}
+
+		rec := newRecorder()
+		verify.ServeHTTP(rec, req)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(InvokeResponse{
+			Outputs: map[string]any{output: rec.response()},
+		})
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// newRequest creates the request described by the trigger binding data.
+func newRequest(r *http.Request, data json.RawMessage) (*http.Request, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("binding is missing")
+	}
+
+	var trigger HTTPRequest
+	if err := json.Unmarshal(data, &trigger); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), trigger.Method, trigger.URL, strings.NewReader(trigger.Body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range trigger.Headers {
+		for _, vv := range v {
+			req.Header.Add(k, vv)
+		}
+	}
+	return req, nil
+}
+
+// recorder is an http.ResponseWriter recording the response of the function.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: make(http.Header)}
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(b)
+}
+
+func (r *recorder) response() HTTPResponse {
+	res := HTTPResponse{
+		StatusCode: r.status,
+		Body:       r.body.String(),
+	}
+	if res.StatusCode == 0 {
+		res.StatusCode = http.StatusOK
+	}
+	if len(r.header) > 0 {
+		res.Headers = make(map[string]string, len(r.header))
+		for k := range r.header {
+			res.Headers[k] = r.header.Get(k)
+		}
+	}
+	return res
+}
@@ -0,0 +1,56 @@
+package azure
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+func TestHandler(t *testing.T) {
+	s := idempotency.New(idempotency.NewMemoryStorage(), idempotency.WithRestorer(func(idempotencyKey string, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	})
+	h := Handler(s, "req", "res", next)
+
+	tests := []struct {
+		name           string
+		headers        string
+		wantHTTPStatus int
+	}{
+		{name: "First request, pass through to handler", headers: `{"Idempotency-Key":["deadbeef"]}`, wantHTTPStatus: http.StatusCreated},
+		{name: "Repeated request ends up in restorer", headers: `{"Idempotency-Key":["deadbeef"]}`, wantHTTPStatus: http.StatusNoContent},
+		{name: "No Idempotency-Key header, bad request error", headers: `{}`, wantHTTPStatus: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := `{"Data":{"req":{"Url":"http://localhost/api/orders","Method":"POST","Headers":` + test.headers + `}}}`
+			req := httptest.NewRequest("POST", "http://localhost/orders", strings.NewReader(body))
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			var res struct {
+				Outputs struct {
+					Res HTTPResponse `json:"res"`
+				}
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatalf("could not decode response %q: %v", w.Body.String(), err)
+			}
+
+			if res.Outputs.Res.StatusCode != test.wantHTTPStatus {
+				t.Errorf("want status code %v, got %v", test.wantHTTPStatus, res.Outputs.Res.StatusCode)
+			}
+		})
+	}
+}
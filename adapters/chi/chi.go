@@ -0,0 +1,51 @@
+// Package chi provides helpers for using the idempotency middleware with the
+// chi router, scoping keys by route pattern and allowing options per route.
+package chi
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// RoutePattern returns the chi route pattern matched by r, it is used as the
+// scope of the idempotency keys.
+func RoutePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return ""
+	}
+	return r.Method + " " + rctx.RoutePattern()
+}
+
+// Route returns a middleware verifying the Idempotency-Key scoped by the chi
+// route pattern, with opts applied on top of the configuration of s. It is
+// meant to be mounted on specific routes, e.g.:
+//
+//	r.With(chi.Route(s, idempotency.WithTTL(time.Hour))).Post("/orders", createOrder)
+func Route(s *idempotency.State, opts ...idempotency.Option) func(http.Handler) http.Handler {
+	opts = append([]idempotency.Option{idempotency.WithScope(RoutePattern)}, opts...)
+	return s.With(opts...).Verify
+}
+
+// Required returns a middleware like Route that requires the Idempotency-Key.
+func Required(s *idempotency.State, opts ...idempotency.Option) func(http.Handler) http.Handler {
+	return Route(s, append(opts, idempotency.WithRequirement(idempotency.Required))...)
+}
+
+// Optional returns a middleware like Route that only applies idempotency
+// when the Idempotency-Key is set.
+func Optional(s *idempotency.State, opts ...idempotency.Option) func(http.Handler) http.Handler {
+	return Route(s, append(opts, idempotency.WithRequirement(idempotency.Optional))...)
+}
+
+// Fingerprint returns an option enabling or disabling fingerprinting of the
+// request body for a route.
+func Fingerprint(enabled bool) idempotency.Option {
+	if enabled {
+		return idempotency.WithFingerprint(idempotency.BodyFingerprint)
+	}
+	return idempotency.WithFingerprint(nil)
+}
@@ -0,0 +1,58 @@
+package chi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+func TestRoute(t *testing.T) {
+	s := idempotency.New(idempotency.NewMemoryStorage(), idempotency.WithRestorer(func(idempotencyKey string, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}
+
+	router := chi.NewRouter()
+	router.With(Required(s, Fingerprint(true))).Post("/orders", handler)
+	router.With(Required(s)).Post("/payments", handler)
+	router.With(Optional(s)).Post("/comments", handler)
+
+	tests := []struct {
+		name           string
+		path           string
+		key            string
+		body           string
+		wantHTTPStatus int
+	}{
+		{name: "First request, pass through to handler", path: "/orders", key: "deadbeef", body: "a", wantHTTPStatus: http.StatusCreated},
+		{name: "Repeated request ends up in restorer", path: "/orders", key: "deadbeef", body: "a", wantHTTPStatus: http.StatusNoContent},
+		{name: "Different payload on fingerprinted route", path: "/orders", key: "deadbeef", body: "b", wantHTTPStatus: http.StatusUnprocessableEntity},
+		{name: "Same key on another route is scoped separately", path: "/payments", key: "deadbeef", wantHTTPStatus: http.StatusCreated},
+		{name: "Missing key on required route", path: "/payments", wantHTTPStatus: http.StatusBadRequest},
+		{name: "Missing key on optional route", path: "/comments", wantHTTPStatus: http.StatusCreated},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://example.com"+test.path, strings.NewReader(test.body))
+			if test.key != "" {
+				req.Header.Set(idempotency.HeaderName, test.key)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != test.wantHTTPStatus {
+				t.Errorf("want status code %v, got %v", test.wantHTTPStatus, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+// Package connect provides a connect.Interceptor applying idempotency to
+// unary procedures served with the Connect, gRPC and gRPC-web protocols.
+package connect
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// Option is the functional option signature for configuring the interceptor.
+type Option func(*Interceptor)
+
+// replayer decodes a stored message into a response of the procedure.
+type replayer func(message []byte) (connect.AnyResponse, error)
+
+// Interceptor verifies the Idempotency-Key of unary requests on handlers and
+// propagates the key from the context to the headers of requests on clients.
+type Interceptor struct {
+	state       *idempotency.State
+	requirement idempotency.Requirement
+	replayers   map[string]replayer
+}
+
+// WithRequirement configures whether the Idempotency-Key header is required,
+// it defaults to idempotency.Required.
+func WithRequirement(requirement idempotency.Requirement) Option {
+	return func(i *Interceptor) {
+		i.requirement = requirement
+	}
+}
+
+// WithReplay registers the response type of procedure so that the stored
+// response of a completed request can be replayed. Completed requests to
+// procedures without a registered response type are rejected with
+// connect.CodeAlreadyExists.
+func WithReplay[Res any, PRes interface {
+	*Res
+	proto.Message
+}](procedure string) Option {
+	return func(i *Interceptor) {
+		i.replayers[procedure] = func(message []byte) (connect.AnyResponse, error) {
+			res := new(Res)
+			if err := proto.Unmarshal(message, PRes(res)); err != nil {
+				return nil, err
+			}
+			return connect.NewResponse(res), nil
+		}
+	}
+}
+
+// NewInterceptor creates an interceptor storing the state of the keys with
+// s, which configures e.g. how long keys are retained. Storing and replaying
+// responses requires a storage implementing idempotency.StatusStorage.
+func NewInterceptor(s *idempotency.State, opts ...Option) *Interceptor {
+	i := &Interceptor{
+		state:     s,
+		replayers: make(map[string]replayer),
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(i)
+		}
+	}
+
+	return i
+}
+
+// record is the stored outcome of a procedure call.
+type record struct {
+	Message []byte       `json:"message,omitempty"`
+	Code    connect.Code `json:"code,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// WrapUnary implements connect.Interceptor.
+func (i *Interceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Spec().IsClient {
+			if key, ok := idempotency.FromContext(ctx); ok && req.Header().Get(idempotency.HeaderName) == "" {
+				req.Header().Set(idempotency.HeaderName, key)
+			}
+			return next(ctx, req)
+		}
+
+		idempotencyKey := req.Header().Get(idempotency.HeaderName)
+		if idempotencyKey == "" {
+			if i.requirement == idempotency.Optional {
+				return next(ctx, req)
+			}
+			return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("no Idempotency-Key set"))
+		}
+		ctx = idempotency.NewContext(ctx, idempotencyKey)
+
+		fingerprint, err := fingerprintMessage(req.Any())
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("could not fingerprint request: %w", err))
+		}
+
+		procedure := req.Spec().Procedure
+		key := i.state.HashKey(procedure + ":" + idempotencyKey)
+
+		d, err := i.state.Reserve(ctx, key, fingerprint)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, err)
+		}
+
+		switch d.Outcome {
+		case idempotency.OutcomeNew:
+			res, err := next(ctx, req)
+
+			if err := i.complete(ctx, key, res, err); err != nil {
+				// Release the key, so that the request can be retried.
+				if ferr := i.state.Fail(ctx, key); ferr != nil {
+					err = errors.Join(err, ferr)
+				}
+				return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("could not complete request: %w", err))
+			}
+			return res, err
+		case idempotency.OutcomeMismatch:
+			return nil, connect.NewError(connect.CodeFailedPrecondition, errors.New("Idempotency-Key is already used for a different request"))
+		case idempotency.OutcomeInProcess:
+			return nil, connect.NewError(connect.CodeAborted, errors.New("request already in progress"))
+		}
+
+		return i.replay(procedure, d.Status.Response)
+	}
+}
+
+// WrapStreamingClient implements connect.Interceptor, streams are passed
+// through without idempotency handling.
+func (i *Interceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+// WrapStreamingHandler implements connect.Interceptor, streams are passed
+// through without idempotency handling.
+func (i *Interceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return next
+}
+
+// complete marks key as completed, storing the outcome of the call when the
+// storage supports it.
+func (i *Interceptor) complete(ctx context.Context, key string, res connect.AnyResponse, callErr error) error {
+	if !i.state.Capabilities().StatusStorage {
+		return i.state.Finish(ctx, key, nil)
+	}
+
+	var (
+		rec    record
+		header http.Header
+	)
+	if callErr != nil {
+		rec.Code = connect.CodeOf(callErr)
+		rec.Error = callErr.Error()
+		var connectErr *connect.Error
+		if errors.As(callErr, &connectErr) {
+			rec.Error = connectErr.Message()
+		}
+	} else {
+		msg, ok := res.Any().(proto.Message)
+		if !ok {
+			return fmt.Errorf("response %T is not a proto.Message", res.Any())
+		}
+
+		b, err := proto.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("failed to encode response: %w", err)
+		}
+		rec.Message = b
+		header = res.Header()
+	}
+
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode response: %w", err)
+	}
+
+	return i.state.Finish(ctx, key, &idempotency.Response{Header: header, Body: body})
+}
+
+// replay returns the stored outcome of a completed call.
+func (i *Interceptor) replay(procedure string, stored *idempotency.Response) (connect.AnyResponse, error) {
+	if stored == nil {
+		return nil, connect.NewError(connect.CodeAlreadyExists, errors.New("request already completed"))
+	}
+
+	var rec record
+	if err := json.Unmarshal(stored.Body, &rec); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("could not decode stored response: %w", err))
+	}
+
+	if rec.Code != 0 {
+		return nil, connect.NewError(rec.Code, errors.New(rec.Error))
+	}
+
+	replay, ok := i.replayers[procedure]
+	if !ok {
+		return nil, connect.NewError(connect.CodeAlreadyExists, errors.New("request already completed"))
+	}
+
+	res, err := replay(rec.Message)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("could not decode stored response: %w", err))
+	}
+	for k, v := range stored.Header {
+		res.Header()[k] = v
+	}
+	return res, nil
+}
+
+// fingerprintMessage fingerprints a request message by the SHA-256 sum of its
+// deterministic wire encoding.
+func fingerprintMessage(msg any) (string, error) {
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return "", fmt.Errorf("request %T is not a proto.Message", msg)
+	}
+
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,117 @@
+package connect
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+const procedure = "/test.v1.TestService/Echo"
+
+func TestInterceptor(t *testing.T) {
+	calls := 0
+	interceptor := NewInterceptor(idempotency.New(idempotency.NewMemoryStorage()), WithReplay[wrapperspb.StringValue](procedure))
+
+	mux := http.NewServeMux()
+	mux.Handle(procedure, connect.NewUnaryHandler(procedure,
+		func(ctx context.Context, req *connect.Request[wrapperspb.StringValue]) (*connect.Response[wrapperspb.StringValue], error) {
+			calls++
+			key, _ := idempotency.FromContext(ctx)
+			return connect.NewResponse(wrapperspb.String(req.Msg.Value + key)), nil
+		},
+		connect.WithInterceptors(interceptor),
+	))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		clientOp connect.ClientOption
+	}{
+		{name: "Connect", clientOp: connect.WithProtoJSON()},
+		{name: "gRPC-web", clientOp: connect.WithGRPCWeb()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls = 0
+			client := connect.NewClient[wrapperspb.StringValue, wrapperspb.StringValue](server.Client(), server.URL+procedure,
+				test.clientOp, connect.WithInterceptors(interceptor))
+			ctx := idempotency.NewContext(context.Background(), "key-"+test.name)
+
+			for i := 0; i < 2; i++ {
+				res, err := client.CallUnary(ctx, connect.NewRequest(wrapperspb.String("hello-")))
+				if err != nil {
+					t.Fatalf("want no error, got %v", err)
+				}
+				if want := "hello-key-" + test.name; res.Msg.Value != want {
+					t.Errorf("want response %q, got %q", want, res.Msg.Value)
+				}
+			}
+			if calls != 1 {
+				t.Errorf("want handler called once, got %d", calls)
+			}
+
+			_, err := client.CallUnary(ctx, connect.NewRequest(wrapperspb.String("other")))
+			if connect.CodeOf(err) != connect.CodeFailedPrecondition {
+				t.Errorf("want code %v, got %v", connect.CodeFailedPrecondition, connect.CodeOf(err))
+			}
+
+			_, err = client.CallUnary(context.Background(), connect.NewRequest(wrapperspb.String("hello-")))
+			if connect.CodeOf(err) != connect.CodeInvalidArgument {
+				t.Errorf("want code %v, got %v", connect.CodeInvalidArgument, connect.CodeOf(err))
+			}
+		})
+	}
+}
+
+func TestInterceptorCompleteError(t *testing.T) {
+	interceptor := NewInterceptor(idempotency.New(idempotency.NewMemoryStorage()))
+
+	calls := 0
+	unary := interceptor.WrapUnary(func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		calls++
+		// The response cannot be stored, as it is not a proto.Message.
+		return connect.NewResponse(&struct{}{}), nil
+	})
+
+	for i := 0; i < 2; i++ {
+		req := connect.NewRequest(wrapperspb.String("hello"))
+		req.Header().Set(idempotency.HeaderName, "key")
+		if _, err := unary(context.Background(), req); connect.CodeOf(err) != connect.CodeInternal {
+			t.Errorf("want code %v, got %v", connect.CodeInternal, connect.CodeOf(err))
+		}
+	}
+	if calls != 2 {
+		t.Errorf("want the key released for the retry, got %d calls", calls)
+	}
+}
+
+func TestInterceptorKeyHashing(t *testing.T) {
+	storage := idempotency.NewMemoryStorage()
+	s := idempotency.New(storage, idempotency.WithKeyHashing([]byte("secret")))
+	unary := NewInterceptor(s).WrapUnary(func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		return connect.NewResponse(wrapperspb.String("hello")), nil
+	})
+
+	req := connect.NewRequest(wrapperspb.String("hello"))
+	req.Header().Set(idempotency.HeaderName, "key")
+	if _, err := unary(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	storage.Scan(context.Background(), func(key string, status *idempotency.RequestStatus) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if want := s.HashKey(req.Spec().Procedure + ":key"); len(keys) != 1 || keys[0] != want {
+		t.Errorf("want only the hashed key %s stored, got %v", want, keys)
+	}
+}
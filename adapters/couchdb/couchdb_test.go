@@ -0,0 +1,101 @@
+package couchdb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Preciselyco/idempotency"
+	"github.com/Preciselyco/idempotency/storagetest"
+)
+
+// fakeCouchDB serves the part of the CouchDB API used by the Storage for a
+// database at /db.
+type fakeCouchDB struct {
+	mu   sync.Mutex
+	docs map[string]map[string]any
+	revs int
+}
+
+func (f *fakeCouchDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id, ok := strings.CutPrefix(r.URL.Path, "/db/")
+	switch {
+	case r.URL.Path == "/db" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]any{"db_name": "db"})
+	case id == "_all_docs" && r.Method == http.MethodGet:
+		var start, end string
+		json.Unmarshal([]byte(r.URL.Query().Get("startkey")), &start)
+		json.Unmarshal([]byte(r.URL.Query().Get("endkey")), &end)
+		var ids []string
+		for id := range f.docs {
+			if id >= start && id <= end {
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+		rows := make([]map[string]any, 0, len(ids))
+		for _, id := range ids {
+			rows = append(rows, map[string]any{"id": id, "doc": f.docs[id]})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"rows": rows})
+	case ok && r.Method == http.MethodGet:
+		doc, found := f.docs[id]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(doc)
+	case ok && r.Method == http.MethodPut:
+		var doc map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		rev, _ := doc["_rev"].(string)
+		if current, found := f.docs[id]; (found && current["_rev"] != rev) || (!found && rev != "") {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.revs++
+		doc["_rev"] = strconv.Itoa(f.revs)
+		f.docs[id] = doc
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "id": id, "rev": doc["_rev"]})
+	case ok && r.Method == http.MethodDelete:
+		current, found := f.docs[id]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if current["_rev"] != r.URL.Query().Get("rev") {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		delete(f.docs, id)
+		json.NewEncoder(w).Encode(map[string]any{"ok": true})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestStorage(t *testing.T) {
+	var clock *idempotency.FakeClock
+	storagetest.RunConformanceTests(t, func(t *testing.T) idempotency.Storage {
+		srv := httptest.NewServer(&fakeCouchDB{docs: make(map[string]map[string]any)})
+		t.Cleanup(srv.Close)
+
+		clock = idempotency.NewFakeClock(time.Now())
+		return New(srv.URL+"/db", time.Hour, WithClock(clock), WithHTTPClient(srv.Client()))
+	}, storagetest.WithSleep(func(d time.Duration) {
+		clock.Advance(d)
+	}))
+}
@@ -0,0 +1,83 @@
+// Package echo adapts the idempotency middleware to the Echo web framework.
+package echo
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// ContextKey is the key under which the idempotency key is stored in the
+// echo.Context.
+const ContextKey = "idempotency-key"
+
+type errorContextKey struct{}
+
+// httpError holds an error reported by the idempotency middleware for a
+// single request.
+type httpError struct {
+	err    error
+	status int
+}
+
+// ErrorResponder returns an idempotency option that hands errors produced by
+// the middleware over to Echo instead of writing them to the response. The
+// State passed to Middleware must be created with this option for errors to
+// reach Echo's HTTPErrorHandler.
+func ErrorResponder() idempotency.Option {
+	return idempotency.WithErrorResponder(func(err error, status int, w http.ResponseWriter, r *http.Request) {
+		if e, ok := r.Context().Value(errorContextKey{}).(*httpError); ok {
+			e.err = err
+			e.status = status
+			return
+		}
+
+		http.Error(w, err.Error(), status)
+	})
+}
+
+// Middleware returns an echo.MiddlewareFunc verifying the Idempotency-Key of
+// each request using s. Errors returned by the next handler are handled by
+// Echo's HTTPErrorHandler within the middleware, so that the error response
+// is stored and replayed like any other, and errors from the middleware
+// itself are returned as *echo.HTTPError when s is configured with
+// ErrorResponder.
+func Middleware(s *idempotency.State) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var verifyErr httpError
+
+			fn := func(w http.ResponseWriter, r *http.Request) {
+				key, _ := idempotency.FromContext(r.Context())
+				c.SetRequest(r)
+				c.Set(ContextKey, key)
+
+				// Respond through w, so that the response is captured.
+				resp := c.Response()
+				c.SetResponse(echo.NewResponse(w, c.Echo()))
+				defer c.SetResponse(resp)
+				if err := next(c); err != nil {
+					c.Error(err)
+				}
+			}
+
+			r := c.Request()
+			r = r.WithContext(context.WithValue(r.Context(), errorContextKey{}, &verifyErr))
+			s.Verify(http.HandlerFunc(fn)).ServeHTTP(c.Response(), r)
+
+			if verifyErr.err != nil {
+				return echo.NewHTTPError(verifyErr.status, verifyErr.err.Error()).SetInternal(verifyErr.err)
+			}
+			return nil
+		}
+	}
+}
+
+// FromContext returns the idempotency key stored in c, if any.
+func FromContext(c echo.Context) (string, bool) {
+	key, ok := c.Get(ContextKey).(string)
+	return key, ok
+}
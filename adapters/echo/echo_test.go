@@ -0,0 +1,118 @@
+package echo
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+func TestMiddleware(t *testing.T) {
+	s := idempotency.New(idempotency.NewMemoryStorage(), ErrorResponder(), idempotency.WithRestorer(func(idempotencyKey string, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	var (
+		gotKey     string
+		handledErr error
+	)
+	e := echo.New()
+	e.HTTPErrorHandler = func(err error, c echo.Context) {
+		handledErr = err
+		e.DefaultHTTPErrorHandler(err, c)
+	}
+	e.Use(Middleware(s))
+	e.POST("/foo", func(c echo.Context) error {
+		gotKey, _ = FromContext(c)
+		return c.NoContent(http.StatusCreated)
+	})
+
+	tests := []struct {
+		name           string
+		key            string
+		wantHTTPStatus int
+		wantErr        bool
+	}{
+		{name: "First request, pass through to handler", key: "deadbeef", wantHTTPStatus: http.StatusCreated},
+		{name: "Repeated request ends up in restorer", key: "deadbeef", wantHTTPStatus: http.StatusNoContent},
+		{name: "No Idempotency-Key header, error handled by Echo", wantHTTPStatus: http.StatusBadRequest, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handledErr = nil
+
+			req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+			if test.key != "" {
+				req.Header.Set(idempotency.HeaderName, test.key)
+			}
+
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, req)
+
+			if w.Code != test.wantHTTPStatus {
+				t.Errorf("want status code %v, got %v", test.wantHTTPStatus, w.Code)
+			}
+
+			var httpErr *echo.HTTPError
+			if test.wantErr != errors.As(handledErr, &httpErr) {
+				t.Errorf("want echo.HTTPError = %v, got %v", test.wantErr, handledErr)
+			}
+		})
+	}
+
+	if gotKey != "deadbeef" {
+		t.Errorf("want idempotency key = %v, got %v", "deadbeef", gotKey)
+	}
+}
+
+func TestMiddlewareCapture(t *testing.T) {
+	s := idempotency.New(idempotency.NewMemoryStorage(), idempotency.WithResponseCapture(true))
+
+	calls := 0
+	e := echo.New()
+	e.Use(Middleware(s))
+	e.POST("/foo", func(c echo.Context) error {
+		calls++
+		return c.String(http.StatusCreated, "hello")
+	})
+	e.POST("/teapot", func(c echo.Context) error {
+		calls++
+		return echo.NewHTTPError(http.StatusTeapot, "short and stout")
+	})
+
+	tests := []struct {
+		name           string
+		path           string
+		wantHTTPStatus int
+		wantBody       string
+	}{
+		{name: "Response", path: "/foo", wantHTTPStatus: http.StatusCreated, wantBody: "hello"},
+		{name: "Returned error", path: "/teapot", wantHTTPStatus: http.StatusTeapot, wantBody: "short and stout"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls = 0
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest("POST", "http://example.com"+test.path, nil)
+				req.Header.Set(idempotency.HeaderName, test.name)
+
+				w := httptest.NewRecorder()
+				e.ServeHTTP(w, req)
+
+				if w.Code != test.wantHTTPStatus || !strings.Contains(w.Body.String(), test.wantBody) {
+					t.Errorf("want %v %q, got %v %q", test.wantHTTPStatus, test.wantBody, w.Code, w.Body.String())
+				}
+			}
+			if calls != 1 {
+				t.Errorf("want handler called once, got %d", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+// Package fdb provides an idempotency storage in FoundationDB. Keys are
+// reserved and completed in strictly serializable transactions, so that the
+// record of a key is exact even under concurrent retries, e.g. for financial
+// workloads.
+//
+// FoundationDB does not expire keys, so the expiry is stored with every key
+// and expired keys are ignored, and removed with Purge.
+//
+// The bindings require the FoundationDB client library libfdb_c and cgo,
+// the package is therefore only built with the fdb build tag:
+//
+//	go build -tags fdb
+package fdb
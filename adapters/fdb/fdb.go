@@ -0,0 +1,396 @@
+//go:build fdb
+
+package fdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// purgeBatch is the number of keys Purge reads per transaction, to stay
+// within the limits of FoundationDB transactions.
+const purgeBatch = 1000
+
+// Option is the functional option signature for configuring the Storage.
+type Option func(*Storage)
+
+// WithKeyPrefix configures the prefix of the keys in FoundationDB, it
+// defaults to "idempotency:".
+func WithKeyPrefix(prefix string) Option {
+	return func(s *Storage) {
+		s.prefix = prefix
+	}
+}
+
+// WithClock configures the Clock of expiries, it defaults to
+// idempotency.SystemClock.
+func WithClock(c idempotency.Clock) Option {
+	return func(s *Storage) {
+		s.clock = c
+	}
+}
+
+// Storage is an idempotency.Reserver storing keys in FoundationDB. It also
+// implements idempotency.Deleter, idempotency.Expirer,
+// idempotency.TTLReader, idempotency.Scanner, idempotency.Purger and
+// idempotency.Pinger.
+type Storage struct {
+	db     fdb.Database
+	expiry time.Duration
+	prefix string
+	clock  idempotency.Clock
+}
+
+// New creates a Storage with db, expiring keys after expiry. The API version
+// must be selected with fdb.APIVersion before opening db.
+func New(db fdb.Database, expiry time.Duration, opts ...Option) *Storage {
+	s := &Storage{
+		db:     db,
+		expiry: expiry,
+		prefix: "idempotency:",
+		clock:  idempotency.SystemClock,
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(s)
+		}
+	}
+
+	return s
+}
+
+// entry is a stored key, encoded as its expiry in Unix milliseconds, zero if
+// it does not expire, followed by its status.
+type entry struct {
+	expiresAt int64
+	status    *idempotency.RequestStatus
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return e.expiresAt != 0 && e.expiresAt <= now.UnixMilli()
+}
+
+// statusOrNil returns the status of e, which may be nil.
+func (e *entry) statusOrNil() *idempotency.RequestStatus {
+	if e == nil {
+		return nil
+	}
+	return e.status
+}
+
+func encode(e *entry) ([]byte, error) {
+	status, err := idempotency.MarshalStatus(e.status)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 8, 8+len(status))
+	binary.BigEndian.PutUint64(b, uint64(e.expiresAt))
+	return append(b, status...), nil
+}
+
+func decode(b []byte) (*entry, error) {
+	if len(b) < 8 {
+		return nil, errors.New("value too short")
+	}
+	status, err := idempotency.UnmarshalStatus(b[8:])
+	if err != nil {
+		return nil, err
+	}
+	return &entry{expiresAt: int64(binary.BigEndian.Uint64(b)), status: status}, nil
+}
+
+// expiresAt returns the expiry of a key written now with expiry, or the
+// expiry of the storage if zero.
+func (s *Storage) expiresAt(expiry time.Duration) int64 {
+	if expiry == 0 {
+		expiry = s.expiry
+	}
+	if expiry <= 0 {
+		return 0
+	}
+	return s.clock.Now().Add(expiry).UnixMilli()
+}
+
+func (s *Storage) key(key string) fdb.Key {
+	return fdb.Key(s.prefix + key)
+}
+
+// limit applies the deadline of ctx to a transaction, FoundationDB does not
+// take contexts.
+func limit(ctx context.Context, o fdb.TransactionOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		return o.SetTimeout(max(time.Until(deadline).Milliseconds(), 1))
+	}
+	return nil
+}
+
+// get reads the live entry of key in tr, it is nil if the key does not exist
+// or is expired.
+func (s *Storage) get(tr fdb.ReadTransaction, key string) (*entry, error) {
+	v, err := tr.Get(s.key(key)).Get()
+	if err != nil || v == nil {
+		return nil, err
+	}
+
+	e, err := decode(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the key %q from fdb: %w", key, err)
+	}
+	if e.expired(s.clock.Now()) {
+		return nil, nil
+	}
+	return e, nil
+}
+
+// read runs fn in a read-only transaction.
+func (s *Storage) read(ctx context.Context, fn func(tr fdb.ReadTransaction) error) error {
+	_, err := s.db.ReadTransact(func(tr fdb.ReadTransaction) (interface{}, error) {
+		if err := limit(ctx, tr.Options()); err != nil {
+			return nil, err
+		}
+		return nil, fn(tr)
+	})
+	return err
+}
+
+// update runs fn in a transaction and commits it. FoundationDB retries fn on
+// conflicts with another transaction, so fn must not keep state of previous
+// attempts.
+func (s *Storage) update(ctx context.Context, fn func(tr fdb.Transaction) error) error {
+	_, err := s.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		if err := limit(ctx, tr.Options()); err != nil {
+			return nil, err
+		}
+		return nil, fn(tr)
+	})
+	return err
+}
+
+// set writes e as key in tr.
+func (s *Storage) set(tr fdb.Transaction, key string, e *entry) error {
+	v, err := encode(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode the status of key %q: %w", key, err)
+	}
+	tr.Set(s.key(key), v)
+	return nil
+}
+
+// Add inserts the initial state of a request with an idempotency key.
+func (s *Storage) Add(ctx context.Context, key string) (bool, error) {
+	return s.AddStatus(ctx, key, &idempotency.RequestStatus{InProcess: true}, 0)
+}
+
+// AddStatus inserts status for an idempotency key, expiring it after expiry
+// or the expiry of the storage if zero.
+func (s *Storage) AddStatus(ctx context.Context, key string, status *idempotency.RequestStatus, expiry time.Duration) (bool, error) {
+	_, added, err := s.AddOrGet(ctx, key, status, expiry)
+	return added, err
+}
+
+// AddOrGet inserts status for an idempotency key or returns its existing
+// status, in a transaction.
+func (s *Storage) AddOrGet(ctx context.Context, key string, status *idempotency.RequestStatus, expiry time.Duration) (*idempotency.RequestStatus, bool, error) {
+	var existing *idempotency.RequestStatus
+	err := s.update(ctx, func(tr fdb.Transaction) error {
+		existing = nil
+		e, err := s.get(tr, key)
+		if err != nil {
+			return err
+		}
+		if e != nil {
+			existing = e.status
+			return nil
+		}
+		return s.set(tr, key, &entry{expiresAt: s.expiresAt(expiry), status: status})
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to insert the key %q in fdb: %w", key, err)
+	}
+	return existing, existing == nil, nil
+}
+
+// Get fetches the RequestStatus for an idempotency key.
+func (s *Storage) Get(ctx context.Context, key string) (*idempotency.RequestStatus, error) {
+	var e *entry
+	err := s.read(ctx, func(tr fdb.ReadTransaction) (err error) {
+		e, err = s.get(tr, key)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the key %q from fdb: %w", key, err)
+	}
+	return e.statusOrNil(), nil
+}
+
+// Complete sets a request to not be in progress.
+func (s *Storage) Complete(ctx context.Context, key string) error {
+	return s.UpdateStatus(ctx, key, &idempotency.RequestStatus{})
+}
+
+// UpdateStatus replaces the RequestStatus of an idempotency key, keeping its
+// expiry.
+func (s *Storage) UpdateStatus(ctx context.Context, key string, status *idempotency.RequestStatus) error {
+	err := s.update(ctx, func(tr fdb.Transaction) error {
+		e, err := s.get(tr, key)
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			e = &entry{expiresAt: s.expiresAt(0)}
+		}
+		e.status = status
+		return s.set(tr, key, e)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update the key %q in fdb: %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes an idempotency key.
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	err := s.update(ctx, func(tr fdb.Transaction) error {
+		tr.Clear(s.key(key))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete the key %q from fdb: %w", key, err)
+	}
+	return nil
+}
+
+// Expire sets an idempotency key to expire after expiry.
+func (s *Storage) Expire(ctx context.Context, key string, expiry time.Duration) error {
+	err := s.update(ctx, func(tr fdb.Transaction) error {
+		e, err := s.get(tr, key)
+		if err != nil || e == nil {
+			return err
+		}
+		e.expiresAt = s.clock.Now().Add(expiry).UnixMilli()
+		return s.set(tr, key, e)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to expire the key %q in fdb: %w", key, err)
+	}
+	return nil
+}
+
+// TTL returns the remaining time until an idempotency key expires.
+func (s *Storage) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	var e *entry
+	err := s.read(ctx, func(tr fdb.ReadTransaction) (err error) {
+		e, err = s.get(tr, key)
+		return err
+	})
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get the expiry of key %q from fdb: %w", key, err)
+	}
+	if e == nil {
+		return 0, false, nil
+	}
+	if e.expiresAt == 0 {
+		return 0, true, nil
+	}
+	return time.Duration(e.expiresAt-s.clock.Now().UnixMilli()) * time.Millisecond, true, nil
+}
+
+// Scan calls fn for every idempotency key and its status.
+func (s *Storage) Scan(ctx context.Context, fn func(key string, status *idempotency.RequestStatus) error) error {
+	r, err := fdb.PrefixRange([]byte(s.prefix))
+	if err != nil {
+		return fmt.Errorf("failed to scan keys in fdb: %w", err)
+	}
+
+	// Read all entries first, as fn may use the storage.
+	var kvs []fdb.KeyValue
+	err = s.read(ctx, func(tr fdb.ReadTransaction) (err error) {
+		kvs, err = tr.GetRange(r, fdb.RangeOptions{}).GetSliceWithError()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan keys in fdb: %w", err)
+	}
+
+	now := s.clock.Now()
+	for _, kv := range kvs {
+		key := string(kv.Key[len(s.prefix):])
+		e, err := decode(kv.Value)
+		if err != nil {
+			return fmt.Errorf("failed to decode the key %q from fdb: %w", key, err)
+		}
+		if e.expired(now) {
+			continue
+		}
+		if err := fn(key, e.status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Purge removes expired idempotency keys, which FoundationDB does not remove
+// by itself, and returns how many were removed. Keys are read and removed in
+// batches, each in a transaction, so that keys reserved again are kept.
+func (s *Storage) Purge(ctx context.Context) (int, error) {
+	r, err := fdb.PrefixRange([]byte(s.prefix))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge keys in fdb: %w", err)
+	}
+
+	now := s.clock.Now()
+	total := 0
+	for {
+		var n, read int
+		var last fdb.Key
+		err := s.update(ctx, func(tr fdb.Transaction) error {
+			n, read, last = 0, 0, nil
+			kvs, err := tr.GetRange(r, fdb.RangeOptions{Limit: purgeBatch}).GetSliceWithError()
+			if err != nil {
+				return err
+			}
+			for _, kv := range kvs {
+				read++
+				last = kv.Key
+				if e, err := decode(kv.Value); err == nil && !e.expired(now) {
+					continue
+				}
+				tr.Clear(kv.Key)
+				n++
+			}
+			return nil
+		})
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("failed to purge keys in fdb: %w", err)
+		}
+		if read < purgeBatch {
+			return total, nil
+		}
+		r.Begin = fdb.Key(append(bytes.Clone(last), 0x00))
+	}
+}
+
+// Ping checks that the cluster is reachable by getting a read version.
+func (s *Storage) Ping(ctx context.Context) error {
+	err := s.read(ctx, func(tr fdb.ReadTransaction) error {
+		_, err := tr.GetReadVersion().Get()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to ping fdb: %w", err)
+	}
+	return nil
+}
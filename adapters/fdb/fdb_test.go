@@ -0,0 +1,47 @@
+//go:build fdb
+
+package fdb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+
+	"github.com/Preciselyco/idempotency"
+	"github.com/Preciselyco/idempotency/storagetest"
+)
+
+// TestStorage runs against the cluster of FDB_CLUSTER_FILE.
+func TestStorage(t *testing.T) {
+	clusterFile := os.Getenv("FDB_CLUSTER_FILE")
+	if clusterFile == "" {
+		t.Skip("FDB_CLUSTER_FILE is not set")
+	}
+	fdb.MustAPIVersion(730)
+	db, err := fdb.OpenDatabase(clusterFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var clock *idempotency.FakeClock
+	n := 0
+	storagetest.RunConformanceTests(t, func(t *testing.T) idempotency.Storage {
+		n++
+		prefix := fmt.Sprintf("idempotency-test-%d-%d:", time.Now().UnixNano(), n)
+		t.Cleanup(func() {
+			r, _ := fdb.PrefixRange([]byte(prefix))
+			db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+				tr.ClearRange(r)
+				return nil, nil
+			})
+		})
+
+		clock = idempotency.NewFakeClock(time.Now())
+		return New(db, time.Hour, WithKeyPrefix(prefix), WithClock(clock))
+	}, storagetest.WithSleep(func(d time.Duration) {
+		clock.Advance(d)
+	}))
+}
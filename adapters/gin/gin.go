@@ -0,0 +1,82 @@
+// Package gin adapts the idempotency middleware to the Gin web framework.
+package gin
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// ContextKey is the key under which the idempotency key is stored in the
+// gin.Context.
+const ContextKey = "idempotency-key"
+
+// Middleware returns a gin.HandlerFunc verifying the Idempotency-Key of each
+// request using s. The handler chain is only continued when the request should
+// be processed, otherwise the chain is aborted after s has written the
+// response.
+func Middleware(s *idempotency.State) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		passed := false
+		next := func(w http.ResponseWriter, r *http.Request) {
+			passed = true
+
+			key, _ := idempotency.FromContext(r.Context())
+			c.Request = r
+			c.Set(ContextKey, key)
+
+			// Write through w, so that the response is captured.
+			writer := c.Writer
+			rw := &responseWriter{ResponseWriter: writer, w: w}
+			c.Writer = rw
+			c.Next()
+			rw.WriteHeaderNow()
+			c.Writer = writer
+		}
+
+		s.Verify(http.HandlerFunc(next)).ServeHTTP(c.Writer, c.Request)
+
+		if !passed {
+			c.Abort()
+		}
+	}
+}
+
+// responseWriter is a gin.ResponseWriter writing the response through the
+// writer of the middleware.
+type responseWriter struct {
+	gin.ResponseWriter
+	w http.ResponseWriter
+}
+
+// WriteHeaderNow writes the status of the response, gin only records it
+// until the body is written.
+func (rw *responseWriter) WriteHeaderNow() {
+	if !rw.Written() {
+		rw.w.WriteHeader(rw.Status())
+		rw.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.WriteHeaderNow()
+	return rw.w.Write(b)
+}
+
+func (rw *responseWriter) WriteString(s string) (int, error) {
+	rw.WriteHeaderNow()
+	return io.WriteString(rw.w, s)
+}
+
+// FromContext returns the idempotency key stored in c, if any.
+func FromContext(c *gin.Context) (string, bool) {
+	v, ok := c.Get(ContextKey)
+	if !ok {
+		return "", false
+	}
+	key, ok := v.(string)
+	return key, ok
+}
@@ -0,0 +1,86 @@
+package gin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := idempotency.New(idempotency.NewMemoryStorage(), idempotency.WithRestorer(func(idempotencyKey string, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	var gotKey string
+	router := gin.New()
+	router.Use(Middleware(s))
+	router.POST("/foo", func(c *gin.Context) {
+		gotKey, _ = FromContext(c)
+		c.Status(http.StatusCreated)
+	})
+
+	tests := []struct {
+		name           string
+		key            string
+		wantHTTPStatus int
+	}{
+		{name: "First request, pass through to handler", key: "deadbeef", wantHTTPStatus: http.StatusCreated},
+		{name: "Repeated request ends up in restorer", key: "deadbeef", wantHTTPStatus: http.StatusNoContent},
+		{name: "No Idempotency-Key header, bad request error", wantHTTPStatus: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+			if test.key != "" {
+				req.Header.Set(idempotency.HeaderName, test.key)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != test.wantHTTPStatus {
+				t.Errorf("want status code %v, got %v", test.wantHTTPStatus, w.Code)
+			}
+		})
+	}
+
+	if gotKey != "deadbeef" {
+		t.Errorf("want idempotency key = %v, got %v", "deadbeef", gotKey)
+	}
+}
+
+func TestMiddlewareCapture(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := idempotency.New(idempotency.NewMemoryStorage(), idempotency.WithResponseCapture(true))
+
+	calls := 0
+	router := gin.New()
+	router.Use(Middleware(s))
+	router.POST("/foo", func(c *gin.Context) {
+		calls++
+		c.String(http.StatusCreated, "hello")
+	})
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+		req.Header.Set(idempotency.HeaderName, "deadbeef")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusCreated || w.Body.String() != "hello" {
+			t.Errorf("want 201 hello, got %v %q", w.Code, w.Body.String())
+		}
+	}
+	if calls != 1 {
+		t.Errorf("want handler called once, got %d", calls)
+	}
+}
@@ -0,0 +1,180 @@
+// Package gqlgen provides a gqlgen extension applying idempotency to GraphQL
+// mutations. As all operations share a single endpoint keys are not scoped
+// by path, instead a key reused for another operation name or other
+// variables is rejected by their fingerprint.
+package gqlgen
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// Error codes set in the extensions of errors returned by the extension.
+// CodeInternalError is set when the operation cannot be fingerprinted.
+const (
+	CodeMissingKey    = "IDEMPOTENCY_KEY_MISSING"
+	CodeConflict      = "IDEMPOTENCY_CONFLICT"
+	CodeMismatch      = "IDEMPOTENCY_MISMATCH"
+	CodeStorageError  = "IDEMPOTENCY_STORAGE_ERROR"
+	CodeInternalError = "IDEMPOTENCY_INTERNAL_ERROR"
+)
+
+// Option is the functional option signature for configuring the extension.
+type Option func(*Extension)
+
+// Extension is a gqlgen handler extension that deduplicates mutations by
+// their Idempotency-Key header. Queries and subscriptions are not affected.
+type Extension struct {
+	state       *idempotency.State
+	requirement idempotency.Requirement
+}
+
+var (
+	_ graphql.HandlerExtension    = (*Extension)(nil)
+	_ graphql.ResponseInterceptor = (*Extension)(nil)
+)
+
+// WithRequirement configures whether the Idempotency-Key header is required
+// for mutations, it defaults to idempotency.Required.
+func WithRequirement(requirement idempotency.Requirement) Option {
+	return func(e *Extension) {
+		e.requirement = requirement
+	}
+}
+
+// New creates an extension storing the state of the keys with s, which
+// configures e.g. how long keys are retained. Replaying the responses of
+// completed mutations requires a storage implementing
+// idempotency.StatusStorage.
+func New(s *idempotency.State, opts ...Option) *Extension {
+	e := &Extension{
+		state: s,
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(e)
+		}
+	}
+
+	return e
+}
+
+// ExtensionName implements graphql.HandlerExtension.
+func (e *Extension) ExtensionName() string {
+	return "Idempotency"
+}
+
+// Validate implements graphql.HandlerExtension.
+func (e *Extension) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+// InterceptResponse implements graphql.ResponseInterceptor.
+func (e *Extension) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	if !graphql.HasOperationContext(ctx) {
+		return next(ctx)
+	}
+
+	oc := graphql.GetOperationContext(ctx)
+	if oc.Operation == nil || oc.Operation.Operation != ast.Mutation {
+		return next(ctx)
+	}
+
+	idempotencyKey := oc.Headers.Get(idempotency.HeaderName)
+	if idempotencyKey == "" {
+		if e.requirement == idempotency.Optional {
+			return next(ctx)
+		}
+		return errorResponse(CodeMissingKey, "no Idempotency-Key set")
+	}
+	ctx = idempotency.NewContext(ctx, idempotencyKey)
+
+	fingerprint, err := fingerprintOperation(oc)
+	if err != nil {
+		return errorResponse(CodeInternalError, fmt.Sprintf("could not fingerprint operation: %v", err))
+	}
+
+	key := e.state.HashKey("graphql:" + idempotencyKey)
+
+	d, err := e.state.Reserve(ctx, key, fingerprint)
+	if err != nil {
+		return errorResponse(CodeStorageError, err.Error())
+	}
+
+	switch d.Outcome {
+	case idempotency.OutcomeNew:
+		res := next(ctx)
+
+		if err := e.complete(ctx, key, res); err != nil {
+			// Release the key, so that the mutation can be retried.
+			if ferr := e.state.Fail(ctx, key); ferr != nil {
+				err = errors.Join(err, ferr)
+			}
+			return errorResponse(CodeStorageError, fmt.Sprintf("could not complete request: %v", err))
+		}
+		return res
+	case idempotency.OutcomeMismatch:
+		return errorResponse(CodeMismatch, "Idempotency-Key is already used for a different operation")
+	case idempotency.OutcomeInProcess:
+		return errorResponse(CodeConflict, "request already in progress")
+	}
+
+	if d.Status.Response == nil {
+		return errorResponse(CodeConflict, "request already completed")
+	}
+
+	var res graphql.Response
+	if err := json.Unmarshal(d.Status.Response.Body, &res); err != nil {
+		return errorResponse(CodeStorageError, fmt.Sprintf("could not decode stored response: %v", err))
+	}
+	return &res
+}
+
+// complete marks key as completed, storing res when the storage supports it.
+func (e *Extension) complete(ctx context.Context, key string, res *graphql.Response) error {
+	if !e.state.Capabilities().StatusStorage {
+		return e.state.Finish(ctx, key, nil)
+	}
+
+	body, err := json.Marshal(res)
+	if err != nil {
+		return fmt.Errorf("failed to encode response: %w", err)
+	}
+
+	return e.state.Finish(ctx, key, &idempotency.Response{Body: body})
+}
+
+// fingerprintOperation fingerprints an operation by the SHA-256 sum of its
+// name and variables.
+func fingerprintOperation(oc *graphql.OperationContext) (string, error) {
+	variables, err := json.Marshal(oc.Variables)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	h.Write([]byte(oc.Operation.Name))
+	h.Write([]byte{0})
+	h.Write(variables)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func errorResponse(code, message string) *graphql.Response {
+	return &graphql.Response{
+		Errors: gqlerror.List{{
+			Message:    message,
+			Extensions: map[string]any{"code": code},
+		}},
+	}
+}
@@ -0,0 +1,167 @@
+package gqlgen
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+func TestExtension(t *testing.T) {
+	schema := gqlparser.MustLoadSchema(&ast.Source{Input: `
+		type Query { name: String! }
+		type Mutation { create(name: String!): String! }
+	`})
+
+	calls := 0
+	srv := handler.New(&graphql.ExecutableSchemaMock{
+		SchemaFunc: func() *ast.Schema { return schema },
+		ExecFunc: func(ctx context.Context) graphql.ResponseHandler {
+			ran := false
+			return func(ctx context.Context) *graphql.Response {
+				if ran {
+					return nil
+				}
+				ran = true
+				calls++
+				return &graphql.Response{Data: []byte(`{"calls":` + strconv.Itoa(calls) + `}`)}
+			}
+		},
+	})
+	srv.AddTransport(transport.POST{})
+	srv.Use(New(idempotency.New(idempotency.NewMemoryStorage())))
+
+	tests := []struct {
+		name      string
+		query     string
+		variables string
+		key       string
+		wantData  string
+		wantCode  string
+	}{
+		{name: "First mutation is executed", query: "mutation Create($n: String!) { create(name: $n) }", variables: `{"n":"a"}`, key: "deadbeef", wantData: `{"calls":1}`},
+		{name: "Repeated mutation is replayed", query: "mutation Create($n: String!) { create(name: $n) }", variables: `{"n":"a"}`, key: "deadbeef", wantData: `{"calls":1}`},
+		{name: "Different variables is a mismatch", query: "mutation Create($n: String!) { create(name: $n) }", variables: `{"n":"b"}`, key: "deadbeef", wantCode: CodeMismatch},
+		{name: "Mutation without key is rejected", query: "mutation Create($n: String!) { create(name: $n) }", variables: `{"n":"a"}`, wantCode: CodeMissingKey},
+		{name: "Queries are not affected", query: "query { name }", variables: `{}`, wantData: `{"calls":2}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := `{"query":` + jsonString(test.query) + `,"variables":` + test.variables + `}`
+			req := httptest.NewRequest("POST", "http://example.com/graphql", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			if test.key != "" {
+				req.Header.Set(idempotency.HeaderName, test.key)
+			}
+
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, req)
+
+			var res struct {
+				Data   json.RawMessage `json:"data"`
+				Errors []struct {
+					Extensions map[string]any `json:"extensions"`
+				} `json:"errors"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatalf("could not decode response %q: %v", w.Body.String(), err)
+			}
+
+			if test.wantCode != "" {
+				if len(res.Errors) == 0 || res.Errors[0].Extensions["code"] != test.wantCode {
+					t.Errorf("want error code %v, got %s", test.wantCode, w.Body.String())
+				}
+				return
+			}
+			if string(res.Data) != test.wantData {
+				t.Errorf("want data %s, got %s", test.wantData, res.Data)
+			}
+		})
+	}
+
+	if calls != 2 {
+		t.Errorf("want 2 executions, got %d", calls)
+	}
+}
+
+func TestExtensionFingerprintError(t *testing.T) {
+	e := New(idempotency.New(idempotency.NewMemoryStorage()))
+
+	ctx := graphql.WithOperationContext(context.Background(), &graphql.OperationContext{
+		Operation: &ast.OperationDefinition{Operation: ast.Mutation},
+		Headers:   http.Header{idempotency.HeaderName: {"deadbeef"}},
+		// Functions cannot be encoded to JSON.
+		Variables: map[string]any{"n": func() {}},
+	})
+	res := e.InterceptResponse(ctx, func(ctx context.Context) *graphql.Response {
+		t.Error("want the mutation not executed")
+		return nil
+	})
+
+	if len(res.Errors) == 0 || res.Errors[0].Extensions["code"] != CodeInternalError {
+		t.Errorf("want error code %v, got %+v", CodeInternalError, res)
+	}
+}
+
+func TestExtensionCompleteError(t *testing.T) {
+	e := New(idempotency.New(idempotency.NewMemoryStorage()))
+
+	calls := 0
+	for i := 0; i < 2; i++ {
+		ctx := graphql.WithOperationContext(context.Background(), &graphql.OperationContext{
+			Operation: &ast.OperationDefinition{Operation: ast.Mutation},
+			Headers:   http.Header{idempotency.HeaderName: {"deadbeef"}},
+		})
+		res := e.InterceptResponse(ctx, func(ctx context.Context) *graphql.Response {
+			calls++
+			// Functions cannot be encoded to JSON.
+			return &graphql.Response{Extensions: map[string]any{"f": func() {}}}
+		})
+		if len(res.Errors) == 0 || res.Errors[0].Extensions["code"] != CodeStorageError {
+			t.Errorf("want error code %v, got %+v", CodeStorageError, res)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("want the key released for the retry, got %d calls", calls)
+	}
+}
+
+func TestExtensionKeyHashing(t *testing.T) {
+	storage := idempotency.NewMemoryStorage()
+	s := idempotency.New(storage, idempotency.WithKeyHashing([]byte("secret")))
+	e := New(s)
+
+	ctx := graphql.WithOperationContext(context.Background(), &graphql.OperationContext{
+		Operation: &ast.OperationDefinition{Operation: ast.Mutation},
+		Headers:   http.Header{idempotency.HeaderName: {"deadbeef"}},
+	})
+	e.InterceptResponse(ctx, func(ctx context.Context) *graphql.Response {
+		return &graphql.Response{Data: []byte(`{}`)}
+	})
+
+	var keys []string
+	storage.Scan(context.Background(), func(key string, status *idempotency.RequestStatus) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if want := s.HashKey("graphql:deadbeef"); len(keys) != 1 || keys[0] != want {
+		t.Errorf("want only the hashed key %s stored, got %v", want, keys)
+	}
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
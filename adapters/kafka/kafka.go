@@ -0,0 +1,129 @@
+// Package kafka deduplicates Kafka messages consumed with sarama or franz-go,
+// so that messages delivered more than once are only processed once.
+//
+// Messages are processed with idempotency.Do using a key derived from the
+// message. Messages whose key is already completed are skipped, and a failed
+// message releases its key so that it is processed again when redelivered.
+// A message whose key is in process by another consumer returns
+// idempotency.ErrInProcess. With idempotency.WithMaxAttempts, a message which
+// failed too often returns idempotency.ErrAttemptsExhausted for every further
+// delivery, consumers should commit its offset or produce it to a dead letter
+// topic instead of retrying it.
+package kafka
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/IBM/sarama"
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/Preciselyco/idempotency"
+	"github.com/Preciselyco/idempotency/audit"
+)
+
+// Message is the part of a Kafka message used to derive keys, it is
+// implemented for both sarama and franz-go messages.
+type Message struct {
+	Topic   string
+	Key     []byte
+	Value   []byte
+	Headers map[string][]byte
+}
+
+// KeyFunc derives the idempotency key of a message, an empty key processes
+// the message without deduplication.
+type KeyFunc func(m Message) string
+
+// HeaderKey derives keys from the value of the header name.
+func HeaderKey(name string) KeyFunc {
+	return func(m Message) string {
+		return string(m.Headers[name])
+	}
+}
+
+// PayloadKey derives keys from the SHA-256 sum of the topic, key and value of
+// the message, so that messages with identical payloads are deduplicated.
+func PayloadKey(m Message) string {
+	h := sha256.New()
+	h.Write([]byte(m.Topic))
+	h.Write([]byte{0})
+	h.Write(m.Key)
+	h.Write([]byte{0})
+	h.Write(m.Value)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// process runs fn once for the key of m.
+func process(ctx context.Context, s *idempotency.State, keyFunc KeyFunc, m Message, fn func(ctx context.Context) error) error {
+	key := keyFunc(m)
+	if key == "" {
+		return fn(ctx)
+	}
+
+	_, _, err := idempotency.Do(ctx, s, "kafka:"+m.Topic+":"+key, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, fn(ctx)
+	})
+	return err
+}
+
+// Sarama wraps fn, processing messages consumed with sarama once per key.
+func Sarama(s *idempotency.State, keyFunc KeyFunc, fn func(ctx context.Context, msg *sarama.ConsumerMessage) error) func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		m := Message{
+			Topic:   msg.Topic,
+			Key:     msg.Key,
+			Value:   msg.Value,
+			Headers: make(map[string][]byte, len(msg.Headers)),
+		}
+		for _, h := range msg.Headers {
+			m.Headers[string(h.Key)] = h.Value
+		}
+
+		return process(ctx, s, keyFunc, m, func(ctx context.Context) error {
+			return fn(ctx, msg)
+		})
+	}
+}
+
+// Franz wraps fn, processing records consumed with franz-go once per key.
+func Franz(s *idempotency.State, keyFunc KeyFunc, fn func(ctx context.Context, r *kgo.Record) error) func(ctx context.Context, r *kgo.Record) error {
+	return func(ctx context.Context, r *kgo.Record) error {
+		m := Message{
+			Topic:   r.Topic,
+			Key:     r.Key,
+			Value:   r.Value,
+			Headers: make(map[string][]byte, len(r.Headers)),
+		}
+		for _, h := range r.Headers {
+			m.Headers[h.Key] = h.Value
+		}
+
+		return process(ctx, s, keyFunc, m, func(ctx context.Context) error {
+			return fn(ctx, r)
+		})
+	}
+}
+
+// AuditSink returns an audit sink producing events as JSON to topic, keyed
+// by the idempotency key so that the events of a key stay in order.
+func AuditSink(producer sarama.SyncProducer, topic string) audit.Sink {
+	return audit.SinkFunc(func(ctx context.Context, events []audit.Event) error {
+		msgs := make([]*sarama.ProducerMessage, len(events))
+		for i, event := range events {
+			value, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			msgs[i] = &sarama.ProducerMessage{
+				Topic:     topic,
+				Key:       sarama.StringEncoder(event.Key),
+				Value:     sarama.ByteEncoder(value),
+				Timestamp: event.Time,
+			}
+		}
+		return producer.SendMessages(msgs)
+	})
+}
@@ -0,0 +1,92 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/Preciselyco/idempotency"
+	"github.com/Preciselyco/idempotency/audit"
+)
+
+func TestSarama(t *testing.T) {
+	s := idempotency.New(idempotency.NewMemoryStorage())
+
+	calls := 0
+	failure := errors.New("failure")
+	fail := true
+	handler := Sarama(s, HeaderKey("Idempotency-Key"), func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		calls++
+		if fail {
+			fail = false
+			return failure
+		}
+		return nil
+	})
+
+	msg := &sarama.ConsumerMessage{
+		Topic:   "orders",
+		Value:   []byte("payload"),
+		Headers: []*sarama.RecordHeader{{Key: []byte("Idempotency-Key"), Value: []byte("deadbeef")}},
+	}
+
+	if err := handler(context.Background(), msg); !errors.Is(err, failure) {
+		t.Errorf("want error %v, got %v", failure, err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := handler(context.Background(), msg); err != nil {
+			t.Errorf("want no error, got %v", err)
+		}
+	}
+
+	// The failed delivery is retried and the redelivery after that skipped.
+	if calls != 2 {
+		t.Errorf("want 2 calls, got %d", calls)
+	}
+}
+
+func TestFranz(t *testing.T) {
+	s := idempotency.New(idempotency.NewMemoryStorage())
+
+	calls := 0
+	handler := Franz(s, PayloadKey, func(ctx context.Context, r *kgo.Record) error {
+		calls++
+		return nil
+	})
+
+	for _, value := range []string{"a", "a", "b"} {
+		if err := handler(context.Background(), &kgo.Record{Topic: "orders", Value: []byte(value)}); err != nil {
+			t.Errorf("want no error, got %v", err)
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("want 2 calls, got %d", calls)
+	}
+}
+
+func TestAuditSink(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		if msg.Topic != "audit" {
+			return errors.New("want audit topic, got " + msg.Topic)
+		}
+		if key, _ := msg.Key.Encode(); string(key) != "key" {
+			return errors.New("want key, got " + string(key))
+		}
+		return nil
+	})
+
+	events := []audit.Event{{Time: time.Now(), Key: "key", Outcome: "completed"}}
+	if err := AuditSink(producer, "audit").Write(context.Background(), events); err != nil {
+		t.Fatalf("want events produced, got %v", err)
+	}
+	if err := producer.Close(); err != nil {
+		t.Error(err)
+	}
+}
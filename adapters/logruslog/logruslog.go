@@ -0,0 +1,44 @@
+// Package logruslog adapts a logrus logger to idempotency.Logger.
+package logruslog
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+type logger struct {
+	l logrus.FieldLogger
+}
+
+// New returns an idempotency.Logger logging to l, e.g. a *logrus.Logger or a
+// *logrus.Entry.
+func New(l logrus.FieldLogger) idempotency.Logger {
+	return logger{l: l}
+}
+
+// Debug implements idempotency.Logger.
+func (l logger) Debug(msg string, args ...any) {
+	l.l.WithFields(fields(args)).Debug(msg)
+}
+
+// Error implements idempotency.Logger.
+func (l logger) Error(msg string, args ...any) {
+	l.l.WithFields(fields(args)).Error(msg)
+}
+
+// fields converts alternating keys and values to fields, a value without key
+// is logged as !BADKEY like slog does.
+func fields(args []any) logrus.Fields {
+	f := make(logrus.Fields, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			f["!BADKEY"] = args[i]
+			break
+		}
+		f[fmt.Sprint(args[i])] = args[i+1]
+	}
+	return f
+}
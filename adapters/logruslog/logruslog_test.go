@@ -0,0 +1,28 @@
+package logruslog
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestLogger(t *testing.T) {
+	base, hook := test.NewNullLogger()
+	base.SetLevel(logrus.DebugLevel)
+	l := New(base)
+
+	l.Debug("verified", "key", "a")
+	l.Error("failed", "key", "b", "odd")
+
+	entries := hook.AllEntries()
+	if len(entries) != 2 {
+		t.Fatalf("want 2 entries, got %d", len(entries))
+	}
+	if e := entries[0]; e.Level != logrus.DebugLevel || e.Data["key"] != "a" {
+		t.Errorf("want debug entry with key a, got %+v", e)
+	}
+	if e := entries[1]; e.Level != logrus.ErrorLevel || e.Data["key"] != "b" || e.Data["!BADKEY"] != "odd" {
+		t.Errorf("want error entry with key b, got %+v", e)
+	}
+}
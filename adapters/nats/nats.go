@@ -0,0 +1,98 @@
+// Package nats deduplicates messages consumed from NATS JetStream, which
+// delivers messages at least once, by the Nats-Msg-Id header.
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// Option is the functional option signature for configuring the handler.
+type Option func(*handler)
+
+type handler struct {
+	state      *idempotency.State
+	fn         func(ctx context.Context, msg *nats.Msg) error
+	onError    func(msg *nats.Msg, err error)
+	retryDelay time.Duration
+}
+
+// WithErrorHandler configures a function called with errors from processing
+// and acknowledging messages, which are otherwise dropped.
+func WithErrorHandler(f func(msg *nats.Msg, err error)) Option {
+	return func(h *handler) {
+		h.onError = f
+	}
+}
+
+// WithRetryDelay configures the delay before a message whose key is in
+// process by another consumer is redelivered, it defaults to one second.
+func WithRetryDelay(d time.Duration) Option {
+	return func(h *handler) {
+		h.retryDelay = d
+	}
+}
+
+// Handler returns a nats.MsgHandler running fn once per Nats-Msg-Id.
+// Messages are acknowledged once processed, or directly when already
+// processed by an earlier delivery. Messages whose processing failed are
+// negatively acknowledged for redelivery, and messages in process by another
+// consumer are redelivered after the retry delay. Messages whose attempts
+// are exhausted, see idempotency.WithMaxAttempts, are terminated. Messages
+// without a Nats-Msg-Id are processed without deduplication.
+func Handler(s *idempotency.State, fn func(ctx context.Context, msg *nats.Msg) error, opts ...Option) nats.MsgHandler {
+	h := &handler{
+		state:      s,
+		fn:         fn,
+		onError:    func(msg *nats.Msg, err error) {},
+		retryDelay: time.Second,
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(h)
+		}
+	}
+
+	return h.handle
+}
+
+func (h *handler) handle(msg *nats.Msg) {
+	ctx := context.Background()
+
+	var err error
+	if key := msg.Header.Get(nats.MsgIdHdr); key != "" {
+		_, _, err = idempotency.Do(ctx, h.state, "nats:"+msg.Subject+":"+key, func(ctx context.Context) (struct{}, error) {
+			return struct{}{}, h.fn(ctx, msg)
+		})
+	} else {
+		err = h.fn(ctx, msg)
+	}
+
+	switch {
+	case errors.Is(err, idempotency.ErrInProcess):
+		h.ack(msg, msg.NakWithDelay(h.retryDelay))
+	case errors.Is(err, idempotency.ErrAttemptsExhausted):
+		// Redelivering would only replay the failure.
+		h.onError(msg, err)
+		h.ack(msg, msg.Term())
+	case err != nil:
+		h.onError(msg, err)
+		h.ack(msg, msg.Nak())
+	default:
+		h.ack(msg, msg.Ack())
+	}
+}
+
+// ack reports errors from acknowledging msg.
+func (h *handler) ack(msg *nats.Msg, err error) {
+	if err != nil {
+		h.onError(msg, fmt.Errorf("could not acknowledge message: %w", err))
+	}
+}
@@ -0,0 +1,30 @@
+package nats
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+func TestHandler(t *testing.T) {
+	s := idempotency.New(idempotency.NewMemoryStorage())
+
+	calls := 0
+	h := Handler(s, func(ctx context.Context, msg *nats.Msg) error {
+		calls++
+		return nil
+	})
+
+	for _, id := range []string{"a", "a", "b"} {
+		msg := nats.NewMsg("orders")
+		msg.Header.Set(nats.MsgIdHdr, id)
+		h(msg)
+	}
+
+	if calls != 2 {
+		t.Errorf("want 2 calls, got %d", calls)
+	}
+}
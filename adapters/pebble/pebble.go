@@ -0,0 +1,382 @@
+// Package pebble provides an embedded idempotency storage in Pebble, an LSM
+// key-value store, for single-node services with many writes.
+//
+// Pebble does not expire keys, so the expiry is stored with every key and
+// expired keys are ignored. Purge removes them and compacts their range, so
+// that the space of the deleted keys is reclaimed.
+package pebble
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/pebble/v2"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// Option is the functional option signature for configuring the Storage.
+type Option func(*Storage)
+
+// WithKeyPrefix configures the prefix of the keys in Pebble, it defaults to
+// "idempotency:".
+func WithKeyPrefix(prefix string) Option {
+	return func(s *Storage) {
+		s.prefix = prefix
+	}
+}
+
+// WithClock configures the Clock of expiries, it defaults to
+// idempotency.SystemClock.
+func WithClock(c idempotency.Clock) Option {
+	return func(s *Storage) {
+		s.clock = c
+	}
+}
+
+// WithSync configures whether writes are synced to disk before they return,
+// it defaults to true. Without syncing, keys written shortly before a crash
+// of the machine may be lost.
+func WithSync(sync bool) Option {
+	return func(s *Storage) {
+		s.write = pebble.NoSync
+		if sync {
+			s.write = pebble.Sync
+		}
+	}
+}
+
+// Storage is an idempotency.Reserver storing keys in a Pebble database. It
+// also implements idempotency.Deleter, idempotency.Expirer,
+// idempotency.TTLReader, idempotency.Scanner, idempotency.Purger and
+// idempotency.Pinger.
+//
+// Keys are read and written under a lock of the Storage, so a database must
+// only be used by a single Storage.
+type Storage struct {
+	db     *pebble.DB
+	expiry time.Duration
+	prefix string
+	clock  idempotency.Clock
+	write  *pebble.WriteOptions
+
+	mu sync.Mutex
+}
+
+// New creates a Storage with db, expiring keys after expiry.
+func New(db *pebble.DB, expiry time.Duration, opts ...Option) *Storage {
+	s := &Storage{
+		db:     db,
+		expiry: expiry,
+		prefix: "idempotency:",
+		clock:  idempotency.SystemClock,
+		write:  pebble.Sync,
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(s)
+		}
+	}
+
+	return s
+}
+
+// entry is a stored key, encoded as its expiry in Unix milliseconds, zero if
+// it does not expire, followed by its status.
+type entry struct {
+	expiresAt int64
+	status    *idempotency.RequestStatus
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return e.expiresAt != 0 && e.expiresAt <= now.UnixMilli()
+}
+
+// statusOrNil returns the status of e, which may be nil.
+func (e *entry) statusOrNil() *idempotency.RequestStatus {
+	if e == nil {
+		return nil
+	}
+	return e.status
+}
+
+func encode(e *entry) ([]byte, error) {
+	status, err := idempotency.MarshalStatus(e.status)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 8, 8+len(status))
+	binary.BigEndian.PutUint64(b, uint64(e.expiresAt))
+	return append(b, status...), nil
+}
+
+func decode(b []byte) (*entry, error) {
+	if len(b) < 8 {
+		return nil, errors.New("value too short")
+	}
+	status, err := idempotency.UnmarshalStatus(b[8:])
+	if err != nil {
+		return nil, err
+	}
+	return &entry{expiresAt: int64(binary.BigEndian.Uint64(b)), status: status}, nil
+}
+
+// expiresAt returns the expiry of a key written now with expiry, or the
+// expiry of the storage if zero.
+func (s *Storage) expiresAt(expiry time.Duration) int64 {
+	if expiry == 0 {
+		expiry = s.expiry
+	}
+	if expiry <= 0 {
+		return 0
+	}
+	return s.clock.Now().Add(expiry).UnixMilli()
+}
+
+// bounds returns the range of the keys with the prefix.
+func (s *Storage) bounds() (start, end []byte) {
+	start = []byte(s.prefix)
+	return start, append(bytes.Clone(start), 0xff)
+}
+
+// get reads the live entry of key, it is nil if the key does not exist or is
+// expired.
+func (s *Storage) get(key string) (*entry, error) {
+	v, closer, err := s.db.Get([]byte(s.prefix + key))
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	e, err := decode(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the key %q from pebble: %w", key, err)
+	}
+	if e.expired(s.clock.Now()) {
+		return nil, nil
+	}
+	return e, nil
+}
+
+// set writes e as key.
+func (s *Storage) set(key string, e *entry) error {
+	v, err := encode(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode the status of key %q: %w", key, err)
+	}
+	return s.db.Set([]byte(s.prefix+key), v, s.write)
+}
+
+// Add inserts the initial state of a request with an idempotency key.
+func (s *Storage) Add(ctx context.Context, key string) (bool, error) {
+	return s.AddStatus(ctx, key, &idempotency.RequestStatus{InProcess: true}, 0)
+}
+
+// AddStatus inserts status for an idempotency key, expiring it after expiry
+// or the expiry of the storage if zero.
+func (s *Storage) AddStatus(ctx context.Context, key string, status *idempotency.RequestStatus, expiry time.Duration) (bool, error) {
+	_, added, err := s.AddOrGet(ctx, key, status, expiry)
+	return added, err
+}
+
+// AddOrGet inserts status for an idempotency key or returns its existing
+// status.
+func (s *Storage) AddOrGet(ctx context.Context, key string, status *idempotency.RequestStatus, expiry time.Duration) (*idempotency.RequestStatus, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, err := s.get(key)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to insert the key %q in pebble: %w", key, err)
+	}
+	if e != nil {
+		return e.status, false, nil
+	}
+	if err := s.set(key, &entry{expiresAt: s.expiresAt(expiry), status: status}); err != nil {
+		return nil, false, fmt.Errorf("failed to insert the key %q in pebble: %w", key, err)
+	}
+	return nil, true, nil
+}
+
+// Get fetches the RequestStatus for an idempotency key.
+func (s *Storage) Get(ctx context.Context, key string) (*idempotency.RequestStatus, error) {
+	e, err := s.get(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the key %q from pebble: %w", key, err)
+	}
+	return e.statusOrNil(), nil
+}
+
+// Complete sets a request to not be in progress.
+func (s *Storage) Complete(ctx context.Context, key string) error {
+	return s.UpdateStatus(ctx, key, &idempotency.RequestStatus{})
+}
+
+// UpdateStatus replaces the RequestStatus of an idempotency key, keeping its
+// expiry.
+func (s *Storage) UpdateStatus(ctx context.Context, key string, status *idempotency.RequestStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, err := s.get(key)
+	if err != nil {
+		return fmt.Errorf("failed to update the key %q in pebble: %w", key, err)
+	}
+	if e == nil {
+		e = &entry{expiresAt: s.expiresAt(0)}
+	}
+	e.status = status
+	if err := s.set(key, e); err != nil {
+		return fmt.Errorf("failed to update the key %q in pebble: %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes an idempotency key.
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.db.Delete([]byte(s.prefix+key), s.write); err != nil {
+		return fmt.Errorf("failed to delete the key %q from pebble: %w", key, err)
+	}
+	return nil
+}
+
+// Expire sets an idempotency key to expire after expiry.
+func (s *Storage) Expire(ctx context.Context, key string, expiry time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, err := s.get(key)
+	if err != nil {
+		return fmt.Errorf("failed to expire the key %q in pebble: %w", key, err)
+	}
+	if e == nil {
+		return nil
+	}
+	e.expiresAt = s.clock.Now().Add(expiry).UnixMilli()
+	if err := s.set(key, e); err != nil {
+		return fmt.Errorf("failed to expire the key %q in pebble: %w", key, err)
+	}
+	return nil
+}
+
+// TTL returns the remaining time until an idempotency key expires.
+func (s *Storage) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	e, err := s.get(key)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get the expiry of key %q from pebble: %w", key, err)
+	}
+	if e == nil {
+		return 0, false, nil
+	}
+	if e.expiresAt == 0 {
+		return 0, true, nil
+	}
+	return time.Duration(e.expiresAt-s.clock.Now().UnixMilli()) * time.Millisecond, true, nil
+}
+
+// scan returns every stored key with the prefix, including expired keys.
+func (s *Storage) scan(ctx context.Context) (map[string]*entry, error) {
+	start, end := s.bounds()
+	it, err := s.db.NewIterWithContext(ctx, &pebble.IterOptions{LowerBound: start, UpperBound: end})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	entries := make(map[string]*entry)
+	for it.First(); it.Valid(); it.Next() {
+		key := string(it.Key()[len(start):])
+		e, err := decode(it.Value())
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode the key %q from pebble: %w", key, err)
+		}
+		entries[key] = e
+	}
+	return entries, it.Error()
+}
+
+// Scan calls fn for every idempotency key and its status.
+func (s *Storage) Scan(ctx context.Context, fn func(key string, status *idempotency.RequestStatus) error) error {
+	// Read all entries first, as fn may use the storage.
+	entries, err := s.scan(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to scan keys in pebble: %w", err)
+	}
+
+	now := s.clock.Now()
+	for key, e := range entries {
+		if e.expired(now) {
+			continue
+		}
+		if err := fn(key, e.status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Purge removes expired idempotency keys, which Pebble does not remove by
+// itself, and returns how many were removed. The range of the keys is
+// compacted afterwards, so that the deleted keys do not take space until
+// Pebble compacts them by itself. Purge is meant to be run periodically,
+// e.g. by a ticker.
+func (s *Storage) Purge(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	entries, err := s.scan(ctx)
+	if err != nil {
+		s.mu.Unlock()
+		return 0, fmt.Errorf("failed to purge keys in pebble: %w", err)
+	}
+
+	now := s.clock.Now()
+	b := s.db.NewBatch()
+	n := 0
+	for key, e := range entries {
+		if !e.expired(now) {
+			continue
+		}
+		if err := b.Delete([]byte(s.prefix+key), nil); err != nil {
+			s.mu.Unlock()
+			return 0, fmt.Errorf("failed to purge keys in pebble: %w", err)
+		}
+		n++
+	}
+	err = b.Commit(s.write)
+	s.mu.Unlock()
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge keys in pebble: %w", err)
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	start, end := s.bounds()
+	if err := s.db.Compact(ctx, start, end, true); err != nil {
+		return n, fmt.Errorf("failed to compact keys in pebble: %w", err)
+	}
+	return n, nil
+}
+
+// Ping checks that the database is usable by reading a key.
+func (s *Storage) Ping(ctx context.Context) error {
+	_, closer, err := s.db.Get([]byte(s.prefix))
+	if err != nil && !errors.Is(err, pebble.ErrNotFound) {
+		return fmt.Errorf("failed to ping pebble: %w", err)
+	}
+	if closer != nil {
+		closer.Close()
+	}
+	return nil
+}
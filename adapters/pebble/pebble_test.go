@@ -0,0 +1,66 @@
+package pebble
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/v2"
+	"github.com/cockroachdb/pebble/v2/vfs"
+
+	"github.com/Preciselyco/idempotency"
+	"github.com/Preciselyco/idempotency/storagetest"
+)
+
+func open(t *testing.T) *pebble.DB {
+	db, err := pebble.Open("", &pebble.Options{FS: vfs.NewMem()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestStorage(t *testing.T) {
+	var clock *idempotency.FakeClock
+	storagetest.RunConformanceTests(t, func(t *testing.T) idempotency.Storage {
+		clock = idempotency.NewFakeClock(time.Now())
+		return New(open(t), time.Hour, WithClock(clock), WithSync(false))
+	}, storagetest.WithSleep(func(d time.Duration) {
+		clock.Advance(d)
+	}))
+}
+
+func TestPurgeKeepsOtherKeys(t *testing.T) {
+	ctx := context.Background()
+	db := open(t)
+	if err := db.Set([]byte("other"), []byte("value"), pebble.Sync); err != nil {
+		t.Fatal(err)
+	}
+
+	clock := idempotency.NewFakeClock(time.Now())
+	s := New(db, time.Minute, WithClock(clock))
+	if _, err := s.Add(ctx, "expired"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * time.Minute)
+	if _, err := s.Add(ctx, "live"); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.Purge(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("want 1 purged key, got %d", n)
+	}
+	if status, err := s.Get(ctx, "live"); err != nil || status == nil {
+		t.Errorf("want live key, got %v, %v", status, err)
+	}
+	if _, closer, err := db.Get([]byte("other")); err != nil {
+		t.Errorf("want other key, got %v", err)
+	} else {
+		closer.Close()
+	}
+}
@@ -0,0 +1,63 @@
+// Package pubsub deduplicates messages received from Google Cloud Pub/Sub,
+// so that messages redelivered to any of several horizontally scaled
+// subscribers are only processed once.
+package pubsub
+
+import (
+	"context"
+	"errors"
+
+	"cloud.google.com/go/pubsub/v2"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// KeyFunc derives the idempotency key of a message, an empty key processes
+// the message without deduplication.
+type KeyFunc func(msg *pubsub.Message) string
+
+// MessageIDKey derives keys from the ID assigned to the message by Pub/Sub.
+func MessageIDKey(msg *pubsub.Message) string {
+	return msg.ID
+}
+
+// AttributeKey derives keys from the attribute name set by the publisher,
+// which also deduplicates messages published more than once.
+func AttributeKey(name string) KeyFunc {
+	return func(msg *pubsub.Message) string {
+		return msg.Attributes[name]
+	}
+}
+
+// Receive wraps fn as a callback for Subscriber.Receive that runs fn once per
+// key. Messages are acknowledged once processed, or directly when already
+// processed by an earlier delivery, and negatively acknowledged for
+// redelivery when fn fails or the key is in process by another subscriber.
+// Messages whose attempts are exhausted, see idempotency.WithMaxAttempts, are
+// acknowledged after passing the error to onError.
+// Errors from fn are passed to onError, if not nil.
+func Receive(s *idempotency.State, keyFunc KeyFunc, fn func(ctx context.Context, msg *pubsub.Message) error, onError func(msg *pubsub.Message, err error)) func(ctx context.Context, msg *pubsub.Message) {
+	return func(ctx context.Context, msg *pubsub.Message) {
+		var err error
+		if key := keyFunc(msg); key != "" {
+			_, _, err = idempotency.Do(ctx, s, "pubsub:"+key, func(ctx context.Context) (struct{}, error) {
+				return struct{}{}, fn(ctx, msg)
+			})
+		} else {
+			err = fn(ctx, msg)
+		}
+
+		if err != nil {
+			if onError != nil && !errors.Is(err, idempotency.ErrInProcess) {
+				onError(msg, err)
+			}
+			// Redelivering would only replay the failure of exhausted
+			// attempts.
+			if !errors.Is(err, idempotency.ErrAttemptsExhausted) {
+				msg.Nack()
+				return
+			}
+		}
+		msg.Ack()
+	}
+}
@@ -0,0 +1,43 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/pubsub/v2"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+func TestReceive(t *testing.T) {
+	s := idempotency.New(idempotency.NewMemoryStorage())
+
+	calls := 0
+	var errs []error
+	receive := Receive(s, AttributeKey("idempotency-key"), func(ctx context.Context, msg *pubsub.Message) error {
+		calls++
+		if string(msg.Data) == "fail" {
+			return errors.New("failure")
+		}
+		return nil
+	}, func(msg *pubsub.Message, err error) {
+		errs = append(errs, err)
+	})
+
+	messages := []*pubsub.Message{
+		{ID: "1", Data: []byte("ok"), Attributes: map[string]string{"idempotency-key": "a"}},
+		{ID: "2", Data: []byte("ok"), Attributes: map[string]string{"idempotency-key": "a"}},
+		{ID: "3", Data: []byte("fail"), Attributes: map[string]string{"idempotency-key": "b"}},
+	}
+	for _, msg := range messages {
+		receive(context.Background(), msg)
+	}
+
+	if calls != 2 {
+		t.Errorf("want 2 calls, got %d", calls)
+	}
+	if len(errs) != 1 {
+		t.Errorf("want 1 error, got %v", errs)
+	}
+}
@@ -0,0 +1,134 @@
+// Package rabbitmq deduplicates deliveries consumed with amqp091-go, so that
+// messages redelivered after a consumer crashed before acknowledging them
+// are only processed once.
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// KeyFunc derives the idempotency key of a delivery, an empty key processes
+// the delivery without deduplication.
+type KeyFunc func(d amqp.Delivery) string
+
+// MessageIDKey derives keys from the message-id property.
+func MessageIDKey(d amqp.Delivery) string {
+	return d.MessageId
+}
+
+// CorrelationIDKey derives keys from the correlation-id property.
+func CorrelationIDKey(d amqp.Delivery) string {
+	return d.CorrelationId
+}
+
+// Option is the functional option signature for configuring the handler.
+type Option func(*handler)
+
+type handler struct {
+	state      *idempotency.State
+	keyFunc    KeyFunc
+	fn         func(ctx context.Context, d amqp.Delivery) error
+	onError    func(d amqp.Delivery, err error)
+	retryDelay time.Duration
+}
+
+// WithErrorHandler configures a function called with errors from processing
+// and acknowledging deliveries, which are otherwise dropped.
+func WithErrorHandler(f func(d amqp.Delivery, err error)) Option {
+	return func(h *handler) {
+		h.onError = f
+	}
+}
+
+// WithRetryDelay configures how long the handler waits before requeueing a
+// delivery whose key is in process by another consumer, so that it is not
+// redelivered in a tight loop, it defaults to one second. The wait blocks the
+// handler, so consumers should use a prefetch count above one.
+func WithRetryDelay(d time.Duration) Option {
+	return func(h *handler) {
+		h.retryDelay = d
+	}
+}
+
+// Handler returns a function running fn once per key of the deliveries
+// passed to it, meant to be called for each delivery of a consumer with
+// manual acknowledgement. Deliveries are acknowledged once processed, or
+// directly when already processed by an earlier delivery. Deliveries whose
+// processing failed are requeued, and deliveries whose key is in process by
+// another consumer are requeued after the retry delay. Deliveries whose
+// attempts are exhausted, see idempotency.WithMaxAttempts, are rejected
+// without requeueing, to the dead letter exchange of the queue if it has one.
+func Handler(ctx context.Context, s *idempotency.State, keyFunc KeyFunc, fn func(ctx context.Context, d amqp.Delivery) error, opts ...Option) func(d amqp.Delivery) {
+	h := &handler{
+		state:      s,
+		keyFunc:    keyFunc,
+		fn:         fn,
+		onError:    func(d amqp.Delivery, err error) {},
+		retryDelay: time.Second,
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(h)
+		}
+	}
+
+	return func(d amqp.Delivery) {
+		h.handle(ctx, d)
+	}
+}
+
+func (h *handler) handle(ctx context.Context, d amqp.Delivery) {
+	var err error
+	if key := h.keyFunc(d); key != "" {
+		_, _, err = idempotency.Do(ctx, h.state, "amqp:"+d.RoutingKey+":"+key, func(ctx context.Context) (struct{}, error) {
+			return struct{}{}, h.fn(ctx, d)
+		})
+	} else {
+		err = h.fn(ctx, d)
+	}
+
+	switch {
+	case errors.Is(err, idempotency.ErrInProcess):
+		h.wait(ctx)
+		h.ack(d, d.Nack(false, true))
+	case errors.Is(err, idempotency.ErrAttemptsExhausted):
+		// Redelivering would only replay the failure, so the delivery is
+		// rejected to the dead letter exchange of the queue, if any.
+		h.onError(d, err)
+		h.ack(d, d.Nack(false, false))
+	case err != nil:
+		h.onError(d, err)
+		h.ack(d, d.Nack(false, true))
+	default:
+		h.ack(d, d.Ack(false))
+	}
+}
+
+// wait waits for the retry delay or until ctx is done.
+func (h *handler) wait(ctx context.Context) {
+	if h.retryDelay <= 0 {
+		return
+	}
+
+	t := time.NewTimer(h.retryDelay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// ack reports errors from acknowledging d.
+func (h *handler) ack(d amqp.Delivery, err error) {
+	if err != nil {
+		h.onError(d, fmt.Errorf("could not acknowledge delivery: %w", err))
+	}
+}
@@ -0,0 +1,109 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+type acknowledger struct {
+	acks, nacks, rejects int
+}
+
+func (a *acknowledger) Ack(tag uint64, multiple bool) error {
+	a.acks++
+	return nil
+}
+
+func (a *acknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	if !requeue {
+		a.rejects++
+		return nil
+	}
+	a.nacks++
+	return nil
+}
+
+func (a *acknowledger) Reject(tag uint64, requeue bool) error {
+	return nil
+}
+
+func TestHandler(t *testing.T) {
+	s := idempotency.New(idempotency.NewMemoryStorage())
+	ack := &acknowledger{}
+
+	calls := 0
+	fail := true
+	h := Handler(context.Background(), s, MessageIDKey, func(ctx context.Context, d amqp.Delivery) error {
+		calls++
+		if fail {
+			fail = false
+			return errors.New("failure")
+		}
+		return nil
+	})
+
+	for i := 0; i < 3; i++ {
+		h(amqp.Delivery{Acknowledger: ack, MessageId: "deadbeef"})
+	}
+
+	// The failed delivery is requeued and processed again, the last delivery
+	// is a duplicate.
+	if calls != 2 {
+		t.Errorf("want 2 calls, got %d", calls)
+	}
+	if ack.acks != 2 || ack.nacks != 1 {
+		t.Errorf("want 2 acks and 1 nack, got %d and %d", ack.acks, ack.nacks)
+	}
+}
+
+func TestHandlerAttemptsExhausted(t *testing.T) {
+	s := idempotency.New(idempotency.NewMemoryStorage(), idempotency.WithMaxAttempts(1, time.Hour))
+	ack := &acknowledger{}
+
+	var errs []error
+	h := Handler(context.Background(), s, MessageIDKey, func(ctx context.Context, d amqp.Delivery) error {
+		return errors.New("poison")
+	}, WithErrorHandler(func(d amqp.Delivery, err error) {
+		errs = append(errs, err)
+	}))
+
+	for i := 0; i < 2; i++ {
+		h(amqp.Delivery{Acknowledger: ack, MessageId: "deadbeef"})
+	}
+
+	// The redelivery is rejected to the dead letter exchange.
+	if ack.nacks != 1 || ack.rejects != 1 {
+		t.Errorf("want 1 requeue and 1 rejection, got %d and %d", ack.nacks, ack.rejects)
+	}
+	if len(errs) != 2 || !errors.Is(errs[1], idempotency.ErrAttemptsExhausted) {
+		t.Errorf("want attempts exhausted reported, got %v", errs)
+	}
+}
+
+func TestHandlerInProcess(t *testing.T) {
+	s := idempotency.New(idempotency.NewMemoryStorage())
+	if _, err := s.Reserve(context.Background(), "amqp:orders:deadbeef", ""); err != nil {
+		t.Fatal(err)
+	}
+	ack := &acknowledger{}
+
+	h := Handler(context.Background(), s, MessageIDKey, func(ctx context.Context, d amqp.Delivery) error {
+		return nil
+	}, WithRetryDelay(50*time.Millisecond))
+
+	start := time.Now()
+	h(amqp.Delivery{Acknowledger: ack, MessageId: "deadbeef", RoutingKey: "orders"})
+
+	if ack.nacks != 1 {
+		t.Errorf("want delivery requeued, got %d nacks", ack.nacks)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("want requeue delayed, got %v", elapsed)
+	}
+}
@@ -0,0 +1,113 @@
+// Package redsync provides an idempotency.Locker using Redlock distributed
+// locks from github.com/go-redsync/redsync, so that a key in process is
+// owned by the replica processing it.
+package redsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redsync/redsync/v4"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// Option is the functional option signature for configuring the Locker.
+type Option func(*Locker)
+
+// Locker is an idempotency.Locker backed by redsync.
+type Locker struct {
+	rs     *redsync.Redsync
+	prefix string
+	opts   []redsync.Option
+}
+
+// WithKeyPrefix configures the prefix of the lock names, it defaults to
+// "idemp-lock:".
+func WithKeyPrefix(prefix string) Option {
+	return func(l *Locker) {
+		l.prefix = prefix
+	}
+}
+
+// WithExpiry configures after how long a lock expires unless it is released
+// or extended, it should exceed the processing time of requests. It defaults
+// to the expiry of redsync.
+func WithExpiry(expiry time.Duration) Option {
+	return func(l *Locker) {
+		l.opts = append(l.opts, redsync.WithExpiry(expiry))
+	}
+}
+
+// WithMutexOptions configures additional options of the redsync mutexes.
+func WithMutexOptions(opts ...redsync.Option) Option {
+	return func(l *Locker) {
+		l.opts = append(l.opts, opts...)
+	}
+}
+
+// New creates a Locker locking keys with rs.
+func New(rs *redsync.Redsync, opts ...Option) *Locker {
+	l := &Locker{
+		rs:     rs,
+		prefix: "idemp-lock:",
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(l)
+		}
+	}
+
+	return l
+}
+
+// TryLock implements idempotency.Locker.
+func (l *Locker) TryLock(ctx context.Context, key string) (idempotency.Lock, error) {
+	m := l.rs.NewMutex(l.prefix+key, l.opts...)
+
+	err := m.TryLockContext(ctx)
+	var taken *redsync.ErrTaken
+	if errors.As(err, &taken) || errors.Is(err, redsync.ErrFailed) {
+		return nil, idempotency.ErrLocked
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock the key %q: %w", key, err)
+	}
+
+	return &lock{mutex: m}, nil
+}
+
+// lock is a held redsync mutex.
+type lock struct {
+	mutex *redsync.Mutex
+}
+
+// Unlock implements idempotency.Lock, it returns
+// idempotency.ErrReservationLost if the lock expired and was taken by
+// another owner, which is left untouched.
+func (l *lock) Unlock(ctx context.Context) error {
+	_, err := l.mutex.UnlockContext(ctx)
+	var taken *redsync.ErrTaken
+	if errors.As(err, &taken) {
+		return idempotency.ErrReservationLost
+	}
+	if err != nil && !errors.Is(err, redsync.ErrLockAlreadyExpired) {
+		return fmt.Errorf("failed to unlock the key %q: %w", l.mutex.Name(), err)
+	}
+	return nil
+}
+
+// Extend extends the lock by its expiry.
+func (l *lock) Extend(ctx context.Context) error {
+	ok, err := l.mutex.ExtendContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to extend the lock %q: %w", l.mutex.Name(), err)
+	}
+	if !ok {
+		return idempotency.ErrReservationLost
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package redsync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redsync/redsync/v4"
+	"github.com/go-redsync/redsync/v4/redis/goredis/v9"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+func TestLocker(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	locker := New(redsync.New(goredis.NewPool(client)), WithExpiry(time.Minute))
+
+	lock, err := locker.TryLock(ctx, "key")
+	if err != nil {
+		t.Fatalf("want lock, got %v", err)
+	}
+	if _, err := locker.TryLock(ctx, "key"); !errors.Is(err, idempotency.ErrLocked) {
+		t.Errorf("want %v, got %v", idempotency.ErrLocked, err)
+	}
+
+	if err := lock.Unlock(ctx); err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+
+	lock, err = locker.TryLock(ctx, "key")
+	if err != nil {
+		t.Fatalf("want lock after unlock, got %v", err)
+	}
+
+	// Locks expire when the replica holding them dies.
+	mr.FastForward(2 * time.Minute)
+	if _, err := locker.TryLock(ctx, "key"); err != nil {
+		t.Errorf("want lock after expiry, got %v", err)
+	}
+	if err := lock.Unlock(ctx); !errors.Is(err, idempotency.ErrReservationLost) {
+		t.Errorf("want %v for a lock taken by another owner, got %v", idempotency.ErrReservationLost, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	s := idempotency.New(idempotency.NewRedisStorage(client, time.Hour),
+		idempotency.WithLocker(New(redsync.New(goredis.NewPool(client)))),
+	)
+
+	d, err := s.Reserve(ctx, "key", "")
+	if err != nil || d.Outcome != idempotency.OutcomeNew {
+		t.Fatalf("want new key, got %+v, %v", d, err)
+	}
+	if d, _ := s.Reserve(ctx, "key", ""); d.Outcome != idempotency.OutcomeInProcess {
+		t.Errorf("want key in process, got %v", d.Outcome)
+	}
+	if err := s.Finish(ctx, "key", nil); err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	if d, _ := s.Reserve(ctx, "key", ""); d.Outcome != idempotency.OutcomeCompleted {
+		t.Errorf("want key completed, got %v", d.Outcome)
+	}
+}
@@ -0,0 +1,96 @@
+// Package sqs deduplicates messages received from Amazon SQS with
+// aws-sdk-go-v2, so that messages delivered more than once, e.g. after a
+// visibility timeout expired, are only processed once.
+//
+// Messages are processed with idempotency.Do using a key derived from the
+// message. When a visibility timeout expires while the first delivery is
+// still processed the redelivery returns idempotency.ErrInProcess and is
+// left on the queue, so the TTL of the State should exceed the visibility
+// timeout.
+package sqs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// KeyFunc derives the idempotency key of a message, an empty key processes
+// the message without deduplication.
+type KeyFunc func(m types.Message) string
+
+// MessageIDKey derives keys from the MessageId of the message.
+func MessageIDKey(m types.Message) string {
+	if m.MessageId == nil {
+		return ""
+	}
+	return *m.MessageId
+}
+
+// DeduplicationIDKey derives keys from the MessageDeduplicationId of messages
+// received from FIFO queues, falling back to the MessageId. The attribute must
+// be requested when receiving messages.
+func DeduplicationIDKey(m types.Message) string {
+	if id := m.Attributes[string(types.MessageSystemAttributeNameMessageDeduplicationId)]; id != "" {
+		return id
+	}
+	return MessageIDKey(m)
+}
+
+// Handler wraps fn, processing messages once per key. A nil error means the
+// message was processed, now or by an earlier delivery, and can be deleted.
+func Handler(s *idempotency.State, keyFunc KeyFunc, fn func(ctx context.Context, m types.Message) error) func(ctx context.Context, m types.Message) error {
+	return func(ctx context.Context, m types.Message) error {
+		key := keyFunc(m)
+		if key == "" {
+			return fn(ctx, m)
+		}
+
+		_, _, err := idempotency.Do(ctx, s, "sqs:"+key, func(ctx context.Context) (struct{}, error) {
+			return struct{}{}, fn(ctx, m)
+		})
+		return err
+	}
+}
+
+// DeleteMessageAPI is the part of *sqs.Client used to delete messages.
+type DeleteMessageAPI interface {
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+}
+
+// Process handles the messages of a receive from queueURL with handler and
+// deletes the ones that were processed. Messages whose key is in process are
+// left to be redelivered, and the errors of failed messages are returned.
+// Messages whose attempts are exhausted, see idempotency.WithMaxAttempts, are
+// deleted, as a redelivery would only return the same error.
+func Process(ctx context.Context, client DeleteMessageAPI, queueURL string, messages []types.Message, handler func(ctx context.Context, m types.Message) error) error {
+	var errs []error
+	for _, m := range messages {
+		err := handler(ctx, m)
+		if errors.Is(err, idempotency.ErrInProcess) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not process message %s: %w", MessageIDKey(m), err))
+			// Redelivering would only replay the failure of exhausted
+			// attempts.
+			if !errors.Is(err, idempotency.ErrAttemptsExhausted) {
+				continue
+			}
+		}
+
+		_, err = client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+			QueueUrl:      &queueURL,
+			ReceiptHandle: m.ReceiptHandle,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not delete message %s: %w", MessageIDKey(m), err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,70 @@
+package sqs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+type fakeClient struct {
+	deleted []string
+}
+
+func (c *fakeClient) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	c.deleted = append(c.deleted, *params.ReceiptHandle)
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func message(id, receipt string) types.Message {
+	return types.Message{MessageId: &id, ReceiptHandle: &receipt}
+}
+
+func TestProcess(t *testing.T) {
+	s := idempotency.New(idempotency.NewMemoryStorage())
+	client := &fakeClient{}
+
+	calls := 0
+	handler := Handler(s, MessageIDKey, func(ctx context.Context, m types.Message) error {
+		calls++
+		return nil
+	})
+
+	// The second message is a redelivery of the first one.
+	messages := []types.Message{message("a", "r1"), message("a", "r2"), message("b", "r3")}
+	if err := Process(context.Background(), client, "https://sqs/queue", messages, handler); err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+
+	if calls != 2 {
+		t.Errorf("want 2 calls, got %d", calls)
+	}
+	if len(client.deleted) != 3 {
+		t.Errorf("want all 3 messages deleted, got %v", client.deleted)
+	}
+}
+
+func TestProcessAttemptsExhausted(t *testing.T) {
+	s := idempotency.New(idempotency.NewMemoryStorage(), idempotency.WithMaxAttempts(1, time.Hour))
+	client := &fakeClient{}
+
+	handler := Handler(s, MessageIDKey, func(ctx context.Context, m types.Message) error {
+		return errors.New("poison")
+	})
+
+	// The redelivery of the poison message is deleted as its attempts are
+	// exhausted.
+	messages := []types.Message{message("a", "r1"), message("a", "r2")}
+	err := Process(context.Background(), client, "https://sqs/queue", messages, handler)
+	if !errors.Is(err, idempotency.ErrAttemptsExhausted) {
+		t.Fatalf("want attempts exhausted, got %v", err)
+	}
+	if len(client.deleted) != 1 || client.deleted[0] != "r2" {
+		t.Errorf("want exhausted message deleted, got %v", client.deleted)
+	}
+}
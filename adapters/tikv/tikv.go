@@ -0,0 +1,406 @@
+// Package tikv provides an idempotency storage in TiKV, using its
+// transactional API so that keys are reserved exactly once across the
+// cluster, e.g. for services in the TiDB ecosystem.
+//
+// TiKV transactions do not expire keys, so the expiry is stored with every
+// key and expired keys are ignored, and removed with Purge.
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	tikverr "github.com/tikv/client-go/v2/error"
+	"github.com/tikv/client-go/v2/txnkv"
+	"github.com/tikv/client-go/v2/txnkv/transaction"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// maxConflicts is how often a transaction is retried on write conflicts.
+const maxConflicts = 5
+
+// Option is the functional option signature for configuring the Storage.
+type Option func(*Storage)
+
+// WithKeyPrefix configures the prefix of the keys in TiKV, it defaults to
+// "idempotency:".
+func WithKeyPrefix(prefix string) Option {
+	return func(s *Storage) {
+		s.prefix = prefix
+	}
+}
+
+// WithClock configures the Clock of expiries, it defaults to
+// idempotency.SystemClock.
+func WithClock(c idempotency.Clock) Option {
+	return func(s *Storage) {
+		s.clock = c
+	}
+}
+
+// Storage is an idempotency.Reserver storing keys in TiKV. It also
+// implements idempotency.Deleter, idempotency.Expirer,
+// idempotency.TTLReader, idempotency.Scanner, idempotency.Purger and
+// idempotency.Pinger.
+type Storage struct {
+	client *txnkv.Client
+	expiry time.Duration
+	prefix string
+	clock  idempotency.Clock
+}
+
+// New creates a Storage with client, expiring keys after expiry.
+func New(client *txnkv.Client, expiry time.Duration, opts ...Option) *Storage {
+	s := &Storage{
+		client: client,
+		expiry: expiry,
+		prefix: "idempotency:",
+		clock:  idempotency.SystemClock,
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(s)
+		}
+	}
+
+	return s
+}
+
+// entry is a stored key, encoded as its expiry in Unix milliseconds, zero if
+// it does not expire, followed by its status.
+type entry struct {
+	expiresAt int64
+	status    *idempotency.RequestStatus
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return e.expiresAt != 0 && e.expiresAt <= now.UnixMilli()
+}
+
+// statusOrNil returns the status of e, which may be nil.
+func (e *entry) statusOrNil() *idempotency.RequestStatus {
+	if e == nil {
+		return nil
+	}
+	return e.status
+}
+
+func encode(e *entry) ([]byte, error) {
+	status, err := idempotency.MarshalStatus(e.status)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 8, 8+len(status))
+	binary.BigEndian.PutUint64(b, uint64(e.expiresAt))
+	return append(b, status...), nil
+}
+
+func decode(b []byte) (*entry, error) {
+	if len(b) < 8 {
+		return nil, errors.New("value too short")
+	}
+	status, err := idempotency.UnmarshalStatus(b[8:])
+	if err != nil {
+		return nil, err
+	}
+	return &entry{expiresAt: int64(binary.BigEndian.Uint64(b)), status: status}, nil
+}
+
+// expiresAt returns the expiry of a key written now with expiry, or the
+// expiry of the storage if zero.
+func (s *Storage) expiresAt(expiry time.Duration) int64 {
+	if expiry == 0 {
+		expiry = s.expiry
+	}
+	if expiry <= 0 {
+		return 0
+	}
+	return s.clock.Now().Add(expiry).UnixMilli()
+}
+
+// get reads the live entry of key in txn, it is nil if the key does not
+// exist or is expired.
+func (s *Storage) get(ctx context.Context, txn *transaction.KVTxn, key string) (*entry, error) {
+	v, err := txn.Get(ctx, []byte(s.prefix+key))
+	if tikverr.IsErrNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := decode(v.Value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the key %q from tikv: %w", key, err)
+	}
+	if e.expired(s.clock.Now()) {
+		return nil, nil
+	}
+	return e, nil
+}
+
+// update runs fn in a transaction and commits it, retrying on write
+// conflicts with another transaction.
+func (s *Storage) update(ctx context.Context, fn func(txn *transaction.KVTxn) error) error {
+	for attempt := 0; ; attempt++ {
+		txn, err := s.client.Begin()
+		if err != nil {
+			return err
+		}
+		if err := fn(txn); err != nil {
+			txn.Rollback()
+			return err
+		}
+		err = txn.Commit(ctx)
+		if err == nil || !tikverr.IsErrWriteConflict(err) || attempt >= maxConflicts {
+			return err
+		}
+	}
+}
+
+// set writes e as key in txn.
+func (s *Storage) set(txn *transaction.KVTxn, key string, e *entry) error {
+	v, err := encode(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode the status of key %q: %w", key, err)
+	}
+	return txn.Set([]byte(s.prefix+key), v)
+}
+
+// Add inserts the initial state of a request with an idempotency key.
+func (s *Storage) Add(ctx context.Context, key string) (bool, error) {
+	return s.AddStatus(ctx, key, &idempotency.RequestStatus{InProcess: true}, 0)
+}
+
+// AddStatus inserts status for an idempotency key, expiring it after expiry
+// or the expiry of the storage if zero.
+func (s *Storage) AddStatus(ctx context.Context, key string, status *idempotency.RequestStatus, expiry time.Duration) (bool, error) {
+	_, added, err := s.AddOrGet(ctx, key, status, expiry)
+	return added, err
+}
+
+// AddOrGet inserts status for an idempotency key or returns its existing
+// status, in a transaction.
+func (s *Storage) AddOrGet(ctx context.Context, key string, status *idempotency.RequestStatus, expiry time.Duration) (*idempotency.RequestStatus, bool, error) {
+	var existing *idempotency.RequestStatus
+	err := s.update(ctx, func(txn *transaction.KVTxn) error {
+		// The transaction is retried on conflicts, existing is of the last
+		// attempt.
+		existing = nil
+		e, err := s.get(ctx, txn, key)
+		if err != nil {
+			return err
+		}
+		if e != nil {
+			existing = e.status
+			return nil
+		}
+		return s.set(txn, key, &entry{expiresAt: s.expiresAt(expiry), status: status})
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to insert the key %q in tikv: %w", key, err)
+	}
+	return existing, existing == nil, nil
+}
+
+// Get fetches the RequestStatus for an idempotency key.
+func (s *Storage) Get(ctx context.Context, key string) (*idempotency.RequestStatus, error) {
+	txn, err := s.client.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the key %q from tikv: %w", key, err)
+	}
+	defer txn.Rollback()
+
+	e, err := s.get(ctx, txn, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the key %q from tikv: %w", key, err)
+	}
+	return e.statusOrNil(), nil
+}
+
+// Complete sets a request to not be in progress.
+func (s *Storage) Complete(ctx context.Context, key string) error {
+	return s.UpdateStatus(ctx, key, &idempotency.RequestStatus{})
+}
+
+// UpdateStatus replaces the RequestStatus of an idempotency key, keeping its
+// expiry.
+func (s *Storage) UpdateStatus(ctx context.Context, key string, status *idempotency.RequestStatus) error {
+	err := s.update(ctx, func(txn *transaction.KVTxn) error {
+		e, err := s.get(ctx, txn, key)
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			e = &entry{expiresAt: s.expiresAt(0)}
+		}
+		e.status = status
+		return s.set(txn, key, e)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update the key %q in tikv: %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes an idempotency key.
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	err := s.update(ctx, func(txn *transaction.KVTxn) error {
+		return txn.Delete([]byte(s.prefix + key))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete the key %q from tikv: %w", key, err)
+	}
+	return nil
+}
+
+// Expire sets an idempotency key to expire after expiry.
+func (s *Storage) Expire(ctx context.Context, key string, expiry time.Duration) error {
+	err := s.update(ctx, func(txn *transaction.KVTxn) error {
+		e, err := s.get(ctx, txn, key)
+		if err != nil || e == nil {
+			return err
+		}
+		e.expiresAt = s.clock.Now().Add(expiry).UnixMilli()
+		return s.set(txn, key, e)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to expire the key %q in tikv: %w", key, err)
+	}
+	return nil
+}
+
+// TTL returns the remaining time until an idempotency key expires.
+func (s *Storage) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	txn, err := s.client.Begin()
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get the expiry of key %q from tikv: %w", key, err)
+	}
+	defer txn.Rollback()
+
+	e, err := s.get(ctx, txn, key)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get the expiry of key %q from tikv: %w", key, err)
+	}
+	if e == nil {
+		return 0, false, nil
+	}
+	if e.expiresAt == 0 {
+		return 0, true, nil
+	}
+	return time.Duration(e.expiresAt-s.clock.Now().UnixMilli()) * time.Millisecond, true, nil
+}
+
+// scan calls fn for every stored key with the prefix, including expired
+// keys.
+func (s *Storage) scan(ctx context.Context, fn func(key string, e *entry) error) error {
+	txn, err := s.client.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	start := []byte(s.prefix)
+	end := append(bytes.Clone(start), 0xff)
+	it, err := txn.Iter(start, end)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	// Read all entries first, as fn may use the storage.
+	entries := make(map[string]*entry)
+	for it.Valid() {
+		key := string(it.Key()[len(start):])
+		e, err := decode(it.Value())
+		if err != nil {
+			return fmt.Errorf("failed to decode the key %q from tikv: %w", key, err)
+		}
+		entries[key] = e
+		if err := it.Next(); err != nil {
+			return err
+		}
+	}
+
+	for key, e := range entries {
+		if err := fn(key, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Scan calls fn for every idempotency key and its status.
+func (s *Storage) Scan(ctx context.Context, fn func(key string, status *idempotency.RequestStatus) error) error {
+	now := s.clock.Now()
+	err := s.scan(ctx, func(key string, e *entry) error {
+		if e.expired(now) {
+			return nil
+		}
+		return fn(key, e.status)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan keys in tikv: %w", err)
+	}
+	return nil
+}
+
+// Purge removes expired idempotency keys, which TiKV does not remove by
+// itself, and returns how many were removed.
+func (s *Storage) Purge(ctx context.Context) (int, error) {
+	now := s.clock.Now()
+	var expired []string
+	err := s.scan(ctx, func(key string, e *entry) error {
+		if e.expired(now) {
+			expired = append(expired, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge keys in tikv: %w", err)
+	}
+
+	n := 0
+	for _, key := range expired {
+		// The transaction may be retried, so the key is counted once it
+		// committed.
+		var deleted bool
+		err := s.update(ctx, func(txn *transaction.KVTxn) error {
+			deleted = false
+			// The key may have been reserved again since the scan.
+			v, err := txn.Get(ctx, []byte(s.prefix+key))
+			if tikverr.IsErrNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if e, err := decode(v.Value); err == nil && !e.expired(now) {
+				return nil
+			}
+			deleted = true
+			return txn.Delete([]byte(s.prefix + key))
+		})
+		if err != nil {
+			return n, fmt.Errorf("failed to purge keys in tikv: %w", err)
+		}
+		if deleted {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Ping checks that the cluster is reachable by getting a timestamp.
+func (s *Storage) Ping(ctx context.Context) error {
+	if _, err := s.client.GetTimestamp(ctx); err != nil {
+		return fmt.Errorf("failed to ping tikv: %w", err)
+	}
+	return nil
+}
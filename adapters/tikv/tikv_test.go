@@ -0,0 +1,34 @@
+package tikv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tikv/client-go/v2/testutils"
+	tikvstore "github.com/tikv/client-go/v2/tikv"
+	"github.com/tikv/client-go/v2/txnkv"
+
+	"github.com/Preciselyco/idempotency"
+	"github.com/Preciselyco/idempotency/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	var clock *idempotency.FakeClock
+	storagetest.RunConformanceTests(t, func(t *testing.T) idempotency.Storage {
+		client, cluster, pdClient, err := testutils.NewMockTiKV("", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutils.BootstrapWithSingleStore(cluster)
+		store, err := tikvstore.NewTestTiKVStore(client, pdClient, nil, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+
+		clock = idempotency.NewFakeClock(time.Now())
+		return New(&txnkv.Client{KVStore: store}, time.Hour, WithClock(clock))
+	}, storagetest.WithSleep(func(d time.Duration) {
+		clock.Advance(d)
+	}))
+}
@@ -0,0 +1,182 @@
+// Package webhook deduplicates inbound webhook deliveries. Providers retry
+// deliveries they consider failed, so the same event can arrive several
+// times. The key is derived from the event ID of the provider instead of an
+// Idempotency-Key header.
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// Extractor derives the idempotency key of a delivery.
+type Extractor func(r *http.Request) (string, error)
+
+// Header returns an Extractor using the value of the header name.
+func Header(name string) Extractor {
+	return func(r *http.Request) (string, error) {
+		key := r.Header.Get(name)
+		if key == "" {
+			return "", fmt.Errorf("no %s header set", name)
+		}
+		return key, nil
+	}
+}
+
+// GitHub returns an Extractor for GitHub deliveries, using the
+// X-GitHub-Delivery header.
+func GitHub() Extractor {
+	return prefixed("github:", Header("X-GitHub-Delivery"))
+}
+
+// Shopify returns an Extractor for Shopify deliveries, using the
+// X-Shopify-Webhook-Id header.
+func Shopify() Extractor {
+	return prefixed("shopify:", Header("X-Shopify-Webhook-Id"))
+}
+
+// Stripe returns an Extractor for Stripe deliveries, using the id of the
+// event in the body.
+func Stripe() Extractor {
+	return prefixed("stripe:", func(r *http.Request) (string, error) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return "", fmt.Errorf("could not read body: %w", err)
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var event struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(body, &event); err != nil {
+			return "", fmt.Errorf("could not decode event: %w", err)
+		}
+		if event.ID == "" {
+			return "", errors.New("no event id set")
+		}
+		return event.ID, nil
+	})
+}
+
+func prefixed(prefix string, extract Extractor) Extractor {
+	return func(r *http.Request) (string, error) {
+		key, err := extract(r)
+		if err != nil {
+			return "", err
+		}
+		return prefix + key, nil
+	}
+}
+
+// statusWriter records the status code written by a handler.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Option is the functional option signature for configuring the handler.
+type Option func(*handler)
+
+type handler struct {
+	onError func(r *http.Request, err error)
+}
+
+// WithErrorHandler configures a function called with errors from completing
+// and releasing the keys of deliveries, which are otherwise dropped.
+func WithErrorHandler(f func(r *http.Request, err error)) Option {
+	return func(h *handler) {
+		h.onError = f
+	}
+}
+
+// Handler returns a handler passing each event to next once. Duplicate
+// deliveries of processed events are acknowledged with a 200 OK so that the
+// provider stops retrying, and deliveries of an event in process get a 409
+// Conflict. When next responds with a server error the key is released so
+// that the retry of the provider is processed again. When the key cannot be
+// completed the delivery is answered with a 500 Internal Server Error, if
+// next did not respond yet. Events are stored under their ID prefixed by
+// webhook:, hashed with idempotency.WithKeyHashing, and reserved IDs are
+// answered with a 400 Bad Request.
+func Handler(s *idempotency.State, extract Extractor, next http.Handler, opts ...Option) http.Handler {
+	h := &handler{
+		onError: func(r *http.Request, err error) {},
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(h)
+		}
+	}
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		eventID, err := extract(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not get event id: %v", err), http.StatusBadRequest)
+			return
+		}
+		if idempotency.IsReservedKey(eventID) {
+			http.Error(w, fmt.Sprintf("event id %q is reserved", eventID), http.StatusBadRequest)
+			return
+		}
+		// Events are kept apart from the keys of other requests in storage.
+		key := s.HashKey("webhook:" + eventID)
+
+		d, err := s.Reserve(ctx, key, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		switch d.Outcome {
+		case idempotency.OutcomeInProcess:
+			http.Error(w, "event already in progress", http.StatusConflict)
+			return
+		case idempotency.OutcomeCompleted:
+			w.Header().Set(idempotency.ReplayedHeaderName, "true")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(idempotency.NewContext(ctx, eventID)))
+
+		if sw.status >= http.StatusInternalServerError {
+			if err := s.Fail(ctx, key); err != nil {
+				h.onError(r, fmt.Errorf("could not release event: %w", err))
+			}
+			return
+		}
+		if err := s.Finish(ctx, key, nil); err != nil {
+			h.onError(r, fmt.Errorf("could not complete event: %w", err))
+			if sw.status == 0 {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+		}
+	}
+
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,119 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+func TestHandler(t *testing.T) {
+	s := idempotency.New(idempotency.NewMemoryStorage())
+
+	calls := 0
+	fail := true
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if fail {
+			fail = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	tests := []struct {
+		name           string
+		extractor      Extractor
+		header         string
+		body           string
+		wantHTTPStatus int
+	}{
+		{name: "Failed delivery", extractor: Stripe(), body: `{"id":"evt_1"}`, wantHTTPStatus: http.StatusServiceUnavailable},
+		{name: "Retried delivery is processed", extractor: Stripe(), body: `{"id":"evt_1"}`, wantHTTPStatus: http.StatusAccepted},
+		{name: "Duplicate delivery is acknowledged", extractor: Stripe(), body: `{"id":"evt_1"}`, wantHTTPStatus: http.StatusOK},
+		{name: "Missing event id", extractor: Stripe(), body: `{}`, wantHTTPStatus: http.StatusBadRequest},
+		{name: "GitHub delivery", extractor: GitHub(), header: "X-GitHub-Delivery", wantHTTPStatus: http.StatusAccepted},
+		{name: "Duplicate GitHub delivery", extractor: GitHub(), header: "X-GitHub-Delivery", wantHTTPStatus: http.StatusOK},
+		{name: "Shopify delivery with the same id", extractor: Shopify(), header: "X-Shopify-Webhook-Id", wantHTTPStatus: http.StatusAccepted},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://example.com/hooks", strings.NewReader(test.body))
+			if test.header != "" {
+				req.Header.Set(test.header, "deadbeef")
+			}
+
+			w := httptest.NewRecorder()
+			Handler(s, test.extractor, next).ServeHTTP(w, req)
+
+			if w.Code != test.wantHTTPStatus {
+				t.Errorf("want status code %v, got %v", test.wantHTTPStatus, w.Code)
+			}
+		})
+	}
+
+	if calls != 4 {
+		t.Errorf("want 4 calls, got %d", calls)
+	}
+}
+
+// failingStorage fails to complete keys.
+type failingStorage struct {
+	idempotency.Storage
+}
+
+func (failingStorage) Complete(ctx context.Context, key string) error {
+	return errors.New("unavailable")
+}
+
+func TestHandlerCompleteError(t *testing.T) {
+	s := idempotency.New(failingStorage{idempotency.NewMemoryStorage()})
+
+	var errs []error
+	onError := WithErrorHandler(func(r *http.Request, err error) {
+		errs = append(errs, err)
+	})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest("POST", "http://example.com/hooks", nil)
+	req.Header.Set("X-GitHub-Delivery", "deadbeef")
+	w := httptest.NewRecorder()
+	Handler(s, GitHub(), next, onError).ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("want status code %v, got %v", http.StatusInternalServerError, w.Code)
+	}
+	if len(errs) != 1 {
+		t.Errorf("want the error reported, got %v", errs)
+	}
+}
+
+func TestHandlerKeys(t *testing.T) {
+	storage := idempotency.NewMemoryStorage()
+	s := idempotency.New(storage, idempotency.WithKeyHashing([]byte("secret")))
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	for id, want := range map[string]int{"evt_1": http.StatusOK, "_idempotency:abuse:client": http.StatusBadRequest} {
+		req := httptest.NewRequest("POST", "http://example.com/hooks", nil)
+		req.Header.Set("X-Event-Id", id)
+		w := httptest.NewRecorder()
+		Handler(s, Header("X-Event-Id"), next).ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("want status code %v for %q, got %v", want, id, w.Code)
+		}
+	}
+
+	ctx := context.Background()
+	if status, _ := storage.Get(ctx, s.HashKey("webhook:evt_1")); status == nil {
+		t.Error("want the event stored by its hashed key")
+	}
+	if status, _ := storage.Get(ctx, "evt_1"); status != nil {
+		t.Error("want no raw event id stored")
+	}
+}
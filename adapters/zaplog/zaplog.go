@@ -0,0 +1,27 @@
+// Package zaplog adapts a zap logger to idempotency.Logger.
+package zaplog
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+type logger struct {
+	l *zap.SugaredLogger
+}
+
+// New returns an idempotency.Logger logging to l.
+func New(l *zap.Logger) idempotency.Logger {
+	return logger{l: l.Sugar()}
+}
+
+// Debug implements idempotency.Logger.
+func (l logger) Debug(msg string, args ...any) {
+	l.l.Debugw(msg, args...)
+}
+
+// Error implements idempotency.Logger.
+func (l logger) Error(msg string, args ...any) {
+	l.l.Errorw(msg, args...)
+}
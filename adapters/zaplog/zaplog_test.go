@@ -0,0 +1,30 @@
+package zaplog
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	l := New(zap.New(core))
+
+	l.Debug("verified", "key", "a")
+	l.Error("failed", "key", "b")
+
+	entries := logs.AllUntimed()
+	if len(entries) != 2 {
+		t.Fatalf("want 2 entries, got %d", len(entries))
+	}
+	for i, want := range []struct {
+		level zapcore.Level
+		key   string
+	}{{zapcore.DebugLevel, "a"}, {zapcore.ErrorLevel, "b"}} {
+		if entries[i].Level != want.level || entries[i].ContextMap()["key"] != want.key {
+			t.Errorf("want %v entry with key %s, got %+v", want.level, want.key, entries[i])
+		}
+	}
+}
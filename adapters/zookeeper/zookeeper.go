@@ -0,0 +1,457 @@
+// Package zookeeper provides an idempotency storage in ZooKeeper, e.g. for
+// services next to JVM systems with an existing ZooKeeper ensemble.
+//
+// Every key is a node below a root node. Keys in process are ephemeral
+// nodes, which ZooKeeper removes when the session of the instance that
+// reserved them is lost, so that their requests can be retried. Completed
+// keys are persistent nodes. Changes are made with the version of the node
+// that was read, so that concurrent changes of a key conflict instead of
+// overwriting each other.
+//
+// ZooKeeper does not expire persistent nodes, so the expiry is stored with
+// every key and expired keys are ignored, and removed with Purge.
+package zookeeper
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// maxConflicts is how often a change is retried on conflicts.
+const maxConflicts = 5
+
+// Conn is the part of *zk.Conn used by the Storage.
+type Conn interface {
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+	Get(path string) ([]byte, *zk.Stat, error)
+	Set(path string, data []byte, version int32) (*zk.Stat, error)
+	Delete(path string, version int32) error
+	Exists(path string) (bool, *zk.Stat, error)
+	Children(path string) ([]string, *zk.Stat, error)
+	Multi(ops ...any) ([]zk.MultiResponse, error)
+}
+
+// Option is the functional option signature for configuring the Storage.
+type Option func(*Storage)
+
+// WithRoot configures the node below which keys are stored, it defaults to
+// "/idempotency". It is created when it does not exist.
+func WithRoot(root string) Option {
+	return func(s *Storage) {
+		s.root = strings.TrimSuffix(root, "/")
+	}
+}
+
+// WithACL configures the ACL of the created nodes, it defaults to
+// zk.WorldACL(zk.PermAll).
+func WithACL(acl []zk.ACL) Option {
+	return func(s *Storage) {
+		s.acl = acl
+	}
+}
+
+// WithClock configures the Clock of expiries, it defaults to
+// idempotency.SystemClock.
+func WithClock(c idempotency.Clock) Option {
+	return func(s *Storage) {
+		s.clock = c
+	}
+}
+
+// Storage is an idempotency.Reserver storing keys in ZooKeeper. It also
+// implements idempotency.Deleter, idempotency.Expirer,
+// idempotency.TTLReader, idempotency.Scanner, idempotency.Purger and
+// idempotency.Pinger.
+type Storage struct {
+	conn   Conn
+	expiry time.Duration
+	root   string
+	acl    []zk.ACL
+	clock  idempotency.Clock
+}
+
+// New creates a Storage with conn, usually a *zk.Conn, expiring keys after
+// expiry.
+func New(conn Conn, expiry time.Duration, opts ...Option) *Storage {
+	s := &Storage{
+		conn:   conn,
+		expiry: expiry,
+		root:   "/idempotency",
+		acl:    zk.WorldACL(zk.PermAll),
+		clock:  idempotency.SystemClock,
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(s)
+		}
+	}
+
+	return s
+}
+
+// entry is a stored key, encoded as its expiry in Unix milliseconds, zero if
+// it does not expire, followed by its status.
+type entry struct {
+	expiresAt int64
+	status    *idempotency.RequestStatus
+	// version and ephemeral are of the node the entry was read from.
+	version   int32
+	ephemeral bool
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return e.expiresAt != 0 && e.expiresAt <= now.UnixMilli()
+}
+
+func encode(e *entry) ([]byte, error) {
+	status, err := idempotency.MarshalStatus(e.status)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 8, 8+len(status))
+	binary.BigEndian.PutUint64(b, uint64(e.expiresAt))
+	return append(b, status...), nil
+}
+
+func decode(b []byte) (*entry, error) {
+	if len(b) < 8 {
+		return nil, errors.New("value too short")
+	}
+	status, err := idempotency.UnmarshalStatus(b[8:])
+	if err != nil {
+		return nil, err
+	}
+	return &entry{expiresAt: int64(binary.BigEndian.Uint64(b)), status: status}, nil
+}
+
+// flags returns the flags of the node of status, ephemeral while it is in
+// process.
+func flags(status *idempotency.RequestStatus) int32 {
+	if status.InProcess {
+		return zk.FlagEphemeral
+	}
+	return 0
+}
+
+// expiresAt returns the expiry of a key written now with expiry, or the
+// expiry of the storage if zero.
+func (s *Storage) expiresAt(expiry time.Duration) int64 {
+	if expiry == 0 {
+		expiry = s.expiry
+	}
+	if expiry <= 0 {
+		return 0
+	}
+	return s.clock.Now().Add(expiry).UnixMilli()
+}
+
+// path returns the path of the node of key, which is escaped as node names
+// cannot contain slashes.
+func (s *Storage) path(key string) string {
+	return s.root + "/" + url.PathEscape(key)
+}
+
+// get reads the entry of key, it is nil if the key does not exist. Expired
+// entries are returned, as their version is needed to replace them.
+func (s *Storage) get(key string) (*entry, error) {
+	b, stat, err := s.conn.Get(s.path(key))
+	if errors.Is(err, zk.ErrNoNode) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := decode(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the key %q from zookeeper: %w", key, err)
+	}
+	e.version = stat.Version
+	e.ephemeral = stat.EphemeralOwner != 0
+	return e, nil
+}
+
+// live reads the entry of key, it is nil if the key does not exist or is
+// expired.
+func (s *Storage) live(key string) (*entry, error) {
+	e, err := s.get(key)
+	if err != nil || e == nil || e.expired(s.clock.Now()) {
+		return nil, err
+	}
+	return e, nil
+}
+
+// create creates the node of key with e, and the root if it does not exist.
+func (s *Storage) create(key string, e *entry) error {
+	data, err := encode(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode the status of key %q: %w", key, err)
+	}
+
+	_, err = s.conn.Create(s.path(key), data, flags(e.status), s.acl)
+	if !errors.Is(err, zk.ErrNoNode) {
+		return err
+	}
+	if err := s.createRoot(); err != nil {
+		return err
+	}
+	_, err = s.conn.Create(s.path(key), data, flags(e.status), s.acl)
+	return err
+}
+
+// createRoot creates the root node and its parents.
+func (s *Storage) createRoot() error {
+	path := ""
+	for _, name := range strings.Split(strings.TrimPrefix(s.root, "/"), "/") {
+		path += "/" + name
+		_, err := s.conn.Create(path, nil, 0, s.acl)
+		if err != nil && !errors.Is(err, zk.ErrNodeExists) {
+			return fmt.Errorf("failed to create %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// replace replaces the node of old, read from key, with e. The node is
+// recreated in a transaction when it changes between ephemeral and
+// persistent.
+func (s *Storage) replace(key string, old, e *entry) error {
+	data, err := encode(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode the status of key %q: %w", key, err)
+	}
+
+	if old.ephemeral == e.status.InProcess {
+		_, err = s.conn.Set(s.path(key), data, old.version)
+		return err
+	}
+	_, err = s.conn.Multi(
+		&zk.DeleteRequest{Path: s.path(key), Version: old.version},
+		&zk.CreateRequest{Path: s.path(key), Data: data, Acl: s.acl, Flags: flags(e.status)},
+	)
+	return err
+}
+
+// conflict reports whether err is caused by a concurrent change of a node.
+func conflict(err error) bool {
+	return errors.Is(err, zk.ErrBadVersion) || errors.Is(err, zk.ErrNodeExists) || errors.Is(err, zk.ErrNoNode)
+}
+
+// update reads the entry of key and calls fn with it, which may be nil, to
+// change it. fn returns nil to keep the entry. The change is retried on
+// conflicts.
+func (s *Storage) update(key string, fn func(e *entry) (*entry, error)) error {
+	for attempt := 0; ; attempt++ {
+		old, err := s.get(key)
+		if err != nil {
+			return err
+		}
+		e, err := fn(old)
+		if err != nil || e == nil {
+			return err
+		}
+		if old == nil {
+			err = s.create(key, e)
+		} else {
+			err = s.replace(key, old, e)
+		}
+		if !conflict(err) || attempt >= maxConflicts {
+			return err
+		}
+	}
+}
+
+// Add inserts the initial state of a request with an idempotency key.
+func (s *Storage) Add(ctx context.Context, key string) (bool, error) {
+	return s.AddStatus(ctx, key, &idempotency.RequestStatus{InProcess: true}, 0)
+}
+
+// AddStatus inserts status for an idempotency key, expiring it after expiry
+// or the expiry of the storage if zero.
+func (s *Storage) AddStatus(ctx context.Context, key string, status *idempotency.RequestStatus, expiry time.Duration) (bool, error) {
+	_, added, err := s.AddOrGet(ctx, key, status, expiry)
+	return added, err
+}
+
+// AddOrGet inserts status for an idempotency key or returns its existing
+// status. A status in process is inserted as an ephemeral node.
+func (s *Storage) AddOrGet(ctx context.Context, key string, status *idempotency.RequestStatus, expiry time.Duration) (*idempotency.RequestStatus, bool, error) {
+	var existing *idempotency.RequestStatus
+	err := s.update(key, func(e *entry) (*entry, error) {
+		// The change is retried on conflicts, existing is of the last
+		// attempt.
+		existing = nil
+		if e != nil && !e.expired(s.clock.Now()) {
+			existing = e.status
+			return nil, nil
+		}
+		return &entry{expiresAt: s.expiresAt(expiry), status: status}, nil
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to insert the key %q in zookeeper: %w", key, err)
+	}
+	return existing, existing == nil, nil
+}
+
+// Get fetches the RequestStatus for an idempotency key.
+func (s *Storage) Get(ctx context.Context, key string) (*idempotency.RequestStatus, error) {
+	e, err := s.live(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the key %q from zookeeper: %w", key, err)
+	}
+	if e == nil {
+		return nil, nil
+	}
+	return e.status, nil
+}
+
+// Complete sets a request to not be in progress.
+func (s *Storage) Complete(ctx context.Context, key string) error {
+	return s.UpdateStatus(ctx, key, &idempotency.RequestStatus{})
+}
+
+// UpdateStatus replaces the RequestStatus of an idempotency key, keeping its
+// expiry. A completed key is stored as a persistent node.
+func (s *Storage) UpdateStatus(ctx context.Context, key string, status *idempotency.RequestStatus) error {
+	err := s.update(key, func(e *entry) (*entry, error) {
+		if e == nil || e.expired(s.clock.Now()) {
+			return &entry{expiresAt: s.expiresAt(0), status: status}, nil
+		}
+		return &entry{expiresAt: e.expiresAt, status: status}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update the key %q in zookeeper: %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes an idempotency key.
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	err := s.conn.Delete(s.path(key), -1)
+	if err != nil && !errors.Is(err, zk.ErrNoNode) {
+		return fmt.Errorf("failed to delete the key %q from zookeeper: %w", key, err)
+	}
+	return nil
+}
+
+// Expire sets an idempotency key to expire after expiry.
+func (s *Storage) Expire(ctx context.Context, key string, expiry time.Duration) error {
+	err := s.update(key, func(e *entry) (*entry, error) {
+		if e == nil || e.expired(s.clock.Now()) {
+			return nil, nil
+		}
+		return &entry{expiresAt: s.clock.Now().Add(expiry).UnixMilli(), status: e.status}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to expire the key %q in zookeeper: %w", key, err)
+	}
+	return nil
+}
+
+// TTL returns the remaining time until an idempotency key expires.
+func (s *Storage) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	e, err := s.live(key)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get the expiry of key %q from zookeeper: %w", key, err)
+	}
+	if e == nil {
+		return 0, false, nil
+	}
+	if e.expiresAt == 0 {
+		return 0, true, nil
+	}
+	return time.Duration(e.expiresAt-s.clock.Now().UnixMilli()) * time.Millisecond, true, nil
+}
+
+// scan returns every stored key, including expired keys.
+func (s *Storage) scan() (map[string]*entry, error) {
+	names, _, err := s.conn.Children(s.root)
+	if errors.Is(err, zk.ErrNoNode) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]*entry, len(names))
+	for _, name := range names {
+		key, err := url.PathUnescape(name)
+		if err != nil {
+			continue
+		}
+		e, err := s.get(key)
+		if err != nil {
+			return nil, err
+		}
+		// The key may have been removed since the children were listed.
+		if e != nil {
+			entries[key] = e
+		}
+	}
+	return entries, nil
+}
+
+// Scan calls fn for every idempotency key and its status.
+func (s *Storage) Scan(ctx context.Context, fn func(key string, status *idempotency.RequestStatus) error) error {
+	// Read all entries first, as fn may use the storage.
+	entries, err := s.scan()
+	if err != nil {
+		return fmt.Errorf("failed to scan keys in zookeeper: %w", err)
+	}
+
+	now := s.clock.Now()
+	for key, e := range entries {
+		if e.expired(now) {
+			continue
+		}
+		if err := fn(key, e.status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Purge removes expired idempotency keys, which ZooKeeper does not remove by
+// itself, and returns how many were removed. Keys changed since they were
+// read, e.g. as they were reserved again, conflict and are kept.
+func (s *Storage) Purge(ctx context.Context) (int, error) {
+	entries, err := s.scan()
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge keys in zookeeper: %w", err)
+	}
+
+	now := s.clock.Now()
+	n := 0
+	for key, e := range entries {
+		if !e.expired(now) {
+			continue
+		}
+		err := s.conn.Delete(s.path(key), e.version)
+		if conflict(err) {
+			continue
+		}
+		if err != nil {
+			return n, fmt.Errorf("failed to purge keys in zookeeper: %w", err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Ping checks that ZooKeeper is reachable by checking that the root exists.
+func (s *Storage) Ping(ctx context.Context) error {
+	if _, _, err := s.conn.Exists(s.root); err != nil {
+		return fmt.Errorf("failed to ping zookeeper: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package zookeeper
+
+import (
+	"context"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+
+	"github.com/Preciselyco/idempotency"
+	"github.com/Preciselyco/idempotency/storagetest"
+)
+
+var _ Conn = (*zk.Conn)(nil)
+
+// fakeNode is a node of fakeConn.
+type fakeNode struct {
+	data      []byte
+	version   int32
+	ephemeral bool
+}
+
+// fakeConn is a ZooKeeper with a single session.
+type fakeConn struct {
+	mu    sync.Mutex
+	nodes map[string]*fakeNode
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{nodes: map[string]*fakeNode{"/": {}}}
+}
+
+// expireSession removes the ephemeral nodes, like ZooKeeper when the session
+// is lost.
+func (c *fakeConn) expireSession() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for p, n := range c.nodes {
+		if n.ephemeral {
+			delete(c.nodes, p)
+		}
+	}
+}
+
+func (c *fakeConn) stat(n *fakeNode) *zk.Stat {
+	stat := &zk.Stat{Version: n.version}
+	if n.ephemeral {
+		stat.EphemeralOwner = 1
+	}
+	return stat
+}
+
+func (c *fakeConn) create(p string, data []byte, flags int32) error {
+	if _, ok := c.nodes[p]; ok {
+		return zk.ErrNodeExists
+	}
+	if parent := c.nodes[path.Dir(p)]; parent == nil || parent.ephemeral {
+		return zk.ErrNoNode
+	}
+	c.nodes[p] = &fakeNode{data: data, ephemeral: flags&zk.FlagEphemeral != 0}
+	return nil
+}
+
+func (c *fakeConn) delete(p string, version int32) error {
+	n, ok := c.nodes[p]
+	if !ok {
+		return zk.ErrNoNode
+	}
+	if version != -1 && version != n.version {
+		return zk.ErrBadVersion
+	}
+	delete(c.nodes, p)
+	return nil
+}
+
+func (c *fakeConn) Create(p string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return p, c.create(p, data, flags)
+}
+
+func (c *fakeConn) Get(p string) ([]byte, *zk.Stat, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, ok := c.nodes[p]
+	if !ok {
+		return nil, nil, zk.ErrNoNode
+	}
+	return n.data, c.stat(n), nil
+}
+
+func (c *fakeConn) Set(p string, data []byte, version int32) (*zk.Stat, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, ok := c.nodes[p]
+	if !ok {
+		return nil, zk.ErrNoNode
+	}
+	if version != -1 && version != n.version {
+		return nil, zk.ErrBadVersion
+	}
+	n.data = data
+	n.version++
+	return c.stat(n), nil
+}
+
+func (c *fakeConn) Delete(p string, version int32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.delete(p, version)
+}
+
+func (c *fakeConn) Exists(p string) (bool, *zk.Stat, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, ok := c.nodes[p]
+	if !ok {
+		return false, nil, nil
+	}
+	return true, c.stat(n), nil
+}
+
+func (c *fakeConn) Children(p string) ([]string, *zk.Stat, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, ok := c.nodes[p]
+	if !ok {
+		return nil, nil, zk.ErrNoNode
+	}
+	var children []string
+	for child := range c.nodes {
+		if child != "/" && path.Dir(child) == p {
+			children = append(children, strings.TrimPrefix(child, p+"/"))
+		}
+	}
+	return children, c.stat(n), nil
+}
+
+// Multi supports a delete followed by a create, which is what the Storage
+// uses.
+func (c *fakeConn) Multi(ops ...any) ([]zk.MultiResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	del, create := ops[0].(*zk.DeleteRequest), ops[1].(*zk.CreateRequest)
+	n, ok := c.nodes[del.Path]
+	if !ok {
+		return nil, zk.ErrNoNode
+	}
+	if del.Version != n.version {
+		return nil, zk.ErrBadVersion
+	}
+	delete(c.nodes, del.Path)
+	if err := c.create(create.Path, create.Data, create.Flags); err != nil {
+		c.nodes[del.Path] = n
+		return nil, err
+	}
+	return make([]zk.MultiResponse, 2), nil
+}
+
+func TestStorage(t *testing.T) {
+	var clock *idempotency.FakeClock
+	storagetest.RunConformanceTests(t, func(t *testing.T) idempotency.Storage {
+		clock = idempotency.NewFakeClock(time.Now())
+		return New(newFakeConn(), time.Hour, WithRoot("/services/idempotency"), WithClock(clock))
+	}, storagetest.WithSleep(func(d time.Duration) {
+		clock.Advance(d)
+	}))
+}
+
+func TestSessionLoss(t *testing.T) {
+	ctx := context.Background()
+	conn := newFakeConn()
+	s := New(conn, time.Hour)
+
+	for _, key := range []string{"completed", "in-process"} {
+		if added, err := s.Add(ctx, key); err != nil || !added {
+			t.Fatalf("want %s key added, got %v, %v", key, added, err)
+		}
+	}
+	if err := s.Complete(ctx, "completed"); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.expireSession()
+
+	if status, err := s.Get(ctx, "completed"); err != nil || status == nil || status.InProcess {
+		t.Errorf("want completed key kept, got %+v, %v", status, err)
+	}
+	if added, err := s.Add(ctx, "in-process"); err != nil || !added {
+		t.Errorf("want key in process released with the session, got %v, %v", added, err)
+	}
+}
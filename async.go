@@ -0,0 +1,95 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+)
+
+// asyncCompletion completes keys in the background after their responses
+// have been sent.
+type asyncCompletion struct {
+	queue   chan asyncJob
+	onError func(key string, err error)
+
+	start  sync.Once
+	done   chan struct{}
+	mu     sync.RWMutex
+	closed bool
+}
+
+type asyncJob struct {
+	key      string
+	complete func() error
+}
+
+// WithAsyncCompletion completes keys, including storing captured responses,
+// in the background after the handler returned, so that the storage latency
+// is not added to the response. Up to queueSize completions are queued, keys
+// are completed synchronously when the queue is full. Errors of background
+// completions are passed to onError, which may be nil. Drain must be called
+// on shutdown so that queued completions are not lost. The queue is shared by
+// states created with With.
+func WithAsyncCompletion(queueSize int, onError func(key string, err error)) Option {
+	return func(s *State) {
+		s.async = &asyncCompletion{
+			queue:   make(chan asyncJob, queueSize),
+			onError: onError,
+			done:    make(chan struct{}),
+		}
+	}
+}
+
+// enqueue queues complete for key and reports whether it was queued.
+func (a *asyncCompletion) enqueue(key string, complete func() error) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.closed {
+		return false
+	}
+	a.start.Do(a.run)
+
+	select {
+	case a.queue <- asyncJob{key: key, complete: complete}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *asyncCompletion) run() {
+	go func() {
+		defer close(a.done)
+
+		for job := range a.queue {
+			if err := job.complete(); err != nil && a.onError != nil {
+				a.onError(job.key, err)
+			}
+		}
+	}()
+}
+
+// Drain waits until the queued completions of WithAsyncCompletion are
+// written, or until ctx is done. Keys are completed synchronously after Drain
+// has been called.
+func (s *State) Drain(ctx context.Context) error {
+	a := s.async
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+	a.start.Do(a.run)
+
+	select {
+	case <-a.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,80 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type blockingStorage struct {
+	*memoryStorage
+	release chan struct{}
+	err     error
+}
+
+func (b *blockingStorage) UpdateStatus(ctx context.Context, key string, status *RequestStatus) error {
+	<-b.release
+	if b.err != nil {
+		return b.err
+	}
+	return b.memoryStorage.UpdateStatus(ctx, key, status)
+}
+
+func TestAsyncCompletion(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		wantErr bool
+	}{
+		{name: "Completed in the background"},
+		{name: "Error is reported", err: errors.New("write failed"), wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage := &blockingStorage{memoryStorage: NewMemoryStorage(), release: make(chan struct{}), err: test.err}
+
+			var mu sync.Mutex
+			var errs []error
+			s := New(storage, WithResponseCapture(true), WithAsyncCompletion(1, func(key string, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				errs = append(errs, err)
+			}))
+			handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("done"))
+			}))
+
+			// The response is sent while the completion is blocked.
+			req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+			req.Header.Set(HeaderName, "key")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != http.StatusOK || w.Body.String() != "done" {
+				t.Fatalf("want response before completion, got %v %q", w.Code, w.Body.String())
+			}
+			if d, _ := s.Check(context.Background(), "key", ""); d.Outcome != OutcomeInProcess {
+				t.Errorf("want key in process before completion, got %v", d.Outcome)
+			}
+
+			close(storage.release)
+			if err := s.Drain(context.Background()); err != nil {
+				t.Fatalf("want drained, got %v", err)
+			}
+
+			wantOutcome := OutcomeCompleted
+			if test.wantErr {
+				wantOutcome = OutcomeInProcess
+			}
+			if d, _ := s.Check(context.Background(), "key", ""); d.Outcome != wantOutcome {
+				t.Errorf("want %v after drain, got %v", wantOutcome, d.Outcome)
+			}
+			if got := len(errs) > 0; got != test.wantErr {
+				t.Errorf("want error reported %v, got %v", test.wantErr, errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package idempotency
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"sync/atomic"
+)
+
+// attempt collects what the handler of a new request reports about its
+// result, see SkipStore and SetMetadata.
+type attempt struct {
+	state *State
+	// key is the storage key of the request.
+	key string
+
+	skipped atomic.Bool
+	// status is the status code of the response, set by Verify when it
+	// records the response.
+	status int
+	// failed is set by Verify for responses counted by WithMaxAttempts.
+	failed bool
+
+	mu          sync.Mutex
+	metadata    map[string]string
+	checkpoints checkpoints
+}
+
+// attemptContextKey defines which key to use for the attempt in
+// context.Context.
+const attemptContextKey contextKey = "idempotency-attempt"
+
+// withAttempt returns a new Context collecting the result of the handler for
+// key.
+func withAttempt(ctx context.Context, s *State, key string) (context.Context, *attempt) {
+	a := &attempt{state: s, key: key}
+	return context.WithValue(ctx, attemptContextKey, a), a
+}
+
+// SkipStore tells Verify or Do that the result of the current request must
+// not be stored and replayed, e.g. because it contains a one-time token. The
+// key is released instead of completed, so that a retry is processed again.
+// Releasing requires a storage implementing Deleter, otherwise the key is
+// completed without storing the response. It has no effect on contexts not
+// created by Verify or Do.
+func SkipStore(ctx context.Context) {
+	if a, ok := ctx.Value(attemptContextKey).(*attempt); ok {
+		a.skipped.Store(true)
+	}
+}
+
+// SetMetadata attaches a small value, e.g. the ID of a created order, to the
+// stored status of the current request, to be able to cross-reference it. It
+// is available in RequestStatus.Metadata to restorers and hooks of repeated
+// requests. It requires a storage implementing StatusStorage and has no
+// effect on contexts not created by Verify or Do.
+func SetMetadata(ctx context.Context, key, value string) {
+	a, ok := ctx.Value(attemptContextKey).(*attempt)
+	if !ok {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.metadata == nil {
+		a.metadata = make(map[string]string)
+	}
+	a.metadata[key] = value
+}
+
+// finish completes key with completed and the metadata of the attempt, or
+// releases it if the handler called SkipStore or the attempt failed.
+func (s *State) finish(ctx context.Context, key string, completed *RequestStatus, a *attempt) error {
+	a.mu.Lock()
+	if len(a.metadata) > 0 {
+		completed.Metadata = maps.Clone(a.metadata)
+	}
+	a.mu.Unlock()
+
+	if s.caps.Deleter && a.failed {
+		if a.skipped.Load() {
+			completed.Response = nil
+		}
+		return s.failAttempt(ctx, key, completed)
+	}
+
+	if !a.skipped.Load() {
+		var err error
+		if s.retention != nil && a.status != 0 {
+			err = s.retain(ctx, key, completed, s.retention(a.status))
+		} else {
+			err = s.complete(ctx, key, completed)
+		}
+		if err == nil {
+			s.clearCheckpoints(ctx, a)
+		}
+		return err
+	}
+
+	if s.caps.Deleter {
+		return s.Fail(ctx, key)
+	}
+	completed.Response = nil
+	return s.complete(ctx, key, completed)
+}
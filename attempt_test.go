@@ -0,0 +1,75 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSkipStore(t *testing.T) {
+	calls := 0
+	s := New(NewMemoryStorage(), WithResponseCapture(true))
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		SkipStore(r.Context())
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+		req.Header.Set(HeaderName, "key")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusCreated {
+			t.Errorf("want status code %v, got %v", http.StatusCreated, w.Code)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("want handler called twice, got %d", calls)
+	}
+
+	_, replayed, err := Do(context.Background(), s, "do", func(ctx context.Context) (int, error) {
+		SkipStore(ctx)
+		return 1, nil
+	})
+	if err != nil || replayed {
+		t.Fatalf("want result, got %v, %v", replayed, err)
+	}
+	if d, _ := s.Check(context.Background(), "do", ""); d.Outcome != OutcomeNew {
+		t.Errorf("want released key, got %v", d.Outcome)
+	}
+}
+
+func TestSetMetadata(t *testing.T) {
+	var restored, hooked string
+	s := New(NewMemoryStorage(),
+		WithRestorer(func(idempotencyKey string, w http.ResponseWriter, r *http.Request) {
+			status, _ := StatusFromContext(r.Context())
+			restored = status.Metadata["order_id"]
+		}),
+		WithHook(func(r *http.Request, e Event) {
+			if e.Outcome == OutcomeCompleted {
+				hooked = e.Metadata["order_id"]
+			}
+		}),
+	)
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetMetadata(r.Context(), "order_id", "42")
+	}))
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+		req.Header.Set(HeaderName, "key")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if restored != "42" {
+		t.Errorf("want metadata %q in restorer, got %q", "42", restored)
+	}
+	if hooked != "42" {
+		t.Errorf("want metadata %q in hook, got %q", "42", hooked)
+	}
+}
@@ -0,0 +1,55 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrAttemptsExhausted is returned by Do for a key whose work failed too
+// often, see WithMaxAttempts.
+var ErrAttemptsExhausted = errors.New("idempotency: too many failed attempts")
+
+// maxAttempts limits how often the work for a key may fail.
+type maxAttempts struct {
+	limit  int64
+	window time.Duration
+}
+
+// WithMaxAttempts limits how often a request may fail within window before
+// its key is failed permanently, so that a poison request does not run
+// expensive work forever. Verify counts responses with a 5xx status as
+// failed and releases their key for a retry, and Do counts errors of its
+// function. The last failure is stored with the key and replayed to further
+// requests. It requires a storage implementing Counter, StatusStorage and
+// Deleter.
+func WithMaxAttempts(limit int, window time.Duration) Option {
+	return func(s *State) {
+		s.attempts = &maxAttempts{limit: int64(limit), window: window}
+	}
+}
+
+// failAttempt releases key after a failed attempt, or completes it with the
+// failed status once the attempts are exhausted.
+func (s *State) failAttempt(ctx context.Context, key string, failed *RequestStatus) error {
+	if s.attempts != nil {
+		if counter, ok := s.storage.(Counter); ok && s.caps.Counter {
+			n, err := counter.Increment(ctx, internalKey("attempts", key), s.attempts.window)
+			if err != nil {
+				// Release the key anyway, so that the request stays
+				// retryable.
+				err = fmt.Errorf("could not count failed attempts: %w", err)
+				if ferr := s.Fail(ctx, key); ferr != nil {
+					err = errors.Join(err, ferr)
+				}
+				return err
+			}
+			if n >= s.attempts.limit {
+				failed.Failed = true
+				return s.complete(ctx, key, failed)
+			}
+		}
+	}
+	return s.Fail(ctx, key)
+}
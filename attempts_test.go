@@ -0,0 +1,103 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMaxAttempts(t *testing.T) {
+	tests := []struct {
+		name      string
+		capture   bool
+		status    int
+		wantCalls int
+	}{
+		{name: "Failures are retried until exhausted", status: http.StatusInternalServerError, wantCalls: 3},
+		{name: "Failures are retried with capture", capture: true, status: http.StatusBadGateway, wantCalls: 3},
+		{name: "Client errors are stored", status: http.StatusBadRequest, wantCalls: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			s := New(NewMemoryStorage(), WithResponseCapture(test.capture), WithMaxAttempts(3, time.Hour))
+			handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				http.Error(w, "failed", test.status)
+			}))
+
+			for i := 0; i < 5; i++ {
+				req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+				req.Header.Set(HeaderName, "key")
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+
+				if i >= test.wantCalls && test.status >= 500 && w.Code != test.status {
+					t.Errorf("request %d: want stored status code %v, got %v", i+1, test.status, w.Code)
+				}
+			}
+
+			if calls != test.wantCalls {
+				t.Errorf("want %d calls, got %d", test.wantCalls, calls)
+			}
+		})
+	}
+}
+
+func TestDoMaxAttempts(t *testing.T) {
+	ctx := context.Background()
+	s := New(NewMemoryStorage(), WithMaxAttempts(2, time.Hour))
+
+	calls := 0
+	fn := func(ctx context.Context) (int, error) {
+		calls++
+		return 0, errors.New("poison")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := Do(ctx, s, "key", fn); err == nil || errors.Is(err, ErrAttemptsExhausted) {
+			t.Fatalf("attempt %d: want error of fn, got %v", i+1, err)
+		}
+	}
+
+	_, restored, err := Do(ctx, s, "key", fn)
+	if !errors.Is(err, ErrAttemptsExhausted) || !restored {
+		t.Errorf("want ErrAttemptsExhausted, got %v, %v", restored, err)
+	}
+	if calls != 2 {
+		t.Errorf("want 2 calls, got %d", calls)
+	}
+}
+
+// failingCounter fails to count.
+type failingCounter struct {
+	*memoryStorage
+}
+
+func (failingCounter) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return 0, errors.New("unavailable")
+}
+
+func TestDoMaxAttemptsCounterError(t *testing.T) {
+	ctx := context.Background()
+	s := New(failingCounter{NewMemoryStorage()}, WithMaxAttempts(2, time.Hour))
+
+	calls := 0
+	fn := func(ctx context.Context) (int, error) {
+		calls++
+		return 0, errors.New("failed")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := Do(ctx, s, "key", fn); err == nil || errors.Is(err, ErrInProcess) {
+			t.Fatalf("attempt %d: want the key released, got %v", i+1, err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("want 2 calls, got %d", calls)
+	}
+}
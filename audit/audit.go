@@ -0,0 +1,283 @@
+// Package audit exports the decisions of the idempotency middleware as
+// structured events to a Sink, e.g. a file, a webhook or Kafka, so that it
+// can be proven that duplicate requests were not processed again.
+//
+// Events are collected by a hook registered with idempotency.WithHook, and
+// the transitions of keys, e.g. reserved and completed, by a hook registered
+// with idempotency.WithTransitionHook. They are written asynchronously in
+// batches, so that a slow sink does not slow down requests. Events are
+// dropped when the buffer is full.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// Event is an audit record of a request verified by the middleware, or of a
+// transition of a key, which has no request.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Key        string    `json:"key,omitempty"`
+	Client     string    `json:"client,omitempty"`
+	Method     string    `json:"method,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	Route      string    `json:"route,omitempty"`
+	Outcome    string    `json:"outcome,omitempty"`
+	Transition string    `json:"transition,omitempty"`
+	Shadow     bool      `json:"shadow,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Sink receives batches of events.
+type Sink interface {
+	Write(ctx context.Context, events []Event) error
+}
+
+// SinkFunc adapts a function to a Sink.
+type SinkFunc func(ctx context.Context, events []Event) error
+
+// Write calls f.
+func (f SinkFunc) Write(ctx context.Context, events []Event) error {
+	return f(ctx, events)
+}
+
+// Option is the functional option signature for configuring the Exporter.
+type Option func(*Exporter)
+
+// WithClient configures how the client of a request is identified, e.g. by
+// its API key.
+func WithClient(f func(r *http.Request) string) Option {
+	return func(e *Exporter) {
+		e.client = f
+	}
+}
+
+// WithBuffer configures how many events are buffered, it defaults to 10000.
+func WithBuffer(size int) Option {
+	return func(e *Exporter) {
+		e.size = size
+	}
+}
+
+// WithBatch configures the maximum number of events per write and how long
+// events are collected for a write, it defaults to 100 events and a second.
+func WithBatch(size int, interval time.Duration) Option {
+	return func(e *Exporter) {
+		e.batchSize = size
+		e.interval = interval
+	}
+}
+
+// WithErrorHandler configures a function receiving the errors of the sink,
+// failed batches are not retried.
+func WithErrorHandler(f func(err error)) Option {
+	return func(e *Exporter) {
+		e.onError = f
+	}
+}
+
+// Exporter writes the events of the middleware to a sink.
+type Exporter struct {
+	sink      Sink
+	client    func(r *http.Request) string
+	size      int
+	batchSize int
+	interval  time.Duration
+	onError   func(err error)
+
+	events  chan Event
+	mu      sync.RWMutex
+	closed  bool
+	done    chan struct{}
+	dropped atomic.Uint64
+}
+
+// New creates an Exporter writing to sink, which runs until Close.
+func New(sink Sink, opts ...Option) *Exporter {
+	e := &Exporter{
+		sink:      sink,
+		size:      10000,
+		batchSize: 100,
+		interval:  time.Second,
+		done:      make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(e)
+		}
+	}
+
+	e.events = make(chan Event, e.size)
+	go e.run()
+
+	return e
+}
+
+// Hook returns the hook collecting events, to be registered with
+// idempotency.WithHook.
+func (e *Exporter) Hook() func(r *http.Request, ev idempotency.Event) {
+	return func(r *http.Request, ev idempotency.Event) {
+		event := Event{
+			Time:    time.Now(),
+			Key:     ev.Key,
+			Method:  r.Method,
+			Path:    r.URL.Path,
+			Route:   r.Pattern,
+			Outcome: ev.Outcome.String(),
+			Shadow:  ev.Shadow,
+		}
+		if e.client != nil {
+			event.Client = e.client(r)
+		}
+		if ev.Err != nil {
+			event.Error = ev.Err.Error()
+		}
+		e.enqueue(event)
+	}
+}
+
+// TransitionHook returns the hook collecting the transitions of keys, e.g.
+// reserved and completed, to be registered with
+// idempotency.WithTransitionHook.
+func (e *Exporter) TransitionHook() func(ctx context.Context, t idempotency.Transition) {
+	return func(ctx context.Context, t idempotency.Transition) {
+		key, ok := idempotency.FromContext(ctx)
+		if !ok {
+			key = t.Key
+		}
+		e.enqueue(Event{Time: time.Now(), Key: key, Transition: t.Kind.String()})
+	}
+}
+
+func (e *Exporter) enqueue(event Event) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.closed {
+		e.dropped.Add(1)
+		return
+	}
+	select {
+	case e.events <- event:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of events dropped since the buffer was full.
+func (e *Exporter) Dropped() uint64 {
+	return e.dropped.Load()
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, e.batchSize)
+	for {
+		select {
+		case event, ok := <-e.events:
+			if !ok {
+				e.write(batch)
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) < e.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+
+		e.write(batch)
+		batch = batch[:0]
+	}
+}
+
+func (e *Exporter) write(batch []Event) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := e.sink.Write(context.Background(), batch); err != nil && e.onError != nil {
+		e.onError(fmt.Errorf("failed to write %d audit events: %w", len(batch), err))
+	}
+}
+
+// Close stops collecting events and waits until the buffered events are
+// written, or until ctx is done.
+func (e *Exporter) Close(ctx context.Context) error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.events)
+	}
+	e.mu.Unlock()
+
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WriterSink writes events as JSON lines to w, e.g. a file.
+func WriterSink(w io.Writer) Sink {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return SinkFunc(func(ctx context.Context, events []Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, event := range events {
+			if err := enc.Encode(event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WebhookSink posts batches of events as a JSON array to url with client,
+// which defaults to http.DefaultClient.
+func WebhookSink(url string, client *http.Client) Sink {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return SinkFunc(func(ctx context.Context, events []Event) error {
+		body, err := json.Marshal(events)
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("webhook responded with %s", resp.Status)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,62 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+func TestExporter(t *testing.T) {
+	var buf bytes.Buffer
+	exporter := New(WriterSink(&buf), WithClient(func(r *http.Request) string { return r.Header.Get("X-Client") }))
+
+	s := idempotency.New(idempotency.NewMemoryStorage(), idempotency.WithHook(exporter.Hook()))
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for range 2 {
+		req := httptest.NewRequest("POST", "http://example.com/orders", nil)
+		req.Header.Set(idempotency.HeaderName, "key")
+		req.Header.Set("X-Client", "alice")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if err := exporter.Close(context.Background()); err != nil {
+		t.Fatalf("want closed, got %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("want 2 events, got %q", buf.String())
+	}
+	for i, outcome := range []string{"new", "completed"} {
+		var event Event
+		if err := json.Unmarshal([]byte(lines[i]), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Outcome != outcome || event.Key != "key" || event.Client != "alice" || event.Path != "/orders" || event.Time.IsZero() {
+			t.Errorf("want %s event, got %+v", outcome, event)
+		}
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var got []Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+
+	events := []Event{{Time: time.Now(), Key: "a", Outcome: "mismatch"}}
+	if err := WebhookSink(server.URL, nil).Write(context.Background(), events); err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	if len(got) != 1 || got[0].Key != "a" {
+		t.Errorf("want events posted, got %+v", got)
+	}
+}
@@ -0,0 +1,32 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStreamSink appends events to the Redis Stream stream, with the JSON
+// encoded event in the field "event", so that other systems can consume the
+// events in order, e.g. with a consumer group. The stream is trimmed to
+// about maxLen entries, unless maxLen is zero.
+func RedisStreamSink(client redis.Cmdable, stream string, maxLen int64) Sink {
+	return SinkFunc(func(ctx context.Context, events []Event) error {
+		pipe := client.Pipeline()
+		for _, event := range events {
+			b, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: stream,
+				MaxLen: maxLen,
+				Approx: maxLen > 0,
+				Values: []any{"event", b},
+			})
+		}
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+}
@@ -0,0 +1,50 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+func TestRedisStreamSink(t *testing.T) {
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	defer client.Close()
+
+	exporter := New(RedisStreamSink(client, "idempotency", 1000))
+	s := idempotency.New(idempotency.NewMemoryStorage(), idempotency.WithTransitionHook(exporter.TransitionHook()))
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for range 2 {
+		req := httptest.NewRequest("POST", "http://example.com/orders", nil)
+		req.Header.Set(idempotency.HeaderName, "key")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if err := exporter.Close(ctx); err != nil {
+		t.Fatalf("want closed, got %v", err)
+	}
+
+	entries, err := client.XRange(ctx, "idempotency", "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"reserved", "completed", "replayed"}
+	if len(entries) != len(want) {
+		t.Fatalf("want %d entries, got %+v", len(want), entries)
+	}
+	for i, entry := range entries {
+		var event Event
+		if err := json.Unmarshal([]byte(entry.Values["event"].(string)), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Transition != want[i] || event.Key != "key" {
+			t.Errorf("want %s transition of key, got %+v", want[i], event)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+// ItemKey returns the sub-key of item of a batch request with key, e.g. its
+// index or an ID supplied by the caller for it. Sub-keys are reserved keys
+// prefixed by the length of key, so that they do not collide with the keys of
+// clients nor with the sub-keys of other batches.
+func ItemKey(key, item string) string {
+	return internalKey("item", strconv.Itoa(len(key))+":"+key+"#"+item)
+}
+
+// BatchResult is the result of an item of a batch, see DoBatch.
+type BatchResult[T any] struct {
+	Value T
+	// Replayed is true when the item was completed by a previous attempt of
+	// the batch and its result was restored from storage.
+	Replayed bool
+	Err      error
+}
+
+// DoItem runs fn once for item of the current request, like Do with the
+// sub-key of item, so that a retried batch only runs the items which did not
+// complete before. The key of the batch is the key of the request of ctx,
+// which was created by Verify or Do. Handlers using Verify should call
+// SkipStore when items failed, so that the batch can be retried.
+func DoItem[T any](ctx context.Context, s *State, item string, fn func(ctx context.Context) (T, error)) (T, bool, error) {
+	key, ok := batchKey(ctx)
+	if !ok {
+		var zero T
+		return zero, false, errors.New("idempotency: DoItem requires a context created by Verify or Do")
+	}
+	return do(ctx, s, ItemKey(key, item), fn)
+}
+
+// DoBatch runs fn once for every item of the batch with key, like Do with
+// the sub-keys of the items. Items are identified by itemKey, or by their
+// index if it is nil. Failed items are released, so that a retry of the
+// batch only runs them again and restores the results of the others.
+func DoBatch[I, T any](ctx context.Context, s *State, key string, items []I, itemKey func(i int, item I) string, fn func(ctx context.Context, item I) (T, error)) []BatchResult[T] {
+	results := make([]BatchResult[T], len(items))
+	for i, item := range items {
+		id := strconv.Itoa(i)
+		if itemKey != nil {
+			id = itemKey(i, item)
+		}
+
+		results[i].Value, results[i].Replayed, results[i].Err = do(ctx, s, ItemKey(key, id), func(ctx context.Context) (T, error) {
+			return fn(ctx, item)
+		})
+	}
+	return results
+}
+
+// batchKey returns the key of the request of ctx to derive sub-keys from,
+// the storage key for requests verified by Verify.
+func batchKey(ctx context.Context) (string, bool) {
+	if a, ok := ctx.Value(attemptContextKey).(*attempt); ok && a.key != "" {
+		return a.key, true
+	}
+	return FromContext(ctx)
+}
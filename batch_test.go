@@ -0,0 +1,103 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDoBatch(t *testing.T) {
+	ctx := context.Background()
+	s := New(NewMemoryStorage())
+
+	runs := make(map[string]int)
+	fail := map[string]bool{"b": true}
+	create := func(ctx context.Context, item string) (string, error) {
+		runs[item]++
+		if fail[item] {
+			return "", errors.New("failed")
+		}
+		return "created " + item, nil
+	}
+	byName := func(i int, item string) string { return item }
+
+	results := DoBatch(ctx, s, "batch", []string{"a", "b"}, byName, create)
+	if results[0].Err != nil || results[1].Err == nil {
+		t.Fatalf("want only b failed, got %+v", results)
+	}
+
+	// The retry only runs the failed item.
+	fail["b"] = false
+	results = DoBatch(ctx, s, "batch", []string{"a", "b"}, byName, create)
+	for i, want := range []BatchResult[string]{{Value: "created a", Replayed: true}, {Value: "created b"}} {
+		if results[i] != want {
+			t.Errorf("want result %+v, got %+v", want, results[i])
+		}
+	}
+	if runs["a"] != 1 || runs["b"] != 2 {
+		t.Errorf("want a run once and b twice, got %v", runs)
+	}
+}
+
+func TestDoItem(t *testing.T) {
+	ctx := context.Background()
+	s := New(NewMemoryStorage())
+
+	var runs int
+	batch := func(ctx context.Context) (int, error) {
+		v, _, err := DoItem(ctx, s, "0", func(ctx context.Context) (int, error) {
+			runs++
+			return runs, nil
+		})
+		return v, err
+	}
+
+	// A retried batch restores its items, batches do not share items.
+	for _, key := range []string{"one", "one", "two"} {
+		if _, _, err := Do(ctx, s, key, batch); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Forget(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runs != 2 {
+		t.Errorf("want an item run per batch, got %d", runs)
+	}
+
+	if _, _, err := DoItem(ctx, s, "0", batch); err == nil {
+		t.Error("want error without a batch")
+	}
+}
+
+func TestItemKeyCollisions(t *testing.T) {
+	ctx := context.Background()
+	s := New(NewMemoryStorage())
+
+	var runs int
+	create := func(ctx context.Context, item int) (int, error) {
+		runs++
+		return item, nil
+	}
+
+	// Neither a client key nor an item of another batch is an item of batch
+	// order.
+	if _, _, err := Do(ctx, s, "order#0", func(ctx context.Context) (int, error) { return create(ctx, 0) }); err != nil {
+		t.Fatal(err)
+	}
+	DoBatch(ctx, s, "order#0", []int{1}, nil, create)
+	for _, itemKey := range []func(i, item int) string{nil, func(i, item int) string { return "0#1" }} {
+		for _, result := range DoBatch(ctx, s, "order", []int{0}, itemKey, create) {
+			if result.Err != nil || result.Replayed {
+				t.Errorf("want item run, got %+v", result)
+			}
+		}
+	}
+	if runs != 4 {
+		t.Errorf("want every item run, got %d", runs)
+	}
+
+	if _, _, err := Do(ctx, s, ItemKey("order", "0"), func(ctx context.Context) (int, error) { return create(ctx, 0) }); !errors.Is(err, errReservedKey) {
+		t.Errorf("want item keys reserved for clients, got %v", err)
+	}
+}
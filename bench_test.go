@@ -0,0 +1,125 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// benchmarkBackends are the storages the benchmarks run against.
+var benchmarkBackends = []struct {
+	name    string
+	storage func(b *testing.B) StatusStorage
+}{
+	{name: "Memory", storage: func(b *testing.B) StatusStorage { return NewMemoryStorage() }},
+	{name: "Redis", storage: func(b *testing.B) StatusStorage {
+		mr := miniredis.RunT(b)
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		b.Cleanup(func() { client.Close() })
+		return NewRedisStorage(client, time.Hour)
+	}},
+}
+
+func BenchmarkVerify(b *testing.B) {
+	body := strings.Repeat("x", 1<<10)
+
+	tests := []struct {
+		name       string
+		opts       []Option
+		body       string
+		sameKey    bool
+		preload    *RequestStatus
+		wantStatus int
+	}{
+		{name: "First request", wantStatus: http.StatusOK},
+		{name: "Fingerprint", opts: []Option{WithFingerprint(BodyFingerprint)}, body: body, wantStatus: http.StatusOK},
+		{name: "Replay", opts: []Option{WithResponseCapture(true)}, sameKey: true, preload: &RequestStatus{Response: &Response{StatusCode: http.StatusCreated, Body: []byte(body)}}, wantStatus: http.StatusCreated},
+		{name: "Conflict", sameKey: true, preload: &RequestStatus{InProcess: true}, wantStatus: http.StatusConflict},
+	}
+
+	for _, backend := range benchmarkBackends {
+		for _, test := range tests {
+			b.Run(backend.name+"/"+test.name, func(b *testing.B) {
+				storage := backend.storage(b)
+				if test.preload != nil {
+					if _, err := storage.AddStatus(context.Background(), "key", test.preload, time.Hour); err != nil {
+						b.Fatal(err)
+					}
+				}
+				handler := New(storage, test.opts...).Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+				reqs := make([]*http.Request, b.N)
+				for i := range reqs {
+					reqs[i] = httptest.NewRequest("POST", "http://example.com/foo", strings.NewReader(test.body))
+					if test.sameKey {
+						reqs[i].Header.Set(HeaderName, "key")
+					} else {
+						reqs[i].Header.Set(HeaderName, strconv.Itoa(i))
+					}
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					w := httptest.NewRecorder()
+					handler.ServeHTTP(w, reqs[i])
+					if w.Code != test.wantStatus {
+						b.Fatalf("want status code %v, got %v", test.wantStatus, w.Code)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkStorage(b *testing.B) {
+	ctx := context.Background()
+	status := &RequestStatus{InProcess: true, Fingerprint: "abc", Token: 1}
+
+	for _, backend := range benchmarkBackends {
+		b.Run(backend.name+"/AddStatus", func(b *testing.B) {
+			storage := backend.storage(b)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := storage.AddStatus(ctx, strconv.Itoa(i), status, time.Hour); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(backend.name+"/Get", func(b *testing.B) {
+			storage := backend.storage(b)
+			if _, err := storage.AddStatus(ctx, "key", status, time.Hour); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := storage.Get(ctx, "key"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(backend.name+"/UpdateStatus", func(b *testing.B) {
+			storage := backend.storage(b)
+			if _, err := storage.AddStatus(ctx, "key", status, time.Hour); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := storage.UpdateStatus(ctx, "key", status); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
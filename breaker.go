@@ -0,0 +1,80 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by storages decorated with BreakerStorage while
+// the storage is considered unavailable.
+var ErrCircuitOpen = errors.New("storage circuit breaker is open")
+
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	clock     Clock
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// BreakerStorage returns a decorator failing storage calls fast with
+// ErrCircuitOpen after threshold consecutive failures, so that an
+// unavailable storage does not hold up every request until its timeout.
+// After cooldown a single call is let through to probe the storage, and its
+// success closes the breaker.
+func BreakerStorage(threshold int, cooldown time.Duration) StorageDecorator {
+	return func(next StatusStorage) StatusStorage {
+		b := &breaker{threshold: threshold, cooldown: cooldown, clock: SystemClock}
+		return &interceptedStorage{
+			forwardingStorage: forwardingStorage{next: next},
+			intercept: func(ctx context.Context, op string, call func(ctx context.Context) error) error {
+				if !b.allow() {
+					return ErrCircuitOpen
+				}
+				err := call(ctx)
+				b.record(err)
+				return err
+			},
+		}
+	}
+}
+
+// allow reports whether a call may pass the breaker.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.probing || b.clock.Now().Sub(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record counts the result of a call that passed the breaker.
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	// Unsupported calls and canceled requests say nothing about the storage.
+	if err == nil || errors.Is(err, errors.ErrUnsupported) || errors.Is(err, context.Canceled) {
+		if err == nil {
+			b.failures = 0
+		}
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = b.clock.Now()
+	}
+}
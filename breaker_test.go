@@ -0,0 +1,29 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerStorage(t *testing.T) {
+	ctx := context.Background()
+	flaky := &flakyStorage{StatusStorage: NewMemoryStorage(), failures: 2}
+	storage := ChainStorage(flaky, BreakerStorage(2, 20*time.Millisecond))
+
+	storage.Get(ctx, "key")
+	storage.Get(ctx, "key")
+	if _, err := storage.Get(ctx, "key"); !errors.Is(err, ErrCircuitOpen) || flaky.gets != 2 {
+		t.Fatalf("want open circuit after 2 failures, got %v after %d gets", err, flaky.gets)
+	}
+
+	// A probe after the cooldown closes the breaker.
+	time.Sleep(30 * time.Millisecond)
+	if _, err := storage.Get(ctx, "key"); err != nil {
+		t.Fatalf("want probe passed, got %v", err)
+	}
+	if _, err := storage.Get(ctx, "key"); err != nil || flaky.gets != 4 {
+		t.Errorf("want closed circuit, got %v after %d gets", err, flaky.gets)
+	}
+}
@@ -0,0 +1,140 @@
+package idempotency
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	key       string
+	status    RequestStatus
+	expiresAt time.Time
+}
+
+type cachedStorage struct {
+	forwardingStorage
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// CacheStorage returns a decorator caching up to size completed keys in
+// process for ttl, so that replays do not read the storage. Keys in process
+// are never cached, and completed keys only change by being deleted or
+// updated, which this instance sees if it made the change. Other instances
+// may replay a key for up to ttl after it was deleted.
+func CacheStorage(size int, ttl time.Duration) StorageDecorator {
+	return func(next StatusStorage) StatusStorage {
+		return &cachedStorage{
+			forwardingStorage: forwardingStorage{next: next},
+			size:              size,
+			ttl:               ttl,
+			entries:           make(map[string]*list.Element),
+			lru:               list.New(),
+		}
+	}
+}
+
+// Get fetches the RequestStatus for an idempotency key, from the cache if it
+// is completed and cached.
+func (s *cachedStorage) Get(ctx context.Context, key string) (*RequestStatus, error) {
+	s.mu.Lock()
+	if e, ok := s.entries[key]; ok {
+		entry := e.Value.(*cacheEntry)
+		if SystemClock.Now().Before(entry.expiresAt) {
+			s.lru.MoveToFront(e)
+			status := entry.status
+			s.mu.Unlock()
+			return &status, nil
+		}
+		s.remove(e)
+	}
+	s.mu.Unlock()
+
+	status, err := s.next.Get(ctx, key)
+	if err == nil && status != nil && !status.InProcess {
+		s.store(key, status)
+	}
+	return status, err
+}
+
+// Complete sets a request to not be in progress.
+func (s *cachedStorage) Complete(ctx context.Context, key string) error {
+	defer s.invalidate(key)
+	return s.next.Complete(ctx, key)
+}
+
+// UpdateStatus replaces the RequestStatus of an idempotency key.
+func (s *cachedStorage) UpdateStatus(ctx context.Context, key string, status *RequestStatus) error {
+	defer s.invalidate(key)
+	return s.next.UpdateStatus(ctx, key, status)
+}
+
+// UpdateStatuses replaces the statuses of idempotency keys.
+func (s *cachedStorage) UpdateStatuses(ctx context.Context, statuses map[string]*RequestStatus) error {
+	defer func() {
+		for key := range statuses {
+			s.invalidate(key)
+		}
+	}()
+	return s.forwardingStorage.UpdateStatuses(ctx, statuses)
+}
+
+// Delete removes an idempotency key.
+func (s *cachedStorage) Delete(ctx context.Context, key string) error {
+	defer s.invalidate(key)
+	return s.forwardingStorage.Delete(ctx, key)
+}
+
+// Expire sets an idempotency key to expire after expiry.
+func (s *cachedStorage) Expire(ctx context.Context, key string, expiry time.Duration) error {
+	defer s.invalidate(key)
+	return s.forwardingStorage.Expire(ctx, key, expiry)
+}
+
+// DeleteByOwner removes all keys of owner.
+func (s *cachedStorage) DeleteByOwner(ctx context.Context, owner string) (int, error) {
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, e := range s.entries {
+			if e.Value.(*cacheEntry).status.Owner == owner {
+				s.remove(e)
+			}
+		}
+	}()
+	return s.forwardingStorage.DeleteByOwner(ctx, owner)
+}
+
+func (s *cachedStorage) store(key string, status *RequestStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok {
+		s.remove(e)
+	}
+	s.entries[key] = s.lru.PushFront(&cacheEntry{key: key, status: *status, expiresAt: SystemClock.Now().Add(s.ttl)})
+	if s.lru.Len() > s.size {
+		s.remove(s.lru.Back())
+	}
+}
+
+func (s *cachedStorage) invalidate(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok {
+		s.remove(e)
+	}
+}
+
+// remove drops an entry, s.mu must be held.
+func (s *cachedStorage) remove(e *list.Element) {
+	s.lru.Remove(e)
+	delete(s.entries, e.Value.(*cacheEntry).key)
+}
@@ -0,0 +1,39 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCacheStorage(t *testing.T) {
+	ctx := context.Background()
+	base := &flakyStorage{StatusStorage: NewMemoryStorage()}
+	storage := ChainStorage(base, CacheStorage(1, time.Hour))
+
+	storage.AddStatus(ctx, "pending", &RequestStatus{InProcess: true}, time.Hour)
+	storage.AddStatus(ctx, "done", &RequestStatus{Token: 1}, time.Hour)
+	storage.AddStatus(ctx, "other", &RequestStatus{Token: 2}, time.Hour)
+
+	tests := []struct {
+		key      string
+		wantGets int
+	}{
+		{key: "pending", wantGets: 1},
+		{key: "pending", wantGets: 2}, // Keys in process are not cached.
+		{key: "done", wantGets: 3},
+		{key: "done", wantGets: 3},
+		{key: "other", wantGets: 4}, // Evicts "done".
+		{key: "done", wantGets: 5},
+	}
+	for _, tt := range tests {
+		if _, err := storage.Get(ctx, tt.key); err != nil || base.gets != tt.wantGets {
+			t.Fatalf("Get %s: want %d gets, got %d, %v", tt.key, tt.wantGets, base.gets, err)
+		}
+	}
+
+	storage.UpdateStatus(ctx, "done", &RequestStatus{Token: 3})
+	if status, _ := storage.Get(ctx, "done"); status == nil || status.Token != 3 {
+		t.Errorf("want updated key not cached, got %+v", status)
+	}
+}
@@ -0,0 +1,89 @@
+package idempotency
+
+import (
+	"context"
+)
+
+// Purger is implemented by storages that keep expired keys until they are
+// purged, such as the memory and SQL storages.
+type Purger interface {
+	// Purge removes expired keys and returns how many were removed.
+	Purge(ctx context.Context) (int, error)
+}
+
+// Capabilities reports which optional interfaces a storage supports. State
+// detects them once in New, and features depending on a missing capability
+// are disabled or return an error when used, instead of failing requests.
+type Capabilities struct {
+	// StatusStorage stores statuses and responses, which is required for
+	// fingerprints, response capture, owners and reservation tokens.
+	StatusStorage bool
+	Reserver      bool
+	Deleter       bool
+	Expirer       bool
+	Persister     bool
+	Counter       bool
+	TTLReader     bool
+	Pinger        bool
+	Scanner       bool
+	Purger        bool
+	OwnerDeleter  bool
+	BatchUpdater  bool
+}
+
+// CapabilityReporter is implemented by storages whose capabilities depend on
+// the storages they wrap. Such storages implement every optional interface
+// and return errors.ErrUnsupported for the unsupported ones, which type
+// assertions cannot tell.
+type CapabilityReporter interface {
+	Capabilities() Capabilities
+}
+
+// StorageCapabilities returns the capabilities of s.
+func StorageCapabilities(s Storage) Capabilities {
+	if r, ok := s.(CapabilityReporter); ok {
+		return r.Capabilities()
+	}
+	return assertCapabilities(s)
+}
+
+// assertCapabilities returns the capabilities of s by type assertions.
+func assertCapabilities(s Storage) Capabilities {
+	var c Capabilities
+	_, c.StatusStorage = s.(StatusStorage)
+	_, c.Reserver = s.(Reserver)
+	_, c.Deleter = s.(Deleter)
+	_, c.Expirer = s.(Expirer)
+	_, c.Persister = s.(Persister)
+	_, c.Counter = s.(Counter)
+	_, c.TTLReader = s.(TTLReader)
+	_, c.Pinger = s.(Pinger)
+	_, c.Scanner = s.(Scanner)
+	_, c.Purger = s.(Purger)
+	_, c.OwnerDeleter = s.(OwnerDeleter)
+	_, c.BatchUpdater = s.(BatchUpdater)
+	return c
+}
+
+// intersect returns the capabilities supported by both c and o.
+func (c Capabilities) intersect(o Capabilities) Capabilities {
+	return Capabilities{
+		StatusStorage: c.StatusStorage && o.StatusStorage,
+		Reserver:      c.Reserver && o.Reserver,
+		Deleter:       c.Deleter && o.Deleter,
+		Expirer:       c.Expirer && o.Expirer,
+		Persister:     c.Persister && o.Persister,
+		Counter:       c.Counter && o.Counter,
+		TTLReader:     c.TTLReader && o.TTLReader,
+		Pinger:        c.Pinger && o.Pinger,
+		Scanner:       c.Scanner && o.Scanner,
+		Purger:        c.Purger && o.Purger,
+		OwnerDeleter:  c.OwnerDeleter && o.OwnerDeleter,
+		BatchUpdater:  c.BatchUpdater && o.BatchUpdater,
+	}
+}
+
+// Capabilities returns the capabilities of the storage of s.
+func (s *State) Capabilities() Capabilities {
+	return s.caps
+}
@@ -0,0 +1,62 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStorageCapabilities(t *testing.T) {
+	noop := InstrumentStorage(func(op string, d time.Duration, err error) {})
+
+	tests := []struct {
+		name    string
+		storage Storage
+		want    Capabilities
+	}{
+		{
+			name:    "Memory",
+			storage: NewMemoryStorage(),
+			want: Capabilities{StatusStorage: true, Reserver: true, Deleter: true, Expirer: true, Persister: true,
+				Counter: true, TTLReader: true, Pinger: true, Scanner: true, Purger: true, OwnerDeleter: true},
+		},
+		{
+			name:    "Decorated",
+			storage: ChainStorage(&flakyStorage{StatusStorage: NewMemoryStorage()}, noop),
+			want:    Capabilities{StatusStorage: true},
+		},
+		{
+			name:    "Sharded",
+			storage: NewShardedStorage(map[string]StatusStorage{"a": NewMemoryStorage(), "b": ChainStorage(&flakyStorage{StatusStorage: NewMemoryStorage()}, noop)}),
+			want:    Capabilities{StatusStorage: true, Pinger: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StorageCapabilities(tt.storage); got != tt.want {
+				t.Errorf("want %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMissingCapability(t *testing.T) {
+	ctx := context.Background()
+	storage := ChainStorage(&flakyStorage{StatusStorage: NewMemoryStorage()}, InstrumentStorage(func(op string, d time.Duration, err error) {}))
+	s := New(storage)
+
+	if s.Capabilities().Deleter {
+		t.Fatal("want no Deleter capability")
+	}
+	// Features needing a missing capability report it instead of calling
+	// the unsupported method.
+	if err := s.Fail(ctx, "key"); err == nil || err.Error() != "storage does not support deleting keys" {
+		t.Errorf("want unsupported Fail, got %v", err)
+	}
+	if _, _, err := s.TTL(ctx, "key"); err == nil {
+		t.Error("want unsupported TTL")
+	}
+	if err := s.Ping(ctx); err != nil {
+		t.Errorf("want storage without Pinger healthy, got %v", err)
+	}
+}
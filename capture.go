@@ -0,0 +1,112 @@
+package idempotency
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+const (
+	// maxPooledBuffer is the capacity up to which capture buffers are reused,
+	// larger buffers are left to the garbage collector.
+	maxPooledBuffer = 64 << 10
+	// maxSizeHint is the largest Content-Length the capture buffer is grown
+	// to in advance.
+	maxSizeHint = 1 << 20
+)
+
+// bufferPool holds the buffers of captureWriters.
+var bufferPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// captureWriter is an http.ResponseWriter passing the response through to
+// the client while recording it, so that it can be stored and replayed.
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   *bytes.Buffer
+}
+
+// newCaptureWriter returns a captureWriter with a pooled buffer, which must
+// be returned with release.
+func newCaptureWriter(w http.ResponseWriter) *captureWriter {
+	return &captureWriter{ResponseWriter: w, body: bufferPool.Get().(*bytes.Buffer)}
+}
+
+func (c *captureWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+		c.header = c.ResponseWriter.Header().Clone()
+
+		// Size the buffer by the Content-Length, if set.
+		if n, err := strconv.Atoi(c.header.Get("Content-Length")); err == nil && n > 0 && n <= maxSizeHint {
+			c.body.Grow(n)
+		}
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *captureWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying http.ResponseWriter, for use with
+// http.ResponseController.
+func (c *captureWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// release returns the buffer to the pool, the writer must not be used
+// afterwards.
+func (c *captureWriter) release() {
+	b := c.body
+	c.body = nil
+	if b.Cap() > maxPooledBuffer {
+		return
+	}
+	b.Reset()
+	bufferPool.Put(b)
+}
+
+// response returns the recorded response.
+func (c *captureWriter) response() *Response {
+	resp := &Response{
+		StatusCode: c.status,
+		Header:     c.header,
+		Body:       bytes.Clone(c.body.Bytes()),
+	}
+	if c.status == 0 {
+		resp.StatusCode = http.StatusOK
+		resp.Header = c.ResponseWriter.Header().Clone()
+	}
+	resp.Header.Del(HeaderName)
+	return resp
+}
+
+// writeResponse replays a stored response, or a 304 Not Modified if r is a
+// conditional request matching its ETag.
+func writeResponse(w http.ResponseWriter, r *http.Request, resp *Response) {
+	if notModified(r, resp) {
+		writeNotModified(w, resp)
+		return
+	}
+
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set(ReplayedHeaderName, "true")
+
+	status := resp.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write(resp.Body)
+}
@@ -0,0 +1,51 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func BenchmarkCaptureWriter(b *testing.B) {
+	for _, size := range []int{1 << 10, 32 << 10} {
+		body := make([]byte, size)
+
+		for _, hint := range []bool{false, true} {
+			b.Run(strconv.Itoa(size)+"/hint="+strconv.FormatBool(hint), func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(size))
+
+				w := httptest.NewRecorder()
+				for i := 0; i < b.N; i++ {
+					w.Body.Reset()
+					if hint {
+						w.Header().Set("Content-Length", strconv.Itoa(size))
+					}
+
+					cw := newCaptureWriter(w)
+					cw.WriteHeader(http.StatusOK)
+					for off := 0; off < size; off += 512 {
+						cw.Write(body[off : off+512])
+					}
+					cw.response()
+					cw.release()
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkVerifyCapture(b *testing.B) {
+	body := make([]byte, 4<<10)
+	handler := New(NewMemoryStorage(), WithResponseCapture(true)).Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+		req.Header.Set(HeaderName, strconv.Itoa(i))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
@@ -0,0 +1,290 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// StorageDecorator wraps a storage to add behavior to it, e.g. retries or
+// caching.
+type StorageDecorator func(StatusStorage) StatusStorage
+
+// ChainStorage wraps base with decorators, so that calls pass through the
+// decorators in order before reaching base. For example
+//
+//	storage := idempotency.ChainStorage(idempotency.NewRedisStorage(client, ttl),
+//		idempotency.InstrumentStorage(observe),
+//		idempotency.CacheStorage(10000, time.Minute),
+//		idempotency.BreakerStorage(5, 10*time.Second),
+//		idempotency.RetryStorage(3, 50*time.Millisecond),
+//	)
+//
+// observes every call, serves completed keys from the cache, and retries
+// calls to Redis unless the breaker is open. Nil decorators are skipped.
+func ChainStorage(base StatusStorage, decorators ...StorageDecorator) StatusStorage {
+	storage := base
+	for i := len(decorators) - 1; i >= 0; i-- {
+		if decorators[i] != nil {
+			storage = decorators[i](storage)
+		}
+	}
+	return storage
+}
+
+// forwardingStorage forwards all calls to the wrapped storage, including the
+// optional interfaces, which return errors.ErrUnsupported if the wrapped
+// storage does not implement them. Decorators embed it and override the
+// methods they change.
+type forwardingStorage struct {
+	next StatusStorage
+}
+
+// Add inserts the initial state of a request with an idempotency key.
+func (s forwardingStorage) Add(ctx context.Context, key string) (bool, error) {
+	return s.next.Add(ctx, key)
+}
+
+// AddStatus inserts status for an idempotency key.
+func (s forwardingStorage) AddStatus(ctx context.Context, key string, status *RequestStatus, expiry time.Duration) (bool, error) {
+	return s.next.AddStatus(ctx, key, status, expiry)
+}
+
+// AddOrGet inserts status for an idempotency key or returns its existing
+// status.
+func (s forwardingStorage) AddOrGet(ctx context.Context, key string, status *RequestStatus, expiry time.Duration) (*RequestStatus, bool, error) {
+	return addOrGet(ctx, s.next, key, status, expiry)
+}
+
+// Get fetches the RequestStatus for an idempotency key.
+func (s forwardingStorage) Get(ctx context.Context, key string) (*RequestStatus, error) {
+	return s.next.Get(ctx, key)
+}
+
+// Complete sets a request to not be in progress.
+func (s forwardingStorage) Complete(ctx context.Context, key string) error {
+	return s.next.Complete(ctx, key)
+}
+
+// UpdateStatus replaces the RequestStatus of an idempotency key.
+func (s forwardingStorage) UpdateStatus(ctx context.Context, key string, status *RequestStatus) error {
+	return s.next.UpdateStatus(ctx, key, status)
+}
+
+// UpdateStatuses replaces the statuses of idempotency keys, with a batch if
+// the wrapped storage implements BatchUpdater.
+func (s forwardingStorage) UpdateStatuses(ctx context.Context, statuses map[string]*RequestStatus) error {
+	if b, ok := s.next.(BatchUpdater); ok {
+		return b.UpdateStatuses(ctx, statuses)
+	}
+	for key, status := range statuses {
+		if err := s.next.UpdateStatus(ctx, key, status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes an idempotency key.
+func (s forwardingStorage) Delete(ctx context.Context, key string) error {
+	d, ok := s.next.(Deleter)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return d.Delete(ctx, key)
+}
+
+// Expire sets an idempotency key to expire after expiry.
+func (s forwardingStorage) Expire(ctx context.Context, key string, expiry time.Duration) error {
+	e, ok := s.next.(Expirer)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return e.Expire(ctx, key, expiry)
+}
+
+// Increment increments the counter of key.
+func (s forwardingStorage) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	c, ok := s.next.(Counter)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	return c.Increment(ctx, key, window)
+}
+
+// TTL returns the remaining time until an idempotency key expires.
+func (s forwardingStorage) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	t, ok := s.next.(TTLReader)
+	if !ok {
+		return 0, false, errors.ErrUnsupported
+	}
+	return t.TTL(ctx, key)
+}
+
+// Ping checks the health of the storage, storages not implementing Pinger
+// are assumed to be healthy.
+func (s forwardingStorage) Ping(ctx context.Context) error {
+	p, ok := s.next.(Pinger)
+	if !ok {
+		return nil
+	}
+	return p.Ping(ctx)
+}
+
+// Scan calls fn for every stored key.
+func (s forwardingStorage) Scan(ctx context.Context, fn func(key string, status *RequestStatus) error) error {
+	sc, ok := s.next.(Scanner)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return sc.Scan(ctx, fn)
+}
+
+// DeleteByOwner removes all keys of owner.
+func (s forwardingStorage) DeleteByOwner(ctx context.Context, owner string) (int, error) {
+	d, ok := s.next.(OwnerDeleter)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	return d.DeleteByOwner(ctx, owner)
+}
+
+// Purge removes expired keys.
+func (s forwardingStorage) Purge(ctx context.Context) (int, error) {
+	p, ok := s.next.(Purger)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	return p.Purge(ctx)
+}
+
+// Capabilities returns the capabilities of the wrapped storage.
+func (s forwardingStorage) Capabilities() Capabilities {
+	return StorageCapabilities(s.next)
+}
+
+// addOrGet adds status for key to storage or returns its existing status,
+// atomically if storage implements Reserver.
+func addOrGet(ctx context.Context, storage StatusStorage, key string, status *RequestStatus, expiry time.Duration) (*RequestStatus, bool, error) {
+	if r, ok := storage.(Reserver); ok {
+		return r.AddOrGet(ctx, key, status, expiry)
+	}
+
+	added, err := storage.AddStatus(ctx, key, status, expiry)
+	if err != nil || added {
+		return nil, added, err
+	}
+	existing, err := storage.Get(ctx, key)
+	return existing, false, err
+}
+
+// interceptedStorage passes every call of the wrapped storage through
+// intercept, with the name of the called method.
+type interceptedStorage struct {
+	forwardingStorage
+	intercept func(ctx context.Context, op string, call func(ctx context.Context) error) error
+}
+
+func (s *interceptedStorage) Add(ctx context.Context, key string) (added bool, err error) {
+	err = s.intercept(ctx, "Add", func(ctx context.Context) (err error) {
+		added, err = s.forwardingStorage.Add(ctx, key)
+		return err
+	})
+	return added, err
+}
+
+func (s *interceptedStorage) AddStatus(ctx context.Context, key string, status *RequestStatus, expiry time.Duration) (added bool, err error) {
+	err = s.intercept(ctx, "AddStatus", func(ctx context.Context) (err error) {
+		added, err = s.forwardingStorage.AddStatus(ctx, key, status, expiry)
+		return err
+	})
+	return added, err
+}
+
+func (s *interceptedStorage) AddOrGet(ctx context.Context, key string, status *RequestStatus, expiry time.Duration) (existing *RequestStatus, added bool, err error) {
+	err = s.intercept(ctx, "AddOrGet", func(ctx context.Context) (err error) {
+		existing, added, err = s.forwardingStorage.AddOrGet(ctx, key, status, expiry)
+		return err
+	})
+	return existing, added, err
+}
+
+func (s *interceptedStorage) Get(ctx context.Context, key string) (status *RequestStatus, err error) {
+	err = s.intercept(ctx, "Get", func(ctx context.Context) (err error) {
+		status, err = s.forwardingStorage.Get(ctx, key)
+		return err
+	})
+	return status, err
+}
+
+func (s *interceptedStorage) Complete(ctx context.Context, key string) error {
+	return s.intercept(ctx, "Complete", func(ctx context.Context) error {
+		return s.forwardingStorage.Complete(ctx, key)
+	})
+}
+
+func (s *interceptedStorage) UpdateStatus(ctx context.Context, key string, status *RequestStatus) error {
+	return s.intercept(ctx, "UpdateStatus", func(ctx context.Context) error {
+		return s.forwardingStorage.UpdateStatus(ctx, key, status)
+	})
+}
+
+func (s *interceptedStorage) UpdateStatuses(ctx context.Context, statuses map[string]*RequestStatus) error {
+	return s.intercept(ctx, "UpdateStatuses", func(ctx context.Context) error {
+		return s.forwardingStorage.UpdateStatuses(ctx, statuses)
+	})
+}
+
+func (s *interceptedStorage) Delete(ctx context.Context, key string) error {
+	return s.intercept(ctx, "Delete", func(ctx context.Context) error {
+		return s.forwardingStorage.Delete(ctx, key)
+	})
+}
+
+func (s *interceptedStorage) Expire(ctx context.Context, key string, expiry time.Duration) error {
+	return s.intercept(ctx, "Expire", func(ctx context.Context) error {
+		return s.forwardingStorage.Expire(ctx, key, expiry)
+	})
+}
+
+func (s *interceptedStorage) Increment(ctx context.Context, key string, window time.Duration) (count int64, err error) {
+	err = s.intercept(ctx, "Increment", func(ctx context.Context) (err error) {
+		count, err = s.forwardingStorage.Increment(ctx, key, window)
+		return err
+	})
+	return count, err
+}
+
+func (s *interceptedStorage) TTL(ctx context.Context, key string) (ttl time.Duration, exists bool, err error) {
+	err = s.intercept(ctx, "TTL", func(ctx context.Context) (err error) {
+		ttl, exists, err = s.forwardingStorage.TTL(ctx, key)
+		return err
+	})
+	return ttl, exists, err
+}
+
+func (s *interceptedStorage) Ping(ctx context.Context) error {
+	return s.intercept(ctx, "Ping", s.forwardingStorage.Ping)
+}
+
+func (s *interceptedStorage) Scan(ctx context.Context, fn func(key string, status *RequestStatus) error) error {
+	return s.intercept(ctx, "Scan", func(ctx context.Context) error {
+		return s.forwardingStorage.Scan(ctx, fn)
+	})
+}
+
+func (s *interceptedStorage) Purge(ctx context.Context) (n int, err error) {
+	err = s.intercept(ctx, "Purge", func(ctx context.Context) (err error) {
+		n, err = s.forwardingStorage.Purge(ctx)
+		return err
+	})
+	return n, err
+}
+
+func (s *interceptedStorage) DeleteByOwner(ctx context.Context, owner string) (n int, err error) {
+	err = s.intercept(ctx, "DeleteByOwner", func(ctx context.Context) (err error) {
+		n, err = s.forwardingStorage.DeleteByOwner(ctx, owner)
+		return err
+	})
+	return n, err
+}
@@ -0,0 +1,66 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// flakyStorage fails the next failures calls of Get.
+type flakyStorage struct {
+	StatusStorage
+	failures int
+	gets     int
+}
+
+var errFlaky = errors.New("flaky")
+
+func (f *flakyStorage) Get(ctx context.Context, key string) (*RequestStatus, error) {
+	f.gets++
+	if f.failures > 0 {
+		f.failures--
+		return nil, errFlaky
+	}
+	return f.StatusStorage.Get(ctx, key)
+}
+
+func TestChainStorage(t *testing.T) {
+	ctx := context.Background()
+
+	var order []string
+	named := func(name string) StorageDecorator {
+		return InstrumentStorage(func(op string, d time.Duration, err error) {
+			order = append(order, name+" "+op)
+		})
+	}
+	storage := ChainStorage(NewMemoryStorage(), named("outer"), nil, named("inner"))
+
+	storage.AddStatus(ctx, "key", &RequestStatus{}, time.Hour)
+	if _, err := storage.Get(ctx, "key"); err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	// Observers are called once their call returns.
+	want := []string{"inner AddStatus", "outer AddStatus", "inner Get", "outer Get"}
+	if len(order) != len(want) {
+		t.Fatalf("want %v, got %v", want, order)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Errorf("want %v, got %v", want, order)
+			break
+		}
+	}
+
+	// Optional interfaces are passed through.
+	if err := storage.(Deleter).Delete(ctx, "key"); err != nil {
+		t.Errorf("want key deleted, got %v", err)
+	}
+	if _, err := storage.(Counter).Increment(ctx, "count", time.Minute); err != nil {
+		t.Errorf("want counter incremented, got %v", err)
+	}
+	unsupported := ChainStorage(&flakyStorage{StatusStorage: NewMemoryStorage()}, named("flaky"))
+	if err := unsupported.(Deleter).Delete(ctx, "key"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("want unsupported delete, got %v", err)
+	}
+}
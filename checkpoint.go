@@ -0,0 +1,139 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+)
+
+// checkpoints are the steps completed by the attempts of a key, see
+// Checkpoint.
+type checkpoints struct {
+	loaded bool
+	stored bool
+	steps  map[string]json.RawMessage
+}
+
+// checkpointKey returns the key of the checkpoints of key, which is kept
+// when a failed attempt releases key.
+func checkpointKey(key string) string {
+	return internalKey("checkpoints", key)
+}
+
+// Checkpoint records that step of the current request is done, with data
+// encoded as JSON, e.g. the ID of a created payment. Checkpoints are kept
+// when the request fails, so that a retry with the same key can skip the
+// steps done before with Checkpointed instead of repeating their side
+// effects. They are removed once the request completes, and expire with the
+// expiry of the storage otherwise. It requires a storage implementing
+// StatusStorage and returns an error for contexts not created by Verify or
+// Do.
+func Checkpoint(ctx context.Context, step string, data any) error {
+	a, ok := ctx.Value(attemptContextKey).(*attempt)
+	if !ok || a.state == nil {
+		return errors.New("idempotency: Checkpoint requires a context created by Verify or Do")
+	}
+
+	value, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("could not encode checkpoint %q: %w", step, err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	c := &a.checkpoints
+	if err := c.load(ctx, a.state, a.key); err != nil {
+		return err
+	}
+	steps := maps.Clone(c.steps)
+	if steps == nil {
+		steps = make(map[string]json.RawMessage)
+	}
+	steps[step] = value
+	if err := c.store(ctx, a.state, a.key, steps); err != nil {
+		return fmt.Errorf("could not store checkpoint %q: %w", step, err)
+	}
+	c.steps = steps
+	return nil
+}
+
+// Checkpointed reports whether step was recorded with Checkpoint by a
+// previous attempt of the current request, and decodes its data into v,
+// which may be nil.
+func Checkpointed(ctx context.Context, step string, v any) (bool, error) {
+	a, ok := ctx.Value(attemptContextKey).(*attempt)
+	if !ok || a.state == nil {
+		return false, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.checkpoints.load(ctx, a.state, a.key); err != nil {
+		return false, err
+	}
+	value, ok := a.checkpoints.steps[step]
+	if !ok {
+		return false, nil
+	}
+	if v != nil {
+		if err := json.Unmarshal(value, v); err != nil {
+			return true, fmt.Errorf("could not decode checkpoint %q: %w", step, err)
+		}
+	}
+	return true, nil
+}
+
+// load reads the checkpoints of previous attempts of key once.
+func (c *checkpoints) load(ctx context.Context, s *State, key string) error {
+	if c.loaded {
+		return nil
+	}
+	if _, ok := s.storage.(StatusStorage); !ok {
+		return fmt.Errorf("idempotency: checkpoints require a storage implementing StatusStorage, got %T", s.storage)
+	}
+
+	status, err := s.storage.Get(ctx, checkpointKey(key))
+	if err != nil {
+		return fmt.Errorf("could not get checkpoints: %w", err)
+	}
+	if status != nil {
+		c.stored = true
+		c.steps = status.Checkpoints
+	}
+	c.loaded = true
+	return nil
+}
+
+// store writes steps as the checkpoints of key.
+func (c *checkpoints) store(ctx context.Context, s *State, key string, steps map[string]json.RawMessage) error {
+	ss := s.storage.(StatusStorage)
+	status := &RequestStatus{Checkpoints: steps}
+	if c.stored {
+		return ss.UpdateStatus(ctx, checkpointKey(key), status)
+	}
+
+	added, err := ss.AddStatus(ctx, checkpointKey(key), status, 0)
+	if err == nil && !added {
+		err = ss.UpdateStatus(ctx, checkpointKey(key), status)
+	}
+	if err == nil {
+		c.stored = true
+	}
+	return err
+}
+
+// clearCheckpoints removes the checkpoints of a completed attempt, if any
+// were stored. Checkpoints which cannot be removed expire.
+func (s *State) clearCheckpoints(ctx context.Context, a *attempt) {
+	a.mu.Lock()
+	stored := a.checkpoints.stored
+	a.mu.Unlock()
+
+	if d, ok := s.storage.(Deleter); ok && s.caps.Deleter && stored {
+		d.Delete(ctx, checkpointKey(a.key))
+	}
+}
@@ -0,0 +1,84 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	s := New(storage)
+
+	var charges int
+	charge := func(ctx context.Context) (string, error) {
+		var id string
+		ok, err := Checkpointed(ctx, "charge", &id)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			charges++
+			id = "ch_1"
+			if err := Checkpoint(ctx, "charge", id); err != nil {
+				return "", err
+			}
+		}
+		if charges == 1 && !ok {
+			return "", errors.New("failed after charging")
+		}
+		return id, nil
+	}
+
+	if _, _, err := Do(ctx, s, "key", charge); err == nil {
+		t.Fatal("want first attempt failed")
+	}
+	v, replayed, err := Do(ctx, s, "key", charge)
+	if err != nil || replayed || v != "ch_1" {
+		t.Fatalf("want retry resumed from checkpoint, got %q, %v, %v", v, replayed, err)
+	}
+	if charges != 1 {
+		t.Errorf("want charged once, got %d", charges)
+	}
+
+	if status, _ := storage.Get(ctx, checkpointKey("key")); status != nil {
+		t.Errorf("want checkpoints removed once completed, got %+v", status)
+	}
+}
+
+func TestCheckpointOutsideRequest(t *testing.T) {
+	if err := Checkpoint(context.Background(), "step", nil); err == nil {
+		t.Error("want error without a request")
+	}
+	if ok, err := Checkpointed(context.Background(), "step", nil); ok || err != nil {
+		t.Errorf("want no checkpoint, got %v, %v", ok, err)
+	}
+}
+
+func TestCheckpointReservedKey(t *testing.T) {
+	ctx := context.Background()
+	s := New(NewMemoryStorage())
+
+	_, _, err := Do(ctx, s, "key", func(ctx context.Context) (string, error) {
+		if err := Checkpoint(ctx, "charge", "ch_1"); err != nil {
+			return "", err
+		}
+		return "", errors.New("failed after charging")
+	})
+	if err == nil {
+		t.Fatal("want first attempt failed")
+	}
+
+	// A client key shaped like the checkpoints of key must not see them.
+	v, replayed, err := Do(ctx, s, "checkpoints:key", func(ctx context.Context) (string, error) {
+		ok, err := Checkpointed(ctx, "charge", nil)
+		if ok || err != nil {
+			return "", errors.New("found checkpoints of another key")
+		}
+		return "ok", nil
+	})
+	if err != nil || replayed || v != "ok" {
+		t.Errorf("want key processed, got %q, %v, %v", v, replayed, err)
+	}
+}
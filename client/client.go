@@ -0,0 +1,234 @@
+// Package client provides an http.RoundTripper for clients of APIs
+// implementing the Idempotency-Key header. It sets a key on every unsafe
+// request and reuses it when retrying, so that retries are safe.
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// Result describes the outcome of a request sent through a Transport.
+type Result struct {
+	// Key is the Idempotency-Key used for all attempts.
+	Key string
+	// Attempts is the number of times the request was sent.
+	Attempts int
+	// Replayed is true when the server responded with a stored response
+	// from a previous attempt.
+	Replayed bool
+	// Conflict is true when the last attempt was rejected because a previous
+	// attempt was still in process.
+	Conflict bool
+}
+
+type resultContextKey struct{}
+
+// WithResult returns a new Context that carries res. The Transport fills in
+// res for requests sent with the returned context.
+func WithResult(ctx context.Context, res *Result) context.Context {
+	return context.WithValue(ctx, resultContextKey{}, res)
+}
+
+// Option is the functional option signature for configuring the Transport.
+type Option func(*Transport)
+
+// Transport is an http.RoundTripper that sets an Idempotency-Key on unsafe
+// requests and retries them with the same key.
+type Transport struct {
+	base         http.RoundTripper
+	keyGenerator idempotency.KeyGenerator
+	maxRetries   int
+	backoff      func(attempt int) time.Duration
+	shouldRetry  func(res *http.Response, err error) bool
+}
+
+// WithKeyGenerator configures the generator of keys for requests without an
+// Idempotency-Key, it defaults to idempotency.UUIDv4.
+func WithKeyGenerator(g idempotency.KeyGenerator) Option {
+	return func(t *Transport) {
+		t.keyGenerator = g
+	}
+}
+
+// WithMaxRetries configures how many times a request is retried, it defaults
+// to 3.
+func WithMaxRetries(n int) Option {
+	return func(t *Transport) {
+		t.maxRetries = n
+	}
+}
+
+// WithBackoff configures the delay before retry attempt, starting at 1. A
+// Retry-After header in the response takes precedence.
+func WithBackoff(f func(attempt int) time.Duration) Option {
+	return func(t *Transport) {
+		t.backoff = f
+	}
+}
+
+// WithRetryPolicy configures which responses and errors are retried, it
+// defaults to DefaultRetryPolicy.
+func WithRetryPolicy(f func(res *http.Response, err error) bool) Option {
+	return func(t *Transport) {
+		t.shouldRetry = f
+	}
+}
+
+// DefaultRetryPolicy retries transport errors, conflicts with in process
+// requests, rate limits and temporary server errors.
+func DefaultRetryPolicy(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	switch res.StatusCode {
+	case http.StatusConflict, http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// ExponentialBackoff returns a backoff starting at base and doubling for
+// each attempt, limited to max.
+func ExponentialBackoff(base, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// NewTransport creates a Transport sending requests with base, or
+// http.DefaultTransport if nil.
+func NewTransport(base http.RoundTripper, opts ...Option) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	t := &Transport{
+		base:         base,
+		keyGenerator: idempotency.UUIDv4(),
+		maxRetries:   3,
+		backoff:      ExponentialBackoff(100*time.Millisecond, 5*time.Second),
+		shouldRetry:  DefaultRetryPolicy,
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(t)
+		}
+	}
+
+	return t
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return t.base.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+	res, _ := ctx.Value(resultContextKey{}).(*Result)
+	if res == nil {
+		res = &Result{}
+	}
+
+	key := req.Header.Get(idempotency.HeaderName)
+	if key == "" {
+		var err error
+		key, err = t.keyGenerator.GenerateKey(req)
+		if err != nil {
+			return nil, fmt.Errorf("could not generate Idempotency-Key: %w", err)
+		}
+	}
+	res.Key = key
+
+	getBody, err := rewindableBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		r := req.Clone(ctx)
+		r.Header.Set(idempotency.HeaderName, key)
+		if getBody != nil {
+			if r.Body, err = getBody(); err != nil {
+				return nil, fmt.Errorf("could not rewind request body: %w", err)
+			}
+		}
+
+		resp, err := t.base.RoundTrip(r)
+		res.Attempts = attempt + 1
+		if err == nil {
+			res.Replayed = resp.Header.Get(idempotency.ReplayedHeaderName) == "true"
+			res.Conflict = resp.StatusCode == http.StatusConflict
+		}
+
+		if attempt >= t.maxRetries || !t.shouldRetry(resp, err) {
+			return resp, err
+		}
+
+		delay := t.backoff(attempt + 1)
+		if resp != nil {
+			if d, ok := retryAfter(resp); ok {
+				delay = d
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// rewindableBody returns a function returning a fresh copy of the body of
+// req, buffering the body if the request does not provide one.
+func rewindableBody(req *http.Request) (func() (io.ReadCloser, error), error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		return req.GetBody, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not read request body: %w", err)
+	}
+
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}, nil
+}
+
+// retryAfter parses the Retry-After header of resp in seconds.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || secs < 0 {
+		return 0, false
+	}
+	return time.Duration(secs) * time.Second, true
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+func TestTransport(t *testing.T) {
+	s := idempotency.New(idempotency.NewMemoryStorage(), idempotency.WithRestorer(func(idempotencyKey string, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	var (
+		keys   []string
+		bodies []string
+	)
+	verify := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		keys = append(keys, r.Header.Get(idempotency.HeaderName))
+		bodies = append(bodies, string(body))
+
+		// Fail the first attempt before it reaches the middleware.
+		if len(keys) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		verify.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	c := &http.Client{Transport: NewTransport(nil, WithBackoff(func(int) time.Duration { return time.Millisecond }))}
+
+	var res Result
+	req, _ := http.NewRequestWithContext(WithResult(context.Background(), &res), "POST", server.URL, strings.NewReader("payload"))
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("want status code %v, got %v", http.StatusCreated, resp.StatusCode)
+	}
+	if res.Attempts != 2 {
+		t.Errorf("want 2 attempts, got %d", res.Attempts)
+	}
+	if res.Replayed {
+		t.Errorf("want replayed = false, got true")
+	}
+	if keys[0] == "" || keys[0] != keys[1] || keys[0] != res.Key {
+		t.Errorf("want the same key for all attempts, got %v (result %q)", keys, res.Key)
+	}
+	if bodies[1] != "payload" {
+		t.Errorf("want body %q on retry, got %q", "payload", bodies[1])
+	}
+
+	// Sending the same key again is replayed.
+	res = Result{}
+	req, _ = http.NewRequestWithContext(WithResult(context.Background(), &res), "POST", server.URL, strings.NewReader("payload"))
+	req.Header.Set(idempotency.HeaderName, keys[0])
+	resp, err = c.Do(req)
+	if err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	resp.Body.Close()
+
+	if !res.Replayed {
+		t.Errorf("want replayed = true, got false")
+	}
+}
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// Propagator is an http.RoundTripper that sets the Idempotency-Key of the
+// incoming request, as stored in the context with idempotency.NewContext, on
+// outgoing requests. This carries the idempotency of a request across
+// services.
+type Propagator struct {
+	base   http.RoundTripper
+	derive func(key string, r *http.Request) string
+}
+
+// NewPropagator creates a Propagator sending requests with base, or
+// http.DefaultTransport if nil. The key set on outgoing requests is derived
+// with derive, or the key itself if nil. Requests which already have an
+// Idempotency-Key are left untouched.
+func NewPropagator(base http.RoundTripper, derive func(key string, r *http.Request) string) *Propagator {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &Propagator{
+		base:   base,
+		derive: derive,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (p *Propagator) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, ok := idempotency.FromContext(req.Context())
+	if !ok || key == "" || req.Header.Get(idempotency.HeaderName) != "" {
+		return p.base.RoundTrip(req)
+	}
+
+	if p.derive != nil {
+		key = p.derive(key, req)
+	}
+
+	r := req.Clone(req.Context())
+	r.Header.Set(idempotency.HeaderName, key)
+	return p.base.RoundTrip(r)
+}
+
+// SubKey derives a key from key and parts, so that several distinct
+// downstream calls made for one request get keys of their own which are
+// still stable across retries of that request.
+func SubKey(key string, parts ...string) string {
+	h := sha256.New()
+	h.Write([]byte(key))
+	for _, part := range parts {
+		h.Write([]byte{0})
+		h.Write([]byte(part))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// DeriveByEndpoint derives a sub key per method, host and path of the
+// outgoing request, it can be used with NewPropagator.
+func DeriveByEndpoint(key string, r *http.Request) string {
+	return SubKey(key, r.Method, r.URL.Host, r.URL.Path)
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+func TestPropagator(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(idempotency.HeaderName)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		derive func(key string, r *http.Request) string
+		want   string
+	}{
+		{name: "Key is propagated", ctx: idempotency.NewContext(context.Background(), "deadbeef"), want: "deadbeef"},
+		{name: "Key is derived", ctx: idempotency.NewContext(context.Background(), "deadbeef"), derive: func(key string, r *http.Request) string {
+			return SubKey(key, "payments")
+		}, want: SubKey("deadbeef", "payments")},
+		{name: "No key in context", ctx: context.Background(), want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &http.Client{Transport: NewPropagator(nil, test.derive)}
+			req, _ := http.NewRequestWithContext(test.ctx, "POST", server.URL, nil)
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatalf("want no error, got %v", err)
+			}
+			resp.Body.Close()
+
+			if got != test.want {
+				t.Errorf("want idempotency key = %q, got %q", test.want, got)
+			}
+		})
+	}
+}
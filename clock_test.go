@@ -0,0 +1,60 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStorageClock(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Now())
+	storage := NewMemoryStorage(WithMemoryClock(clock))
+	s := New(storage, WithTTL(time.Minute), WithClock(clock))
+
+	d, err := s.Reserve(ctx, "key", "")
+	if err != nil || d.Outcome != OutcomeNew {
+		t.Fatalf("want key reserved, got %+v, %v", d, err)
+	}
+
+	clock.Advance(59 * time.Second)
+	if d, _ := s.Check(ctx, "key", ""); d.Outcome != OutcomeInProcess {
+		t.Errorf("want key in process before the TTL, got %v", d.Outcome)
+	}
+
+	// Extending the reservation moves the expiry relative to the clock.
+	if err := d.Reservation.Extend(ctx, time.Minute); err != nil {
+		t.Fatalf("want reservation extended, got %v", err)
+	}
+	clock.Advance(59 * time.Second)
+	if d, _ := s.Check(ctx, "key", ""); d.Outcome != OutcomeInProcess {
+		t.Errorf("want extended key in process, got %v", d.Outcome)
+	}
+
+	clock.Advance(time.Second)
+	if d, _ := s.Check(ctx, "key", ""); d.Outcome != OutcomeNew {
+		t.Errorf("want key expired after the TTL, got %v", d.Outcome)
+	}
+}
+
+func TestFakeClockAfter(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	ch := clock.After(time.Second)
+
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case <-ch:
+		t.Fatal("want no tick before the duration")
+	default:
+	}
+
+	clock.Advance(time.Millisecond)
+	select {
+	case now := <-ch:
+		if !now.Equal(time.Unix(1, 0)) {
+			t.Errorf("want tick at %v, got %v", time.Unix(1, 0), now)
+		}
+	default:
+		t.Fatal("want tick after the duration")
+	}
+}
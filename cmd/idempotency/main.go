@@ -0,0 +1,107 @@
+// Command idempotency exports and imports the keys of a Redis storage, e.g.
+// to back them up before maintenance or to move them to another instance.
+//
+//	idempotency export -redis redis://localhost:6379/0 -file keys.jsonl
+//	idempotency import -redis redis://other:6379/0 -file keys.jsonl
+//
+// Without -file, keys are exported to stdout and imported from stdin.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/Preciselyco/idempotency"
+	"github.com/redis/go-redis/v9"
+)
+
+const usage = "usage: idempotency export|import -redis URL [-prefix PREFIX] [-expiry DURATION] [-file FILE]"
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "idempotency:", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command with args, reading imports from stdin and writing
+// exports to stdout unless a file is given, and reporting to stderr.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	command := args[0]
+	if command != "export" && command != "import" {
+		return fmt.Errorf("unknown command %q\n%s", command, usage)
+	}
+
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	url := flags.String("redis", "", "URL of the Redis server, e.g. redis://localhost:6379/0")
+	prefix := flags.String("prefix", "", "key prefix of the storage")
+	expiry := flags.Duration("expiry", 24*time.Hour, "expiry of imported keys exported without a TTL")
+	file := flags.String("file", "", "file to export to or import from, instead of stdout or stdin")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *url == "" {
+		return errors.New("-redis is required")
+	}
+
+	opts, err := redis.ParseURL(*url)
+	if err != nil {
+		return fmt.Errorf("failed to parse the Redis URL: %w", err)
+	}
+	client := redis.NewClient(opts)
+	defer client.Close()
+	storage := idempotency.NewRedisStorage(client, *expiry, idempotency.WithKeyPrefix(*prefix))
+
+	var n int
+	if command == "export" {
+		n, err = exportKeys(ctx, storage, *file, stdout)
+	} else {
+		n, err = importKeys(ctx, storage, *file, stdin)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stderr, "%sed %d keys\n", command, n)
+	return nil
+}
+
+// exportKeys exports the keys of storage to file, or to stdout without one.
+func exportKeys(ctx context.Context, storage idempotency.Storage, file string, stdout io.Writer) (int, error) {
+	if file == "" {
+		return idempotency.Export(ctx, storage, stdout)
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return 0, err
+	}
+	n, err := idempotency.Export(ctx, storage, f)
+	return n, errors.Join(err, f.Close())
+}
+
+// importKeys imports the keys of file, or of stdin without one, to storage.
+func importKeys(ctx context.Context, storage idempotency.Storage, file string, stdin io.Reader) (int, error) {
+	if file == "" {
+		return idempotency.Import(ctx, storage, stdin)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return idempotency.Import(ctx, storage, f)
+}
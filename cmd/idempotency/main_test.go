@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Preciselyco/idempotency"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	url := "redis://" + mr.Addr()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	from := idempotency.NewRedisStorage(client, time.Hour, idempotency.WithKeyPrefix("from:"))
+	to := idempotency.NewRedisStorage(client, time.Hour, idempotency.WithKeyPrefix("to:"))
+	from.AddStatus(ctx, "done", &idempotency.RequestStatus{Fingerprint: "a"}, time.Minute)
+	from.AddStatus(ctx, "persistent", &idempotency.RequestStatus{Fingerprint: "b"}, 0)
+	from.Persist(ctx, "persistent")
+
+	// Through a file.
+	file := filepath.Join(t.TempDir(), "keys.jsonl")
+	var stderr bytes.Buffer
+	if err := run(ctx, []string{"export", "-redis", url, "-prefix", "from:", "-file", file}, nil, io.Discard, &stderr); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if got := stderr.String(); got != "exported 2 keys\n" {
+		t.Errorf("export: want 2 keys reported, got %q", got)
+	}
+	if err := run(ctx, []string{"import", "-redis", url, "-prefix", "to:", "-file", file}, nil, io.Discard, io.Discard); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if status, _ := to.Get(ctx, "done"); status == nil || status.Fingerprint != "a" {
+		t.Errorf("want imported key, got %+v", status)
+	}
+	if ttl, _, _ := to.TTL(ctx, "done"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("want TTL kept, got %v", ttl)
+	}
+	if ttl, exists, _ := to.TTL(ctx, "persistent"); !exists || ttl != 0 {
+		t.Errorf("want key without expiry kept, got %v, %v", ttl, exists)
+	}
+
+	// Through stdout and stdin.
+	var keys bytes.Buffer
+	if err := run(ctx, []string{"export", "-redis", url, "-prefix", "from:"}, nil, &keys, io.Discard); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	stderr.Reset()
+	if err := run(ctx, []string{"import", "-redis", url, "-prefix", "other:"}, &keys, io.Discard, &stderr); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if got := stderr.String(); got != "imported 2 keys\n" {
+		t.Errorf("import: want 2 keys reported, got %q", got)
+	}
+}
+
+func TestRunInvalid(t *testing.T) {
+	tests := [][]string{
+		nil,
+		{"delete", "-redis", "redis://localhost:6379"},
+		{"export"},
+		{"export", "-redis", "://"},
+		{"export", "-unknown"},
+	}
+	for _, args := range tests {
+		if err := run(context.Background(), args, nil, io.Discard, io.Discard); err == nil {
+			t.Errorf("%q: want error", args)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// defaultTxRetries is the number of retries of statements failing with a
+// serialization error on CockroachDB.
+const defaultTxRetries = 5
+
+// WithTxRetries configures how often statements failing with a serialization
+// error (SQLSTATE 40001) are retried, which CockroachDB returns under
+// contention, or chosen as deadlock victims by SQL Server. Statements in a transaction of NewTxContext are not retried,
+// as the whole transaction has to be retried by the application.
+func WithTxRetries(n int) SQLStorageOption {
+	return func(s *sqlStorage) {
+		s.txRetries = n
+	}
+}
+
+// NewCockroachStorage creates a SQL storage tuned for CockroachDB, with the
+// schema of NewSQLStorage and PostgresDialect. Statements are retried on
+// serialization errors, see WithTxRetries, and Migrate configures row-level TTL on expires_at so that CockroachDB removes
+// expired keys by itself.
+func NewCockroachStorage(db *sql.DB, expiry time.Duration, opts ...SQLStorageOption) *sqlStorage {
+	opts = append([]SQLStorageOption{WithDialect(PostgresDialect), WithTxRetries(defaultTxRetries)}, opts...)
+	s := NewSQLStorage(db, expiry, opts...)
+	s.rowTTL = true
+	return s
+}
+
+// sqlStateError is implemented by the errors of PostgreSQL drivers, e.g. pgx
+// and lib/pq.
+type sqlStateError interface {
+	SQLState() string
+}
+
+// isSerializationFailure reports whether err is a serialization error or a
+// deadlock, which are resolved by retrying.
+func isSerializationFailure(err error) bool {
+	var e sqlStateError
+	if errors.As(err, &e) {
+		return e.SQLState() == "40001"
+	}
+	var n sqlErrorNumberError
+	return errors.As(err, &n) && n.SQLErrorNumber() == sqlServerDeadlock
+}
+
+// exec runs query, retrying serialization errors unless it runs in the
+// transaction of ctx.
+func (s *sqlStorage) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	exec := s.executor(ctx)
+	_, inTx := exec.(*sql.Tx)
+
+	for attempt := 0; ; attempt++ {
+		res, err := exec.ExecContext(ctx, query, args...)
+		if err == nil || inTx || attempt >= s.txRetries || !isSerializationFailure(err) {
+			return res, err
+		}
+
+		t := time.NewTimer(time.Duration(attempt+1) * 10 * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, err
+		case <-t.C:
+		}
+	}
+}
+
+// enableRowTTL configures CockroachDB to remove expired keys, keys without
+// expiry are kept.
+func (s *sqlStorage) enableRowTTL(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, "ALTER TABLE "+s.table+" SET (ttl_expiration_expression = "+
+		"'CASE WHEN expires_at = 0 THEN NULL ELSE to_timestamp(expires_at::FLOAT8 / 1000) END', ttl_job_cron = '@hourly')")
+	if err != nil {
+		return fmt.Errorf("failed to enable row-level TTL in sql: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package idempotency
+
+import (
+	"net/http"
+	"sync"
+)
+
+// concurrencyLimiter limits the number of requests in process by this
+// instance, globally and per tenant.
+type concurrencyLimiter struct {
+	mu          sync.Mutex
+	global      int
+	perTenant   int
+	tenant      func(r *http.Request) string
+	inProcess   int
+	perTenantIn map[string]int
+}
+
+// WithConcurrencyLimit limits how many requests with an Idempotency-Key can
+// be in the middleware at the same time, including requests that are
+// replayed or conflict, as the limit is checked before any storage is
+// accessed. Requests exceeding the limit get a 503 Service Unavailable with a
+// Retry-After, which protects the storage and the handler during retry
+// storms. The limit is per instance and shared by states created with With,
+// unless they configure their own limits, which are then counted separately.
+// It is not applied by Decide.
+func WithConcurrencyLimit(limit int) Option {
+	return func(s *State) {
+		s.limiter = s.concurrencyLimiter()
+		s.limiter.global = limit
+	}
+}
+
+// WithTenantConcurrencyLimit limits how many requests with an Idempotency-Key
+// can be in the middleware at the same time per tenant, as identified by the
+// tenant function, like WithConcurrencyLimit.
+func WithTenantConcurrencyLimit(limit int, tenant func(r *http.Request) string) Option {
+	return func(s *State) {
+		s.limiter = s.concurrencyLimiter()
+		s.limiter.perTenant = limit
+		s.limiter.tenant = tenant
+	}
+}
+
+// WithLoadShedding configures a function called before any storage is
+// accessed, e.g. to check an external load-shedding signal. Requests it
+// sheds get a 503 Service Unavailable with a Retry-After like requests
+// exceeding WithConcurrencyLimit, so that the storage calls of the
+// middleware do not add to an overload.
+func WithLoadShedding(shed func(r *http.Request) bool) Option {
+	return func(s *State) {
+		s.shed = shed
+	}
+}
+
+// concurrencyLimiter returns a new limiter with the limits of s, so that
+// configuring a state created with With does not change the limiter it
+// shares with its parent.
+func (s *State) concurrencyLimiter() *concurrencyLimiter {
+	l := &concurrencyLimiter{perTenantIn: make(map[string]int)}
+	if s.limiter != nil {
+		l.global = s.limiter.global
+		l.perTenant = s.limiter.perTenant
+		l.tenant = s.limiter.tenant
+	}
+	return l
+}
+
+// acquire reserves a slot for r, the returned function releases it.
+func (l *concurrencyLimiter) acquire(r *http.Request) (func(), bool) {
+	if l == nil {
+		return func() {}, true
+	}
+
+	var tenant string
+	if l.tenant != nil {
+		tenant = l.tenant(r)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.global > 0 && l.inProcess >= l.global {
+		return nil, false
+	}
+	if l.perTenant > 0 && tenant != "" && l.perTenantIn[tenant] >= l.perTenant {
+		return nil, false
+	}
+
+	l.inProcess++
+	if tenant != "" {
+		l.perTenantIn[tenant]++
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			l.inProcess--
+			if tenant != "" {
+				l.perTenantIn[tenant]--
+				if l.perTenantIn[tenant] == 0 {
+					delete(l.perTenantIn, tenant)
+				}
+			}
+		})
+	}, true
+}
+
+// overloaded responds to a request exceeding the concurrency limits.
+func (s *State) overloaded(idempotencyKey string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	if !s.shadow {
+		w.Header().Set("Retry-After", "1")
+	}
+	s.reject(Event{Key: idempotencyKey, Outcome: OutcomeOverloaded}, errOverloaded, http.StatusServiceUnavailable, next, w, r)
+}
@@ -0,0 +1,98 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	tenant := func(r *http.Request) string {
+		return r.Header.Get("X-Tenant")
+	}
+
+	tests := []struct {
+		name           string
+		opt            Option
+		tenant         string
+		wantHTTPStatus int
+	}{
+		{name: "Global limit exceeded", opt: WithConcurrencyLimit(1), tenant: "a", wantHTTPStatus: http.StatusServiceUnavailable},
+		{name: "Tenant limit exceeded", opt: WithTenantConcurrencyLimit(1, tenant), tenant: "a", wantHTTPStatus: http.StatusServiceUnavailable},
+		{name: "Other tenant is not limited", opt: WithTenantConcurrencyLimit(1, tenant), tenant: "b", wantHTTPStatus: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var handler http.Handler
+			inner := httptest.NewRecorder()
+			handler = New(NewMemoryStorage(), test.opt).Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get(HeaderName) != "outer" {
+					return
+				}
+
+				// Issue a request while the outer one is in process.
+				req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+				req.Header.Set(HeaderName, "inner")
+				req.Header.Set("X-Tenant", test.tenant)
+				handler.ServeHTTP(inner, req)
+			}))
+
+			req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+			req.Header.Set(HeaderName, "outer")
+			req.Header.Set("X-Tenant", "a")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if inner.Code != test.wantHTTPStatus {
+				t.Errorf("want status code %v, got %v", test.wantHTTPStatus, inner.Code)
+			}
+			if test.wantHTTPStatus == http.StatusServiceUnavailable && inner.Header().Get("Retry-After") == "" {
+				t.Errorf("want Retry-After header")
+			}
+
+			// The slot is released once the outer request completed.
+			req = httptest.NewRequest("POST", "http://example.com/foo", nil)
+			req.Header.Set(HeaderName, "after")
+			req.Header.Set("X-Tenant", "a")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Errorf("want status code %v after completion, got %v", http.StatusOK, w.Code)
+			}
+		})
+	}
+}
+
+func TestLoadShedding(t *testing.T) {
+	storage := &countingStorage{StatusStorage: NewMemoryStorage()}
+	shedding := true
+	s := New(storage, WithLoadShedding(func(r *http.Request) bool { return shedding }))
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+		req.Header.Set(HeaderName, "key")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve(); w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" || storage.gets != 0 {
+		t.Errorf("want request shed before the storage, got %d with %d storage calls", w.Code, storage.gets)
+	}
+	shedding = false
+	if w := serve(); w.Code != http.StatusOK {
+		t.Errorf("want request processed, got %d", w.Code)
+	}
+}
+
+func TestConcurrencyLimitWith(t *testing.T) {
+	api := New(NewMemoryStorage(), WithConcurrencyLimit(1))
+	route := api.With(WithConcurrencyLimit(5))
+
+	if api.limiter.global != 1 || route.limiter.global != 5 {
+		t.Errorf("want limits 1 and 5, got %d and %d", api.limiter.global, route.limiter.global)
+	}
+	if shared := api.With(); shared.limiter != api.limiter {
+		t.Error("want limiter shared without own limits")
+	}
+}
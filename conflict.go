@@ -0,0 +1,96 @@
+package idempotency
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// defaultRetryDelay is the retry delay suggested for keys in process when it
+// cannot be derived from their expiry.
+const defaultRetryDelay = time.Second
+
+// ConflictError is the error passed to the error responder for a request
+// whose key is in process, with details allowing clients to back off.
+type ConflictError struct {
+	// State is the state of the key, "in_process".
+	State string
+	// StartedAt is when the key was reserved, zero if unknown.
+	StartedAt time.Time
+	// RetryAfter is the suggested delay before retrying.
+	RetryAfter time.Duration
+}
+
+func (e *ConflictError) Error() string {
+	return "request already in progress"
+}
+
+// newConflictError returns the ConflictError for status, retrying after
+// delay.
+func newConflictError(status *RequestStatus, delay time.Duration) *ConflictError {
+	e := &ConflictError{State: "in_process", RetryAfter: delay}
+	if status != nil && status.StartedAt > 0 {
+		e.StartedAt = time.UnixMilli(status.StartedAt).UTC()
+	}
+	return e
+}
+
+// conflictDetails are the members describing a ConflictError in JSON
+// responses.
+type conflictDetails struct {
+	State        string     `json:"state,omitempty"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	RetryAfterMS int64      `json:"retry_after_ms,omitempty"`
+}
+
+// details returns the JSON members of err if it is a ConflictError.
+func details(err error) conflictDetails {
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		return conflictDetails{}
+	}
+
+	d := conflictDetails{State: conflict.State, RetryAfterMS: conflict.RetryAfter.Milliseconds()}
+	if !conflict.StartedAt.IsZero() {
+		d.StartedAt = &conflict.StartedAt
+	}
+	return d
+}
+
+// WithConflictDetails configures whether 409 Conflict responses for keys in
+// process have a JSON body with the state of the key, when it was started
+// and the suggested retry delay in milliseconds, so that clients can back
+// off. Other errors stay plain text. The Draft06 profile always includes
+// these details in its Problem Details. Like WithProfile, it replaces the
+// error responder.
+func WithConflictDetails(enabled bool) Option {
+	return func(s *State) {
+		if enabled {
+			s.errResponder = conflictResponder
+		}
+	}
+}
+
+// conflictResponder responds with JSON to conflicts and with plain text to
+// other errors.
+func conflictResponder(err error, status int, w http.ResponseWriter, r *http.Request) {
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	body := struct {
+		Error string `json:"error"`
+		conflictDetails
+	}{
+		Error:           err.Error(),
+		conflictDetails: details(err),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,67 @@
+package idempotency
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConflictDetails(t *testing.T) {
+	tests := []struct {
+		name             string
+		opts             []Option
+		wantContentType  string
+		wantRetryAfterMS int64
+	}{
+		{name: "Plain text by default", wantContentType: "text/plain; charset=utf-8"},
+		{name: "JSON details", opts: []Option{WithConflictDetails(true)}, wantContentType: "application/json", wantRetryAfterMS: 1000},
+		{name: "JSON details with TTL", opts: []Option{WithConflictDetails(true), WithRetryAfter(5 * time.Second)}, wantContentType: "application/json", wantRetryAfterMS: 5000},
+		{name: "Problem details", opts: []Option{WithProfile(Latest), WithScope(func(r *http.Request) string { return "" })}, wantContentType: "application/problem+json", wantRetryAfterMS: 1000},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var handler http.Handler
+			inner := httptest.NewRecorder()
+			handler = New(NewMemoryStorage(), append([]Option{WithTTL(time.Minute)}, test.opts...)...).Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("X-Inner") != "" {
+					return
+				}
+
+				// Repeat the request while it is in process.
+				req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+				req.Header.Set(HeaderName, `"key"`)
+				req.Header.Set("X-Inner", "true")
+				handler.ServeHTTP(inner, req)
+			}))
+
+			req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+			req.Header.Set(HeaderName, `"key"`)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if inner.Code != http.StatusConflict {
+				t.Fatalf("want status code %v, got %v", http.StatusConflict, inner.Code)
+			}
+			if got := inner.Header().Get("Content-Type"); got != test.wantContentType {
+				t.Fatalf("want content type %q, got %q", test.wantContentType, got)
+			}
+			if test.wantRetryAfterMS == 0 {
+				return
+			}
+
+			var body struct {
+				State        string    `json:"state"`
+				StartedAt    time.Time `json:"started_at"`
+				RetryAfterMS int64     `json:"retry_after_ms"`
+			}
+			if err := json.Unmarshal(inner.Body.Bytes(), &body); err != nil {
+				t.Fatalf("want JSON body, got %v", err)
+			}
+			if body.State != "in_process" || body.StartedAt.IsZero() || body.RetryAfterMS != test.wantRetryAfterMS {
+				t.Errorf("want in_process with started_at and retry_after_ms %d, got %+v", test.wantRetryAfterMS, body)
+			}
+		})
+	}
+}
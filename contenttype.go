@@ -0,0 +1,98 @@
+package idempotency
+
+import (
+	"maps"
+	"mime"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// WithContentTypes restricts idempotency handling to requests with one of
+// mediaTypes, e.g. "application/json" or "text/*". Requests with other
+// content types, e.g. binary uploads, are passed to the handler without
+// handling, as if the key was optional and missing. Requests without a
+// Content-Type are handled.
+func WithContentTypes(mediaTypes ...string) Option {
+	return func(s *State) {
+		s.mediaTypes = append(slices.Clip(s.mediaTypes), mediaTypes...)
+	}
+}
+
+// WithContentType configures opts for requests with mediaType, e.g.
+// "multipart/form-data", on top of the other options of the State, e.g. to
+// fingerprint them differently or not to capture their responses.
+func WithContentType(mediaType string, opts ...Option) Option {
+	return func(s *State) {
+		// Copied, since the map is shared with the State of With.
+		mediaTypeOpts := maps.Clone(s.mediaTypeOpts)
+		if mediaTypeOpts == nil {
+			mediaTypeOpts = make(map[string][]Option)
+		}
+		mediaTypeOpts[mediaType] = append(slices.Clip(mediaTypeOpts[mediaType]), opts...)
+		s.mediaTypeOpts = mediaTypeOpts
+	}
+}
+
+// matchMediaType returns the pattern of patterns matching mediaType, which
+// may be a wildcard such as "text/*".
+func matchMediaType(patterns []string, mediaType string) (string, bool) {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "/*"); ok {
+			if t, _, _ := strings.Cut(mediaType, "/"); strings.EqualFold(t, prefix) {
+				return p, true
+			}
+			continue
+		}
+		if strings.EqualFold(p, mediaType) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// verifyContentTypes returns a handler verifying requests with the options
+// of their content type.
+func (s *State) verifyContentTypes(next http.Handler) http.Handler {
+	base := s.With()
+	base.mediaTypes = nil
+	base.mediaTypeOpts = nil
+
+	patterns := make([]string, 0, len(s.mediaTypeOpts))
+	handlers := make(map[string]http.Handler, len(s.mediaTypeOpts))
+	for mediaType, opts := range s.mediaTypeOpts {
+		cs := base.With(opts...)
+		cs.mediaTypes = nil
+		cs.mediaTypeOpts = nil
+		patterns = append(patterns, mediaType)
+		handlers[mediaType] = cs.Verify(next)
+	}
+	// Exact media types take precedence over wildcards.
+	sort.SliceStable(patterns, func(i, j int) bool {
+		return !strings.HasSuffix(patterns[i], "/*") && strings.HasSuffix(patterns[j], "/*")
+	})
+	fallback := base.Verify(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Content-Type")
+		if header == "" {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+
+		mediaType, _, err := mime.ParseMediaType(header)
+		if err != nil {
+			mediaType = header
+		}
+		if _, ok := matchMediaType(s.mediaTypes, mediaType); len(s.mediaTypes) > 0 && !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if p, ok := matchMediaType(patterns, mediaType); ok {
+			handlers[p].ServeHTTP(w, r)
+			return
+		}
+		fallback.ServeHTTP(w, r)
+	})
+}
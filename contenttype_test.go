@@ -0,0 +1,48 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestContentTypes(t *testing.T) {
+	s := New(NewMemoryStorage(),
+		WithContentTypes("application/json", "multipart/*"),
+		WithContentType("multipart/*", WithRequirement(Optional)),
+	)
+
+	calls := 0
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+
+	tests := []struct {
+		name        string
+		contentType string
+		key         string
+		wantCode    int
+		wantCalls   int
+	}{
+		{name: "Handled", contentType: "application/json; charset=utf-8", wantCode: http.StatusBadRequest, wantCalls: 0},
+		{name: "Without Content-Type", wantCode: http.StatusBadRequest, wantCalls: 0},
+		{name: "Excluded", contentType: "application/octet-stream", wantCode: http.StatusOK, wantCalls: 1},
+		{name: "Options of the content type", contentType: "multipart/form-data; boundary=x", wantCode: http.StatusOK, wantCalls: 2},
+		{name: "New key", contentType: "application/json", key: "a", wantCode: http.StatusOK, wantCalls: 3},
+		{name: "Replay", contentType: "application/json", key: "a", wantCode: http.StatusOK, wantCalls: 3},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		if tt.key != "" {
+			req.Header.Set(HeaderName, tt.key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.wantCode || calls != tt.wantCalls {
+			t.Errorf("%s: want %d after %d calls, got %d after %d", tt.name, tt.wantCode, tt.wantCalls, w.Code, calls)
+		}
+	}
+}
@@ -5,7 +5,7 @@ import "context"
 type contextKey string
 
 // idempotencyContextKey defines which key to use for context.Context.
-var idempotencyContextKey contextKey = "idempotency-key"
+const idempotencyContextKey contextKey = "idempotency-key"
 
 // NewContext returns a new Context that carries value idempotencyKey.
 func NewContext(ctx context.Context, idempotencyKey string) context.Context {
@@ -17,3 +17,44 @@ func FromContext(ctx context.Context) (string, bool) {
 	key, ok := ctx.Value(idempotencyContextKey).(string)
 	return key, ok
 }
+
+// statusContextKey defines which key to use for the RequestStatus in
+// context.Context.
+const statusContextKey contextKey = "idempotency-status"
+
+// NewStatusContext returns a new Context that carries status.
+func NewStatusContext(ctx context.Context, status *RequestStatus) context.Context {
+	return context.WithValue(ctx, statusContextKey, status)
+}
+
+// StatusFromContext returns the RequestStatus stored in ctx, if any. Verify
+// stores the status of the key, which is the reserved status for requests
+// passed to the handler.
+func StatusFromContext(ctx context.Context) (*RequestStatus, bool) {
+	status, ok := ctx.Value(statusContextKey).(*RequestStatus)
+	return status, ok && status != nil
+}
+
+// outcomeContextKey defines which key to use for the Outcome in
+// context.Context.
+const outcomeContextKey contextKey = "idempotency-outcome"
+
+// newOutcomeContext returns a new Context that carries outcome.
+func newOutcomeContext(ctx context.Context, outcome Outcome) context.Context {
+	return context.WithValue(ctx, outcomeContextKey, outcome)
+}
+
+// IsFirstAttempt reports whether Verify found the key of the request to be
+// new, so that the handler is processing it for the first time.
+func IsFirstAttempt(ctx context.Context) bool {
+	outcome, ok := ctx.Value(outcomeContextKey).(Outcome)
+	return ok && outcome == OutcomeNew
+}
+
+// IsReplay reports whether Verify found the request to be a duplicate of a
+// request which is completed or in process. Handlers only see such requests
+// in shadow mode, e.g. to skip side effects like sending a second email.
+func IsReplay(ctx context.Context) bool {
+	outcome, ok := ctx.Value(outcomeContextKey).(Outcome)
+	return ok && (outcome == OutcomeCompleted || outcome == OutcomeInProcess)
+}
@@ -2,6 +2,9 @@ package idempotency
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -20,3 +23,47 @@ func TestContext(t *testing.T) {
 		t.Errorf("want idempotency key = %v, got %v", have, got)
 	}
 }
+
+func TestVerifyContext(t *testing.T) {
+	var (
+		gotKey    string
+		gotStatus *RequestStatus
+	)
+	s := New(NewMemoryStorage(), WithFingerprint(BodyFingerprint))
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey, _ = FromContext(r.Context())
+		gotStatus, _ = StatusFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest("POST", "http://example.com/foo", strings.NewReader("body"))
+	req.Header.Set(HeaderName, "key")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if gotKey != "key" {
+		t.Errorf("want idempotency key = %v, got %v", "key", gotKey)
+	}
+	if gotStatus == nil || !gotStatus.InProcess || gotStatus.Fingerprint == "" {
+		t.Errorf("want reserved status, got %+v", gotStatus)
+	}
+}
+
+func TestAttemptContext(t *testing.T) {
+	type attempt struct{ first, replay bool }
+	var got []attempt
+
+	s := New(NewMemoryStorage(), WithShadow(true))
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, attempt{first: IsFirstAttempt(r.Context()), replay: IsReplay(r.Context())})
+	}))
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+		req.Header.Set(HeaderName, "key")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	want := []attempt{{first: true}, {replay: true}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("want attempts %+v, got %+v", want, got)
+	}
+}
@@ -0,0 +1,49 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+)
+
+// OriginalRequestIDHeaderName is the name of the header carrying the request
+// ID of the original request on replayed responses, see WithRequestID.
+const OriginalRequestIDHeaderName = "X-Original-Request-Id"
+
+// requestIDContextKey defines which key to use for the request ID in
+// context.Context.
+const requestIDContextKey contextKey = "idempotency-request-id"
+
+// WithRequestID stores the request ID of the request reserving a key from
+// the first of headers that is set, e.g. X-Request-Id or traceparent. It is
+// returned on replays as X-Original-Request-Id, so that the original
+// execution of a replayed response can be traced. It requires a storage
+// implementing StatusStorage.
+func WithRequestID(headers ...string) Option {
+	return func(s *State) {
+		s.requestIDHeaders = headers
+	}
+}
+
+// requestID returns the request ID of r.
+func (s *State) requestID(r *http.Request) string {
+	for _, h := range s.requestIDHeaders {
+		if v := r.Header.Get(h); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// requestIDFromContext returns the request ID stored in ctx, if any.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// setOriginalRequestID sets the request ID of the original request on a
+// replay.
+func setOriginalRequestID(w http.ResponseWriter, status *RequestStatus) {
+	if status.RequestID != "" {
+		w.Header().Set(OriginalRequestIDHeaderName, status.RequestID)
+	}
+}
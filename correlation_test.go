@@ -0,0 +1,39 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithRequestID(t *testing.T) {
+	tests := []struct {
+		name    string
+		capture bool
+	}{
+		{name: "Restorer", capture: false},
+		{name: "Captured response", capture: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := New(NewMemoryStorage(), WithRequestID("X-Request-Id", "traceparent"), WithResponseCapture(test.capture))
+			handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			serve := func(requestID string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+				req.Header.Set(HeaderName, "key")
+				req.Header.Set("traceparent", requestID)
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+				return w
+			}
+
+			if w := serve("first"); w.Header().Get(OriginalRequestIDHeaderName) != "" {
+				t.Errorf("want no original request ID on the first request, got %q", w.Header().Get(OriginalRequestIDHeaderName))
+			}
+			if w := serve("second"); w.Header().Get(OriginalRequestIDHeaderName) != "first" {
+				t.Errorf("want original request ID first on replay, got %q", w.Header().Get(OriginalRequestIDHeaderName))
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DebugHeaderName is the name of the response header carrying the decision
+// trace of WithDebug.
+const DebugHeaderName = "Idempotency-Debug"
+
+// debugContextKey is the key of the *debugTrace of a request in
+// context.Context.
+const debugContextKey contextKey = "idempotency-debug"
+
+// WithDebug traces the decisions of the middleware: the key, the storage key
+// including its scope, digests of the fingerprints, the status found in the
+// storage and the chosen outcome. The trace is logged at the debug level with
+// the Logger of WithLogger and, if headers is true, set as the
+// Idempotency-Debug response header. Headers expose internals and should not
+// be enabled in production.
+func WithDebug(headers bool) Option {
+	return func(s *State) {
+		s.debug = &debugMode{headers: headers}
+	}
+}
+
+type debugMode struct {
+	headers bool
+}
+
+// debugTrace collects the decisions of the middleware for a request.
+type debugTrace struct {
+	header      http.Header
+	storageKey  string
+	fingerprint string
+	stored      *RequestStatus
+}
+
+func newDebugTrace(s *State, w http.ResponseWriter) *debugTrace {
+	t := &debugTrace{}
+	if s.debug.headers {
+		t.header = w.Header()
+	}
+	return t
+}
+
+// debugFromContext returns the trace of a request, which is nil unless
+// WithDebug is configured.
+func debugFromContext(r *http.Request) *debugTrace {
+	t, _ := r.Context().Value(debugContextKey).(*debugTrace)
+	return t
+}
+
+// digest shortens fingerprints, which may be long or sensitive.
+func digest(fingerprint string) string {
+	if fingerprint == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(fingerprint))
+	return hex.EncodeToString(sum[:4])
+}
+
+// storageResult describes the status found in the storage.
+func (t *debugTrace) storageResult(e Event) string {
+	if t.stored == nil {
+		return "none"
+	}
+	if e.Outcome == OutcomeNew {
+		return "reserved"
+	}
+
+	var b strings.Builder
+	switch {
+	case t.stored.InProcess:
+		b.WriteString("in-process")
+	case t.stored.Failed:
+		b.WriteString("failed")
+	default:
+		b.WriteString("completed")
+	}
+	if t.stored.Owner != "" {
+		b.WriteString(" owner=" + t.stored.Owner)
+	}
+	if t.stored.Instance != "" {
+		b.WriteString(" instance=" + t.stored.Instance)
+	}
+	if t.stored.Token != 0 {
+		b.WriteString(" token=" + strconv.FormatInt(t.stored.Token, 10))
+	}
+	if t.stored.Fingerprint != "" {
+		b.WriteString(" fingerprint=" + digest(t.stored.Fingerprint))
+	}
+	return b.String()
+}
+
+// report logs the trace and sets its header once the outcome is known.
+func (t *debugTrace) report(s *State, r *http.Request, e Event) {
+	fields := []string{
+		"key", e.Key,
+		"storage-key", t.storageKey,
+		"fingerprint", digest(t.fingerprint),
+		"storage", t.storageResult(e),
+		"outcome", e.Outcome.String(),
+	}
+	if e.Err != nil {
+		fields = append(fields, "error", e.Err.Error())
+	}
+
+	if s.logger != nil {
+		args := make([]any, 0, len(fields)+4)
+		args = append(args, "method", r.Method, "path", r.URL.Path)
+		for _, f := range fields {
+			args = append(args, f)
+		}
+		s.logger.Debug("idempotency: decision trace", args...)
+	}
+
+	if t.header != nil {
+		parts := make([]string, 0, len(fields)/2)
+		for i := 0; i < len(fields); i += 2 {
+			if fields[i+1] != "" {
+				parts = append(parts, fmt.Sprintf("%s=%q", fields[i], fields[i+1]))
+			}
+		}
+		t.header.Set(DebugHeaderName, strings.Join(parts, "; "))
+	}
+}
@@ -0,0 +1,62 @@
+package idempotency
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithDebug(t *testing.T) {
+	var buf bytes.Buffer
+	s := New(NewMemoryStorage(), WithDebug(true), WithOwner(func(r *http.Request) string { return "alice" }),
+		WithLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+		WithFingerprint(func(r *http.Request) (string, error) { return r.URL.RawQuery, nil }))
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	serve := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "http://example.com/foo?"+query, nil)
+		req.Header.Set(HeaderName, "key")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- serve("a") }()
+	<-started
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{name: "In process", query: "a", want: []string{`storage="in-process owner=alice`, `outcome="in-process"`, `fingerprint="` + digest("a")}},
+		{name: "Mismatch", query: "b", want: []string{`outcome="mismatch"`, `fingerprint="` + digest("b"), `fingerprint=` + digest("a")}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := serve(test.query).Header().Get(DebugHeaderName)
+			for _, want := range test.want {
+				if !strings.Contains(header, want) {
+					t.Errorf("want %s in %q", want, header)
+				}
+			}
+		})
+	}
+
+	close(release)
+	if header := (<-done).Header().Get(DebugHeaderName); !strings.Contains(header, `storage="reserved"`) {
+		t.Errorf("want reserved key traced, got %q", header)
+	}
+	if n := strings.Count(buf.String(), "decision trace"); n != 3 {
+		t.Errorf("want 3 traces logged, got %d", n)
+	}
+}
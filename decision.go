@@ -0,0 +1,352 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Outcome classifies what should happen with a request given the state of
+// its idempotency key.
+type Outcome int
+
+const (
+	// OutcomeNew means the key has not been seen before and the request
+	// should be processed.
+	OutcomeNew Outcome = iota
+	// OutcomeInProcess means a request with the key is in process.
+	OutcomeInProcess
+	// OutcomeCompleted means a request with the key is completed and its
+	// result should be returned.
+	OutcomeCompleted
+	// OutcomeMismatch means the key is used for a request with a different
+	// fingerprint.
+	OutcomeMismatch
+	// OutcomeInvalidKey means the key of a request is missing or malformed,
+	// it is only reported to hooks.
+	OutcomeInvalidKey
+	// OutcomeRateLimited means the client created too many new keys, it is
+	// only reported to hooks.
+	OutcomeRateLimited
+	// OutcomeOverloaded means too many requests are in process, it is only
+	// reported to hooks.
+	OutcomeOverloaded
+	// OutcomeAbuse means the client reused keys for different requests too
+	// often, it is only reported to hooks.
+	OutcomeAbuse
+	// OutcomeError means the request could not be verified, e.g. due to a
+	// storage failure, it is only reported to hooks.
+	OutcomeError
+)
+
+// String returns the name of the outcome.
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeNew:
+		return "new"
+	case OutcomeInProcess:
+		return "in-process"
+	case OutcomeCompleted:
+		return "completed"
+	case OutcomeMismatch:
+		return "mismatch"
+	case OutcomeInvalidKey:
+		return "invalid-key"
+	case OutcomeRateLimited:
+		return "rate-limited"
+	case OutcomeOverloaded:
+		return "overloaded"
+	case OutcomeAbuse:
+		return "abuse"
+	case OutcomeError:
+		return "error"
+	}
+	return fmt.Sprintf("Outcome(%d)", int(o))
+}
+
+// Decision is the result of checking or reserving an idempotency key.
+type Decision struct {
+	Outcome Outcome
+	// Status is the stored status of the key. It is nil when Check finds a
+	// new key, and the reserved status when Reserve succeeds.
+	Status *RequestStatus
+	// Reservation is set when Reserve succeeds, it allows completing the
+	// reservation, possibly in another process.
+	Reservation *Reservation
+}
+
+// decide classifies an existing status for a request with fingerprint.
+func decide(status *RequestStatus, fingerprint string) Decision {
+	switch {
+	case fingerprint != "" && status.Fingerprint != "" && fingerprint != status.Fingerprint:
+		return Decision{Outcome: OutcomeMismatch, Status: status}
+	case status.InProcess:
+		return Decision{Outcome: OutcomeInProcess, Status: status}
+	default:
+		return Decision{Outcome: OutcomeCompleted, Status: status}
+	}
+}
+
+// Check returns the Decision for key without reserving it. The fingerprint
+// may be empty if the request payload should not be compared.
+func (s *State) Check(ctx context.Context, key, fingerprint string) (Decision, error) {
+	gctx, cancel := withTimeout(ctx, s.timeouts.Get)
+	defer cancel()
+
+	status, err := s.storage.Get(gctx, key)
+	if err != nil {
+		return Decision{}, fmt.Errorf("could not process request to get Idempotency-Key: %w", err)
+	}
+	if status == nil {
+		return Decision{Outcome: OutcomeNew}, nil
+	}
+	return decide(status, fingerprint), nil
+}
+
+// Reserve reserves key for processing. When the returned Outcome is
+// OutcomeNew the caller owns the key and must call Finish or Fail once done,
+// other outcomes are decided by the existing status of the key.
+func (s *State) Reserve(ctx context.Context, key, fingerprint string) (Decision, error) {
+	d, err := s.reserveKey(ctx, key, fingerprint)
+	if err == nil && d.Reservation != nil {
+		s.inflight.add(key, d.Reservation.Token)
+		s.transition(ctx, key, TransitionReserved, d.Status)
+	}
+	return d, err
+}
+
+// reserveKey reserves key with the locker or storage of s.
+func (s *State) reserveKey(ctx context.Context, key, fingerprint string) (Decision, error) {
+	if s.locker != nil {
+		return s.reserveLocked(ctx, key, fingerprint)
+	}
+
+	if r, ok := s.storage.(Reserver); ok && s.caps.Reserver {
+		return s.reserveAtomic(ctx, r, key, fingerprint)
+	}
+
+	if s.filter != nil {
+		defer s.filter.add(key)
+		if !s.filter.mayContain(key) {
+			// The key is most likely new, reserve it without reading it.
+			return s.reserve(ctx, key, fingerprint)
+		}
+	}
+
+	d, err := s.Check(ctx, key, fingerprint)
+	if err != nil || d.Outcome != OutcomeNew {
+		return d, err
+	}
+	return s.reserve(ctx, key, fingerprint)
+}
+
+// newReservation returns the status of a key reserved by s.
+func (s *State) newReservation(ctx context.Context, fingerprint string) *RequestStatus {
+	return &RequestStatus{
+		InProcess:   true,
+		Fingerprint: fingerprint,
+		Owner:       ownerFromContext(ctx),
+		Token:       newToken(s.clock),
+		Instance:    s.instance,
+		StartedAt:   s.clock.Now().UnixMilli(),
+		RequestID:   requestIDFromContext(ctx),
+	}
+}
+
+// reserveAtomic reserves key with a single call of a Reserver.
+func (s *State) reserveAtomic(ctx context.Context, r Reserver, key, fingerprint string) (Decision, error) {
+	status := s.newReservation(ctx, fingerprint)
+	actx, cancel := withTimeout(ctx, s.timeouts.Add)
+	defer cancel()
+
+	existing, added, err := r.AddOrGet(actx, key, status, s.ttl)
+	if err != nil {
+		return Decision{}, fmt.Errorf("could not process request to save Idempotency-Key: %w", err)
+	}
+	if added {
+		return Decision{Outcome: OutcomeNew, Status: status, Reservation: s.Reservation(key, status.Token)}, nil
+	}
+	return decide(existing, fingerprint), nil
+}
+
+// reserve adds key, which was found to be new.
+func (s *State) reserve(ctx context.Context, key, fingerprint string) (Decision, error) {
+	// Try adding the key
+	status := s.newReservation(ctx, fingerprint)
+	success, err := s.add(ctx, key, status)
+	if err != nil {
+		return Decision{}, fmt.Errorf("could not process request to save Idempotency-Key: %w", err)
+	}
+	if success {
+		return Decision{Outcome: OutcomeNew, Status: status, Reservation: s.Reservation(key, status.Token)}, nil
+	}
+
+	// Couldn't set the key, try reading it again
+	d, err := s.Check(ctx, key, fingerprint)
+	if err != nil {
+		return Decision{}, err
+	}
+	if d.Outcome == OutcomeNew {
+		return Decision{}, fmt.Errorf("failed to both get and set the Idempotency-Key %s", key)
+	}
+	return d, nil
+}
+
+// Finish marks a reserved key as completed and stores resp, which may be nil,
+// to be able to replay it. The fingerprint, owner and token of the
+// reservation are kept.
+func (s *State) Finish(ctx context.Context, key string, resp *Response) error {
+	if _, ok := s.storage.(StatusStorage); !ok {
+		return s.complete(ctx, key, nil)
+	}
+
+	gctx, cancel := withTimeout(ctx, s.timeouts.Get)
+	status, err := s.storage.Get(gctx, key)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("could not process request to get Idempotency-Key: %w", err)
+	}
+
+	if status == nil {
+		status = &RequestStatus{}
+	}
+	return s.complete(ctx, key, status.completed(resp))
+}
+
+// Fail releases a reserved key so that the request can be retried. It
+// requires a storage implementing Deleter.
+func (s *State) Fail(ctx context.Context, key string) error {
+	defer s.inflight.remove(key)
+	defer s.unlock(ctx, key)
+
+	d, ok := s.storage.(Deleter)
+	if !ok || !s.caps.Deleter {
+		return errors.New("storage does not support deleting keys")
+	}
+
+	if err := d.Delete(ctx, key); err != nil {
+		return fmt.Errorf("could not release Idempotency-Key: %w", err)
+	}
+	s.transition(ctx, key, TransitionFailed, nil)
+	return nil
+}
+
+// Forget removes key, which may be completed, so that the next request with
+// it is processed as new, e.g. after the effects of the request were rolled
+// back by a compensating action. Counters of the key, such as its attempts,
+// are kept until their window ends. It requires a storage implementing
+// Deleter.
+func (s *State) Forget(ctx context.Context, key string) error {
+	d, ok := s.storage.(Deleter)
+	if !ok || !s.caps.Deleter {
+		return errors.New("storage does not support deleting keys")
+	}
+
+	if err := d.Delete(ctx, key); err != nil {
+		return fmt.Errorf("could not forget Idempotency-Key: %w", err)
+	}
+	return nil
+}
+
+// Decide verifies r like Verify without responding: it reads and validates
+// the Idempotency-Key, fingerprints the request and reserves its key. It is
+// meant for frameworks and RPC stacks which cannot use the middleware.
+//
+// When the Outcome is OutcomeNew and Reservation is set the caller owns the
+// key and must confirm or fail the reservation once done. A Reservation of
+// nil with OutcomeNew means the request is processed without idempotency
+// handling, e.g. as it has no key and keys are Optional. The error is set
+// for OutcomeInvalidKey, OutcomeOverloaded, OutcomeAbuse, OutcomeRateLimited
+// and OutcomeError.
+// The options of WithPattern and WithContentType are not applied, derive the
+// State of a route with With instead. The limits of WithConcurrencyLimit and
+// WithTenantConcurrencyLimit are not applied either, as Decide does not see
+// when the request is done, callers must limit concurrency themselves.
+func (s *State) Decide(ctx context.Context, r *http.Request) (Decision, error) {
+	r = r.WithContext(ctx)
+	requirement := s.requirementFor(r)
+	if requirement == Ignored {
+		return Decision{Outcome: OutcomeNew}, nil
+	}
+
+	reject := func(e Event, err error) (Decision, error) {
+		e.Err = err
+		s.emit(r, e)
+		return Decision{Outcome: e.Outcome}, err
+	}
+
+	idempotencyKey, err := s.parseKey(r.Header.Get(HeaderName))
+	if err != nil {
+		return reject(Event{Outcome: OutcomeInvalidKey}, err)
+	}
+	if idempotencyKey == "" && s.keyGenerator != nil {
+		idempotencyKey, err = s.keyGenerator.GenerateKey(r)
+		if err != nil {
+			return reject(Event{Outcome: OutcomeError}, fmt.Errorf("could not generate Idempotency-Key: %w", err))
+		}
+		r.Header.Set(HeaderName, idempotencyKey)
+	}
+	if idempotencyKey == "" {
+		if requirement == Optional {
+			return Decision{Outcome: OutcomeNew}, nil
+		}
+		return reject(Event{Outcome: OutcomeInvalidKey}, errNoKey)
+	}
+
+	ctx = NewContext(ctx, idempotencyKey)
+	r = r.WithContext(ctx)
+
+	var fingerprint string
+	if s.fingerprint != nil {
+		fingerprint, err = s.fingerprint(r)
+		if err != nil {
+			return reject(Event{Key: idempotencyKey, Outcome: OutcomeError}, fmt.Errorf("could not fingerprint request: %w", err))
+		}
+	}
+
+	key := s.storageKey(r, idempotencyKey)
+	if s.owner != nil {
+		ctx = NewOwnerContext(ctx, s.owner(r))
+	}
+	if id := s.requestID(r); id != "" {
+		ctx = context.WithValue(ctx, requestIDContextKey, id)
+	}
+
+	if s.shed != nil && s.shed(r) {
+		return reject(Event{Key: idempotencyKey, Outcome: OutcomeOverloaded}, errOverloaded)
+	}
+
+	blocked, err := s.blocked(ctx, r)
+	if err != nil {
+		return reject(Event{Key: idempotencyKey, Outcome: OutcomeError}, err)
+	}
+	if blocked {
+		return reject(Event{Key: idempotencyKey, Outcome: OutcomeAbuse}, errAbuse)
+	}
+
+	allowed, err := s.allowNewKey(ctx, r, key, fingerprint)
+	if err != nil {
+		return reject(Event{Key: idempotencyKey, Outcome: OutcomeError}, err)
+	}
+	if !allowed {
+		return reject(Event{Key: idempotencyKey, Outcome: OutcomeRateLimited}, errRateLimited)
+	}
+
+	d, err := s.Reserve(ctx, key, fingerprint)
+	if err != nil {
+		return reject(Event{Key: idempotencyKey, Outcome: OutcomeError}, err)
+	}
+	e := Event{Key: idempotencyKey, Outcome: d.Outcome}
+	switch d.Outcome {
+	case OutcomeMismatch:
+		s.countMismatch(ctx, r, idempotencyKey)
+		e.Err = errMismatch
+	case OutcomeInProcess:
+		e.Err = newConflictError(d.Status, defaultRetryDelay)
+	case OutcomeCompleted:
+		e.Metadata = d.Status.Metadata
+	}
+	s.emit(r, e)
+	return d, nil
+}
@@ -0,0 +1,92 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLifecycle(t *testing.T) {
+	s := New(NewMemoryStorage())
+	ctx := context.Background()
+
+	steps := []struct {
+		name        string
+		run         func() (Decision, error)
+		wantOutcome Outcome
+	}{
+		{name: "Check of a new key", run: func() (Decision, error) { return s.Check(ctx, "key", "a") }, wantOutcome: OutcomeNew},
+		{name: "Reserve of a new key", run: func() (Decision, error) { return s.Reserve(ctx, "key", "a") }, wantOutcome: OutcomeNew},
+		{name: "Reserve of a reserved key", run: func() (Decision, error) { return s.Reserve(ctx, "key", "a") }, wantOutcome: OutcomeInProcess},
+		{name: "Reserve with another fingerprint", run: func() (Decision, error) { return s.Reserve(ctx, "key", "b") }, wantOutcome: OutcomeMismatch},
+		{name: "Check of a finished key", run: func() (Decision, error) {
+			if err := s.Finish(ctx, "key", &Response{Body: []byte("result")}); err != nil {
+				return Decision{}, err
+			}
+			return s.Check(ctx, "key", "a")
+		}, wantOutcome: OutcomeCompleted},
+		{name: "Reserve of a failed key", run: func() (Decision, error) {
+			if err := s.Fail(ctx, "key"); err != nil {
+				return Decision{}, err
+			}
+			return s.Reserve(ctx, "key", "b")
+		}, wantOutcome: OutcomeNew},
+		{name: "Reserve of a forgotten key", run: func() (Decision, error) {
+			if err := s.Finish(ctx, "key", nil); err != nil {
+				return Decision{}, err
+			}
+			if err := s.Forget(ctx, "key"); err != nil {
+				return Decision{}, err
+			}
+			return s.Reserve(ctx, "key", "c")
+		}, wantOutcome: OutcomeNew},
+	}
+
+	for _, step := range steps {
+		d, err := step.run()
+		if err != nil {
+			t.Fatalf("%s: want no error, got %v", step.name, err)
+		}
+		if d.Outcome != step.wantOutcome {
+			t.Errorf("%s: want outcome %v, got %v", step.name, step.wantOutcome, d.Outcome)
+		}
+	}
+}
+
+func TestDecide(t *testing.T) {
+	s := New(NewMemoryStorage(), WithFingerprint(func(r *http.Request) (string, error) { return r.URL.RawQuery, nil }),
+		WithMethodRequirement("GET", Optional))
+
+	tests := []struct {
+		name            string
+		method          string
+		key             string
+		query           string
+		wantOutcome     Outcome
+		wantReservation bool
+		wantErr         bool
+	}{
+		{name: "New key", method: "POST", key: "key", query: "a", wantOutcome: OutcomeNew, wantReservation: true},
+		{name: "In process", method: "POST", key: "key", query: "a", wantOutcome: OutcomeInProcess},
+		{name: "Mismatch", method: "POST", key: "key", query: "b", wantOutcome: OutcomeMismatch},
+		{name: "Missing key", method: "POST", wantOutcome: OutcomeInvalidKey, wantErr: true},
+		{name: "Optional key", method: "GET", wantOutcome: OutcomeNew},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, "http://example.com/foo?"+test.query, nil)
+			if test.key != "" {
+				req.Header.Set(HeaderName, test.key)
+			}
+			d, err := s.Decide(context.Background(), req)
+			if (err != nil) != test.wantErr {
+				t.Errorf("want error %v, got %v", test.wantErr, err)
+			}
+			if d.Outcome != test.wantOutcome || (d.Reservation != nil) != test.wantReservation {
+				t.Errorf("want outcome %v with reservation %v, got %+v", test.wantOutcome, test.wantReservation, d)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package idempotency
+
+import (
+	"io/fs"
+)
+
+// Dialect is the SQL of a database for the statements of the SQL storage
+// that are not portable. Statements use ? for parameters, which the storage
+// replaces with the placeholder style of the dialect. Expiries are Unix
+// milliseconds computed by the Clock of the storage, so dialects need no
+// time functions.
+type Dialect interface {
+	// Placeholder returns the style of query parameters.
+	Placeholder() Placeholder
+	// Reserve returns the statement inserting a key, or replacing it if it
+	// is expired at now, and its parameters. It must affect rows only if
+	// the key was inserted or replaced.
+	Reserve(table, key, status, owner string, expiresAt, now int64) (string, []any)
+	// Cleanup returns the statement deleting up to limit keys expired at
+	// now, and its parameters.
+	Cleanup(table string, now int64, limit int) (string, []any)
+	// SelectFirst returns the statement selecting columns of the first
+	// limit rows of table in order, and its parameters, e.g. for the
+	// outbox.
+	SelectFirst(table, columns, order string, limit int) (string, []any)
+	// CreateMigrations returns the statement creating the table versions of
+	// the applied migrations if it does not exist, with the columns version
+	// and applied_at.
+	CreateMigrations(versions string) string
+	// Migrations returns the migrations creating the schema of
+	// NewSQLStorage, named by their version, with {{table}} for the table.
+	Migrations() fs.FS
+}
+
+// Built-in dialects for WithDialect.
+var (
+	// SQLiteDialect is the dialect of SQLite, it is the default.
+	SQLiteDialect Dialect = onConflictDialect{placeholder: QuestionPlaceholder}
+	// PostgresDialect is the dialect of PostgreSQL and CockroachDB.
+	PostgresDialect Dialect = onConflictDialect{placeholder: DollarPlaceholder}
+	// MySQLDialect is the dialect of MySQL and MariaDB. The connection must
+	// report changed rows, which is the default of go-sql-driver/mysql,
+	// rather than found rows.
+	MySQLDialect Dialect = mysqlDialect{}
+	// MSSQLDialect is the dialect of Microsoft SQL Server.
+	MSSQLDialect Dialect = mssqlDialect{}
+)
+
+// WithDialect configures the SQL of the database, it defaults to
+// SQLiteDialect. It also configures the placeholder style of the dialect,
+// so WithPlaceholder must follow it to override the style.
+func WithDialect(d Dialect) SQLStorageOption {
+	return func(s *sqlStorage) {
+		s.dialect = d
+		s.placeholder = d.Placeholder()
+	}
+}
+
+// subMigrations returns the embedded migrations in dir.
+func subMigrations(dir string) fs.FS {
+	sub, err := fs.Sub(sqlMigrations, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// onConflictDialect is a dialect supporting INSERT ... ON CONFLICT.
+type onConflictDialect struct {
+	placeholder Placeholder
+}
+
+func (d onConflictDialect) Placeholder() Placeholder {
+	return d.placeholder
+}
+
+func (onConflictDialect) Reserve(table, key, status, owner string, expiresAt, now int64) (string, []any) {
+	return "INSERT INTO " + table + " (idempotency_key, status, owner, expires_at) VALUES (?, ?, ?, ?)" +
+		" ON CONFLICT (idempotency_key) DO UPDATE SET status = excluded.status, owner = excluded.owner, expires_at = excluded.expires_at" +
+		" WHERE " + table + ".expires_at <> 0 AND " + table + ".expires_at <= ?", []any{key, status, owner, expiresAt, now}
+}
+
+func (onConflictDialect) Cleanup(table string, now int64, limit int) (string, []any) {
+	return "DELETE FROM " + table + " WHERE idempotency_key IN (SELECT idempotency_key FROM " + table +
+		" WHERE expires_at <> 0 AND expires_at <= ? LIMIT ?)", []any{now, limit}
+}
+
+func (onConflictDialect) SelectFirst(table, columns, order string, limit int) (string, []any) {
+	return "SELECT " + columns + " FROM " + table + " ORDER BY " + order + " LIMIT ?", []any{limit}
+}
+
+func (onConflictDialect) CreateMigrations(versions string) string {
+	return "CREATE TABLE IF NOT EXISTS " + versions + " (version BIGINT PRIMARY KEY, applied_at BIGINT NOT NULL)"
+}
+
+func (onConflictDialect) Migrations() fs.FS {
+	return subMigrations("migrations")
+}
+
+// mysqlDialect is the dialect of MySQL.
+type mysqlDialect struct{}
+
+func (mysqlDialect) Placeholder() Placeholder {
+	return QuestionPlaceholder
+}
+
+func (mysqlDialect) Reserve(table, key, status, owner string, expiresAt, now int64) (string, []any) {
+	// Assignments see the columns assigned before them, so expires_at is
+	// assigned last.
+	expired := "expires_at <> 0 AND expires_at <= ?"
+	return "INSERT INTO " + table + " (idempotency_key, status, owner, expires_at) VALUES (?, ?, ?, ?)" +
+			" ON DUPLICATE KEY UPDATE status = IF(" + expired + ", VALUES(status), status)," +
+			" owner = IF(" + expired + ", VALUES(owner), owner)," +
+			" expires_at = IF(" + expired + ", VALUES(expires_at), expires_at)",
+		[]any{key, status, owner, expiresAt, now, now, now}
+}
+
+func (mysqlDialect) Cleanup(table string, now int64, limit int) (string, []any) {
+	return "DELETE FROM " + table + " WHERE expires_at <> 0 AND expires_at <= ? LIMIT ?", []any{now, limit}
+}
+
+func (mysqlDialect) SelectFirst(table, columns, order string, limit int) (string, []any) {
+	return "SELECT " + columns + " FROM " + table + " ORDER BY " + order + " LIMIT ?", []any{limit}
+}
+
+func (mysqlDialect) CreateMigrations(versions string) string {
+	return "CREATE TABLE IF NOT EXISTS " + versions + " (version BIGINT PRIMARY KEY, applied_at BIGINT NOT NULL)"
+}
+
+// Migrations returns the migrations of MySQL, which does not support
+// CREATE INDEX IF NOT EXISTS, so indexes of tables created by hand must be
+// named like the indexes of the migrations.
+func (mysqlDialect) Migrations() fs.FS {
+	return subMigrations("migrations/mysql")
+}
+
+// mssqlDialect is the dialect of SQL Server.
+type mssqlDialect struct{}
+
+func (mssqlDialect) Placeholder() Placeholder {
+	return AtPlaceholder
+}
+
+// Reserve returns a MERGE holding an update lock on the key, so that
+// concurrent reservations of a key wait for each other instead of both
+// inserting it.
+func (mssqlDialect) Reserve(table, key, status, owner string, expiresAt, now int64) (string, []any) {
+	return "MERGE INTO " + table + " WITH (UPDLOCK, HOLDLOCK) AS target" +
+		" USING (SELECT ? AS idempotency_key, ? AS status, ? AS owner, ? AS expires_at) AS source" +
+		" ON target.idempotency_key = source.idempotency_key" +
+		" WHEN MATCHED AND target.expires_at <> 0 AND target.expires_at <= ? THEN" +
+		" UPDATE SET status = source.status, owner = source.owner, expires_at = source.expires_at" +
+		" WHEN NOT MATCHED THEN INSERT (idempotency_key, status, owner, expires_at)" +
+		" VALUES (source.idempotency_key, source.status, source.owner, source.expires_at);", []any{key, status, owner, expiresAt, now}
+}
+
+// Cleanup deletes the first rows with TOP, as SQL Server has no LIMIT.
+func (mssqlDialect) Cleanup(table string, now int64, limit int) (string, []any) {
+	return "DELETE TOP (?) FROM " + table + " WHERE expires_at <> 0 AND expires_at <= ?", []any{limit, now}
+}
+
+func (mssqlDialect) SelectFirst(table, columns, order string, limit int) (string, []any) {
+	return "SELECT TOP (?) " + columns + " FROM " + table + " ORDER BY " + order, []any{limit}
+}
+
+func (mssqlDialect) CreateMigrations(versions string) string {
+	return "IF OBJECT_ID(N'" + versions + "', N'U') IS NULL CREATE TABLE " + versions + " (version BIGINT PRIMARY KEY, applied_at BIGINT NOT NULL)"
+}
+
+func (mssqlDialect) Migrations() fs.FS {
+	return subMigrations("migrations/mssql")
+}
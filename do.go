@@ -0,0 +1,100 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrInProcess is returned by Do when the work for a key is already in
+// process.
+var ErrInProcess = errors.New("idempotency: request already in progress")
+
+// Do runs fn once for key and stores its JSON encoded result, subsequent calls
+// with the same key return the stored result instead of running fn again. The
+// returned bool is true when the result was restored from storage. It allows
+// the same guarantees as Verify for work outside of HTTP handlers such as
+// workers, cron jobs and CLIs.
+//
+// If fn returns an error the key is released, when the storage implements
+// Deleter, so that the work can be retried. With WithMaxAttempts the key is
+// failed permanently once its attempts are exhausted, and Do returns
+// ErrAttemptsExhausted with the last error for it. Do requires a storage
+// implementing StatusStorage. The key is stored hashed with WithKeyHashing,
+// keys starting with _idempotency: are reserved.
+func Do[T any](ctx context.Context, s *State, key string, fn func(ctx context.Context) (T, error)) (T, bool, error) {
+	if reservedKey(key) {
+		var zero T
+		return zero, false, fmt.Errorf("idempotency: %w", errReservedKey)
+	}
+	return do(ctx, s, key, fn)
+}
+
+// do is Do for any key, including the reserved keys of items of batches.
+func do[T any](ctx context.Context, s *State, key string, fn func(ctx context.Context) (T, error)) (T, bool, error) {
+	var zero T
+
+	if _, ok := s.storage.(StatusStorage); !ok {
+		return zero, false, fmt.Errorf("idempotency: Do requires a storage implementing StatusStorage, got %T", s.storage)
+	}
+
+	ctx = NewContext(ctx, key)
+	key = s.HashKey(key)
+
+	d, err := s.Reserve(ctx, key, "")
+	if err != nil {
+		return zero, false, err
+	}
+
+	switch d.Outcome {
+	case OutcomeNew:
+		fnCtx, a := withAttempt(ctx, s, key)
+		// Failures release the key, so that the work can be retried.
+		release := func(err error) error {
+			if s.caps.Deleter {
+				failed := d.Status.completed(&Response{Body: []byte(err.Error())})
+				if ferr := s.failAttempt(ctx, key, failed); ferr != nil {
+					return errors.Join(err, ferr)
+				}
+			}
+			return err
+		}
+
+		v, err := fn(fnCtx)
+		if err != nil {
+			return zero, false, release(err)
+		}
+
+		body, err := json.Marshal(v)
+		if err != nil {
+			return v, false, release(fmt.Errorf("could not encode result: %w", err))
+		}
+
+		err = s.finish(ctx, key, d.Status.completed(&Response{Body: body}), a)
+		if err != nil {
+			return v, false, fmt.Errorf("could not complete request: %w", err)
+		}
+		return v, false, nil
+	case OutcomeInProcess:
+		return zero, false, ErrInProcess
+	}
+
+	if d.Status.Failed {
+		var msg []byte
+		if d.Status.Response != nil {
+			msg = d.Status.Response.Body
+		}
+		return zero, true, fmt.Errorf("%w: %s", ErrAttemptsExhausted, msg)
+	}
+	if d.Status.Response == nil {
+		return zero, false, fmt.Errorf("no result stored for Idempotency-Key %s", key)
+	}
+
+	var v T
+	if err := json.Unmarshal(d.Status.Response.Body, &v); err != nil {
+		return zero, false, fmt.Errorf("could not decode stored result: %w", err)
+	}
+	s.transition(ctx, key, TransitionReplayed, d.Status)
+	return v, true, nil
+}
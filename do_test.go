@@ -0,0 +1,65 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDo(t *testing.T) {
+	s := New(NewMemoryStorage())
+	ctx := context.Background()
+
+	calls := 0
+	work := func(ctx context.Context) (int, error) {
+		calls++
+		return calls, nil
+	}
+
+	got, restored, err := Do(ctx, s, "job-1", work)
+	if err != nil || got != 1 || restored {
+		t.Errorf("want (1, false, nil), got (%v, %v, %v)", got, restored, err)
+	}
+
+	got, restored, err = Do(ctx, s, "job-1", work)
+	if err != nil || got != 1 || !restored {
+		t.Errorf("want (1, true, nil), got (%v, %v, %v)", got, restored, err)
+	}
+
+	failure := errors.New("failure")
+	_, _, err = Do(ctx, s, "job-2", func(ctx context.Context) (int, error) {
+		return 0, failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("want error %v, got %v", failure, err)
+	}
+
+	// The failed key is released so the work can be retried.
+	got, restored, err = Do(ctx, s, "job-2", work)
+	if err != nil || got != 2 || restored {
+		t.Errorf("want (2, false, nil), got (%v, %v, %v)", got, restored, err)
+	}
+
+	_, _, err = Do(ctx, s, "job-3", func(ctx context.Context) (int, error) {
+		_, _, err := Do(ctx, s, "job-3", work)
+		return 0, err
+	})
+	if !errors.Is(err, ErrInProcess) {
+		t.Errorf("want error %v, got %v", ErrInProcess, err)
+	}
+}
+
+func TestDoEncodingError(t *testing.T) {
+	ctx := context.Background()
+	s := New(NewMemoryStorage())
+
+	// Channels cannot be encoded as JSON.
+	for i := 0; i < 2; i++ {
+		_, _, err := Do(ctx, s, "key", func(ctx context.Context) (chan int, error) {
+			return make(chan int), nil
+		})
+		if err == nil || errors.Is(err, ErrInProcess) {
+			t.Fatalf("want encoding error with the key released, got %v", err)
+		}
+	}
+}
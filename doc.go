@@ -5,10 +5,17 @@ the draft-ietf-httpapi-idempotency-key-header-00 RFC.
 See: https://datatracker.ietf.org/doc/html/draft-ietf-httpapi-idempotency-key-header
 
 Note that the RFC is a draft and some assumptions will be made a long the way.
+Later versions of the draft are supported through WithProfile.
 
 The package aims to implement a way to use a net/http handler that will check
 the Idempotency-Key header and determine what action to do. The client is
 responsible of sending a unique value of the Idempotency-Key header,
 recommended values are UUIDs.
+
+Storages implement Storage and, optionally, further interfaces such as
+StatusStorage, Deleter, TTLReader, Pinger, Scanner and Purger. State detects
+them with StorageCapabilities when it is created, and features depending on a
+missing capability are skipped or report an error, so storages can implement
+them incrementally.
 */
 package idempotency
@@ -0,0 +1,152 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// encryptedHeaderName marks a stored response whose header and body are
+// encrypted in its body.
+const encryptedHeaderName = "Idempotency-Encrypted"
+
+var errCiphertextTooShort = errors.New("ciphertext too short")
+
+type encryptedStorage struct {
+	forwardingStorage
+	aead cipher.AEAD
+}
+
+// EncryptStorage returns a decorator encrypting the headers and bodies of
+// captured responses with aead, e.g. AES-GCM, before they are stored, since
+// they may contain personal data. The idempotency key is authenticated with
+// the response, so that stored responses cannot be moved between keys.
+// Responses stored without encryption are read as is.
+func EncryptStorage(aead cipher.AEAD) StorageDecorator {
+	return func(next StatusStorage) StatusStorage {
+		return &encryptedStorage{forwardingStorage: forwardingStorage{next: next}, aead: aead}
+	}
+}
+
+type sealedResponse struct {
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// encrypt returns a copy of status with its response encrypted.
+func (s *encryptedStorage) encrypt(key string, status *RequestStatus) (*RequestStatus, error) {
+	if status == nil || status.Response == nil {
+		return status, nil
+	}
+
+	plaintext, err := json.Marshal(sealedResponse{Header: status.Response.Header, Body: status.Response.Body})
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(plaintext)+s.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	c := *status
+	c.Response = &Response{
+		StatusCode: status.Response.StatusCode,
+		Header:     http.Header{encryptedHeaderName: {"1"}},
+		Body:       s.aead.Seal(nonce, nonce, plaintext, []byte(key)),
+	}
+	return &c, nil
+}
+
+// decrypt decrypts the response of status in place.
+func (s *encryptedStorage) decrypt(key string, status *RequestStatus) error {
+	if status == nil || status.Response == nil || status.Response.Header.Get(encryptedHeaderName) == "" {
+		return nil
+	}
+
+	body := status.Response.Body
+	if len(body) < s.aead.NonceSize() {
+		return fmt.Errorf("failed to decrypt the response of %q: %w", key, errCiphertextTooShort)
+	}
+	plaintext, err := s.aead.Open(nil, body[:s.aead.NonceSize()], body[s.aead.NonceSize():], []byte(key))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt the response of %q: %w", key, err)
+	}
+
+	var sealed sealedResponse
+	if err := json.Unmarshal(plaintext, &sealed); err != nil {
+		return fmt.Errorf("failed to decrypt the response of %q: %w", key, err)
+	}
+	status.Response = &Response{StatusCode: status.Response.StatusCode, Header: sealed.Header, Body: sealed.Body}
+	return nil
+}
+
+// AddStatus inserts status for an idempotency key.
+func (s *encryptedStorage) AddStatus(ctx context.Context, key string, status *RequestStatus, expiry time.Duration) (bool, error) {
+	status, err := s.encrypt(key, status)
+	if err != nil {
+		return false, err
+	}
+	return s.next.AddStatus(ctx, key, status, expiry)
+}
+
+// AddOrGet inserts status for an idempotency key or returns its existing
+// status.
+func (s *encryptedStorage) AddOrGet(ctx context.Context, key string, status *RequestStatus, expiry time.Duration) (*RequestStatus, bool, error) {
+	status, err := s.encrypt(key, status)
+	if err != nil {
+		return nil, false, err
+	}
+	existing, added, err := s.forwardingStorage.AddOrGet(ctx, key, status, expiry)
+	if err != nil {
+		return nil, false, err
+	}
+	return existing, added, s.decrypt(key, existing)
+}
+
+// Get fetches the RequestStatus for an idempotency key.
+func (s *encryptedStorage) Get(ctx context.Context, key string) (*RequestStatus, error) {
+	status, err := s.next.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.decrypt(key, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// UpdateStatus replaces the RequestStatus of an idempotency key.
+func (s *encryptedStorage) UpdateStatus(ctx context.Context, key string, status *RequestStatus) error {
+	status, err := s.encrypt(key, status)
+	if err != nil {
+		return err
+	}
+	return s.next.UpdateStatus(ctx, key, status)
+}
+
+// UpdateStatuses replaces the statuses of idempotency keys.
+func (s *encryptedStorage) UpdateStatuses(ctx context.Context, statuses map[string]*RequestStatus) error {
+	encrypted := make(map[string]*RequestStatus, len(statuses))
+	for key, status := range statuses {
+		var err error
+		if encrypted[key], err = s.encrypt(key, status); err != nil {
+			return err
+		}
+	}
+	return s.forwardingStorage.UpdateStatuses(ctx, encrypted)
+}
+
+// Scan calls fn for every stored key.
+func (s *encryptedStorage) Scan(ctx context.Context, fn func(key string, status *RequestStatus) error) error {
+	return s.forwardingStorage.Scan(ctx, func(key string, status *RequestStatus) error {
+		if err := s.decrypt(key, status); err != nil {
+			return err
+		}
+		return fn(key, status)
+	})
+}
@@ -0,0 +1,50 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestEncryptStorage(t *testing.T) {
+	ctx := context.Background()
+	block, err := aes.NewCipher(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := NewMemoryStorage()
+	storage := ChainStorage(base, EncryptStorage(aead))
+
+	resp := &Response{StatusCode: http.StatusCreated, Header: http.Header{"Location": {"/users/1"}}, Body: []byte("secret")}
+	storage.AddStatus(ctx, "key", &RequestStatus{InProcess: true}, time.Hour)
+	if err := storage.UpdateStatus(ctx, "key", &RequestStatus{Response: resp}); err != nil {
+		t.Fatalf("want updated, got %v", err)
+	}
+
+	stored, _ := base.Get(ctx, "key")
+	if bytes.Contains(stored.Response.Body, []byte("secret")) || stored.Response.Header.Get("Location") != "" {
+		t.Errorf("want response encrypted, got %+v", stored.Response)
+	}
+
+	got, err := storage.Get(ctx, "key")
+	if err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	if got.Response.StatusCode != resp.StatusCode || got.Response.Header.Get("Location") != "/users/1" || string(got.Response.Body) != "secret" {
+		t.Errorf("want response decrypted, got %+v", got.Response)
+	}
+
+	// Responses cannot be moved to other keys.
+	base.AddStatus(ctx, "moved", stored, time.Hour)
+	if _, err := storage.Get(ctx, "moved"); err == nil {
+		t.Error("want error for a moved response")
+	}
+}
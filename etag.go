@@ -0,0 +1,36 @@
+package idempotency
+
+import (
+	"net/http"
+	"strings"
+)
+
+// notModified reports whether the If-None-Match header of r matches the
+// ETag of a stored successful response, so that the replay can be a 304 Not
+// Modified. ETags are compared weakly as required for If-None-Match.
+func notModified(r *http.Request, resp *Response) bool {
+	inm := r.Header.Get("If-None-Match")
+	etag := resp.Header.Get("ETag")
+	if inm == "" || etag == "" || (resp.StatusCode != 0 && (resp.StatusCode < 200 || resp.StatusCode > 299)) {
+		return false
+	}
+
+	for _, candidate := range strings.Split(inm, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeNotModified replays a stored response as 304 Not Modified.
+func writeNotModified(w http.ResponseWriter, resp *Response) {
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Del("Content-Type")
+	w.Header().Set(ReplayedHeaderName, "true")
+	w.WriteHeader(http.StatusNotModified)
+}
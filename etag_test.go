@@ -0,0 +1,51 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReplayIfNoneMatch(t *testing.T) {
+	s := New(NewMemoryStorage(), WithResponseCapture(true))
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("created"))
+	}))
+
+	req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+	req.Header.Set(HeaderName, "key")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	tests := []struct {
+		name           string
+		ifNoneMatch    string
+		wantHTTPStatus int
+		wantBody       string
+	}{
+		{name: "Unconditional", wantHTTPStatus: http.StatusOK, wantBody: "created"},
+		{name: "Matching ETag", ifNoneMatch: `"v0", "v1"`, wantHTTPStatus: http.StatusNotModified},
+		{name: "Weak ETag", ifNoneMatch: `W/"v1"`, wantHTTPStatus: http.StatusNotModified},
+		{name: "Any ETag", ifNoneMatch: "*", wantHTTPStatus: http.StatusNotModified},
+		{name: "Other ETag", ifNoneMatch: `"v2"`, wantHTTPStatus: http.StatusOK, wantBody: "created"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+			req.Header.Set(HeaderName, "key")
+			if test.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", test.ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != test.wantHTTPStatus || w.Body.String() != test.wantBody {
+				t.Errorf("want %d %q, got %d %q", test.wantHTTPStatus, test.wantBody, w.Code, w.Body.String())
+			}
+			if w.Header().Get("ETag") != `"v1"` || w.Header().Get(ReplayedHeaderName) != "true" {
+				t.Errorf("want replayed ETag, got %v", w.Header())
+			}
+		})
+	}
+}
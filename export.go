@@ -0,0 +1,113 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// exportLine is a line of an export, the record is encoded by MarshalStatus
+// and imported with UnmarshalStatus. Persistent marks keys without expiry,
+// which a missing TTL cannot tell from storages not reporting TTLs.
+type exportLine struct {
+	Key        string          `json:"key"`
+	TTL        int64           `json:"ttl_ms,omitempty"`
+	Persistent bool            `json:"persistent,omitempty"`
+	Record     json.RawMessage `json:"record"`
+}
+
+// Export writes every key of storage with its status and remaining TTL to w
+// as JSON lines, e.g. to back up the storage before maintenance or to move
+// the keys to another storage with Import. It requires a storage
+// implementing Scanner, the TTLs are exported if it implements TTLReader.
+// Counters are not exported. It returns the number of exported keys.
+func Export(ctx context.Context, storage Storage, w io.Writer) (int, error) {
+	caps := StorageCapabilities(storage)
+	sc, ok := storage.(Scanner)
+	if !ok || !caps.Scanner {
+		return 0, errors.New("storage does not support scanning keys")
+	}
+	ttls, _ := storage.(TTLReader)
+
+	enc := json.NewEncoder(w)
+	n := 0
+	err := sc.Scan(ctx, func(key string, status *RequestStatus) error {
+		line := exportLine{Key: key}
+		if caps.TTLReader {
+			ttl, exists, err := ttls.TTL(ctx, key)
+			if err != nil {
+				return fmt.Errorf("failed to export the key %q: %w", key, err)
+			}
+			if !exists {
+				// The key expired during the export.
+				return nil
+			}
+			line.TTL = ttl.Milliseconds()
+			line.Persistent = ttl == 0
+		}
+
+		record, err := MarshalStatus(status)
+		if err != nil {
+			return fmt.Errorf("failed to export the key %q: %w", key, err)
+		}
+		line.Record = record
+		if err := enc.Encode(line); err != nil {
+			return fmt.Errorf("failed to export the key %q: %w", key, err)
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// Import adds the keys exported by Export from r to storage, which must
+// implement StatusStorage. Keys keep their exported TTL, and keys exported
+// without expiry are kept without one, which requires a storage implementing
+// Persister. Keys exported without a TTL get the default expiry of storage.
+// Keys which already exist in storage are kept. It returns the number of
+// added keys.
+func Import(ctx context.Context, storage Storage, r io.Reader) (int, error) {
+	ss, ok := storage.(StatusStorage)
+	if !ok {
+		return 0, errors.New("storage does not support storing statuses")
+	}
+	persister, _ := storage.(Persister)
+	canPersist := StorageCapabilities(storage).Persister
+
+	dec := json.NewDecoder(r)
+	n := 0
+	for {
+		var line exportLine
+		err := dec.Decode(&line)
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("failed to read the export: %w", err)
+		}
+
+		if line.Persistent && !canPersist {
+			return n, fmt.Errorf("failed to import the key %q: storage does not support keys without expiry", line.Key)
+		}
+		status, err := UnmarshalStatus(line.Record)
+		if err != nil {
+			return n, fmt.Errorf("failed to import the key %q: %w", line.Key, err)
+		}
+		added, err := ss.AddStatus(ctx, line.Key, status, time.Duration(line.TTL)*time.Millisecond)
+		if err != nil {
+			return n, fmt.Errorf("failed to import the key %q: %w", line.Key, err)
+		}
+		if !added {
+			continue
+		}
+		if line.Persistent {
+			if err := persister.Persist(ctx, line.Key); err != nil {
+				return n, fmt.Errorf("failed to import the key %q: %w", line.Key, err)
+			}
+		}
+		n++
+	}
+}
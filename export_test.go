@@ -0,0 +1,59 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemoryStorage()
+	memory.AddStatus(ctx, "done", &RequestStatus{Fingerprint: "a", Response: &Response{StatusCode: 201, Body: []byte("created")}}, time.Hour)
+	memory.AddStatus(ctx, "pending", &RequestStatus{InProcess: true}, time.Minute)
+	memory.AddStatus(ctx, "persistent", &RequestStatus{Fingerprint: "b"}, 0)
+
+	var buf bytes.Buffer
+	n, err := Export(ctx, memory, &buf)
+	if err != nil || n != 3 {
+		t.Fatalf("Export: want 3 keys, got %d, %v", n, err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 3 {
+		t.Errorf("Export: want 3 lines, got %d", lines)
+	}
+
+	mr := miniredis.RunT(t)
+	rs := NewRedisStorage(redis.NewClient(&redis.Options{Addr: mr.Addr()}), 24*time.Hour)
+	rs.AddStatus(ctx, "pending", &RequestStatus{InProcess: true, Token: 9}, time.Minute)
+
+	n, err = Import(ctx, rs, bytes.NewReader(buf.Bytes()))
+	if err != nil || n != 2 {
+		t.Fatalf("Import: want 2 keys added, got %d, %v", n, err)
+	}
+
+	status, _ := rs.Get(ctx, "done")
+	if status == nil || status.Fingerprint != "a" || string(status.Response.Body) != "created" {
+		t.Errorf("want imported status, got %+v", status)
+	}
+	if ttl, _, _ := rs.TTL(ctx, "done"); ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Errorf("want TTL kept, got %v", ttl)
+	}
+	if status, _ := rs.Get(ctx, "pending"); status == nil || status.Token != 9 {
+		t.Errorf("want existing key kept, got %+v", status)
+	}
+	if ttl, exists, _ := rs.TTL(ctx, "persistent"); !exists || ttl != 0 {
+		t.Errorf("want persistent key kept without expiry, got %v, %v", ttl, exists)
+	}
+
+	// Keys without expiry are not imported with the default expiry of
+	// storages which cannot persist them.
+	other := &flakyStorage{StatusStorage: NewMemoryStorage()}
+	if _, err := Import(ctx, other, bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("want import of a persistent key failing without Persister")
+	}
+}
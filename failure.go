@@ -0,0 +1,35 @@
+package idempotency
+
+import "net/http"
+
+// Failure describes why the middleware rejects a request, so that a responder
+// configured with WithFailureResponder can respond per Outcome.
+type Failure struct {
+	// Outcome classifies the failure, e.g. OutcomeInProcess for a conflict,
+	// OutcomeMismatch for a reused key and OutcomeError for a storage
+	// failure.
+	Outcome Outcome
+	// Key is the idempotency key of the request, if it is known.
+	Key string
+	Err error
+	// StatusCode is the HTTP status code the middleware responds with by
+	// default.
+	StatusCode int
+	// Status is the stored status of the key, if it was looked up.
+	Status *RequestStatus
+}
+
+// WithFailureResponder configures a function that responds to the client
+// whenever a request is rejected. It replaces the responder of
+// WithErrorResponder and of profiles.
+func WithFailureResponder(f func(f Failure, w http.ResponseWriter, r *http.Request)) Option {
+	return func(s *State) {
+		s.failureResponder = f
+	}
+}
+
+// newFailure describes the failure of the request with event e.
+func newFailure(r *http.Request, e Event, err error, status int) Failure {
+	stored, _ := StatusFromContext(r.Context())
+	return Failure{Outcome: e.Outcome, Key: e.Key, Err: err, StatusCode: status, Status: stored}
+}
@@ -0,0 +1,57 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithFailureResponder(t *testing.T) {
+	var got Failure
+	storage := NewMemoryStorage()
+	s := New(storage,
+		WithFingerprint(func(r *http.Request) (string, error) { return r.URL.RawQuery, nil }),
+		WithFailureResponder(func(f Failure, w http.ResponseWriter, r *http.Request) {
+			got = f
+			w.WriteHeader(f.StatusCode)
+		}))
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	s.Reserve(t.Context(), "in-process", "b")
+	s.Reserve(t.Context(), "completed", "a")
+	s.Finish(t.Context(), "completed", nil)
+
+	tests := []struct {
+		name           string
+		key            string
+		wantOutcome    Outcome
+		wantHTTPStatus int
+		wantStatus     bool
+	}{
+		{name: "Conflict", key: "in-process", wantOutcome: OutcomeInProcess, wantHTTPStatus: http.StatusConflict, wantStatus: true},
+		{name: "Mismatch", key: "completed", wantOutcome: OutcomeMismatch, wantHTTPStatus: http.StatusUnprocessableEntity, wantStatus: true},
+		{name: "Invalid key", key: "", wantOutcome: OutcomeInvalidKey, wantHTTPStatus: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got = Failure{}
+			req := httptest.NewRequest("POST", "http://example.com/foo?b", nil)
+			if test.key != "" {
+				req.Header.Set(HeaderName, test.key)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != test.wantHTTPStatus || got.StatusCode != test.wantHTTPStatus {
+				t.Errorf("want HTTP status %d, got %d", test.wantHTTPStatus, w.Code)
+			}
+			if got.Outcome != test.wantOutcome || got.Key != test.key || got.Err == nil {
+				t.Errorf("want %v failure of %q, got %+v", test.wantOutcome, test.key, got)
+			}
+			if (got.Status != nil) != test.wantStatus {
+				t.Errorf("want stored status %v, got %+v", test.wantStatus, got.Status)
+			}
+		})
+	}
+}
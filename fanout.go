@@ -0,0 +1,110 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// fanOutInterval is how often the status of a key in process is read while a
+// request waits for a leader of another instance.
+const fanOutInterval = 100 * time.Millisecond
+
+// flight is a key in process which requests of the same instance wait for.
+type flight struct {
+	done chan struct{}
+	// status is the completed status of the key, it is nil if the key was
+	// released instead. It is set before done is closed.
+	status *RequestStatus
+}
+
+// WithFanOut makes requests for a key in process wait up to max for the
+// request processing it, the leader, and replay its response, instead of
+// answering them with 409 Conflict. Requests waiting in the instance of the
+// leader receive its status when it completes, requests in other instances
+// poll the storage. Requests are still answered with 409 Conflict if the
+// leader fails or takes longer. It requires WithResponseCapture, without it
+// there is no response to replay and requests are not held.
+func WithFanOut(max time.Duration) Option {
+	return func(s *State) {
+		s.fanOut = max
+	}
+}
+
+// flight returns the flight of key if it is in process in this instance.
+func (f *inflight) flight(key string) *flight {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.keys[key]; !ok {
+		return nil
+	}
+	if f.flights == nil {
+		f.flights = make(map[string]*flight)
+	}
+	fl, ok := f.flights[key]
+	if !ok {
+		fl = &flight{done: make(chan struct{})}
+		f.flights[key] = fl
+	}
+	return fl
+}
+
+// land passes the completed status of key to the requests waiting for it.
+// They are woken up once key is removed.
+func (f *inflight) land(key string, status *RequestStatus) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if fl, ok := f.flights[key]; ok {
+		fl.status = status
+	}
+}
+
+// awaitCompletion waits for key, which is in process, for as long as r
+// prefers to wait or WithFanOut allows. It returns the Decision of the
+// completed key, or d if the key is still in process or could not be read.
+func (s *State) awaitCompletion(ctx context.Context, r *http.Request, key, fingerprint string, d Decision) Decision {
+	wait := max(s.preferredWait(r), s.fanOut)
+	if wait <= 0 || s.shadow || !s.capture {
+		return d
+	}
+	return s.await(ctx, key, fingerprint, wait, d)
+}
+
+// await waits up to wait for key, which is in process, to complete. It
+// returns the Decision of the completed key, or d if the key is still in
+// process, was released or could not be read.
+func (s *State) await(ctx context.Context, key, fingerprint string, wait time.Duration, d Decision) Decision {
+	if fl := s.inflight.flight(key); fl != nil {
+		select {
+		case <-fl.done:
+			if fl.status != nil {
+				return decide(fl.status, fingerprint)
+			}
+		case <-ctx.Done():
+		case <-s.clock.After(wait):
+		}
+		return d
+	}
+
+	deadline := s.clock.Now().Add(wait)
+	for {
+		select {
+		case <-ctx.Done():
+			return d
+		case <-s.clock.After(min(fanOutInterval, deadline.Sub(s.clock.Now()))):
+		}
+
+		current, err := s.Check(ctx, key, fingerprint)
+		if err != nil {
+			return d
+		}
+		if current.Outcome == OutcomeCompleted {
+			return current
+		}
+		if current.Outcome != OutcomeInProcess || !s.clock.Now().Before(deadline) {
+			return d
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package idempotency
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// awaitWaiters waits until n channels of After of c are pending.
+func awaitWaiters(c *FakeClock, n int) {
+	for {
+		c.mu.Lock()
+		pending := len(c.waiters)
+		c.mu.Unlock()
+		if pending >= n {
+			return
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	tests := []struct {
+		name string
+		// remote serves the followers by another instance sharing the
+		// storage.
+		remote bool
+		// capture enables WithResponseCapture, without it followers are not
+		// held.
+		capture  bool
+		wantCode int
+		wantBody string
+	}{
+		{name: "Followers in the instance of the leader", capture: true, wantCode: http.StatusCreated, wantBody: "leader"},
+		{name: "Followers in another instance", remote: true, capture: true, wantCode: http.StatusCreated, wantBody: "leader"},
+		{name: "Without capture", wantCode: http.StatusConflict},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := NewFakeClock(time.Now())
+			storage := NewMemoryStorage(WithMemoryClock(clock))
+			opts := []Option{WithResponseCapture(test.capture), WithFanOut(time.Minute), WithClock(clock)}
+			release := make(chan struct{})
+			started := make(chan struct{})
+			handler := func(s *State) http.Handler {
+				return s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					close(started)
+					<-release
+					w.WriteHeader(http.StatusCreated)
+					io.WriteString(w, "leader")
+				}))
+			}
+			leader := handler(New(storage, opts...))
+			followers := leader
+			if test.remote {
+				followers = handler(New(storage, opts...))
+			}
+
+			request := func(h http.Handler) *httptest.ResponseRecorder {
+				req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+				req.Header.Set(HeaderName, `"key"`)
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, req)
+				return w
+			}
+
+			led := make(chan struct{})
+			go func() {
+				request(leader)
+				close(led)
+			}()
+			<-started
+
+			var wg sync.WaitGroup
+			responses := make([]*httptest.ResponseRecorder, 3)
+			for i := range responses {
+				wg.Go(func() { responses[i] = request(followers) })
+			}
+			followed := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(followed)
+			}()
+
+			if test.capture {
+				awaitWaiters(clock, len(responses))
+			} else {
+				<-followed
+			}
+			close(release)
+			<-led
+
+			// Followers in other instances read the storage once due.
+			for done := false; !done; {
+				select {
+				case <-followed:
+					done = true
+				default:
+					clock.Advance(fanOutInterval)
+					runtime.Gosched()
+				}
+			}
+
+			for _, w := range responses {
+				if w.Code != test.wantCode || (test.wantBody != "" && w.Body.String() != test.wantBody) {
+					t.Errorf("want %v %q, got %v %q", test.wantCode, test.wantBody, w.Code, w.Body.String())
+				}
+			}
+		})
+	}
+}
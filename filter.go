@@ -0,0 +1,121 @@
+package idempotency
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// Bounds of the false positive rate of WithKeyFilter.
+const (
+	minFalsePositiveRate = 1e-9
+	maxFalsePositiveRate = 0.5
+)
+
+// bloomFilter is a bloom filter of strings, safe for concurrent use.
+type bloomFilter struct {
+	bits   []atomic.Uint64
+	hashes int
+}
+
+func newBloomFilter(capacity int, falsePositiveRate float64) *bloomFilter {
+	m := math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := max(1, int(math.Round(m/float64(capacity)*math.Ln2)))
+	return &bloomFilter{bits: make([]atomic.Uint64, int(m)/64+1), hashes: k}
+}
+
+// positions calls fn with the bit positions of s, derived by double hashing.
+func (f *bloomFilter) positions(s string, fn func(word int, mask uint64) bool) {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	h1 := h.Sum64()
+	h2 := mix64(h1) | 1
+	n := uint64(len(f.bits) * 64)
+	for i := range f.hashes {
+		bit := (h1 + uint64(i)*h2) % n
+		if !fn(int(bit/64), 1<<(bit%64)) {
+			return
+		}
+	}
+}
+
+func (f *bloomFilter) add(s string) {
+	f.positions(s, func(word int, mask uint64) bool {
+		f.bits[word].Or(mask)
+		return true
+	})
+}
+
+func (f *bloomFilter) mayContain(s string) bool {
+	found := true
+	f.positions(s, func(word int, mask uint64) bool {
+		found = f.bits[word].Load()&mask != 0
+		return found
+	})
+	return found
+}
+
+// keyFilter remembers recently seen keys in two generations of bloom
+// filters, the older generation is dropped when the newer is full.
+type keyFilter struct {
+	capacity          int
+	falsePositiveRate float64
+
+	mu       sync.RWMutex
+	current  *bloomFilter
+	previous *bloomFilter
+	count    atomic.Int64
+}
+
+// WithKeyFilter configures an in-process bloom filter of the keys seen by
+// this instance, remembering about the last capacity keys with the given
+// false positive rate. Keys that were definitely not seen are reserved
+// without reading them first, which saves a storage round trip for most new
+// keys. Storages implementing Reserver reserve keys in one round trip anyway
+// and do not benefit from the filter.
+//
+// The storage remains authoritative, so keys seen by other instances are
+// still detected, at the cost of an extra round trip. A capacity below one
+// is raised to one, and the false positive rate is clamped to between one in
+// a billion and one half, as other rates cannot size a filter.
+func WithKeyFilter(capacity int, falsePositiveRate float64) Option {
+	capacity = max(capacity, 1)
+	if math.IsNaN(falsePositiveRate) {
+		falsePositiveRate = maxFalsePositiveRate
+	}
+	falsePositiveRate = min(max(falsePositiveRate, minFalsePositiveRate), maxFalsePositiveRate)
+
+	return func(s *State) {
+		s.filter = &keyFilter{
+			capacity:          capacity,
+			falsePositiveRate: falsePositiveRate,
+			current:           newBloomFilter(capacity, falsePositiveRate),
+			previous:          newBloomFilter(capacity, falsePositiveRate),
+		}
+	}
+}
+
+func (f *keyFilter) add(key string) {
+	f.mu.RLock()
+	f.current.add(key)
+	f.mu.RUnlock()
+
+	if f.count.Add(1) < int64(f.capacity) {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.count.Load() >= int64(f.capacity) {
+		f.previous = f.current
+		f.current = newBloomFilter(f.capacity, f.falsePositiveRate)
+		f.count.Store(0)
+	}
+}
+
+func (f *keyFilter) mayContain(key string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.current.mayContain(key) || f.previous.mayContain(key)
+}
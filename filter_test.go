@@ -0,0 +1,101 @@
+package idempotency
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+type countingStorage struct {
+	StatusStorage
+	gets int
+}
+
+func (c *countingStorage) Get(ctx context.Context, key string) (*RequestStatus, error) {
+	c.gets++
+	return c.StatusStorage.Get(ctx, key)
+}
+
+func TestKeyFilter(t *testing.T) {
+	storage := &countingStorage{StatusStorage: NewMemoryStorage()}
+	calls := 0
+	handler := New(storage, WithKeyFilter(100, 0.01)).Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	serve := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+		req.Header.Set(HeaderName, key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// New keys are reserved without reading them.
+	serve("key")
+	gets := storage.gets
+	if gets != 0 || calls != 1 {
+		t.Errorf("want new key not read, got %d reads", gets)
+	}
+
+	// Seen keys are read.
+	serve("key")
+	if storage.gets == gets || calls != 1 {
+		t.Errorf("want seen key read and replayed, got %d calls", calls)
+	}
+
+	// Keys seen by another instance are still detected.
+	storage.AddStatus(context.Background(), "other", &RequestStatus{}, 0)
+	if serve("other"); calls != 1 {
+		t.Errorf("want key of another instance not processed, got %d calls", calls)
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	f := newBloomFilter(1000, 0.01)
+	for i := range 1000 {
+		f.add(strconv.Itoa(i))
+	}
+	for i := range 1000 {
+		if !f.mayContain(strconv.Itoa(i)) {
+			t.Fatalf("want added key %d found", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := 1000; i < 11000; i++ {
+		if f.mayContain(strconv.Itoa(i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 200 {
+		t.Errorf("want about 1%% false positives, got %d of 10000", falsePositives)
+	}
+}
+
+func TestKeyFilterArguments(t *testing.T) {
+	tests := []struct {
+		capacity          int
+		falsePositiveRate float64
+	}{
+		{capacity: 0, falsePositiveRate: 0.01},
+		{capacity: -1, falsePositiveRate: 0.01},
+		{capacity: 1000, falsePositiveRate: 0},
+		{capacity: 1000, falsePositiveRate: 1},
+		{capacity: 1000, falsePositiveRate: -1},
+		{capacity: 1000, falsePositiveRate: math.NaN()},
+	}
+
+	for _, test := range tests {
+		s := New(NewMemoryStorage(), WithKeyFilter(test.capacity, test.falsePositiveRate))
+		s.filter.add("key")
+		if !s.filter.mayContain("key") {
+			t.Errorf("%d, %v: want added key found", test.capacity, test.falsePositiveRate)
+		}
+		if s.filter.mayContain("other") {
+			t.Errorf("%d, %v: want other key not found", test.capacity, test.falsePositiveRate)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// BodyFingerprint fingerprints a request by the SHA-256 sum of its body. The
+// body is restored so that it can still be read by the handler.
+func BodyFingerprint(r *http.Request) (string, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return fingerprintBytes(nil), nil
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read request body: %w", err)
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	return fingerprintBytes(body), nil
+}
+
+// readBody reads the body of r, sizing the buffer by its Content-Length.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.ContentLength <= 0 || r.ContentLength > maxSizeHint {
+		return io.ReadAll(r.Body)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, r.ContentLength+bytes.MinRead))
+	_, err := buf.ReadFrom(r.Body)
+	return buf.Bytes(), err
+}
+
+func fingerprintBytes(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,46 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Pinger is implemented by storages that can check the health of their
+// backend, which is used to report readiness.
+type Pinger interface {
+	// Ping returns an error if the backend is unreachable.
+	Ping(ctx context.Context) error
+}
+
+// Ping checks the health of the storage. Storages not implementing Pinger
+// are assumed to be healthy.
+func (s *State) Ping(ctx context.Context) error {
+	p, ok := s.storage.(Pinger)
+	if !ok || !s.caps.Pinger {
+		return nil
+	}
+
+	if err := p.Ping(ctx); err != nil {
+		return fmt.Errorf("idempotency storage is unavailable: %w", err)
+	}
+	return nil
+}
+
+// ReadyHandler returns a handler reporting whether the storage is healthy,
+// e.g. to be served as /readyz. It responds with 200 OK when the storage is
+// reachable and 503 Service Unavailable otherwise, so that deployments can
+// stop routing traffic to instances that would fail to verify requests.
+func (s *State) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+
+		if err := s.Ping(r.Context()); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
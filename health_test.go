@@ -0,0 +1,40 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type unreachableStorage struct {
+	*memoryStorage
+}
+
+func (u *unreachableStorage) Ping(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestReadyHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		storage        Storage
+		wantHTTPStatus int
+	}{
+		{name: "Healthy storage", storage: NewMemoryStorage(), wantHTTPStatus: http.StatusOK},
+		{name: "Unreachable storage", storage: &unreachableStorage{NewMemoryStorage()}, wantHTTPStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/readyz", nil)
+			w := httptest.NewRecorder()
+			New(test.storage).ReadyHandler().ServeHTTP(w, req)
+
+			if w.Code != test.wantHTTPStatus {
+				t.Errorf("want status code %v, got %v", test.wantHTTPStatus, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package idempotency
+
+import (
+	"net/http"
+	"time"
+)
+
+// Event describes a decision taken by the middleware for a request.
+type Event struct {
+	// Key is the idempotency key of the request, it is empty when the key
+	// is missing or invalid.
+	Key     string
+	Outcome Outcome
+	// Err is the error responded with for rejected requests.
+	Err error
+	// Shadow is true when the request was passed to the handler regardless
+	// of the outcome, see WithShadow.
+	Shadow bool
+	// Metadata is the metadata attached to a completed request, see
+	// SetMetadata.
+	Metadata map[string]string
+	// Overhead is the latency added by the middleware until the event, e.g.
+	// for storage calls. Errors completing a request are reported after the
+	// handler ran, so their overhead includes the handler.
+	Overhead time.Duration
+}
+
+// startContextKey is the key of the time the middleware started to verify a
+// request in context.Context.
+const startContextKey contextKey = "idempotency-start"
+
+// WithHook adds a function called with the Event of every request verified
+// by the middleware, e.g. to record metrics. Hooks are called synchronously
+// and should not block.
+func WithHook(f func(r *http.Request, e Event)) Option {
+	return func(s *State) {
+		// Copy the hooks so that states created by With do not share them.
+		s.hooks = append(s.hooks[:len(s.hooks):len(s.hooks)], f)
+	}
+}
+
+// emit logs and traces e and calls the hooks with it.
+func (s *State) emit(r *http.Request, e Event) {
+	if len(s.hooks) == 0 && s.logger == nil && s.debug == nil {
+		return
+	}
+	if start, ok := r.Context().Value(startContextKey).(time.Time); ok {
+		e.Overhead = time.Since(start)
+	}
+	if s.logger != nil {
+		s.log(r, e)
+	}
+	if t := debugFromContext(r); t != nil {
+		t.report(s, r, e)
+	}
+	for _, hook := range s.hooks {
+		hook(r, e)
+	}
+}
+
+// reject responds with err to a request which is not processed. In shadow
+// mode the request is passed to next instead.
+func (s *State) reject(e Event, err error, status int, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	e.Err = err
+	e.Shadow = s.shadow
+	s.emit(r, e)
+
+	if s.shadow {
+		next.ServeHTTP(w, r)
+		return
+	}
+	s.respondError(newFailure(r, e, err, status), w, r)
+}
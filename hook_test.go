@@ -0,0 +1,45 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestShadow(t *testing.T) {
+	var outcomes []Outcome
+	s := New(NewMemoryStorage(), WithShadow(true), WithHook(func(r *http.Request, e Event) {
+		if e.Outcome != OutcomeNew && !e.Shadow {
+			t.Errorf("want shadow event for outcome %v", e.Outcome)
+		}
+		outcomes = append(outcomes, e.Outcome)
+	}))
+
+	calls := 0
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	for _, key := range []string{"deadbeef", "deadbeef", ""} {
+		req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+		if key != "" {
+			req.Header.Set(HeaderName, key)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != http.StatusCreated {
+			t.Errorf("want status code %v, got %v", http.StatusCreated, w.Code)
+		}
+	}
+
+	if calls != 3 {
+		t.Errorf("want 3 calls, got %d", calls)
+	}
+	if want := []Outcome{OutcomeNew, OutcomeCompleted, OutcomeInvalidKey}; !reflect.DeepEqual(outcomes, want) {
+		t.Errorf("want outcomes %v, got %v", want, outcomes)
+	}
+}
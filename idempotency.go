@@ -1,30 +1,135 @@
 package idempotency
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"net/http"
+	"time"
 )
 
 // RequestStatus keeps track of requests that are in process and what body sum
 // they have, this is to check wether to return a Conflict or a Unprocessable
-// Entity.
+// Entity. Instance is the instance which reserved the key, see WithInstance,
+// and StartedAt and Heartbeat are Unix milliseconds of when the key was
+// reserved and when the instance of a heartbeat record was last alive.
+// RequestID is the request ID of the request reserving the key, see
+// WithRequestID. Failed marks a key whose attempts are exhausted, see WithMaxAttempts.
 type RequestStatus struct {
-	InProcess bool
+	InProcess   bool              `json:"in_process"`
+	Fingerprint string            `json:"fingerprint,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	Token       int64             `json:"token,omitempty"`
+	Instance    string            `json:"instance,omitempty"`
+	StartedAt   int64             `json:"started_at,omitempty"`
+	Heartbeat   int64             `json:"heartbeat,omitempty"`
+	RequestID   string            `json:"request_id,omitempty"`
+	Failed      bool              `json:"failed,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Response    *Response         `json:"response,omitempty"`
+	// Checkpoints are the steps recorded with Checkpoint, they are only set
+	// on the status of the checkpoints of a key.
+	Checkpoints map[string]json.RawMessage `json:"checkpoints,omitempty"`
 }
 
+// Response is a response captured from a completed request, it is stored with
+// the RequestStatus to be able to replay it.
+type Response struct {
+	StatusCode int         `json:"status_code,omitempty"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       []byte      `json:"body,omitempty"`
+}
+
+// Requirement defines how requests without an Idempotency-Key are handled.
+type Requirement int
+
+const (
+	// Required rejects requests without an Idempotency-Key with a 400 Bad
+	// Request.
+	Required Requirement = iota
+	// Optional passes requests without an Idempotency-Key through to the
+	// handler without any idempotency handling.
+	Optional
+	// Ignored passes all requests through to the handler without any
+	// idempotency handling, even if they have an Idempotency-Key.
+	Ignored
+)
+
+// HeaderName is the name of the header carrying the idempotency key.
+const HeaderName = "Idempotency-Key"
+
+// ReplayedHeaderName is the name of the header set on responses restored from
+// a previous request.
+const ReplayedHeaderName = "Idempotent-Replayed"
+
+// The errors of rejected requests, allocated once as they are static.
+var (
+	errNoKey       = errors.New("no Idempotency-Key set")
+	errMismatch    = errors.New("Idempotency-Key is already used for a different request")
+	errAbuse       = errors.New("too many Idempotency-Keys reused for different requests")
+	errOverloaded  = errors.New("too many requests in progress")
+	errRateLimited = errors.New("too many new Idempotency-Keys")
+)
+
 // Option is the functional option signature for configuring idempotency.
-type Option func(*state)
+type Option func(*State)
 
-type state struct {
-	storage      Storage
-	restorer     func(idempotencyKey string, w http.ResponseWriter, r *http.Request)
-	errResponder func(err error, status int, w http.ResponseWriter, r *http.Request)
+// State holds the configuration and storage used to verify idempotency keys.
+type State struct {
+	storage          Storage
+	restorer         func(idempotencyKey string, w http.ResponseWriter, r *http.Request)
+	errResponder     func(err error, status int, w http.ResponseWriter, r *http.Request)
+	failureResponder func(f Failure, w http.ResponseWriter, r *http.Request)
+	requirement      Requirement
+	ttl              time.Duration
+	fingerprint      func(r *http.Request) (string, error)
+	scope            func(r *http.Request) string
+	keySecret        []byte
+	keyGenerator     KeyGenerator
+	capture          bool
+	profile          Profile
+	documentation    string
+	shadow           bool
+	hooks            []func(r *http.Request, e Event)
+	transitionHooks  []func(ctx context.Context, t Transition)
+	logger           Logger
+	debug            *debugMode
+	rateLimit        *rateLimit
+	limiter          *concurrencyLimiter
+	abuse            *abuseDetection
+	owner            func(r *http.Request) string
+	clock            Clock
+	echoKey          bool
+	locker           Locker
+	locks            *locks
+	retryAfter       time.Duration
+	maxPreferWait    time.Duration
+	fanOut           time.Duration
+	preferAsync      func(r *http.Request, key string) string
+	async            *asyncCompletion
+	inflight         *inflight
+	instance         string
+	attempts         *maxAttempts
+	retention        func(status int) time.Duration
+	noStore          map[int]bool
+	filter           *keyFilter
+	caps             Capabilities
+	patterns         map[string][]Option
+	mediaTypes       []string
+	mediaTypeOpts    map[string][]Option
+	methods          map[string]Requirement
+	requestIDHeaders []string
+	timeouts         StorageTimeouts
+	shed             func(r *http.Request) bool
+	stripKey         bool
 }
 
 // WithRestorer configures the function that restores a previous payload from
 // storage.
 func WithRestorer(f func(idempotencyKey string, w http.ResponseWriter, r *http.Request)) Option {
-	return func(s *state) {
+	return func(s *State) {
 		s.restorer = f
 	}
 }
@@ -32,15 +137,135 @@ func WithRestorer(f func(idempotencyKey string, w http.ResponseWriter, r *http.R
 // WithErrorResponder configures a function that responds to the client
 // whenever an error occurs.
 func WithErrorResponder(f func(err error, status int, w http.ResponseWriter, r *http.Request)) Option {
-	return func(s *state) {
+	return func(s *State) {
 		s.errResponder = f
 	}
 }
 
+// WithRequirement configures whether the Idempotency-Key header is required,
+// it defaults to Required.
+func WithRequirement(requirement Requirement) Option {
+	return func(s *State) {
+		s.requirement = requirement
+	}
+}
+
+// WithMethodRequirement configures how requests with method are handled,
+// overriding WithRequirement, e.g. to require keys for POST, make them
+// optional for PATCH and ignore them for DELETE.
+func WithMethodRequirement(method string, requirement Requirement) Option {
+	return func(s *State) {
+		// Copied, since the map is shared with the State of With.
+		methods := maps.Clone(s.methods)
+		if methods == nil {
+			methods = make(map[string]Requirement)
+		}
+		methods[method] = requirement
+		s.methods = methods
+	}
+}
+
+// requirementFor returns the Requirement of r.
+func (s *State) requirementFor(r *http.Request) Requirement {
+	if requirement, ok := s.methods[r.Method]; ok {
+		return requirement
+	}
+	return s.requirement
+}
+
+// WithTTL configures how long keys are retained. It requires a storage
+// implementing StatusStorage, other storages use their own expiry.
+func WithTTL(ttl time.Duration) Option {
+	return func(s *State) {
+		s.ttl = ttl
+	}
+}
+
+// WithFingerprint configures the function computing a fingerprint of the
+// request payload. A repeated request with a different fingerprint is
+// rejected with a 422 Unprocessable Entity. It requires a storage implementing
+// StatusStorage, and a nil function disables fingerprinting which is the
+// default.
+func WithFingerprint(f func(r *http.Request) (string, error)) Option {
+	return func(s *State) {
+		s.fingerprint = f
+	}
+}
+
+// WithScope configures a function returning the scope of a request, e.g. the
+// route pattern. Keys are stored per scope so that the same Idempotency-Key
+// can be used for different endpoints.
+func WithScope(f func(r *http.Request) string) Option {
+	return func(s *State) {
+		s.scope = f
+	}
+}
+
+// WithKeyGenerator configures a KeyGenerator creating keys for requests
+// without an Idempotency-Key, instead of applying the Requirement. The
+// generated key is set on the request and the response. This is mainly
+// useful with content derived keys, see ContentKey.
+func WithKeyGenerator(g KeyGenerator) Option {
+	return func(s *State) {
+		s.keyGenerator = g
+	}
+}
+
+// WithResponseCapture configures whether responses are recorded and stored
+// with the key, to be replayed for repeated requests instead of calling the
+// restorer. It requires a storage implementing StatusStorage.
+func WithResponseCapture(enabled bool) Option {
+	return func(s *State) {
+		s.capture = enabled
+	}
+}
+
+// WithNoStoreStatus configures status codes whose responses are never stored
+// for replay, e.g. 429 and 503, which are only valid at the time of the
+// request. The key is still completed or released as for other responses,
+// and repeated requests are passed to the restorer.
+func WithNoStoreStatus(codes ...int) Option {
+	return func(s *State) {
+		s.noStore = make(map[int]bool, len(codes))
+		for _, code := range codes {
+			s.noStore[code] = true
+		}
+	}
+}
+
+// WithShadow configures the shadow mode, in which decisions are only
+// reported to the hooks and every request is passed to the handler. It allows
+// validating the behavior in production before enforcing it.
+func WithShadow(enabled bool) Option {
+	return func(s *State) {
+		s.shadow = enabled
+	}
+}
+
+// WithKeyEcho configures whether the Idempotency-Key of the request is set on
+// the response, for fresh and replayed responses as well as errors of the
+// middleware.
+func WithKeyEcho(enabled bool) Option {
+	return func(s *State) {
+		s.echoKey = enabled
+	}
+}
+
+// WithClock configures the Clock of the state, it defaults to SystemClock.
+// Storages have their own Clock.
+func WithClock(c Clock) Option {
+	return func(s *State) {
+		s.clock = c
+	}
+}
+
 // New creates a new idempotency state.
-func New(storage Storage, opts ...Option) *state {
-	s := &state{
-		storage: storage,
+func New(storage Storage, opts ...Option) *State {
+	s := &State{
+		storage:  storage,
+		caps:     StorageCapabilities(storage),
+		clock:    SystemClock,
+		inflight: &inflight{keys: make(map[string]int64)},
 		restorer: func(idempotencyKey string, w http.ResponseWriter, r *http.Request) {
 		},
 		errResponder: func(err error, status int, w http.ResponseWriter, r *http.Request) {
@@ -57,73 +282,322 @@ func New(storage Storage, opts ...Option) *state {
 	return s
 }
 
+// With returns a copy of s with opts applied, sharing the storage of s. It
+// is used to configure specific routes differently from the rest.
+func (s *State) With(opts ...Option) *State {
+	c := *s
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&c)
+		}
+	}
+
+	return &c
+}
+
+// storageKey returns the key used in storage for idempotencyKey.
+func (s *State) storageKey(r *http.Request, idempotencyKey string) string {
+	return s.scopedKey(s.scopeOf(r), idempotencyKey)
+}
+
+// scopedKey returns the key used in storage for idempotencyKey in scope.
+func (s *State) scopedKey(scope, idempotencyKey string) string {
+	if scope == "" {
+		return s.HashKey(idempotencyKey)
+	}
+	return scope + ":" + s.HashKey(idempotencyKey)
+}
+
+// scopeOf returns the scope of the keys of r, see WithScope.
+func (s *State) scopeOf(r *http.Request) string {
+	if s.scope != nil {
+		return s.scope(r)
+	}
+	if s.profile >= Draft06 {
+		return defaultScope(r)
+	}
+	return ""
+}
+
+// completed returns the status of a completed request reserved with st,
+// storing resp.
+func (st *RequestStatus) completed(resp *Response) *RequestStatus {
+	c := *st
+	c.InProcess = false
+	c.Response = resp
+	return &c
+}
+
+// add reserves key in storage, storing the status and TTL if the storage
+// supports it.
+func (s *State) add(ctx context.Context, key string, status *RequestStatus) (bool, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Add)
+	defer cancel()
+
+	if ss, ok := s.storage.(StatusStorage); ok {
+		return ss.AddStatus(ctx, key, status, s.ttl)
+	}
+	return s.storage.Add(ctx, key)
+}
+
+// complete marks key as completed in storage, storing status if the storage
+// supports it.
+func (s *State) complete(ctx context.Context, key string, status *RequestStatus) error {
+	cctx, cancel := withTimeout(ctx, s.timeouts.Complete)
+	defer cancel()
+
+	var err error
+	if ss, ok := s.storage.(StatusStorage); ok && status != nil {
+		err = ss.UpdateStatus(cctx, key, status)
+	} else {
+		err = s.storage.Complete(cctx, key)
+	}
+	if err != nil {
+		s.unlock(ctx, key)
+		s.inflight.remove(key)
+		return err
+	}
+
+	// Requests waiting for the key and hooks only observe the completion
+	// once it is committed.
+	completed := func() {
+		if status != nil {
+			s.inflight.land(key, status)
+		}
+		kind := TransitionCompleted
+		if status != nil && status.Failed {
+			kind = TransitionFailed
+		}
+		s.transition(ctx, key, kind, status)
+		s.unlock(ctx, key)
+		s.inflight.remove(key)
+	}
+	if !deferTxEffect(ctx, completed) {
+		completed()
+	}
+	return nil
+}
+
 // Verify verifies the contents of the Idempotency-Key to make sure the
 // request has not been seen before. The RFC defines the following
 // functionality:
 // * If the key has not been seen before, perform the request.
 // * If a request with the key is in process, then return a 409 Conflict.
 // * If a request with the key is completed, then return the prior result.
-// * TODO: If a request has a different request payload, it should return a
-// 422 Unprocessable Entity.
-// * TODO: Implement Link: <https://developer.example.com/idempotency>; rel="describedby"; type="text/html"
-func (s *state) Verify(next http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-		idempotencyKey := r.Header.Get("Idempotency-Key")
+// * If a request has a different request payload, it should return a
+// 422 Unprocessable Entity. This requires WithFingerprint.
+// * Errors link to the documentation configured with WithDocumentation.
+// The key and its status are available to the handler with FromContext and
+// StatusFromContext.
+func (s *State) Verify(next http.Handler) http.Handler {
+	if len(s.patterns) > 0 {
+		return s.verifyPatterns(next)
+	}
+	if len(s.mediaTypes) > 0 || len(s.mediaTypeOpts) > 0 {
+		return s.verifyContentTypes(next)
+	}
 
-		if idempotencyKey == "" {
-			s.errResponder(fmt.Errorf("no Idempotency-Key set"), http.StatusBadRequest, w, r)
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		requirement := s.requirementFor(r)
+		if requirement == Ignored {
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		status, err := s.storage.Get(ctx, idempotencyKey)
+		if len(s.hooks) > 0 || s.logger != nil {
+			// Events report the overhead of the middleware from here on.
+			r = r.WithContext(context.WithValue(r.Context(), startContextKey, time.Now()))
+		}
+		var trace *debugTrace
+		if s.debug != nil {
+			trace = newDebugTrace(s, w)
+			r = r.WithContext(context.WithValue(r.Context(), debugContextKey, trace))
+		}
+
+		ctx := r.Context()
+		if raw := r.Header.Get(HeaderName); s.echoKey && raw != "" {
+			w.Header().Set(HeaderName, raw)
+		}
+
+		idempotencyKey, err := s.parseKey(r.Header.Get(HeaderName))
 		if err != nil {
-			s.errResponder(fmt.Errorf("could not process request to get Idempotency-Key: %w", err), http.StatusInternalServerError, w, r)
+			s.reject(Event{Outcome: OutcomeInvalidKey}, err, http.StatusBadRequest, next, w, r)
 			return
 		}
 
-		// If the idempotency key does not exist, we do not need to
-		// process further.
-		if status == nil {
-			// Try adding the key
-			success, err := s.storage.Add(ctx, idempotencyKey)
+		if idempotencyKey == "" && s.keyGenerator != nil {
+			idempotencyKey, err = s.keyGenerator.GenerateKey(r)
 			if err != nil {
-				s.errResponder(fmt.Errorf("could not process request to save Idempotency-Key: %w", err), http.StatusInternalServerError, w, r)
+				s.reject(Event{Outcome: OutcomeError}, fmt.Errorf("could not generate Idempotency-Key: %w", err), http.StatusInternalServerError, next, w, r)
 				return
 			}
+			r.Header.Set(HeaderName, idempotencyKey)
+			w.Header().Set(HeaderName, idempotencyKey)
+		}
 
-			if success {
-				// Run the handlers that has the actual functionality.
+		if idempotencyKey == "" {
+			if requirement == Optional {
 				next.ServeHTTP(w, r)
-
-				// Complete the request.
-				err = s.storage.Complete(ctx, idempotencyKey)
-				if err != nil {
-					s.errResponder(fmt.Errorf("could not complete request: %w", err), http.StatusInternalServerError, w, r)
-				}
 				return
 			}
+			s.reject(Event{Outcome: OutcomeInvalidKey}, errNoKey, http.StatusBadRequest, next, w, r)
+			return
+		}
 
-			// Couldn't set the key, try reading it again
-			status, err = s.storage.Get(ctx, idempotencyKey)
+		// Make the key available to the handler.
+		ctx = NewContext(ctx, idempotencyKey)
+		r = r.WithContext(ctx)
+
+		var fingerprint string
+		if s.fingerprint != nil {
+			fingerprint, err = s.fingerprint(r)
 			if err != nil {
-				s.errResponder(fmt.Errorf("could not process request to get Idempotency-Key: %w", err), http.StatusInternalServerError, w, r)
+				s.reject(Event{Key: idempotencyKey, Outcome: OutcomeError}, fmt.Errorf("could not fingerprint request: %w", err), http.StatusBadRequest, next, w, r)
+				return
+			}
+		}
+
+		key := s.storageKey(r, idempotencyKey)
+		if trace != nil {
+			trace.storageKey, trace.fingerprint = key, fingerprint
+		}
+		if s.owner != nil {
+			ctx = NewOwnerContext(ctx, s.owner(r))
+		}
+		if id := s.requestID(r); id != "" {
+			ctx = context.WithValue(ctx, requestIDContextKey, id)
+		}
+
+		if s.shed != nil && s.shed(r) {
+			s.overloaded(idempotencyKey, next, w, r)
+			return
+		}
+
+		blocked, err := s.blocked(ctx, r)
+		if err != nil {
+			s.reject(Event{Key: idempotencyKey, Outcome: OutcomeError}, err, http.StatusInternalServerError, next, w, r)
+			return
+		}
+		if blocked {
+			s.reject(Event{Key: idempotencyKey, Outcome: OutcomeAbuse}, errAbuse, http.StatusForbidden, next, w, r)
+			return
+		}
+
+		release, ok := s.limiter.acquire(r)
+		if !ok {
+			s.overloaded(idempotencyKey, next, w, r)
+			return
+		}
+		defer release()
+
+		allowed, err := s.allowNewKey(ctx, r, key, fingerprint)
+		if err != nil {
+			s.reject(Event{Key: idempotencyKey, Outcome: OutcomeError}, err, http.StatusInternalServerError, next, w, r)
+			return
+		}
+		if !allowed {
+			s.rateLimited(idempotencyKey, next, w, r)
+			return
+		}
+
+		d, err := s.Reserve(ctx, key, fingerprint)
+		if err != nil {
+			s.reject(Event{Key: idempotencyKey, Outcome: OutcomeError}, err, http.StatusInternalServerError, next, w, r)
+			return
+		}
+		if d.Outcome == OutcomeInProcess {
+			d = s.awaitCompletion(ctx, r, key, fingerprint, d)
+		}
+		if trace != nil {
+			trace.stored = d.Status
+		}
+
+		rctx := newOutcomeContext(NewStatusContext(r.Context(), d.Status), d.Outcome)
+		var a *attempt
+		if d.Outcome == OutcomeNew {
+			// The attempt collects what the handler reports about its result.
+			rctx, a = withAttempt(rctx, s, key)
+		}
+		r = r.WithContext(rctx)
+
+		switch d.Outcome {
+		case OutcomeNew:
+			s.emit(r, Event{Key: idempotencyKey, Outcome: OutcomeNew})
+
+			completed := d.Status.completed(nil)
+
+			// Run the handlers that has the actual functionality.
+			if s.capture || s.attempts != nil || s.retention != nil {
+				cw := newCaptureWriter(w)
+				s.serveNext(ctx, key, next, cw, r)
+				completed.Response = cw.response()
+				cw.release()
+				a.status = completed.Response.StatusCode
+				a.failed = s.attempts != nil && a.status >= 500
+				if (!s.capture && !a.failed) || s.noStore[a.status] {
+					// Without capture the response is only kept to replay
+					// exhausted attempts.
+					completed.Response = nil
+				}
+			} else {
+				s.serveNext(ctx, key, next, w, r)
+			}
+
+			// Complete the request, in the background if configured.
+			if s.async != nil && s.async.enqueue(key, func() error {
+				return s.finish(context.WithoutCancel(ctx), key, completed, a)
+			}) {
 				return
 			}
-			if status == nil {
-				s.errResponder(fmt.Errorf("failed to both get and set the Idempotency-Key %s", idempotencyKey), http.StatusInternalServerError, w, r)
+			err = s.finish(ctx, key, completed, a)
+			if err != nil {
+				err = fmt.Errorf("could not complete request: %w", err)
+				e := Event{Key: idempotencyKey, Outcome: OutcomeError, Err: err}
+				s.emit(r, e)
+				if !s.shadow {
+					s.respondError(newFailure(r, e, err, http.StatusInternalServerError), w, r)
+				}
+			}
+			return
+		case OutcomeMismatch:
+			// The key must not be reused for a different request.
+			s.countMismatch(ctx, r, idempotencyKey)
+			s.reject(Event{Key: idempotencyKey, Outcome: OutcomeMismatch}, errMismatch, http.StatusUnprocessableEntity, next, w, r)
+			return
+		case OutcomeInProcess:
+			// Conflict if it is in process.
+			delay, ok := s.retryDelay(ctx, key)
+			if ok && !s.shadow {
+				setRetryAfter(w, delay)
+			}
+			if !ok {
+				delay = defaultRetryDelay
+			}
+			if s.preferAsync != nil && !s.shadow && prefersAsync(r) {
+				s.emit(r, Event{Key: idempotencyKey, Outcome: OutcomeInProcess})
+				s.respondAsync(w, r, idempotencyKey, delay)
 				return
 			}
+			s.reject(Event{Key: idempotencyKey, Outcome: OutcomeInProcess}, newConflictError(d.Status, delay), http.StatusConflict, next, w, r)
+			return
 		}
 
-		// Conflict if it is in process.
-		if status.InProcess {
-			s.errResponder(fmt.Errorf("request already in progress"), http.StatusConflict, w, r)
+		s.emit(r, Event{Key: idempotencyKey, Outcome: OutcomeCompleted, Shadow: s.shadow, Metadata: d.Status.Metadata})
+		if s.shadow {
+			next.ServeHTTP(w, r)
 			return
 		}
 
 		// Return the previous data if the request has been completed
 		// previously.
+		s.transition(ctx, key, TransitionReplayed, d.Status)
+		setOriginalRequestID(w, d.Status)
+		if (s.capture || d.Status.Failed) && d.Status.Response != nil {
+			writeResponse(w, r, d.Status.Response)
+			return
+		}
+		w.Header().Set(ReplayedHeaderName, "true")
 		s.restorer(idempotencyKey, w, r)
 	}
 
@@ -4,6 +4,8 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -22,6 +24,11 @@ func (f *incompleteStorage) Complete(ctx context.Context, key string) error {
 	return nil
 }
 
+// UpdateStatus is not set so that all requests are InProgress.
+func (f *incompleteStorage) UpdateStatus(ctx context.Context, key string, status *RequestStatus) error {
+	return nil
+}
+
 func TestVerify(t *testing.T) {
 	testRestorer := WithRestorer(func(idempotencyKey string, w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
@@ -29,10 +36,11 @@ func TestVerify(t *testing.T) {
 
 	tests := []struct {
 		name           string
-		have           *state
+		have           *State
 		wantHTTPStatus int
 		unsetHeader    bool
 		repeated       int
+		bodies         []string
 	}{
 		{
 			// If the key has not been seen before, perform the request.
@@ -65,6 +73,44 @@ func TestVerify(t *testing.T) {
 			wantHTTPStatus: http.StatusConflict,
 			repeated:       2,
 		},
+		{
+			name:           "No Idempotency-Key header with optional requirement, pass through to handler",
+			have:           New(NewMemoryStorage(), testRestorer, WithRequirement(Optional)),
+			wantHTTPStatus: http.StatusOK,
+			unsetHeader:    true,
+			repeated:       2,
+		},
+		{
+			// If a request has a different request payload, it should
+			// return a 422 Unprocessable Entity.
+			name:           "Repeated requests with different payloads renders unprocessable entity",
+			have:           New(NewMemoryStorage(), testRestorer, WithFingerprint(BodyFingerprint)),
+			wantHTTPStatus: http.StatusUnprocessableEntity,
+			repeated:       2,
+			bodies:         []string{`{"amount":1}`, `{"amount":2}`},
+		},
+		{
+			name:           "Repeated requests with the same payload ends up in restorer",
+			have:           New(NewMemoryStorage(), testRestorer, WithFingerprint(BodyFingerprint)),
+			wantHTTPStatus: http.StatusNoContent,
+			repeated:       2,
+			bodies:         []string{`{"amount":1}`, `{"amount":1}`},
+		},
+		{
+			name:           "No Idempotency-Key header with content derived keys ends up in restorer",
+			have:           New(NewMemoryStorage(), testRestorer, WithKeyGenerator(ContentKey())),
+			wantHTTPStatus: http.StatusNoContent,
+			unsetHeader:    true,
+			repeated:       2,
+		},
+		{
+			name: "Repeated requests in different scopes, pass through to handler",
+			have: New(NewMemoryStorage(), testRestorer, WithScope(func(r *http.Request) string {
+				return r.Header.Get("X-Scope")
+			})),
+			wantHTTPStatus: http.StatusOK,
+			repeated:       2,
+		},
 	}
 
 	for _, test := range tests {
@@ -78,10 +124,16 @@ func TestVerify(t *testing.T) {
 
 			var resp *http.Response
 			for i := 0; i < test.repeated; i++ {
-				req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+				var body string
+				if i < len(test.bodies) {
+					body = test.bodies[i]
+				}
+
+				req := httptest.NewRequest("GET", "http://example.com/foo", strings.NewReader(body))
 				if !test.unsetHeader {
 					req.Header.Set("Idempotency-Key", "deadbeef")
 				}
+				req.Header.Set("X-Scope", strconv.Itoa(i))
 
 				w := httptest.NewRecorder()
 				test.have.Verify(handler()).ServeHTTP(w, req)
@@ -94,3 +146,117 @@ func TestVerify(t *testing.T) {
 		})
 	}
 }
+
+func TestKeyEcho(t *testing.T) {
+	tests := []struct {
+		name           string
+		have           *State
+		repeated       int
+		wantHTTPStatus int
+	}{
+		{name: "Fresh response", have: New(NewMemoryStorage(), WithKeyEcho(true)), repeated: 1, wantHTTPStatus: http.StatusOK},
+		{name: "Replayed response", have: New(NewMemoryStorage(), WithKeyEcho(true), WithResponseCapture(true)), repeated: 2, wantHTTPStatus: http.StatusOK},
+		{name: "Error of the middleware", have: New(newIncompleteStorage(), WithKeyEcho(true)), repeated: 2, wantHTTPStatus: http.StatusConflict},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var w *httptest.ResponseRecorder
+			for i := 0; i < test.repeated; i++ {
+				req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+				req.Header.Set(HeaderName, "deadbeef")
+
+				w = httptest.NewRecorder()
+				test.have.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, req)
+			}
+
+			if w.Code != test.wantHTTPStatus {
+				t.Errorf("want status code %v, got %v", test.wantHTTPStatus, w.Code)
+			}
+			if got := w.Header().Get(HeaderName); got != "deadbeef" {
+				t.Errorf("want %s header %q, got %q", HeaderName, "deadbeef", got)
+			}
+		})
+	}
+}
+
+func TestNoStoreStatus(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		wantReplayed bool
+	}{
+		{name: "Stored status is replayed", status: http.StatusCreated, wantReplayed: true},
+		{name: "Not stored status is restored", status: http.StatusTooManyRequests},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			restored := false
+			s := New(NewMemoryStorage(), WithResponseCapture(true), WithNoStoreStatus(http.StatusTooManyRequests, http.StatusServiceUnavailable),
+				WithRestorer(func(idempotencyKey string, w http.ResponseWriter, r *http.Request) {
+					restored = true
+				}))
+			handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+			}))
+
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+				req.Header.Set(HeaderName, "key")
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			d, err := s.Check(context.Background(), "key", "")
+			if err != nil || d.Outcome != OutcomeCompleted {
+				t.Fatalf("want key completed, got %v, %v", d.Outcome, err)
+			}
+			if got := d.Status.Response != nil; got != test.wantReplayed {
+				t.Errorf("want response stored %v, got %v", test.wantReplayed, got)
+			}
+			if restored == test.wantReplayed {
+				t.Errorf("want restorer called %v, got %v", !test.wantReplayed, restored)
+			}
+		})
+	}
+}
+
+func TestMethodRequirement(t *testing.T) {
+	s := New(NewMemoryStorage(), WithMethodRequirement("PATCH", Optional), WithMethodRequirement("DELETE", Ignored))
+	route := s.With(WithMethodRequirement("PUT", Ignored))
+
+	calls := 0
+	handler := route.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+
+	tests := []struct {
+		method    string
+		key       string
+		wantCode  int
+		wantCalls int
+	}{
+		{method: "POST", wantCode: http.StatusBadRequest, wantCalls: 0},
+		{method: "PATCH", wantCode: http.StatusOK, wantCalls: 1},
+		{method: "PATCH", key: "a", wantCode: http.StatusOK, wantCalls: 2},
+		{method: "PATCH", key: "a", wantCode: http.StatusOK, wantCalls: 2},
+		{method: "DELETE", key: "a", wantCode: http.StatusOK, wantCalls: 3},
+		{method: "DELETE", key: "a", wantCode: http.StatusOK, wantCalls: 4},
+		{method: "PUT", wantCode: http.StatusOK, wantCalls: 5},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "http://example.com/foo", nil)
+		if tt.key != "" {
+			req.Header.Set(HeaderName, tt.key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.wantCode || calls != tt.wantCalls {
+			t.Errorf("%s with key %q: want %d after %d calls, got %d after %d", tt.method, tt.key, tt.wantCode, tt.wantCalls, w.Code, calls)
+		}
+	}
+
+	if _, ok := s.methods["PUT"]; ok {
+		t.Error("want With not to change the requirements of s")
+	}
+}
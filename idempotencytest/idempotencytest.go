@@ -0,0 +1,182 @@
+// Package idempotencytest provides fakes and assertions for testing
+// applications using the idempotency middleware, e.g. how a handler behaves
+// for replays and conflicts, without a real storage backend.
+package idempotencytest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// ErrForced is returned by the Storage for keys forced to
+// idempotency.OutcomeError.
+var ErrForced = errors.New("idempotencytest: forced error")
+
+// MismatchFingerprint is the fingerprint of keys forced to
+// idempotency.OutcomeMismatch.
+const MismatchFingerprint = "idempotencytest:mismatch"
+
+// memory is the set of interfaces implemented by the memory storage.
+type memory interface {
+	idempotency.StatusStorage
+	idempotency.Deleter
+	idempotency.Counter
+	idempotency.Expirer
+	idempotency.OwnerDeleter
+}
+
+// Storage is a scriptable in memory storage. Keys can be preloaded with a
+// status or forced to an outcome. Keys are the keys in storage, which include
+// the scope of the request if the State is configured with one.
+type Storage struct {
+	memory
+
+	mu     sync.Mutex
+	forced map[string]idempotency.Outcome
+}
+
+// NewStorage creates an empty Storage.
+func NewStorage() *Storage {
+	return &Storage{
+		memory: idempotency.NewMemoryStorage(),
+		forced: make(map[string]idempotency.Outcome),
+	}
+}
+
+// NewState creates a State with a new Storage, applying opts.
+func NewState(opts ...idempotency.Option) (*idempotency.State, *Storage) {
+	storage := NewStorage()
+	return idempotency.New(storage, opts...), storage
+}
+
+// Preload stores status for key.
+func (s *Storage) Preload(key string, status idempotency.RequestStatus) {
+	ctx := context.Background()
+	if ok, _ := s.memory.AddStatus(ctx, key, &status, 0); !ok {
+		s.memory.UpdateStatus(ctx, key, &status)
+	}
+}
+
+// Force makes every request with key get outcome, regardless of what is
+// stored. Forcing idempotency.OutcomeMismatch requires a State configured
+// with a fingerprint, and idempotency.OutcomeNew removes a forced outcome.
+func (s *Storage) Force(key string, outcome idempotency.Outcome) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if outcome == idempotency.OutcomeNew {
+		delete(s.forced, key)
+		return
+	}
+	s.forced[key] = outcome
+}
+
+// Get implements idempotency.Storage, returning a status matching the
+// forced outcome of key if any.
+func (s *Storage) Get(ctx context.Context, key string) (*idempotency.RequestStatus, error) {
+	s.mu.Lock()
+	outcome, ok := s.forced[key]
+	s.mu.Unlock()
+	if !ok {
+		return s.memory.Get(ctx, key)
+	}
+
+	switch outcome {
+	case idempotency.OutcomeInProcess:
+		return &idempotency.RequestStatus{InProcess: true}, nil
+	case idempotency.OutcomeCompleted:
+		return &idempotency.RequestStatus{}, nil
+	case idempotency.OutcomeMismatch:
+		return &idempotency.RequestStatus{Fingerprint: MismatchFingerprint}, nil
+	default:
+		return nil, ErrForced
+	}
+}
+
+// Status returns the stored status of key, ignoring forced outcomes.
+func (s *Storage) Status(key string) *idempotency.RequestStatus {
+	status, _ := s.memory.Get(context.Background(), key)
+	return status
+}
+
+// Recorder records the events of a State.
+type Recorder struct {
+	mu     sync.Mutex
+	events []idempotency.Event
+}
+
+// Hook returns the option adding the Recorder as a hook of a State.
+func (rec *Recorder) Hook() idempotency.Option {
+	return idempotency.WithHook(func(r *http.Request, e idempotency.Event) {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+
+		rec.events = append(rec.events, e)
+	})
+}
+
+// Events returns the recorded events.
+func (rec *Recorder) Events() []idempotency.Event {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	return slices.Clone(rec.events)
+}
+
+// Outcomes returns the outcomes of the recorded events.
+func (rec *Recorder) Outcomes() []idempotency.Outcome {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	outcomes := make([]idempotency.Outcome, len(rec.events))
+	for i, e := range rec.events {
+		outcomes[i] = e.Outcome
+	}
+	return outcomes
+}
+
+// AssertOutcomes checks that the recorded outcomes are want, in order.
+func (rec *Recorder) AssertOutcomes(t testing.TB, want ...idempotency.Outcome) {
+	t.Helper()
+
+	if got := rec.Outcomes(); !slices.Equal(got, want) {
+		t.Errorf("want outcomes %v, got %v", want, got)
+	}
+}
+
+// AssertReplayed checks whether w is a response replayed by the middleware.
+func AssertReplayed(t testing.TB, w *httptest.ResponseRecorder, want bool) {
+	t.Helper()
+
+	if got := w.Header().Get(idempotency.ReplayedHeaderName) == "true"; got != want {
+		t.Errorf("want replayed %v, got %v", want, got)
+	}
+}
+
+// AssertStored checks whether key is stored and completed in storage.
+func AssertStored(t testing.TB, storage idempotency.Storage, key string) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	status, err := storage.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("want key %q stored, got %v", key, err)
+	}
+	if status == nil {
+		t.Errorf("want key %q stored, got none", key)
+		return
+	}
+	if status.InProcess {
+		t.Errorf("want key %q completed, got in process", key)
+	}
+}
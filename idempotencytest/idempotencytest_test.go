@@ -0,0 +1,74 @@
+package idempotencytest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+func TestStorage(t *testing.T) {
+	var rec Recorder
+	s, storage := NewState(idempotency.WithFingerprint(idempotency.BodyFingerprint), rec.Hook())
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	storage.Preload("completed", idempotency.RequestStatus{})
+	storage.Force("in-process", idempotency.OutcomeInProcess)
+	storage.Force("mismatch", idempotency.OutcomeMismatch)
+	storage.Force("error", idempotency.OutcomeError)
+
+	tests := []struct {
+		key            string
+		wantHTTPStatus int
+		wantReplayed   bool
+	}{
+		{key: "new", wantHTTPStatus: http.StatusCreated},
+		{key: "completed", wantHTTPStatus: http.StatusOK, wantReplayed: true},
+		{key: "in-process", wantHTTPStatus: http.StatusConflict},
+		{key: "mismatch", wantHTTPStatus: http.StatusUnprocessableEntity},
+		{key: "error", wantHTTPStatus: http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://example.com/foo", strings.NewReader("body"))
+			req.Header.Set(idempotency.HeaderName, test.key)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != test.wantHTTPStatus {
+				t.Errorf("want status code %v, got %v", test.wantHTTPStatus, w.Code)
+			}
+			AssertReplayed(t, w, test.wantReplayed)
+		})
+	}
+
+	AssertStored(t, storage, "new")
+	rec.AssertOutcomes(t,
+		idempotency.OutcomeNew,
+		idempotency.OutcomeCompleted,
+		idempotency.OutcomeInProcess,
+		idempotency.OutcomeMismatch,
+		idempotency.OutcomeError,
+	)
+}
+
+func TestRunScenarios(t *testing.T) {
+	s := idempotency.New(idempotency.NewMemoryStorage(),
+		idempotency.WithFingerprint(idempotency.BodyFingerprint),
+		idempotency.WithResponseCapture(true),
+	)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	RunScenarios(t, s, next, Request{Target: "/orders", Body: "a", MismatchBody: "b"}, Expectations{
+		FirstStatus:  http.StatusCreated,
+		ReplayStatus: http.StatusCreated,
+	})
+}
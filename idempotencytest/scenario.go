@@ -0,0 +1,156 @@
+package idempotencytest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// Request describes the request driven through the scenarios.
+type Request struct {
+	Method string
+	Target string
+	Header http.Header
+	Body   string
+	// MismatchBody is a different body sent with the same key, the mismatch
+	// scenario is skipped if it is empty.
+	MismatchBody string
+	// QuoteKey sends the key as a structured field string, as required by
+	// idempotency.Draft06.
+	QuoteKey bool
+}
+
+// Expectations are the expected responses of the scenarios, zero values
+// use the defaults.
+type Expectations struct {
+	// FirstStatus is the status of the first attempt, any status below 400
+	// by default.
+	FirstStatus int
+	// DuplicateStatus is the status of a duplicate sent while the first
+	// attempt is in process, 409 Conflict by default.
+	DuplicateStatus int
+	// ReplayStatus is the status of a duplicate sent after the first attempt
+	// completed, it is not checked by default. The response must always
+	// carry the replayed header.
+	ReplayStatus int
+	// MismatchStatus is the status of a request reusing a key with a
+	// different body, 422 Unprocessable Entity by default.
+	MismatchStatus int
+}
+
+var scenarioKeys atomic.Int64
+
+// RunScenarios drives next, verified by s, through the canonical scenarios
+// as subtests of t: the first attempt, a concurrent duplicate, a replay after
+// completion and a mismatched payload. Every scenario uses a new key.
+func RunScenarios(t *testing.T, s *idempotency.State, next http.Handler, req Request, want Expectations) {
+	t.Helper()
+
+	if want.DuplicateStatus == 0 {
+		want.DuplicateStatus = http.StatusConflict
+	}
+	if want.MismatchStatus == 0 {
+		want.MismatchStatus = http.StatusUnprocessableEntity
+	}
+
+	handler := s.Verify(next)
+
+	t.Run("FirstAttempt", func(t *testing.T) {
+		w := serve(handler, req, newKey(), req.Body)
+		checkFirst(t, w, want)
+		AssertReplayed(t, w, false)
+	})
+
+	t.Run("ConcurrentDuplicate", func(t *testing.T) {
+		key := newKey()
+
+		// Send the duplicate while the first attempt is in the handler.
+		var duplicate *httptest.ResponseRecorder
+		var verified http.Handler
+		verified = s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if duplicate == nil {
+				duplicate = serve(verified, req, key, req.Body)
+			}
+			next.ServeHTTP(w, r)
+		}))
+
+		w := serve(verified, req, key, req.Body)
+		checkFirst(t, w, want)
+		if duplicate == nil {
+			t.Fatalf("want duplicate sent, the handler was not called")
+		}
+		if duplicate.Code != want.DuplicateStatus {
+			t.Errorf("want duplicate status code %v, got %v", want.DuplicateStatus, duplicate.Code)
+		}
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		key := newKey()
+		checkFirst(t, serve(handler, req, key, req.Body), want)
+
+		w := serve(handler, req, key, req.Body)
+		if want.ReplayStatus != 0 && w.Code != want.ReplayStatus {
+			t.Errorf("want replay status code %v, got %v", want.ReplayStatus, w.Code)
+		}
+		AssertReplayed(t, w, true)
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		if req.MismatchBody == "" {
+			t.Skip("no MismatchBody set")
+		}
+
+		key := newKey()
+		checkFirst(t, serve(handler, req, key, req.Body), want)
+
+		w := serve(handler, req, key, req.MismatchBody)
+		if w.Code != want.MismatchStatus {
+			t.Errorf("want mismatch status code %v, got %v", want.MismatchStatus, w.Code)
+		}
+	})
+}
+
+func newKey() string {
+	return "idempotencytest-" + strconv.FormatInt(scenarioKeys.Add(1), 10)
+}
+
+// serve sends req with key and body to handler.
+func serve(handler http.Handler, req Request, key, body string) *httptest.ResponseRecorder {
+	method := req.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	target := req.Target
+	if target == "" {
+		target = "/"
+	}
+
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for name, values := range req.Header {
+		r.Header[name] = values
+	}
+	if req.QuoteKey {
+		key = strconv.Quote(key)
+	}
+	r.Header.Set(idempotency.HeaderName, key)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func checkFirst(t *testing.T, w *httptest.ResponseRecorder, want Expectations) {
+	t.Helper()
+
+	if want.FirstStatus != 0 && w.Code != want.FirstStatus {
+		t.Errorf("want first status code %v, got %v", want.FirstStatus, w.Code)
+	}
+	if want.FirstStatus == 0 && w.Code >= 400 {
+		t.Errorf("want first status code below 400, got %v", w.Code)
+	}
+}
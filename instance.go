@@ -0,0 +1,127 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// WithInstance identifies the instance running the State, e.g. by its host
+// name. Reserved keys store the instance, so that RecoverOrphans can release
+// the keys of instances which died. The instance must call Heartbeat or
+// RunHeartbeat to be considered alive.
+func WithInstance(id string) Option {
+	return func(s *State) {
+		s.instance = id
+	}
+}
+
+// Heartbeat records that the instance of s is alive. It requires
+// WithInstance and a storage implementing StatusStorage.
+func (s *State) Heartbeat(ctx context.Context) error {
+	ss, ok := s.storage.(StatusStorage)
+	if !ok {
+		return errors.New("storage does not support storing heartbeats")
+	}
+	if s.instance == "" {
+		return errors.New("no instance configured")
+	}
+
+	key := internalKey("instance", s.instance)
+	status := &RequestStatus{Instance: s.instance, Heartbeat: s.clock.Now().UnixMilli()}
+
+	added, err := ss.AddStatus(ctx, key, status, 0)
+	if err == nil && !added {
+		err = ss.UpdateStatus(ctx, key, status)
+	}
+	if err != nil {
+		return fmt.Errorf("could not record heartbeat: %w", err)
+	}
+	return nil
+}
+
+// RunHeartbeat records heartbeats every interval until ctx is done, see
+// Heartbeat. Errors are passed to onError, which may be nil, and retried on
+// the next interval. Intervals are measured by the Clock of s.
+func (s *State) RunHeartbeat(ctx context.Context, interval time.Duration, onError func(err error)) error {
+	for {
+		if err := s.Heartbeat(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if onError != nil {
+				onError(err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.clock.After(interval):
+		}
+	}
+}
+
+// RecoverOrphans releases the keys in process whose instance has not
+// recorded a heartbeat within timeout, and the keys of this instance that it
+// did not reserve since it started, e.g. before a restart. It returns the
+// number of released keys. Keys reserved without WithInstance are left to
+// expire. It requires a storage implementing Scanner and Deleter.
+func (s *State) RecoverOrphans(ctx context.Context, timeout time.Duration) (int, error) {
+	sc, ok := s.storage.(Scanner)
+	if !ok || !s.caps.Scanner {
+		return 0, errors.New("storage does not support scanning keys")
+	}
+
+	now := s.clock.Now().UnixMilli()
+	alive := make(map[string]bool)
+	var orphans []*Reservation
+
+	err := sc.Scan(ctx, func(key string, status *RequestStatus) error {
+		if !status.InProcess || status.Instance == "" {
+			return nil
+		}
+
+		if status.Instance == s.instance {
+			s.inflight.mu.Lock()
+			_, ours := s.inflight.keys[key]
+			s.inflight.mu.Unlock()
+			if !ours {
+				orphans = append(orphans, s.Reservation(key, status.Token))
+			}
+			return nil
+		}
+
+		isAlive, ok := alive[status.Instance]
+		if !ok {
+			hb, err := s.storage.Get(ctx, internalKey("instance", status.Instance))
+			if err != nil {
+				return fmt.Errorf("could not get heartbeat: %w", err)
+			}
+			isAlive = hb != nil && now-hb.Heartbeat < timeout.Milliseconds()
+			alive[status.Instance] = isAlive
+		}
+		if !isAlive {
+			orphans = append(orphans, s.Reservation(key, status.Token))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not scan Idempotency-Keys: %w", err)
+	}
+
+	n := 0
+	for _, r := range orphans {
+		// Keys completed or taken over since the scan are skipped.
+		err := r.Fail(ctx)
+		if errors.Is(err, ErrReservationLost) {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
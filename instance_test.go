@@ -0,0 +1,101 @@
+package idempotency
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRecoverOrphans(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Now())
+	storage := NewMemoryStorage(WithMemoryClock(clock))
+
+	dead := New(storage, WithInstance("dead"), WithClock(clock))
+	alive := New(storage, WithInstance("alive"), WithClock(clock))
+	for _, s := range []*State{dead, alive} {
+		if err := s.Heartbeat(ctx); err != nil {
+			t.Fatalf("want heartbeat, got %v", err)
+		}
+		if _, err := s.Reserve(ctx, s.instance, ""); err != nil {
+			t.Fatalf("want key reserved, got %v", err)
+		}
+	}
+
+	clock.Advance(time.Minute)
+	if err := alive.Heartbeat(ctx); err != nil {
+		t.Fatalf("want heartbeat, got %v", err)
+	}
+
+	// The restarted instance recovers the keys of the dead instance and its
+	// own keys reserved before the restart.
+	restarted := New(storage, WithInstance("alive"), WithClock(clock))
+	n, err := restarted.RecoverOrphans(ctx, 30*time.Second)
+	if err != nil || n != 2 {
+		t.Fatalf("want 2 keys recovered, got %d, %v", n, err)
+	}
+
+	// The running instance keeps its own keys.
+	if _, err := alive.Reserve(ctx, "running", ""); err != nil {
+		t.Fatalf("want key reserved, got %v", err)
+	}
+	n, err = alive.RecoverOrphans(ctx, 30*time.Second)
+	if err != nil || n != 0 {
+		t.Fatalf("want no keys recovered, got %d, %v", n, err)
+	}
+	if d, _ := alive.Check(ctx, "running", ""); d.Outcome != OutcomeInProcess {
+		t.Errorf("want running key in process, got %v", d.Outcome)
+	}
+}
+
+func TestHeartbeatReservedKey(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Now())
+	storage := NewMemoryStorage(WithMemoryClock(clock))
+	web1 := New(storage, WithInstance("web-1"), WithClock(clock))
+	other := New(storage, WithInstance("web-2"), WithClock(clock))
+
+	if err := web1.Heartbeat(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := web1.Reserve(ctx, "running", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests cannot overwrite the heartbeat of an instance.
+	if _, _, err := Do(ctx, other, "instance:web-1", func(ctx context.Context) (int, error) { return 0, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Do(ctx, other, internalKey("instance", "web-1"), func(ctx context.Context) (int, error) { return 0, nil }); err == nil {
+		t.Error("want reserved key rejected")
+	}
+
+	if n, err := other.RecoverOrphans(ctx, time.Minute); err != nil || n != 0 {
+		t.Errorf("want key of live instance kept, got %d, %v", n, err)
+	}
+}
+
+func TestRunHeartbeat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	clock := NewFakeClock(time.Now())
+	storage := NewMemoryStorage(WithMemoryClock(clock))
+	s := New(storage, WithInstance("web-1"), WithClock(clock))
+
+	done := make(chan error)
+	go func() { done <- s.RunHeartbeat(ctx, time.Second, nil) }()
+
+	start := clock.Now()
+	for {
+		clock.Advance(time.Second)
+		status, _ := storage.Get(ctx, internalKey("instance", "web-1"))
+		if status != nil && status.Heartbeat > start.UnixMilli() {
+			break
+		}
+		runtime.Gosched()
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("want canceled, got %v", err)
+	}
+}
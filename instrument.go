@@ -0,0 +1,23 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+)
+
+// InstrumentStorage returns a decorator calling observe after every storage
+// call with the name of the called method, e.g. "Get", its duration and its
+// error, e.g. to record metrics or traces of the storage.
+func InstrumentStorage(observe func(op string, d time.Duration, err error)) StorageDecorator {
+	return func(next StatusStorage) StatusStorage {
+		return &interceptedStorage{
+			forwardingStorage: forwardingStorage{next: next},
+			intercept: func(ctx context.Context, op string, call func(ctx context.Context) error) error {
+				start := time.Now()
+				err := call(ctx)
+				observe(op, time.Since(start), err)
+				return err
+			},
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package idempotency
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// KeyGenerator generates idempotency keys. It is used by clients to create
+// keys for requests and by State to create keys for requests without one,
+// see WithKeyGenerator.
+type KeyGenerator interface {
+	GenerateKey(r *http.Request) (string, error)
+}
+
+// KeyGeneratorFunc is an adapter to allow the use of ordinary functions as
+// KeyGenerator.
+type KeyGeneratorFunc func(r *http.Request) (string, error)
+
+// GenerateKey calls f(r).
+func (f KeyGeneratorFunc) GenerateKey(r *http.Request) (string, error) {
+	return f(r)
+}
+
+// UUIDv4 returns a KeyGenerator generating random (version 4) UUIDs.
+func UUIDv4() KeyGenerator {
+	return KeyGeneratorFunc(func(r *http.Request) (string, error) {
+		var b [16]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return "", err
+		}
+		b[6] = (b[6] & 0x0f) | 0x40
+		b[8] = (b[8] & 0x3f) | 0x80
+		return formatUUID(b), nil
+	})
+}
+
+// UUIDv7 returns a KeyGenerator generating time ordered (version 7) UUIDs.
+func UUIDv7() KeyGenerator {
+	return KeyGeneratorFunc(func(r *http.Request) (string, error) {
+		var b [16]byte
+		if _, err := rand.Read(b[6:]); err != nil {
+			return "", err
+		}
+		putMillis(b[:6], time.Now())
+		b[6] = (b[6] & 0x0f) | 0x70
+		b[8] = (b[8] & 0x3f) | 0x80
+		return formatUUID(b), nil
+	})
+}
+
+// crockford is the alphabet used to encode ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID returns a KeyGenerator generating lexicographically sortable ULIDs.
+func ULID() KeyGenerator {
+	return KeyGeneratorFunc(func(r *http.Request) (string, error) {
+		var b [16]byte
+		if _, err := rand.Read(b[6:]); err != nil {
+			return "", err
+		}
+		putMillis(b[:6], time.Now())
+
+		// Encode the 128 bits as 26 characters of 5 bits, the first
+		// character only holds 3 bits.
+		hi := binary.BigEndian.Uint64(b[:8])
+		lo := binary.BigEndian.Uint64(b[8:])
+		var out [26]byte
+		for i := 25; i >= 0; i-- {
+			out[i] = crockford[lo&0x1f]
+			lo = lo>>5 | hi<<59
+			hi >>= 5
+		}
+		return string(out[:]), nil
+	})
+}
+
+// ContentKey returns a KeyGenerator deriving keys from the method, path and
+// body of the request, so that identical requests get identical keys.
+func ContentKey() KeyGenerator {
+	return KeyGeneratorFunc(func(r *http.Request) (string, error) {
+		body, err := BodyFingerprint(r)
+		if err != nil {
+			return "", err
+		}
+		return fingerprintBytes([]byte(r.Method + " " + r.URL.Path + " " + body)), nil
+	})
+}
+
+func putMillis(b []byte, t time.Time) {
+	ms := uint64(t.UnixMilli())
+	for i := 5; i >= 0; i-- {
+		b[i] = byte(ms)
+		ms >>= 8
+	}
+}
+
+func formatUUID(b [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
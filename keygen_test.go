@@ -0,0 +1,49 @@
+package idempotency
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestKeyGenerators(t *testing.T) {
+	tests := []struct {
+		name      string
+		generator KeyGenerator
+		want      *regexp.Regexp
+	}{
+		{name: "UUIDv4", generator: UUIDv4(), want: regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)},
+		{name: "UUIDv7", generator: UUIDv7(), want: regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)},
+		{name: "ULID", generator: ULID(), want: regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)},
+		{name: "ContentKey", generator: ContentKey(), want: regexp.MustCompile(`^[0-9a-f]{64}$`)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://example.com/foo", strings.NewReader("payload"))
+			got, err := test.generator.GenerateKey(req)
+			if err != nil {
+				t.Fatalf("want no error, got %v", err)
+			}
+
+			if !test.want.MatchString(got) {
+				t.Errorf("want key matching %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestContentKey(t *testing.T) {
+	generate := func(body string) string {
+		key, _ := ContentKey().GenerateKey(httptest.NewRequest("POST", "http://example.com/foo", strings.NewReader(body)))
+		return key
+	}
+
+	if generate("a") != generate("a") {
+		t.Errorf("want identical requests to get identical keys")
+	}
+	if generate("a") == generate("b") {
+		t.Errorf("want different requests to get different keys")
+	}
+}
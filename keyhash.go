@@ -0,0 +1,34 @@
+package idempotency
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// WithKeyHashing configures the keys to be stored as their HMAC-SHA256 with
+// secret instead of their raw value, since clients may put personal data
+// such as order numbers or email addresses in keys. The scope of a key is
+// kept, so that keys can still be told apart per scope. Keys stored before
+// hashing was enabled, or with another secret, are not found anymore.
+//
+// The methods of State taking a key, e.g. Forget, TTL and Reserve, operate
+// on stored keys, use HashKey to find the stored key of an Idempotency-Key.
+// Adapters calling Reserve hash the keys they derive from requests with it.
+func WithKeyHashing(secret []byte) Option {
+	return func(s *State) {
+		s.keySecret = secret
+	}
+}
+
+// HashKey returns the stored form of the Idempotency-Key key, its hex
+// encoded HMAC-SHA256 with the secret of WithKeyHashing, or key itself if
+// keys are not hashed.
+func (s *State) HashKey(key string) string {
+	if s.keySecret == nil {
+		return key
+	}
+	mac := hmac.New(sha256.New, s.keySecret)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,43 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithKeyHashing(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	s := New(storage, WithKeyHashing([]byte("secret")), WithScope(func(r *http.Request) string { return "orders" }))
+
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest("POST", "http://example.com/orders", nil)
+	req.Header.Set(HeaderName, "alice@example.com")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var keys []string
+	storage.Scan(ctx, func(key string, status *RequestStatus) error {
+		keys = append(keys, key)
+		return nil
+	})
+	want := "orders:" + s.HashKey("alice@example.com")
+	if len(keys) != 1 || keys[0] != want {
+		t.Fatalf("want stored key %q, got %q", want, keys)
+	}
+	if len(s.HashKey("alice@example.com")) != 64 || s.HashKey("a") == s.HashKey("b") {
+		t.Errorf("want distinct hex encoded HMACs, got %q", s.HashKey("a"))
+	}
+
+	if err := s.Forget(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := storage.Get(ctx, want); status != nil {
+		t.Errorf("want key forgotten by its hash, got %+v", status)
+	}
+
+	if key := New(storage).HashKey("raw"); key != "raw" {
+		t.Errorf("want key unchanged without hashing, got %q", key)
+	}
+}
@@ -0,0 +1,29 @@
+package idempotency
+
+import (
+	"errors"
+	"strings"
+)
+
+// internalPrefix starts the keys of the records stored next to the keys of
+// requests, e.g. counters and heartbeats. Idempotency-Keys starting with it
+// are rejected, so that clients cannot read or overwrite the records.
+const internalPrefix = "_idempotency:"
+
+var errReservedKey = errors.New("Idempotency-Key must not start with " + internalPrefix)
+
+// internalKey returns the key of the record of kind for id.
+func internalKey(kind, id string) string {
+	return internalPrefix + kind + ":" + id
+}
+
+// IsReservedKey reports whether key is reserved for the records of the
+// package. Adapters deriving keys from requests must reject such keys.
+func IsReservedKey(key string) bool {
+	return reservedKey(key)
+}
+
+// reservedKey reports whether key is in the namespace of internal records.
+func reservedKey(key string) bool {
+	return strings.HasPrefix(key, internalPrefix)
+}
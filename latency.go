@@ -0,0 +1,251 @@
+package idempotency
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds of the buckets of a
+// LatencyHistogram created without bounds.
+var DefaultLatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	time.Second,
+}
+
+// OtherLabel is the label value of tenants and routes beyond the cardinality
+// limits of MetricLabels.
+const OtherLabel = "other"
+
+// MetricLabels configures the tenant and route labels of metrics. Label
+// values are limited to allowlists, or to the first values seen up to a
+// maximum, and other values are labeled OtherLabel, so that the number of
+// series stays bounded.
+type MetricLabels struct {
+	// Tenant returns the tenant of a request, no tenant label is recorded
+	// if it is nil.
+	Tenant func(r *http.Request) string
+	// Tenants is the allowlist of tenants, if it is empty the first
+	// MaxTenants tenants are labeled.
+	Tenants    []string
+	MaxTenants int
+	// Routes labels requests by their ServeMux pattern, see r.Pattern.
+	Routes bool
+	// RouteAllowlist is the allowlist of patterns, if it is empty the first
+	// MaxRoutes patterns are labeled.
+	RouteAllowlist []string
+	MaxRoutes      int
+}
+
+// labelValues limits the values of a label.
+type labelValues struct {
+	allowed map[string]bool
+	max     int
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func newLabelValues(allowlist []string, max int) *labelValues {
+	l := &labelValues{max: max, seen: make(map[string]bool)}
+	if len(allowlist) > 0 {
+		l.allowed = make(map[string]bool, len(allowlist))
+		for _, v := range allowlist {
+			l.allowed[v] = true
+		}
+	}
+	return l
+}
+
+// value returns the label value of v.
+func (l *labelValues) value(v string) string {
+	if v == "" {
+		return ""
+	}
+	if l.allowed != nil {
+		if l.allowed[v] {
+			return v
+		}
+		return OtherLabel
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.seen[v] {
+		return v
+	}
+	if len(l.seen) < l.max {
+		l.seen[v] = true
+		return v
+	}
+	return OtherLabel
+}
+
+type seriesKey struct {
+	outcome Outcome
+	tenant  string
+	route   string
+}
+
+type histogram struct {
+	counts []atomic.Uint64 // per bucket, the last one is unbounded
+	sum    atomic.Int64    // nanoseconds
+}
+
+// LatencyHistogram records the overhead of the middleware per Outcome, see
+// Event.Overhead, to quantify the latency it adds to requests. It is
+// registered with WithHook(h.Hook()) and served in the Prometheus text format
+// as idempotency_overhead_seconds.
+type LatencyHistogram struct {
+	bounds []time.Duration
+	tenant func(r *http.Request) string
+	routes bool
+
+	tenants     *labelValues
+	routeValues *labelValues
+
+	mu     sync.RWMutex
+	series map[seriesKey]*histogram
+}
+
+// NewLatencyHistogram creates a LatencyHistogram with buckets of the
+// ascending upper bounds, it defaults to DefaultLatencyBuckets.
+func NewLatencyHistogram(bounds ...time.Duration) *LatencyHistogram {
+	if len(bounds) == 0 {
+		bounds = DefaultLatencyBuckets
+	}
+	return &LatencyHistogram{bounds: bounds, series: make(map[seriesKey]*histogram)}
+}
+
+// WithLabels configures h to label its series by tenant and route, it must
+// be called before h is used.
+func (h *LatencyHistogram) WithLabels(l MetricLabels) *LatencyHistogram {
+	h.tenant = l.Tenant
+	h.tenants = newLabelValues(l.Tenants, l.MaxTenants)
+	h.routes = l.Routes
+	h.routeValues = newLabelValues(l.RouteAllowlist, l.MaxRoutes)
+	return h
+}
+
+// Hook returns a hook for WithHook recording the overhead of events.
+func (h *LatencyHistogram) Hook() func(r *http.Request, e Event) {
+	return func(r *http.Request, e Event) {
+		key := seriesKey{outcome: e.Outcome}
+		if h.tenant != nil {
+			key.tenant = h.tenants.value(h.tenant(r))
+		}
+		if h.routes {
+			key.route = h.routeValues.value(r.Pattern)
+		}
+		h.observe(key, e.Overhead)
+	}
+}
+
+// Observe records an overhead of d for outcome.
+func (h *LatencyHistogram) Observe(outcome Outcome, d time.Duration) {
+	h.observe(seriesKey{outcome: outcome}, d)
+}
+
+func (h *LatencyHistogram) observe(key seriesKey, d time.Duration) {
+	h.mu.RLock()
+	s, ok := h.series[key]
+	h.mu.RUnlock()
+	if !ok {
+		h.mu.Lock()
+		if s, ok = h.series[key]; !ok {
+			s = &histogram{counts: make([]atomic.Uint64, len(h.bounds)+1)}
+			h.series[key] = s
+		}
+		h.mu.Unlock()
+	}
+
+	i := 0
+	for i < len(h.bounds) && d > h.bounds[i] {
+		i++
+	}
+	s.counts[i].Add(1)
+	s.sum.Add(int64(d))
+}
+
+// HistogramSnapshot is the state of the histogram of an outcome, tenant and
+// route.
+type HistogramSnapshot struct {
+	Outcome Outcome
+	Tenant  string
+	Route   string
+	// Bounds are the upper bounds of the buckets.
+	Bounds []time.Duration
+	// Counts are the cumulative counts of the buckets, with one more count
+	// for the unbounded bucket, which is the total count.
+	Counts []uint64
+	Sum    time.Duration
+}
+
+// Snapshot returns the histograms which were observed, ordered by outcome,
+// tenant and route.
+func (h *LatencyHistogram) Snapshot() []HistogramSnapshot {
+	h.mu.RLock()
+	snapshots := make([]HistogramSnapshot, 0, len(h.series))
+	for key, s := range h.series {
+		snap := HistogramSnapshot{Outcome: key.outcome, Tenant: key.tenant, Route: key.route, Bounds: h.bounds,
+			Counts: make([]uint64, len(s.counts)), Sum: time.Duration(s.sum.Load())}
+		var total uint64
+		for i := range s.counts {
+			total += s.counts[i].Load()
+			snap.Counts[i] = total
+		}
+		snapshots = append(snapshots, snap)
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		a, b := snapshots[i], snapshots[j]
+		if a.Outcome != b.Outcome {
+			return a.Outcome < b.Outcome
+		}
+		if a.Tenant != b.Tenant {
+			return a.Tenant < b.Tenant
+		}
+		return a.Route < b.Route
+	})
+	return snapshots
+}
+
+// ServeHTTP writes the histograms in the Prometheus text format.
+func (h *LatencyHistogram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	fmt.Fprintln(w, "# HELP idempotency_overhead_seconds Latency added by the idempotency middleware.")
+	fmt.Fprintln(w, "# TYPE idempotency_overhead_seconds histogram")
+	for _, snap := range h.Snapshot() {
+		labels := fmt.Sprintf("outcome=%q", snap.Outcome.String())
+		if h.tenant != nil {
+			labels += fmt.Sprintf(",tenant=%q", snap.Tenant)
+		}
+		if h.routes {
+			labels += fmt.Sprintf(",route=%q", snap.Route)
+		}
+
+		for i, bound := range snap.Bounds {
+			fmt.Fprintf(w, "idempotency_overhead_seconds_bucket{%s,le=%q} %d\n", labels, strconv.FormatFloat(bound.Seconds(), 'g', -1, 64), snap.Counts[i])
+		}
+		count := snap.Counts[len(snap.Counts)-1]
+		fmt.Fprintf(w, "idempotency_overhead_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, count)
+		fmt.Fprintf(w, "idempotency_overhead_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(snap.Sum.Seconds(), 'g', -1, 64))
+		fmt.Fprintf(w, "idempotency_overhead_seconds_count{%s} %d\n", labels, count)
+	}
+}
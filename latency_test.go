@@ -0,0 +1,78 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	h := NewLatencyHistogram(time.Millisecond, time.Second)
+	handler := New(NewMemoryStorage(), WithHook(h.Hook())).Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The handler is not part of the overhead.
+		time.Sleep(10 * time.Millisecond)
+	}))
+	for range 2 {
+		req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+		req.Header.Set(HeaderName, "key")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	snapshots := h.Snapshot()
+	if len(snapshots) != 2 {
+		t.Fatalf("want 2 series, got %+v", snapshots)
+	}
+	for i, outcome := range []Outcome{OutcomeNew, OutcomeCompleted} {
+		if snap := snapshots[i]; snap.Outcome != outcome || snap.Counts[0] != 1 || snap.Counts[2] != 1 {
+			t.Errorf("want one fast %v event, got %+v", outcome, snap)
+		}
+	}
+
+	h.Observe(OutcomeError, 2*time.Second)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`idempotency_overhead_seconds_bucket{outcome="new",le="0.001"} 1`,
+		`idempotency_overhead_seconds_bucket{outcome="error",le="1"} 0`,
+		`idempotency_overhead_seconds_bucket{outcome="error",le="+Inf"} 1`,
+		`idempotency_overhead_seconds_sum{outcome="error"} 2`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("want %s in\n%s", want, w.Body.String())
+		}
+	}
+}
+
+func TestLatencyHistogramLabels(t *testing.T) {
+	h := NewLatencyHistogram().WithLabels(MetricLabels{
+		Tenant:     func(r *http.Request) string { return r.Header.Get("X-Tenant") },
+		MaxTenants: 2,
+		Routes:     true,
+		// Only the users endpoint is labeled.
+		RouteAllowlist: []string{"POST /users"},
+	})
+	handler := New(NewMemoryStorage(), WithRequirement(Optional), WithHook(h.Hook())).Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	mux := http.NewServeMux()
+	mux.Handle("POST /users", handler)
+	mux.Handle("POST /orders", handler)
+
+	for _, tt := range []struct{ tenant, path string }{
+		{"a", "/users"}, {"b", "/users"}, {"c", "/users"}, {"a", "/orders"},
+	} {
+		req := httptest.NewRequest("POST", "http://example.com"+tt.path, nil)
+		req.Header.Set(HeaderName, tt.tenant+tt.path)
+		req.Header.Set("X-Tenant", tt.tenant)
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	var got []string
+	for _, snap := range h.Snapshot() {
+		got = append(got, snap.Tenant+" "+snap.Route)
+	}
+	want := []string{"a POST /users", "a other", "b POST /users", "other POST /users"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("want series %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,133 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrLocked is returned by a Locker when the key is locked by another owner.
+var ErrLocked = errors.New("idempotency: key is locked")
+
+// Locker is a distributed lock manager. With WithLocker the in process claim
+// of a key is a lock held by the processing replica instead of the status
+// stored with the key, so that claims expire with the lock if the replica
+// dies and can only be released by their owner.
+type Locker interface {
+	// TryLock locks key without waiting, it returns ErrLocked if the key is
+	// locked already.
+	TryLock(ctx context.Context, key string) (Lock, error)
+}
+
+// Lock is a lock held on a key. Locks implementing an Extend(ctx) error
+// method are extended by Reservation.Extend.
+type Lock interface {
+	// Unlock releases the lock if it is still held by its owner.
+	Unlock(ctx context.Context) error
+}
+
+// locks are the locks held by the reservations of a State.
+type locks struct {
+	mu   sync.Mutex
+	held map[string]Lock
+}
+
+// WithLocker configures a Locker claiming keys while they are in process.
+// A key found in process without a lock, e.g. because its replica died, is
+// taken over by the next request. It requires a storage implementing
+// StatusStorage.
+func WithLocker(l Locker) Option {
+	return func(s *State) {
+		s.locker = l
+		s.locks = &locks{held: make(map[string]Lock)}
+	}
+}
+
+// reserveLocked reserves key while holding its lock, the lock is kept until
+// the reservation is finished or failed.
+func (s *State) reserveLocked(ctx context.Context, key, fingerprint string) (Decision, error) {
+	lock, err := s.locker.TryLock(ctx, key)
+	if errors.Is(err, ErrLocked) {
+		d, err := s.Check(ctx, key, fingerprint)
+		if err != nil || d.Outcome != OutcomeNew {
+			return d, err
+		}
+		// The owner of the lock did not store the key yet.
+		return Decision{Outcome: OutcomeInProcess, Status: &RequestStatus{InProcess: true}}, nil
+	}
+	if err != nil {
+		return Decision{}, fmt.Errorf("could not lock Idempotency-Key: %w", err)
+	}
+
+	d, err := s.Check(ctx, key, fingerprint)
+	if err == nil {
+		switch d.Outcome {
+		case OutcomeNew:
+			d, err = s.reserve(ctx, key, fingerprint)
+		case OutcomeInProcess:
+			// The owner of the key lost its lock, take the key over.
+			d, err = s.takeOver(ctx, key, d.Status)
+		}
+	}
+	if err != nil || d.Outcome != OutcomeNew {
+		lock.Unlock(ctx)
+		return d, err
+	}
+
+	s.locks.mu.Lock()
+	s.locks.held[key] = lock
+	s.locks.mu.Unlock()
+	return d, nil
+}
+
+// takeOver reserves a key which is in process without a lock.
+func (s *State) takeOver(ctx context.Context, key string, status *RequestStatus) (Decision, error) {
+	ss, ok := s.storage.(StatusStorage)
+	if !ok {
+		return Decision{Outcome: OutcomeInProcess, Status: status}, nil
+	}
+
+	taken := *status
+	taken.Token = newToken(s.clock)
+	taken.Instance = s.instance
+	taken.StartedAt = s.clock.Now().UnixMilli()
+	if err := ss.UpdateStatus(ctx, key, &taken); err != nil {
+		return Decision{}, fmt.Errorf("could not process request to save Idempotency-Key: %w", err)
+	}
+	return Decision{Outcome: OutcomeNew, Status: &taken, Reservation: s.Reservation(key, taken.Token)}, nil
+}
+
+// unlock releases the lock held on key, if any.
+func (s *State) unlock(ctx context.Context, key string) {
+	if s.locks == nil {
+		return
+	}
+
+	s.locks.mu.Lock()
+	lock, ok := s.locks.held[key]
+	delete(s.locks.held, key)
+	s.locks.mu.Unlock()
+
+	if ok {
+		lock.Unlock(ctx)
+	}
+}
+
+// extendLock extends the lock held on key, if any and supported.
+func (s *State) extendLock(ctx context.Context, key string) error {
+	if s.locks == nil {
+		return nil
+	}
+
+	s.locks.mu.Lock()
+	lock := s.locks.held[key]
+	s.locks.mu.Unlock()
+
+	if e, ok := lock.(interface{ Extend(context.Context) error }); ok {
+		if err := e.Extend(ctx); err != nil {
+			return fmt.Errorf("could not extend lock: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// testLocker is a Locker for a single process.
+type testLocker struct {
+	mu     sync.Mutex
+	locked map[string]bool
+}
+
+func (l *testLocker) TryLock(ctx context.Context, key string) (Lock, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.locked[key] {
+		return nil, ErrLocked
+	}
+	l.locked[key] = true
+	return testLock{l: l, key: key}, nil
+}
+
+type testLock struct {
+	l   *testLocker
+	key string
+}
+
+func (t testLock) Unlock(ctx context.Context) error {
+	t.l.mu.Lock()
+	defer t.l.mu.Unlock()
+
+	delete(t.l.locked, t.key)
+	return nil
+}
+
+func TestLocker(t *testing.T) {
+	ctx := context.Background()
+	locker := &testLocker{locked: make(map[string]bool)}
+	storage := NewMemoryStorage()
+	s := New(storage, WithLocker(locker))
+
+	// A key left in process by a dead replica holds no lock.
+	storage.AddStatus(ctx, "stale", &RequestStatus{InProcess: true, Token: 1}, 0)
+
+	steps := []struct {
+		name        string
+		run         func() (Decision, error)
+		wantOutcome Outcome
+		wantLocked  bool
+	}{
+		{name: "Reserve of a new key", run: func() (Decision, error) { return s.Reserve(ctx, "key", "") }, wantOutcome: OutcomeNew, wantLocked: true},
+		{name: "Reserve of a locked key", run: func() (Decision, error) { return s.Reserve(ctx, "key", "") }, wantOutcome: OutcomeInProcess, wantLocked: true},
+		{name: "Reserve of a finished key", run: func() (Decision, error) {
+			if err := s.Finish(ctx, "key", nil); err != nil {
+				return Decision{}, err
+			}
+			return s.Reserve(ctx, "key", "")
+		}, wantOutcome: OutcomeCompleted},
+		{name: "Reserve of a key in process without lock", run: func() (Decision, error) {
+			d, err := s.Reserve(ctx, "stale", "")
+			if err == nil && d.Status.Token == 1 {
+				t.Errorf("want new token on take over")
+			}
+			if ferr := s.Finish(ctx, "stale", nil); ferr != nil {
+				return d, ferr
+			}
+			return d, err
+		}, wantOutcome: OutcomeNew},
+	}
+
+	for _, step := range steps {
+		d, err := step.run()
+		if err != nil {
+			t.Fatalf("%s: want no error, got %v", step.name, err)
+		}
+		if d.Outcome != step.wantOutcome {
+			t.Errorf("%s: want outcome %v, got %v", step.name, step.wantOutcome, d.Outcome)
+		}
+		if got := locker.locked["key"]; got != step.wantLocked {
+			t.Errorf("%s: want key locked %v, got %v", step.name, step.wantLocked, got)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package idempotency
+
+import "net/http"
+
+// Logger is the logger used by the middleware, args are alternating keys and
+// values. It is implemented by *slog.Logger, and adapters for zap and logrus
+// are in adapters/zaplog and adapters/logruslog.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// WithLogger configures l to log the verified requests: errors at the error
+// level and other outcomes at the debug level.
+func WithLogger(l Logger) Option {
+	return func(s *State) {
+		s.logger = l
+	}
+}
+
+// log logs the event of a verified request.
+func (s *State) log(r *http.Request, e Event) {
+	args := []any{"method", r.Method, "path", r.URL.Path, "key", e.Key, "outcome", e.Outcome.String()}
+	if e.Shadow {
+		args = append(args, "shadow", true)
+	}
+	if e.Err != nil {
+		args = append(args, "error", e.Err)
+	}
+
+	if e.Outcome == OutcomeError {
+		s.logger.Error("idempotency: could not verify request", args...)
+		return
+	}
+	s.logger.Debug("idempotency: verified request", args...)
+}
@@ -0,0 +1,39 @@
+package idempotency
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	var fingerprintErr error
+	s := New(NewMemoryStorage(), WithLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+		WithFingerprint(func(r *http.Request) (string, error) { return "", fingerprintErr }))
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func() {
+		req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+		req.Header.Set(HeaderName, "key")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	serve()
+	fingerprintErr = errors.New("unreadable")
+	serve()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("want 2 lines, got %q", buf.String())
+	}
+	if !strings.Contains(lines[0], "level=DEBUG") || !strings.Contains(lines[0], "outcome=new") {
+		t.Errorf("want new request logged at debug, got %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "level=ERROR") || !strings.Contains(lines[1], "unreadable") {
+		t.Errorf("want error logged, got %q", lines[1])
+	}
+}
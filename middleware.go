@@ -0,0 +1,57 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+)
+
+// VerifyFunc is Verify for a handler function.
+func (s *State) VerifyFunc(next http.HandlerFunc) http.HandlerFunc {
+	return s.Verify(next).ServeHTTP
+}
+
+// Middleware returns Verify as a constructor for middleware chains, e.g.
+// alice.New(s.Middleware()) or chi's Use.
+//
+// The middleware should be placed after authentication, so that WithOwner
+// and WithScope see the client, and outside of panic recovery. A recovery
+// middleware between this one and the handler turns panics into responses
+// which are stored and replayed, while a panic passing through the
+// middleware releases the key so that the request can be retried.
+func (s *State) Middleware() func(http.Handler) http.Handler {
+	return s.Verify
+}
+
+// ServeHTTP makes s a negroni.Handler, verifying the request before calling
+// next. The handler is built for every request, so states with WithPattern
+// or WithContentType are cheaper to use with Middleware.
+func (s *State) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	s.Verify(next).ServeHTTP(w, r)
+}
+
+// WithKeyForwarding configures whether the Idempotency-Key header is passed
+// on to the handler once the key is reserved, it defaults to true. Stripping
+// the header prevents a proxied idempotency-aware service from processing
+// the key again, the handler still gets the key with FromContext.
+func WithKeyForwarding(forward bool) Option {
+	return func(s *State) {
+		s.stripKey = !forward
+	}
+}
+
+// serveNext calls next for the reserved key. A panic releases the key and is
+// passed on, e.g. to a recovery middleware wrapping this one.
+func (s *State) serveNext(ctx context.Context, key string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	if s.stripKey {
+		r = r.Clone(r.Context())
+		r.Header.Del(HeaderName)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			s.Fail(context.WithoutCancel(ctx), key)
+			panic(p)
+		}
+	}()
+	next.ServeHTTP(w, r)
+}
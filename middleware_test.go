@@ -0,0 +1,92 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	s := New(NewMemoryStorage())
+
+	calls := 0
+	handlers := []struct {
+		name    string
+		handler http.Handler
+	}{
+		{name: "VerifyFunc", handler: s.VerifyFunc(func(w http.ResponseWriter, r *http.Request) { calls++ })},
+		{name: "Middleware", handler: s.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { calls++ }))},
+		{name: "Negroni", handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.ServeHTTP(w, r, func(w http.ResponseWriter, r *http.Request) { calls++ })
+		})},
+	}
+
+	for _, h := range handlers {
+		t.Run(h.name, func(t *testing.T) {
+			calls = 0
+			for range 2 {
+				req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+				req.Header.Set(HeaderName, h.name)
+				h.handler.ServeHTTP(httptest.NewRecorder(), req)
+			}
+			if calls != 1 {
+				t.Errorf("want 1 call, got %d", calls)
+			}
+		})
+	}
+}
+
+func TestPanicReleasesKey(t *testing.T) {
+	s := New(NewMemoryStorage())
+	panics := true
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if panics {
+			panic("failure")
+		}
+	}))
+	serve := func() (w *httptest.ResponseRecorder, p any) {
+		defer func() { p = recover() }()
+		req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+		req.Header.Set(HeaderName, "key")
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w, nil
+	}
+
+	if _, p := serve(); p != "failure" {
+		t.Fatalf("want panic passed on, got %v", p)
+	}
+	panics = false
+	if w, _ := serve(); w.Code != http.StatusOK {
+		t.Errorf("want key released after panic, got %d", w.Code)
+	}
+}
+
+func TestWithKeyForwarding(t *testing.T) {
+	tests := []struct {
+		name    string
+		forward bool
+		want    string
+	}{
+		{name: "Forwarded", forward: true, want: "key"},
+		{name: "Stripped", forward: false, want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var header, key string
+			handler := New(NewMemoryStorage(), WithKeyForwarding(test.forward)).Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header.Get(HeaderName)
+				key, _ = FromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+			req.Header.Set(HeaderName, "key")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if header != test.want || key != "key" {
+				t.Errorf("want header %q and key in context, got %q and %q", test.want, header, key)
+			}
+		})
+	}
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS {{table}} (
+	idempotency_key VARCHAR(255) PRIMARY KEY,
+	status TEXT NOT NULL,
+	owner VARCHAR(255) NOT NULL DEFAULT '',
+	expires_at BIGINT NOT NULL
+);
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS {{table}}_owner ON {{table}} (owner);
@@ -0,0 +1,6 @@
+IF OBJECT_ID(N'{{table}}', N'U') IS NULL CREATE TABLE {{table}} (
+	idempotency_key NVARCHAR(255) NOT NULL PRIMARY KEY,
+	status NVARCHAR(MAX) NOT NULL,
+	owner NVARCHAR(255) NOT NULL DEFAULT '',
+	expires_at BIGINT NOT NULL
+);
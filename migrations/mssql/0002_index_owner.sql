@@ -0,0 +1,2 @@
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'{{table}}_owner' AND object_id = OBJECT_ID(N'{{table}}'))
+	CREATE INDEX {{table}}_owner ON {{table}} (owner);
@@ -0,0 +1,2 @@
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = N'{{table}}_expires_at' AND object_id = OBJECT_ID(N'{{table}}'))
+	CREATE INDEX {{table}}_expires_at ON {{table}} (expires_at);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS {{table}} (
+	idempotency_key VARCHAR(255) NOT NULL PRIMARY KEY,
+	status LONGTEXT NOT NULL,
+	owner VARCHAR(255) NOT NULL DEFAULT '',
+	expires_at BIGINT NOT NULL
+);
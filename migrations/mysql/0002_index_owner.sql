@@ -0,0 +1 @@
+CREATE INDEX {{table}}_owner ON {{table}} (owner);
@@ -0,0 +1 @@
+CREATE INDEX {{table}}_expires_at ON {{table}} (expires_at);
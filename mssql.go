@@ -0,0 +1,28 @@
+package idempotency
+
+import (
+	"database/sql"
+	"time"
+)
+
+// sqlServerDeadlock is the error number of SQL Server for a transaction that
+// was chosen as the victim of a deadlock.
+const sqlServerDeadlock = 1205
+
+// NewMSSQLStorage creates a SQL storage for Microsoft SQL Server, with the
+// schema of NewSQLStorage in SQL Server types and MSSQLDialect, e.g. for the
+// sqlserver driver of go-mssqldb. Statements are retried when they are
+// chosen as deadlock victims, see WithTxRetries.
+//
+// SQL Server does not remove expired keys, run Cleanup or RunCleanup
+// periodically.
+func NewMSSQLStorage(db *sql.DB, expiry time.Duration, opts ...SQLStorageOption) *sqlStorage {
+	opts = append([]SQLStorageOption{WithDialect(MSSQLDialect), WithTxRetries(defaultTxRetries)}, opts...)
+	return NewSQLStorage(db, expiry, opts...)
+}
+
+// sqlErrorNumberError is implemented by the errors of SQL Server drivers,
+// e.g. go-mssqldb.
+type sqlErrorNumberError interface {
+	SQLErrorNumber() int32
+}
@@ -0,0 +1,125 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+type namespacedStorage struct {
+	forwardingStorage
+	prefix string
+}
+
+// NamespaceStorage returns a decorator storing every key, including the
+// counters of rate limits and attempts, below namespace, e.g. the name and
+// environment of a service, which are joined with colons. Services sharing a
+// storage, e.g. one Redis, do not see each other's keys. For example
+//
+//	storage := idempotency.ChainStorage(idempotency.NewRedisStorage(client, ttl),
+//		idempotency.NamespaceStorage("orders", "production"),
+//	)
+//
+// stores the key k as "orders:production:k". Scan only returns the keys of
+// the namespace, without it, and DeleteByOwner only deletes them, which
+// requires a storage implementing Scanner and Deleter. Purge removes the
+// expired keys of all namespaces.
+func NamespaceStorage(namespace ...string) StorageDecorator {
+	prefix := strings.Join(namespace, ":") + ":"
+	return func(next StatusStorage) StatusStorage {
+		return &namespacedStorage{forwardingStorage: forwardingStorage{next: next}, prefix: prefix}
+	}
+}
+
+func (s *namespacedStorage) Add(ctx context.Context, key string) (bool, error) {
+	return s.forwardingStorage.Add(ctx, s.prefix+key)
+}
+
+func (s *namespacedStorage) AddStatus(ctx context.Context, key string, status *RequestStatus, expiry time.Duration) (bool, error) {
+	return s.forwardingStorage.AddStatus(ctx, s.prefix+key, status, expiry)
+}
+
+func (s *namespacedStorage) AddOrGet(ctx context.Context, key string, status *RequestStatus, expiry time.Duration) (*RequestStatus, bool, error) {
+	return s.forwardingStorage.AddOrGet(ctx, s.prefix+key, status, expiry)
+}
+
+func (s *namespacedStorage) Get(ctx context.Context, key string) (*RequestStatus, error) {
+	return s.forwardingStorage.Get(ctx, s.prefix+key)
+}
+
+func (s *namespacedStorage) Complete(ctx context.Context, key string) error {
+	return s.forwardingStorage.Complete(ctx, s.prefix+key)
+}
+
+func (s *namespacedStorage) UpdateStatus(ctx context.Context, key string, status *RequestStatus) error {
+	return s.forwardingStorage.UpdateStatus(ctx, s.prefix+key, status)
+}
+
+func (s *namespacedStorage) UpdateStatuses(ctx context.Context, statuses map[string]*RequestStatus) error {
+	prefixed := make(map[string]*RequestStatus, len(statuses))
+	for key, status := range statuses {
+		prefixed[s.prefix+key] = status
+	}
+	return s.forwardingStorage.UpdateStatuses(ctx, prefixed)
+}
+
+func (s *namespacedStorage) Delete(ctx context.Context, key string) error {
+	return s.forwardingStorage.Delete(ctx, s.prefix+key)
+}
+
+func (s *namespacedStorage) Expire(ctx context.Context, key string, expiry time.Duration) error {
+	return s.forwardingStorage.Expire(ctx, s.prefix+key, expiry)
+}
+
+func (s *namespacedStorage) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return s.forwardingStorage.Increment(ctx, s.prefix+key, window)
+}
+
+func (s *namespacedStorage) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	return s.forwardingStorage.TTL(ctx, s.prefix+key)
+}
+
+func (s *namespacedStorage) Scan(ctx context.Context, fn func(key string, status *RequestStatus) error) error {
+	return s.forwardingStorage.Scan(ctx, func(key string, status *RequestStatus) error {
+		key, ok := strings.CutPrefix(key, s.prefix)
+		if !ok {
+			return nil
+		}
+		return fn(key, status)
+	})
+}
+
+// DeleteByOwner deletes the keys of owner in the namespace, as the wrapped
+// storage would delete the keys of all namespaces.
+func (s *namespacedStorage) DeleteByOwner(ctx context.Context, owner string) (int, error) {
+	caps := StorageCapabilities(s.next)
+	if !caps.Scanner || !caps.Deleter {
+		return 0, errors.ErrUnsupported
+	}
+
+	var keys []string
+	err := s.Scan(ctx, func(key string, status *RequestStatus) error {
+		if status.Owner == owner {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i, key := range keys {
+		if err := s.Delete(ctx, key); err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
+
+// Capabilities returns the capabilities of the wrapped storage, deleting the
+// keys of an owner requires scanning and deleting keys.
+func (s *namespacedStorage) Capabilities() Capabilities {
+	c := StorageCapabilities(s.next)
+	c.OwnerDeleter = c.Scanner && c.Deleter
+	return c
+}
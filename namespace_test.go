@@ -0,0 +1,42 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNamespaceStorage(t *testing.T) {
+	ctx := context.Background()
+	base := NewMemoryStorage()
+	orders := ChainStorage(base, NamespaceStorage("orders", "production"))
+	payments := ChainStorage(base, NamespaceStorage("payments", "production"))
+
+	for _, storage := range []StatusStorage{orders, payments} {
+		if added, err := storage.AddStatus(ctx, "key", &RequestStatus{Owner: "alice"}, time.Hour); err != nil || !added {
+			t.Fatalf("want key added per namespace, got %v, %v", added, err)
+		}
+	}
+	if status, _ := base.Get(ctx, "orders:production:key"); status == nil {
+		t.Error("want key stored below the namespace")
+	}
+
+	var keys []string
+	orders.(Scanner).Scan(ctx, func(key string, status *RequestStatus) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if len(keys) != 1 || keys[0] != "key" {
+		t.Errorf("want only the key of the namespace, got %q", keys)
+	}
+
+	if !StorageCapabilities(orders).OwnerDeleter {
+		t.Fatal("want owner deletion by scanning")
+	}
+	if n, err := orders.(OwnerDeleter).DeleteByOwner(ctx, "alice"); err != nil || n != 1 {
+		t.Errorf("want 1 key of owner deleted, got %d, %v", n, err)
+	}
+	if status, _ := payments.Get(ctx, "key"); status == nil {
+		t.Error("want keys of other namespaces kept")
+	}
+}
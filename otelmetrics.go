@@ -0,0 +1,54 @@
+package idempotency
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// meterName is the instrumentation scope of the OpenTelemetry metrics.
+const meterName = "github.com/Preciselyco/idempotency"
+
+// WithMeterProvider records the metrics of the middleware with the
+// OpenTelemetry metrics API: the requests per outcome as
+// idempotency.requests and the overhead of the middleware as the histogram
+// idempotency.overhead, with the bounds of DefaultLatencyBuckets. Requests
+// routed by a ServeMux are labeled with their pattern as http.route.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	meter := mp.Meter(meterName)
+
+	requests, err := meter.Int64Counter("idempotency.requests",
+		metric.WithDescription("Requests verified by the idempotency middleware."),
+		metric.WithUnit("{request}"))
+	if err != nil {
+		otel.Handle(err)
+	}
+
+	bounds := make([]float64, len(DefaultLatencyBuckets))
+	for i, b := range DefaultLatencyBuckets {
+		bounds[i] = b.Seconds()
+	}
+	overhead, err := meter.Float64Histogram("idempotency.overhead",
+		metric.WithDescription("Latency added by the idempotency middleware."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(bounds...))
+	if err != nil {
+		otel.Handle(err)
+	}
+
+	return WithHook(func(r *http.Request, e Event) {
+		attrs := []attribute.KeyValue{
+			attribute.String("outcome", e.Outcome.String()),
+			attribute.Bool("shadow", e.Shadow),
+		}
+		if r.Pattern != "" {
+			attrs = append(attrs, attribute.String("http.route", r.Pattern))
+		}
+		set := metric.WithAttributes(attrs...)
+
+		requests.Add(r.Context(), 1, set)
+		overhead.Record(r.Context(), e.Overhead.Seconds(), set)
+	})
+}
@@ -0,0 +1,52 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestWithMeterProvider(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	s := New(NewMemoryStorage(), WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))))
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /orders", s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	for range 2 {
+		req := httptest.NewRequest("POST", "http://example.com/orders", nil)
+		req.Header.Set(HeaderName, "key")
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	if len(rm.ScopeMetrics) != 1 {
+		t.Fatalf("want 1 scope, got %d", len(rm.ScopeMetrics))
+	}
+
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		switch data := m.Data.(type) {
+		case metricdata.Sum[int64]:
+			if len(data.DataPoints) != 2 {
+				t.Errorf("want requests counted per outcome, got %+v", data.DataPoints)
+			}
+			for _, dp := range data.DataPoints {
+				if route, _ := dp.Attributes.Value("http.route"); route.AsString() != "POST /orders" || dp.Value != 1 {
+					t.Errorf("want 1 request of the route, got %+v", dp)
+				}
+			}
+		case metricdata.Histogram[float64]:
+			if len(data.DataPoints) != 2 || data.DataPoints[0].Count != 1 {
+				t.Errorf("want overhead recorded per outcome, got %+v", data.DataPoints)
+			}
+		default:
+			t.Errorf("unexpected metric %s", m.Name)
+		}
+	}
+}
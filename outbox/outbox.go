@@ -0,0 +1,229 @@
+// Package outbox implements the transactional outbox pattern on top of the
+// SQL storage, so that work is processed once and its events are published
+// once.
+//
+// Completing an idempotency key and enqueueing the events of the work happen
+// in the same transaction, and a Relay publishes the enqueued events
+// afterwards. Events are published at least once, they carry an ID derived
+// from the idempotency key so that consumers can deduplicate them.
+//
+// The outbox is stored in a table with the following schema:
+//
+//	CREATE TABLE idempotency_outbox (
+//		id VARCHAR(255) PRIMARY KEY,
+//		topic VARCHAR(255) NOT NULL,
+//		payload BLOB NOT NULL,
+//		created_at BIGINT NOT NULL
+//	);
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// Message is an event enqueued in the outbox.
+type Message struct {
+	// ID identifies the message, it defaults to the idempotency key and the
+	// index of the message when completing a key.
+	ID      string
+	Topic   string
+	Payload []byte
+}
+
+// Option is the functional option signature for configuring the Outbox.
+type Option func(*Outbox)
+
+// Outbox stores messages to be published in a SQL table.
+type Outbox struct {
+	db          *sql.DB
+	table       string
+	placeholder idempotency.Placeholder
+	dialect     idempotency.Dialect
+}
+
+// WithTableName configures the table of the outbox, it defaults to
+// idempotency_outbox.
+func WithTableName(table string) Option {
+	return func(o *Outbox) {
+		o.table = table
+	}
+}
+
+// WithPlaceholder configures the style of query parameters, it defaults to
+// idempotency.QuestionPlaceholder.
+func WithPlaceholder(p idempotency.Placeholder) Option {
+	return func(o *Outbox) {
+		o.placeholder = p
+	}
+}
+
+// WithDialect configures the SQL of the database, like the option of the
+// same name of the SQL storage, it defaults to idempotency.SQLiteDialect. It
+// also configures the placeholder style of the dialect, so WithPlaceholder
+// must follow it to override the style.
+func WithDialect(d idempotency.Dialect) Option {
+	return func(o *Outbox) {
+		o.dialect = d
+		o.placeholder = d.Placeholder()
+	}
+}
+
+// New creates an Outbox stored in db.
+func New(db *sql.DB, opts ...Option) *Outbox {
+	o := &Outbox{
+		db:      db,
+		table:   "idempotency_outbox",
+		dialect: idempotency.SQLiteDialect,
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(o)
+		}
+	}
+
+	return o
+}
+
+// query replaces the ? parameters of query with the placeholder style of the
+// outbox.
+func (o *Outbox) query(query string) string {
+	var prefix string
+	switch o.placeholder {
+	case idempotency.DollarPlaceholder:
+		prefix = "$"
+	case idempotency.AtPlaceholder:
+		prefix = "@p"
+	default:
+		return query
+	}
+
+	b := make([]byte, 0, len(query)+8)
+	n := 0
+	for i := 0; i < len(query); i++ {
+		if query[i] != '?' {
+			b = append(b, query[i])
+			continue
+		}
+		n++
+		b = append(b, prefix...)
+		b = strconv.AppendInt(b, int64(n), 10)
+	}
+	return string(b)
+}
+
+// Enqueue inserts msgs in the outbox within tx. Messages without an ID are
+// rejected.
+func (o *Outbox) Enqueue(ctx context.Context, tx *sql.Tx, msgs ...Message) error {
+	now := time.Now().UnixMilli()
+	for _, msg := range msgs {
+		if msg.ID == "" {
+			return errors.New("outbox: no message ID set")
+		}
+
+		_, err := tx.ExecContext(ctx, o.query("INSERT INTO "+o.table+" (id, topic, payload, created_at) VALUES (?, ?, ?, ?)"),
+			msg.ID, msg.Topic, msg.Payload, now)
+		if err != nil {
+			return fmt.Errorf("outbox: failed to enqueue message %q: %w", msg.ID, err)
+		}
+	}
+	return nil
+}
+
+// Complete finishes the reserved key with resp and enqueues msgs within tx,
+// so that both are committed or rolled back together. The State must use the
+// SQL storage of the same database. Messages without an ID get the key and
+// their index as ID. ctx should be created with idempotency.DeferTxEffects,
+// whose done function is called once tx is committed or rolled back, so that
+// the completion is not observed by waiting requests and transition hooks
+// before it is committed.
+func (o *Outbox) Complete(ctx context.Context, tx *sql.Tx, s *idempotency.State, key string, resp *idempotency.Response, msgs ...Message) error {
+	ctx = idempotency.NewTxContext(ctx, tx)
+
+	if err := s.Finish(ctx, key, resp); err != nil {
+		return err
+	}
+
+	for i := range msgs {
+		if msgs[i].ID == "" {
+			msgs[i].ID = key + ":" + strconv.Itoa(i)
+		}
+	}
+	return o.Enqueue(ctx, tx, msgs...)
+}
+
+// Relay publishes up to limit messages in the order they were enqueued,
+// removing each message once it is published. It returns the number of
+// published messages, stopping at the first error of publish.
+func (o *Outbox) Relay(ctx context.Context, limit int, publish func(ctx context.Context, msg Message) error) (int, error) {
+	query, args := o.dialect.SelectFirst(o.table, "id, topic, payload", "created_at, id", limit)
+	rows, err := o.db.QueryContext(ctx, o.query(query), args...)
+	if err != nil {
+		return 0, fmt.Errorf("outbox: failed to read messages: %w", err)
+	}
+
+	var msgs []Message
+	for rows.Next() {
+		var msg Message
+		if err := rows.Scan(&msg.ID, &msg.Topic, &msg.Payload); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("outbox: failed to read messages: %w", err)
+		}
+		msgs = append(msgs, msg)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("outbox: failed to read messages: %w", err)
+	}
+
+	for i, msg := range msgs {
+		if err := publish(ctx, msg); err != nil {
+			return i, fmt.Errorf("outbox: failed to publish message %q: %w", msg.ID, err)
+		}
+
+		_, err := o.db.ExecContext(ctx, o.query("DELETE FROM "+o.table+" WHERE id = ?"), msg.ID)
+		if err != nil {
+			return i, fmt.Errorf("outbox: failed to remove message %q: %w", msg.ID, err)
+		}
+	}
+	return len(msgs), nil
+}
+
+// Run relays messages every interval until ctx is done, see Relay. Errors
+// are passed to onError, which may be nil, and retried on the next interval.
+func (o *Outbox) Run(ctx context.Context, interval time.Duration, publish func(ctx context.Context, msg Message) error, onError func(err error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Drain the outbox before waiting for the next interval.
+		for {
+			n, err := o.Relay(ctx, 100, publish)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if onError != nil {
+					onError(err)
+				}
+				break
+			}
+			if n < 100 {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// Every connection to :memory: opens a different database.
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE idempotency_keys (
+		idempotency_key VARCHAR(255) PRIMARY KEY,
+		status TEXT NOT NULL,
+		owner VARCHAR(255) NOT NULL DEFAULT '',
+		expires_at BIGINT NOT NULL
+	);
+	CREATE TABLE idempotency_outbox (
+		id VARCHAR(255) PRIMARY KEY,
+		topic VARCHAR(255) NOT NULL,
+		payload BLOB NOT NULL,
+		created_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var completed []string
+	s := idempotency.New(idempotency.NewSQLStorage(db, time.Hour), idempotency.WithTransitionHook(func(ctx context.Context, tr idempotency.Transition) {
+		if tr.Kind == idempotency.TransitionCompleted {
+			completed = append(completed, tr.Key)
+		}
+	}))
+	o := New(db)
+
+	tests := []struct {
+		name          string
+		key           string
+		commit        bool
+		wantPublished []string
+	}{
+		{name: "Committed messages are published", key: "a", commit: true, wantPublished: []string{"a:0", "a:1"}},
+		{name: "Rolled back messages are not published", key: "b", commit: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := s.Reserve(ctx, test.key, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			completed = nil
+			txCtx, done := idempotency.DeferTxEffects(ctx)
+			err = o.Complete(txCtx, tx, s, test.key, nil,
+				Message{Topic: "orders", Payload: []byte("created")},
+				Message{Topic: "emails", Payload: []byte("send")},
+			)
+			if err != nil {
+				t.Fatalf("want no error, got %v", err)
+			}
+			if len(completed) != 0 {
+				t.Errorf("want completion observed after the transaction, got %v", completed)
+			}
+			if test.commit {
+				err = tx.Commit()
+			} else {
+				err = tx.Rollback()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			done(test.commit)
+			if got := len(completed) == 1; got != test.commit {
+				t.Errorf("want completion observed %v, got %v", test.commit, completed)
+			}
+
+			d, err := s.Check(ctx, test.key, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := map[bool]idempotency.Outcome{true: idempotency.OutcomeCompleted, false: idempotency.OutcomeInProcess}[test.commit]; d.Outcome != want {
+				t.Errorf("want outcome %v, got %v", want, d.Outcome)
+			}
+
+			var published []string
+			n, err := o.Relay(ctx, 10, func(ctx context.Context, msg Message) error {
+				published = append(published, msg.ID)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("want no error, got %v", err)
+			}
+			if n != len(test.wantPublished) || len(published) != len(test.wantPublished) {
+				t.Fatalf("want %v published, got %v", test.wantPublished, published)
+			}
+			for i := range published {
+				if published[i] != test.wantPublished[i] {
+					t.Errorf("want %v published, got %v", test.wantPublished, published)
+				}
+			}
+
+			// Published messages are removed from the outbox.
+			if n, _ := o.Relay(ctx, 10, func(ctx context.Context, msg Message) error { return nil }); n != 0 {
+				t.Errorf("want no messages left, got %d", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// OwnerDeleter is implemented by storages that index keys by the owner of
+// the request, see WithOwner, to be able to erase all data of an owner, e.g.
+// for a GDPR erasure request.
+type OwnerDeleter interface {
+	// DeleteByOwner removes all keys of owner and returns how many were
+	// removed.
+	DeleteByOwner(ctx context.Context, owner string) (int, error)
+}
+
+// ownerContextKey defines which key to use for the owner in context.Context.
+const ownerContextKey contextKey = "idempotency-owner"
+
+// NewOwnerContext returns a new Context that carries owner. Keys reserved
+// with the returned context are stored with owner, so that they can be
+// removed with DeleteByOwner.
+func NewOwnerContext(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, ownerContextKey, owner)
+}
+
+// ownerFromContext returns the owner stored in ctx, if any.
+func ownerFromContext(ctx context.Context) string {
+	owner, _ := ctx.Value(ownerContextKey).(string)
+	return owner
+}
+
+// WithOwner configures a function returning the owner of a request, e.g. the
+// user or tenant ID. Keys are stored with their owner so that they can be
+// removed with DeleteByOwner. It requires a storage implementing
+// StatusStorage.
+func WithOwner(f func(r *http.Request) string) Option {
+	return func(s *State) {
+		s.owner = f
+	}
+}
+
+// DeleteByOwner removes all keys and stored responses of owner. It requires a
+// storage implementing OwnerDeleter.
+func (s *State) DeleteByOwner(ctx context.Context, owner string) (int, error) {
+	d, ok := s.storage.(OwnerDeleter)
+	if !ok || !s.caps.OwnerDeleter {
+		return 0, errors.New("storage does not support deleting keys by owner")
+	}
+	if owner == "" {
+		return 0, errors.New("no owner set")
+	}
+
+	n, err := d.DeleteByOwner(ctx, owner)
+	if err != nil {
+		return n, fmt.Errorf("could not delete Idempotency-Keys of owner: %w", err)
+	}
+	return n, nil
+}
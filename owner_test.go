@@ -0,0 +1,43 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDeleteByOwner(t *testing.T) {
+	storage := NewMemoryStorage()
+	s := New(storage, WithOwner(func(r *http.Request) string {
+		return r.Header.Get("X-User")
+	}))
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, req := range []struct{ user, key string }{{"a", "1"}, {"a", "2"}, {"b", "3"}} {
+		r := httptest.NewRequest("POST", "http://example.com/foo", nil)
+		r.Header.Set(HeaderName, req.key)
+		r.Header.Set("X-User", req.user)
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	status, _ := storage.Get(context.Background(), "1")
+	if status == nil || status.Owner != "a" {
+		t.Fatalf("want owner a kept after completion, got %+v", status)
+	}
+
+	n, err := s.DeleteByOwner(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	if n != 2 {
+		t.Errorf("want 2 keys deleted, got %d", n)
+	}
+
+	for key, want := range map[string]bool{"1": false, "2": false, "3": true} {
+		status, _ := storage.Get(context.Background(), key)
+		if got := status != nil; got != want {
+			t.Errorf("want key %s stored %v, got %v", key, want, got)
+		}
+	}
+}
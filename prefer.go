@@ -0,0 +1,82 @@
+package idempotency
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WithPreferWait makes requests for a key in process wait until the key is
+// completed and replay its result, when they send a Prefer: wait=N header
+// (RFC 7240). Requests wait for up to N seconds and at most max, they are
+// answered with 409 Conflict when the key is still in process then. Like
+// WithFanOut, it requires WithResponseCapture.
+func WithPreferWait(max time.Duration) Option {
+	return func(s *State) {
+		s.maxPreferWait = max
+	}
+}
+
+// WithPreferAsync answers requests for a key in process with 202 Accepted
+// instead of 409 Conflict when they send a Prefer: respond-async header (RFC
+// 7240). The Location header of the response is set to the URL returned by
+// location for the Idempotency-Key of the request, where the client polls the
+// status of the key, e.g. an endpoint of the application using Check. The key
+// is passed as sent, including its quotes, and must be escaped.
+func WithPreferAsync(location func(r *http.Request, key string) string) Option {
+	return func(s *State) {
+		s.preferAsync = location
+	}
+}
+
+// preferences returns the preferences of the Prefer headers of r by their
+// lower case names, with their values unquoted. Parameters of preferences
+// are ignored.
+func preferences(r *http.Request) map[string]string {
+	prefs := make(map[string]string)
+	for _, header := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(header, ",") {
+			pref, _, _ = strings.Cut(pref, ";")
+			name, value, _ := strings.Cut(pref, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if _, ok := prefs[name]; !ok {
+				prefs[name] = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+	}
+	return prefs
+}
+
+// preferredWait returns how long r prefers to wait, bounded by the maximum
+// of WithPreferWait.
+func (s *State) preferredWait(r *http.Request) time.Duration {
+	if s.maxPreferWait <= 0 {
+		return 0
+	}
+	seconds, err := strconv.Atoi(preferences(r)["wait"])
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return min(time.Duration(seconds)*time.Second, s.maxPreferWait)
+}
+
+// prefersAsync reports whether r prefers an asynchronous response.
+func prefersAsync(r *http.Request) bool {
+	_, ok := preferences(r)["respond-async"]
+	return ok
+}
+
+// respondAsync responds that the request for key, which is in process, is
+// accepted and its status can be polled at the Location, after delay.
+func (s *State) respondAsync(w http.ResponseWriter, r *http.Request, key string, delay time.Duration) {
+	if location := s.preferAsync(r, key); location != "" {
+		w.Header().Set("Location", location)
+	}
+	w.Header().Set("Preference-Applied", "respond-async")
+	setRetryAfter(w, delay)
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,116 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPreferences(t *testing.T) {
+	r := httptest.NewRequest("POST", "http://example.com/foo", nil)
+	r.Header.Add("Prefer", `Wait=5, return=minimal;foo="bar"`)
+	r.Header.Add("Prefer", `respond-async, wait="10"`)
+
+	prefs := preferences(r)
+	if prefs["wait"] != "5" || prefs["return"] != "minimal" {
+		t.Errorf("want first wait and return preferences, got %v", prefs)
+	}
+	if _, ok := prefs["respond-async"]; !ok {
+		t.Errorf("want respond-async preference, got %v", prefs)
+	}
+}
+
+func TestPreferWait(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefer   string
+		maxWait  time.Duration
+		delay    time.Duration
+		wantCode int
+	}{
+		{name: "Replays once completed", prefer: "wait=5", maxWait: time.Minute, delay: 50 * time.Millisecond, wantCode: http.StatusCreated},
+		{name: "Conflict without preference", maxWait: time.Minute, delay: 50 * time.Millisecond, wantCode: http.StatusConflict},
+		{name: "Conflict when not enabled", prefer: "wait=5", delay: 50 * time.Millisecond, wantCode: http.StatusConflict},
+		{name: "Conflict after the maximum wait", prefer: "wait=5", maxWait: 100 * time.Millisecond, delay: time.Second, wantCode: http.StatusConflict},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := New(NewMemoryStorage(), WithResponseCapture(true), WithPreferWait(test.maxWait))
+			started := make(chan struct{})
+			handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				time.Sleep(test.delay)
+				w.WriteHeader(http.StatusCreated)
+			}))
+
+			go func() {
+				req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+				req.Header.Set(HeaderName, `"key"`)
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}()
+			<-started
+
+			req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+			req.Header.Set(HeaderName, `"key"`)
+			if test.prefer != "" {
+				req.Header.Set("Prefer", test.prefer)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != test.wantCode {
+				t.Errorf("want status code %v, got %v", test.wantCode, w.Code)
+			}
+		})
+	}
+}
+
+func TestPreferAsync(t *testing.T) {
+	tests := []struct {
+		name         string
+		prefer       string
+		wantCode     int
+		wantLocation string
+	}{
+		{name: "Accepted with a status location", prefer: "respond-async", wantCode: http.StatusAccepted, wantLocation: "/status/%22key%22"},
+		{name: "Conflict without preference", wantCode: http.StatusConflict},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := New(NewMemoryStorage(), WithPreferAsync(func(r *http.Request, key string) string {
+				return "/status/" + url.PathEscape(key)
+			}))
+			inner := httptest.NewRecorder()
+			var handler http.Handler
+			handler = s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("X-Inner") != "" {
+					return
+				}
+
+				// Repeat the request while it is in process.
+				req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+				req.Header.Set(HeaderName, `"key"`)
+				req.Header.Set("X-Inner", "true")
+				if test.prefer != "" {
+					req.Header.Set("Prefer", test.prefer)
+				}
+				handler.ServeHTTP(inner, req)
+			}))
+
+			req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+			req.Header.Set(HeaderName, `"key"`)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if inner.Code != test.wantCode {
+				t.Fatalf("want status code %v, got %v", test.wantCode, inner.Code)
+			}
+			if got := inner.Header().Get("Location"); got != test.wantLocation {
+				t.Errorf("want location %q, got %q", test.wantLocation, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package idempotency
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Profile selects which version of the Idempotency-Key draft the middleware
+// complies with.
+type Profile int
+
+const (
+	// Draft00 follows draft-ietf-httpapi-idempotency-key-header-00, the key
+	// is the raw header value and errors are plain text. It is the default.
+	Draft00 Profile = iota
+	// Draft06 follows draft-ietf-httpapi-idempotency-key-header-06, the key
+	// is a Structured Field String, keys are scoped per method and path
+	// unless WithScope is used, and errors are Problem Details (RFC 9457).
+	Draft06
+)
+
+// Latest is the most recent draft supported.
+const Latest = Draft06
+
+// WithProfile configures the draft the middleware complies with. The error
+// responder of the profile is replaced by options passed after it to New.
+func WithProfile(p Profile) Option {
+	return func(s *State) {
+		s.profile = p
+		if p >= Draft06 {
+			s.errResponder = s.problemResponder
+		}
+	}
+}
+
+// WithDocumentation configures a URL to documentation about the idempotency
+// of the API, which is linked from error responses as recommended by the
+// draft.
+func WithDocumentation(url string) Option {
+	return func(s *State) {
+		s.documentation = url
+	}
+}
+
+// parseKey returns the idempotency key from the raw header value.
+func (s *State) parseKey(raw string) (string, error) {
+	key := raw
+	if s.profile >= Draft06 && raw != "" {
+		var err error
+		if key, err = parseSFString(raw); err != nil {
+			return "", err
+		}
+	}
+	if reservedKey(key) {
+		return "", errReservedKey
+	}
+	return key, nil
+}
+
+// defaultScope scopes keys per method and path.
+func defaultScope(r *http.Request) string {
+	return r.Method + " " + r.URL.Path
+}
+
+// parseSFString parses a Structured Field String as defined in RFC 8941.
+func parseSFString(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if len(raw) < 2 || raw[0] != '"' || raw[len(raw)-1] != '"' {
+		return "", errors.New("Idempotency-Key must be a quoted string")
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(raw)-1; i++ {
+		c := raw[i]
+		switch {
+		case c == '\\':
+			i++
+			if i == len(raw)-1 || (raw[i] != '"' && raw[i] != '\\') {
+				return "", errors.New("Idempotency-Key has an invalid escape")
+			}
+			b.WriteByte(raw[i])
+		case c == '"':
+			return "", errors.New("Idempotency-Key has an unescaped quote")
+		case c < 0x20 || c > 0x7e:
+			return "", errors.New("Idempotency-Key has an invalid character")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// problemResponder responds with Problem Details as defined in RFC 9457.
+func (s *State) problemResponder(err error, status int, w http.ResponseWriter, r *http.Request) {
+	problem := struct {
+		Type   string `json:"type"`
+		Title  string `json:"title"`
+		Status int    `json:"status"`
+		Detail string `json:"detail"`
+		conflictDetails
+	}{
+		Type:            "about:blank",
+		Title:           http.StatusText(status),
+		Status:          status,
+		Detail:          err.Error(),
+		conflictDetails: details(err),
+	}
+	if s.documentation != "" {
+		problem.Type = s.documentation
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// respondError sets the documentation link and responds with the failure.
+func (s *State) respondError(f Failure, w http.ResponseWriter, r *http.Request) {
+	if s.documentation != "" {
+		w.Header().Add("Link", "<"+s.documentation+`>; rel="describedby"; type="text/html"`)
+	}
+	if s.failureResponder != nil {
+		s.failureResponder(f, w, r)
+		return
+	}
+	s.errResponder(f.Err, f.StatusCode, w, r)
+}
@@ -0,0 +1,76 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseSFString(t *testing.T) {
+	tests := []struct {
+		have    string
+		want    string
+		wantErr bool
+	}{
+		{have: `"8e03978e-40d5-43e8-bc93-6894a57f9324"`, want: "8e03978e-40d5-43e8-bc93-6894a57f9324"},
+		{have: `"a \"quoted\" \\ key"`, want: `a "quoted" \ key`},
+		{have: `8e03978e`, wantErr: true},
+		{have: `"unterminated`, wantErr: true},
+		{have: `"bad \escape"`, wantErr: true},
+		{have: `"a"b"`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := parseSFString(test.have)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: want error = %v, got %v", test.have, test.wantErr, err)
+		}
+		if got != test.want {
+			t.Errorf("%s: want %q, got %q", test.have, test.want, got)
+		}
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	tests := []struct {
+		name            string
+		profile         Profile
+		key             string
+		wantHTTPStatus  int
+		wantContentType string
+	}{
+		{name: "Draft00 uses the raw key", profile: Draft00, key: "deadbeef", wantHTTPStatus: http.StatusOK},
+		{name: "Draft00 responds with plain text", profile: Draft00, wantHTTPStatus: http.StatusBadRequest, wantContentType: "text/plain; charset=utf-8"},
+		{name: "Latest uses structured field keys", profile: Latest, key: `"deadbeef"`, wantHTTPStatus: http.StatusOK},
+		{name: "Latest rejects tokens", profile: Latest, key: "deadbeef", wantHTTPStatus: http.StatusBadRequest, wantContentType: "application/problem+json"},
+		{name: "Latest responds with problem details", profile: Latest, wantHTTPStatus: http.StatusBadRequest, wantContentType: "application/problem+json"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := New(NewMemoryStorage(), WithProfile(test.profile), WithDocumentation("https://developer.example.com/idempotency"))
+			handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+			if test.key != "" {
+				req.Header.Set(HeaderName, test.key)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != test.wantHTTPStatus {
+				t.Errorf("want status code %v, got %v", test.wantHTTPStatus, w.Code)
+			}
+			if test.wantContentType == "" {
+				return
+			}
+			if got := w.Header().Get("Content-Type"); got != test.wantContentType {
+				t.Errorf("want content type %v, got %v", test.wantContentType, got)
+			}
+			if want, got := `<https://developer.example.com/idempotency>; rel="describedby"; type="text/html"`, w.Header().Get("Link"); got != want {
+				t.Errorf("want link %v, got %v", want, got)
+			}
+		})
+	}
+}
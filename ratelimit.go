@@ -0,0 +1,66 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// rateLimit limits the number of new keys a client can create per window.
+type rateLimit struct {
+	limit  int64
+	window time.Duration
+	client func(r *http.Request) string
+}
+
+// WithKeyRateLimit limits how many new keys a client, as identified by the
+// client function, can create per window. Requests exceeding the limit get a
+// 429 Too Many Requests. Repeated requests with existing keys are not
+// limited, and requests for which client returns an empty string are not
+// limited. It requires a storage implementing Counter.
+func WithKeyRateLimit(limit int, window time.Duration, client func(r *http.Request) string) Option {
+	return func(s *State) {
+		s.rateLimit = &rateLimit{
+			limit:  int64(limit),
+			window: window,
+			client: client,
+		}
+	}
+}
+
+// allowNewKey reports whether the client of r may create key, counting the
+// key if it is new.
+func (s *State) allowNewKey(ctx context.Context, r *http.Request, key, fingerprint string) (bool, error) {
+	counter, ok := s.storage.(Counter)
+	if s.rateLimit == nil || !ok || !s.caps.Counter {
+		return true, nil
+	}
+
+	client := s.rateLimit.client(r)
+	if client == "" {
+		return true, nil
+	}
+
+	d, err := s.Check(ctx, key, fingerprint)
+	if err != nil || d.Outcome != OutcomeNew {
+		return true, err
+	}
+
+	count, err := counter.Increment(ctx, internalKey("ratelimit", client), s.rateLimit.window)
+	if err != nil {
+		return false, fmt.Errorf("could not count new Idempotency-Keys: %w", err)
+	}
+	return count <= s.rateLimit.limit, nil
+}
+
+// rateLimited responds to a request of a client that created too many keys.
+func (s *State) rateLimited(idempotencyKey string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	if !s.shadow {
+		secs := int(math.Ceil(s.rateLimit.window.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+	}
+	s.reject(Event{Key: idempotencyKey, Outcome: OutcomeRateLimited}, errRateLimited, http.StatusTooManyRequests, next, w, r)
+}
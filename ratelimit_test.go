@@ -0,0 +1,43 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestKeyRateLimit(t *testing.T) {
+	s := New(NewMemoryStorage(), WithKeyRateLimit(2, time.Minute, func(r *http.Request) string {
+		return r.Header.Get("X-Client")
+	}))
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name           string
+		client         string
+		key            string
+		wantHTTPStatus int
+	}{
+		{name: "First new key", client: "a", key: "1", wantHTTPStatus: http.StatusOK},
+		{name: "Second new key", client: "a", key: "2", wantHTTPStatus: http.StatusOK},
+		{name: "Third new key is limited", client: "a", key: "3", wantHTTPStatus: http.StatusTooManyRequests},
+		{name: "Existing key is not limited", client: "a", key: "1", wantHTTPStatus: http.StatusOK},
+		{name: "Other client is not limited", client: "b", key: "3", wantHTTPStatus: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+			req.Header.Set(HeaderName, test.key)
+			req.Header.Set("X-Client", test.client)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != test.wantHTTPStatus {
+				t.Errorf("want status code %v, got %v", test.wantHTTPStatus, w.Code)
+			}
+		})
+	}
+}
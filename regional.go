@@ -0,0 +1,306 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// errReplicationQueueFull is passed to the replication error handler when a
+// replication is dropped.
+var errReplicationQueueFull = errors.New("replication queue is full")
+
+type replication struct {
+	key    string
+	status *RequestStatus // nil for deletes
+	expiry time.Duration
+}
+
+type regionalStorage struct {
+	home     StatusStorage
+	local    StatusStorage
+	replicas []StatusStorage
+	onError  func(err error)
+
+	queue  chan replication
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// RegionalOption is the signature for functional options for the regional
+// storage.
+type RegionalOption func(*regionalStorage)
+
+// WithLocalReplica configures the store of the region of this instance.
+// Completed keys are read from it before the home store, and completions
+// are replicated to it.
+func WithLocalReplica(local StatusStorage) RegionalOption {
+	return func(s *regionalStorage) {
+		s.local = local
+	}
+}
+
+// WithReplicas configures the stores of other regions, to which completions
+// are replicated.
+func WithReplicas(replicas ...StatusStorage) RegionalOption {
+	return func(s *regionalStorage) {
+		s.replicas = append(s.replicas, replicas...)
+	}
+}
+
+// WithReplicationQueue configures how many replications are queued, it
+// defaults to 1000. Replications are dropped when the queue is full.
+func WithReplicationQueue(size int) RegionalOption {
+	return func(s *regionalStorage) {
+		s.queue = make(chan replication, size)
+	}
+}
+
+// WithReplicationError configures a function receiving the errors of
+// replications, which are not retried.
+func WithReplicationError(f func(err error)) RegionalOption {
+	return func(s *regionalStorage) {
+		s.onError = f
+	}
+}
+
+// NewRegionalStorage creates a storage for active-active deployments across
+// regions. Every region uses the same home store, in one designated home
+// region, for reservations, and replicates completions asynchronously to the
+// stores of the regions, so that replays are served from the local region.
+//
+// The conflict rules are:
+//   - Keys are only reserved in the home store, which decides which request
+//     is processed. Replicas never hold keys in process.
+//   - A completed key in the local replica is final and is read without
+//     asking the home store. Any other read goes to the home store.
+//   - A replicated completion does not overwrite a completion with a higher
+//     fencing token, so the latest reservation wins if a key was reserved
+//     again after it expired.
+//   - Deletes, e.g. of failed requests, are applied to the local replica
+//     with the home store and replicated to the other regions like
+//     completions.
+//
+// Replication is best effort and not retried. A lost completion only costs
+// a read of the home store, but a lost delete leaves the completion in the
+// replicas of the other regions, which replay it until the key expires
+// there. Close must be called on shutdown to drain the queue. The home store
+// must implement TTLReader for replicas to expire keys with it, otherwise
+// their default expiry is used. The storage implements Deleter,
+// Expirer, Counter and TTLReader with the home store.
+func NewRegionalStorage(home StatusStorage, opts ...RegionalOption) *regionalStorage {
+	s := &regionalStorage{
+		home:  home,
+		queue: make(chan replication, 1000),
+		done:  make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(s)
+		}
+	}
+
+	go s.run()
+
+	return s
+}
+
+// targets returns the stores completions are replicated to.
+func (s *regionalStorage) targets() []StatusStorage {
+	if s.local == nil {
+		return s.replicas
+	}
+	return append([]StatusStorage{s.local}, s.replicas...)
+}
+
+func (s *regionalStorage) run() {
+	defer close(s.done)
+
+	ctx := context.Background()
+	for r := range s.queue {
+		for _, target := range s.targets() {
+			if err := replicate(ctx, target, r); err != nil && s.onError != nil {
+				s.onError(fmt.Errorf("failed to replicate the key %q: %w", r.key, err))
+			}
+		}
+	}
+}
+
+// replicate applies r to target, following the conflict rules.
+func replicate(ctx context.Context, target StatusStorage, r replication) error {
+	if r.status == nil {
+		if d, ok := target.(Deleter); ok {
+			return d.Delete(ctx, r.key)
+		}
+		return nil
+	}
+
+	added, err := target.AddStatus(ctx, r.key, r.status, r.expiry)
+	if err != nil || added {
+		return err
+	}
+
+	existing, err := target.Get(ctx, r.key)
+	if err != nil {
+		return err
+	}
+	if existing != nil && !existing.InProcess && existing.Token > r.status.Token {
+		return nil
+	}
+	return target.UpdateStatus(ctx, r.key, r.status)
+}
+
+// enqueue queues a replication, dropping it if the queue is full.
+func (s *regionalStorage) enqueue(r replication) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return
+	}
+	select {
+	case s.queue <- r:
+	default:
+		if s.onError != nil {
+			s.onError(fmt.Errorf("failed to replicate the key %q: %w", r.key, errReplicationQueueFull))
+		}
+	}
+}
+
+// Close stops accepting replications and waits until the queued ones are
+// done, or until ctx is done.
+func (s *regionalStorage) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Add inserts the initial state of a request with an idempotency key in the
+// home store.
+func (s *regionalStorage) Add(ctx context.Context, key string) (bool, error) {
+	return s.home.Add(ctx, key)
+}
+
+// AddStatus inserts status for an idempotency key in the home store.
+func (s *regionalStorage) AddStatus(ctx context.Context, key string, status *RequestStatus, expiry time.Duration) (bool, error) {
+	return s.home.AddStatus(ctx, key, status, expiry)
+}
+
+// Get fetches the RequestStatus for an idempotency key, from the local
+// replica if it is completed there and from the home store otherwise.
+func (s *regionalStorage) Get(ctx context.Context, key string) (*RequestStatus, error) {
+	if s.local != nil {
+		// Failing replicas fall back to the home store.
+		status, err := s.local.Get(ctx, key)
+		if err == nil && status != nil && !status.InProcess {
+			return status, nil
+		}
+	}
+	return s.home.Get(ctx, key)
+}
+
+// Complete sets a request to not be in progress in the home store.
+func (s *regionalStorage) Complete(ctx context.Context, key string) error {
+	return s.UpdateStatus(ctx, key, &RequestStatus{InProcess: false})
+}
+
+// UpdateStatus replaces the RequestStatus of an idempotency key in the home
+// store, and replicates it if it is completed.
+func (s *regionalStorage) UpdateStatus(ctx context.Context, key string, status *RequestStatus) error {
+	if err := s.home.UpdateStatus(ctx, key, status); err != nil {
+		return err
+	}
+	if status.InProcess {
+		return nil
+	}
+
+	var expiry time.Duration
+	if t, ok := s.home.(TTLReader); ok {
+		ttl, exists, err := t.TTL(ctx, key)
+		if err != nil || !exists {
+			return nil
+		}
+		expiry = ttl
+	}
+
+	st := *status
+	s.enqueue(replication{key: key, status: &st, expiry: expiry})
+	return nil
+}
+
+// Delete removes an idempotency key from the home store and the local
+// replica, whose completions are read without asking the home store, and
+// replicates the removal. The removal is also queued for the local replica,
+// so that a completion queued before it does not bring the key back.
+func (s *regionalStorage) Delete(ctx context.Context, key string) error {
+	d, ok := s.home.(Deleter)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	if err := d.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	s.enqueue(replication{key: key})
+	if s.local != nil {
+		if err := replicate(ctx, s.local, replication{key: key}); err != nil {
+			return fmt.Errorf("failed to delete the key %q from the local replica: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// Expire sets an idempotency key to expire after expiry in the home store.
+func (s *regionalStorage) Expire(ctx context.Context, key string, expiry time.Duration) error {
+	e, ok := s.home.(Expirer)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return e.Expire(ctx, key, expiry)
+}
+
+// Increment increments the counter of key in the home store.
+func (s *regionalStorage) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	c, ok := s.home.(Counter)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	return c.Increment(ctx, key, window)
+}
+
+// TTL returns the remaining time until an idempotency key expires in the
+// home store.
+func (s *regionalStorage) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	t, ok := s.home.(TTLReader)
+	if !ok {
+		return 0, false, errors.ErrUnsupported
+	}
+	return t.TTL(ctx, key)
+}
+
+// Capabilities returns the capabilities of the home store that the regional
+// storage passes through.
+func (s *regionalStorage) Capabilities() Capabilities {
+	c := StorageCapabilities(s.home)
+	return Capabilities{
+		StatusStorage: true,
+		Deleter:       c.Deleter,
+		Expirer:       c.Expirer,
+		Counter:       c.Counter,
+		TTLReader:     c.TTLReader,
+	}
+}
@@ -0,0 +1,100 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRegionalStorage(t *testing.T) {
+	ctx := context.Background()
+	home, east, west := NewMemoryStorage(), NewMemoryStorage(), NewMemoryStorage()
+	eastStorage := NewRegionalStorage(home, WithLocalReplica(east), WithReplicas(west))
+	westStorage := NewRegionalStorage(home, WithLocalReplica(west), WithReplicas(east))
+
+	calls := 0
+	serve := func(storage Storage, key string) *httptest.ResponseRecorder {
+		handler := New(storage, WithResponseCapture(true)).Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Write([]byte("done"))
+		}))
+		req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+		req.Header.Set(HeaderName, key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// Reservations are made in the home store only.
+	reserved, err := eastStorage.AddStatus(ctx, "pending", &RequestStatus{InProcess: true}, time.Hour)
+	if err != nil || !reserved {
+		t.Fatalf("want reserved, got %v, %v", reserved, err)
+	}
+	if w := serve(westStorage, "pending"); w.Code != http.StatusConflict {
+		t.Errorf("want key in process in the home store, got %d", w.Code)
+	}
+	if status, _ := east.Get(ctx, "pending"); status != nil {
+		t.Errorf("want no reservation in the replica, got %+v", status)
+	}
+
+	// Completions are replicated to every region.
+	serve(eastStorage, "key")
+	if err := eastStorage.Close(ctx); err != nil {
+		t.Fatalf("want closed, got %v", err)
+	}
+	for name, replica := range map[string]StatusStorage{"east": east, "west": west} {
+		if status, _ := replica.Get(ctx, "key"); status == nil || status.InProcess || status.Response == nil {
+			t.Errorf("want completion replicated to %s, got %+v", name, status)
+		}
+	}
+	if w := serve(westStorage, "key"); w.Header().Get(ReplayedHeaderName) != "true" || calls != 1 {
+		t.Errorf("want replay in another region, got %d calls", calls)
+	}
+
+	// Replicated completions do not overwrite newer ones.
+	west.AddStatus(ctx, "newer", &RequestStatus{Token: 2}, time.Hour)
+	home.AddStatus(ctx, "newer", &RequestStatus{InProcess: true, Token: 1}, time.Hour)
+	westStorage.UpdateStatus(ctx, "newer", &RequestStatus{Token: 1})
+
+	// Deletes are replicated.
+	if err := westStorage.Delete(ctx, "key"); err != nil {
+		t.Fatalf("want deleted, got %v", err)
+	}
+	if err := westStorage.Close(ctx); err != nil {
+		t.Fatalf("want closed, got %v", err)
+	}
+	if status, _ := west.Get(ctx, "newer"); status == nil || status.Token != 2 {
+		t.Errorf("want newer completion kept, got %+v", status)
+	}
+	if status, _ := east.Get(ctx, "newer"); status == nil || status.Token != 1 {
+		t.Errorf("want completion replicated, got %+v", status)
+	}
+	for name, store := range map[string]StatusStorage{"home": home, "east": east, "west": west} {
+		if status, _ := store.Get(ctx, "key"); status != nil {
+			t.Errorf("want key deleted in %s, got %+v", name, status)
+		}
+	}
+}
+
+func TestRegionalStorageDeleteLocal(t *testing.T) {
+	ctx := context.Background()
+	home, local := NewMemoryStorage(), NewMemoryStorage()
+	storage := NewRegionalStorage(home, WithLocalReplica(local))
+
+	home.AddStatus(ctx, "key", &RequestStatus{}, time.Hour)
+	local.AddStatus(ctx, "key", &RequestStatus{}, time.Hour)
+
+	// Replications are dropped once closed, the local replica is deleted
+	// anyway so that it does not replay the key.
+	if err := storage.Close(ctx); err != nil {
+		t.Fatalf("want closed, got %v", err)
+	}
+	if err := storage.Delete(ctx, "key"); err != nil {
+		t.Fatalf("want deleted, got %v", err)
+	}
+	if status, err := storage.Get(ctx, "key"); err != nil || status != nil {
+		t.Errorf("want key deleted, got %+v, %v", status, err)
+	}
+}
@@ -0,0 +1,105 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrReservationLost is returned when a reservation is used after the key
+// was completed, released or reserved again, e.g. after it expired.
+var ErrReservationLost = errors.New("idempotency: reservation lost")
+
+// lastToken is the last fencing token handed out by this process.
+var lastToken atomic.Int64
+
+// newToken returns a fencing token which increases with the time of clock,
+// and strictly within the process.
+func newToken(clock Clock) int64 {
+	for {
+		last := lastToken.Load()
+		token := max(clock.Now().UnixNano(), last+1)
+		if lastToken.CompareAndSwap(last, token) {
+			return token
+		}
+	}
+}
+
+// Reservation is the ownership of a reserved key, returned by Reserve. The
+// Key and Token identify the reservation, so that it can be completed by
+// another process using State.Reservation.
+type Reservation struct {
+	Key string
+	// Token is the fencing token of the reservation, it is stored with the
+	// key and increases with every reservation. Writes with a token that is
+	// no longer stored fail with ErrReservationLost.
+	Token int64
+	state *State
+}
+
+// Reservation returns the reservation of key with token, e.g. to complete a
+// reservation made by another process.
+func (s *State) Reservation(key string, token int64) *Reservation {
+	return &Reservation{Key: key, Token: token, state: s}
+}
+
+// check returns the stored status of the reservation, if it is still owned.
+// As storages have no compare-and-set the reservation can still be lost
+// between the check and the following write.
+func (r *Reservation) check(ctx context.Context) (*RequestStatus, error) {
+	ctx, cancel := withTimeout(ctx, r.state.timeouts.Get)
+	defer cancel()
+
+	status, err := r.state.storage.Get(ctx, r.Key)
+	if err != nil {
+		return nil, fmt.Errorf("could not process request to get Idempotency-Key: %w", err)
+	}
+	if status == nil || !status.InProcess || status.Token != r.Token {
+		return nil, ErrReservationLost
+	}
+	return status, nil
+}
+
+// Confirm marks the reserved key as completed and stores resp, which may be
+// nil, to be able to replay it. It requires a storage implementing
+// StatusStorage.
+func (r *Reservation) Confirm(ctx context.Context, resp *Response) error {
+	if _, ok := r.state.storage.(StatusStorage); !ok {
+		return errors.New("storage does not support storing reservations")
+	}
+
+	status, err := r.check(ctx)
+	if err != nil {
+		return err
+	}
+	return r.state.complete(ctx, r.Key, status.completed(resp))
+}
+
+// Fail releases the reserved key so that the request can be retried. It
+// requires a storage implementing Deleter.
+func (r *Reservation) Fail(ctx context.Context) error {
+	if _, err := r.check(ctx); err != nil {
+		return err
+	}
+	return r.state.Fail(ctx, r.Key)
+}
+
+// Extend extends the reservation to expire after ttl, e.g. for work taking
+// longer than the TTL of the State, and the lock of the key when held by this
+// State. It requires a storage implementing Expirer.
+func (r *Reservation) Extend(ctx context.Context, ttl time.Duration) error {
+	e, ok := r.state.storage.(Expirer)
+	if !ok || !r.state.caps.Expirer {
+		return errors.New("storage does not support extending reservations")
+	}
+
+	if _, err := r.check(ctx); err != nil {
+		return err
+	}
+	if err := e.Expire(ctx, r.Key, ttl); err != nil {
+		return fmt.Errorf("could not extend Idempotency-Key: %w", err)
+	}
+	return r.state.extendLock(ctx, r.Key)
+}
@@ -0,0 +1,42 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReservation(t *testing.T) {
+	s := New(NewMemoryStorage())
+	ctx := context.Background()
+
+	d, err := s.Reserve(ctx, "key", "a")
+	if err != nil || d.Reservation == nil {
+		t.Fatalf("want reservation, got %+v, %v", d, err)
+	}
+	if err := d.Reservation.Extend(ctx, time.Hour); err != nil {
+		t.Fatalf("want reservation extended, got %v", err)
+	}
+
+	// A stale reservation of the same key is fenced off.
+	stale := s.Reservation("key", d.Reservation.Token-1)
+	if err := stale.Confirm(ctx, nil); !errors.Is(err, ErrReservationLost) {
+		t.Errorf("want %v, got %v", ErrReservationLost, err)
+	}
+
+	// The reservation can be confirmed by another process knowing its token.
+	other := New(s.storage).Reservation(d.Reservation.Key, d.Reservation.Token)
+	if err := other.Confirm(ctx, &Response{Body: []byte("result")}); err != nil {
+		t.Fatalf("want reservation confirmed, got %v", err)
+	}
+
+	d, err = s.Check(ctx, "key", "a")
+	if err != nil || d.Outcome != OutcomeCompleted || string(d.Status.Response.Body) != "result" {
+		t.Errorf("want completed key with response, got %+v, %v", d, err)
+	}
+
+	if err := other.Fail(ctx); !errors.Is(err, ErrReservationLost) {
+		t.Errorf("want %v after confirmation, got %v", ErrReservationLost, err)
+	}
+}
@@ -0,0 +1,40 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WithRetentionPolicy configures how long completed keys are retained by the
+// status code of their response, e.g. to keep 2xx results for 24 hours and
+// 4xx results for an hour. A retention of zero or less releases the key
+// instead of completing it, so that the request is processed again. Keys
+// are reserved with the TTL of the State and their expiry is changed once
+// completed. It requires a storage implementing Expirer and Deleter.
+func WithRetentionPolicy(policy func(status int) time.Duration) Option {
+	return func(s *State) {
+		s.retention = policy
+	}
+}
+
+// retain completes key with completed and retains it for retention, or
+// releases it if retention is not positive.
+func (s *State) retain(ctx context.Context, key string, completed *RequestStatus, retention time.Duration) error {
+	if retention <= 0 {
+		if s.caps.Deleter {
+			return s.Fail(ctx, key)
+		}
+		return s.complete(ctx, key, completed)
+	}
+
+	if err := s.complete(ctx, key, completed); err != nil {
+		return err
+	}
+	if e, ok := s.storage.(Expirer); ok && s.caps.Expirer {
+		if err := e.Expire(ctx, key, retention); err != nil {
+			return fmt.Errorf("could not set the retention of Idempotency-Key: %w", err)
+		}
+	}
+	return nil
+}
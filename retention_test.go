@@ -0,0 +1,54 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicy(t *testing.T) {
+	policy := func(status int) time.Duration {
+		switch {
+		case status >= 500:
+			return 0
+		case status >= 400:
+			return time.Hour
+		}
+		return 24 * time.Hour
+	}
+
+	tests := []struct {
+		name       string
+		status     int
+		wantStored bool
+		wantTTL    time.Duration
+	}{
+		{name: "Success is kept long", status: http.StatusCreated, wantStored: true, wantTTL: 24 * time.Hour},
+		{name: "Client error is kept short", status: http.StatusNotFound, wantStored: true, wantTTL: time.Hour},
+		{name: "Server error is not stored", status: http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := NewFakeClock(time.Now())
+			s := New(NewMemoryStorage(WithMemoryClock(clock)), WithTTL(time.Minute), WithRetentionPolicy(policy))
+			handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+			}))
+
+			req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+			req.Header.Set(HeaderName, "key")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			ttl, stored, err := s.TTL(context.Background(), "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored != test.wantStored || ttl != test.wantTTL {
+				t.Errorf("want stored %v for %v, got %v for %v", test.wantStored, test.wantTTL, stored, ttl)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// retriedOps are the storage calls that are safe to retry. Retrying a
+// reservation that succeeded without a response would report the key of the
+// request itself as in process, and retrying Increment could count twice.
+var retriedOps = map[string]bool{
+	"Get":            true,
+	"Complete":       true,
+	"UpdateStatus":   true,
+	"UpdateStatuses": true,
+	"Delete":         true,
+	"Expire":         true,
+	"TTL":            true,
+	"Ping":           true,
+	"DeleteByOwner":  true,
+}
+
+// RetryStorage returns a decorator retrying failed storage calls up to
+// attempts times in total, waiting backoff before the first retry and
+// doubling the wait for every further retry. Reservations and counters are
+// not retried, since a reservation that timed out may have succeeded.
+// Calls are not retried once their context is done.
+func RetryStorage(attempts int, backoff time.Duration) StorageDecorator {
+	return func(next StatusStorage) StatusStorage {
+		return &interceptedStorage{
+			forwardingStorage: forwardingStorage{next: next},
+			intercept: func(ctx context.Context, op string, call func(ctx context.Context) error) error {
+				err := call(ctx)
+				if !retriedOps[op] {
+					return err
+				}
+
+				wait := backoff
+				for i := 1; i < attempts && err != nil && !errors.Is(err, errors.ErrUnsupported); i++ {
+					t := time.NewTimer(wait)
+					select {
+					case <-ctx.Done():
+						t.Stop()
+						return err
+					case <-t.C:
+					}
+					wait *= 2
+					err = call(ctx)
+				}
+				return err
+			},
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRetryStorage(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		failures int
+		wantErr  bool
+		wantGets int
+	}{
+		{name: "Success", failures: 0, wantGets: 1},
+		{name: "Retried", failures: 2, wantGets: 3},
+		{name: "Exhausted", failures: 5, wantErr: true, wantGets: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flaky := &flakyStorage{StatusStorage: NewMemoryStorage(), failures: tt.failures}
+			storage := ChainStorage(flaky, RetryStorage(3, time.Millisecond))
+
+			_, err := storage.Get(ctx, "key")
+			if (err != nil) != tt.wantErr || flaky.gets != tt.wantGets {
+				t.Errorf("want error %v after %d gets, got %v after %d", tt.wantErr, tt.wantGets, err, flaky.gets)
+			}
+		})
+	}
+}
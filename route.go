@@ -0,0 +1,57 @@
+package idempotency
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// PatternScope is a scope for WithScope scoping keys by the ServeMux pattern
+// that matched the request, e.g. "POST /users/{id}", so that a key is shared
+// by all requests to an endpoint. Requests not routed by a ServeMux are
+// scoped by method and path.
+func PatternScope(r *http.Request) string {
+	if r.Pattern != "" {
+		return r.Pattern
+	}
+	return defaultScope(r)
+}
+
+// WithPattern configures opts for requests routed by a ServeMux to pattern,
+// as reported by r.Pattern, on top of the other options of the State. Since
+// the ServeMux sets r.Pattern when it dispatches a request, the State must
+// wrap the handlers registered with the ServeMux rather than the ServeMux.
+func WithPattern(pattern string, opts ...Option) Option {
+	return func(s *State) {
+		// Copied, since the map is shared with the State of With.
+		patterns := maps.Clone(s.patterns)
+		if patterns == nil {
+			patterns = make(map[string][]Option)
+		}
+		patterns[pattern] = append(slices.Clip(patterns[pattern]), opts...)
+		s.patterns = patterns
+	}
+}
+
+// verifyPatterns returns a handler verifying requests with the options of
+// the pattern they were routed to.
+func (s *State) verifyPatterns(next http.Handler) http.Handler {
+	base := s.With()
+	base.patterns = nil
+
+	handlers := make(map[string]http.Handler, len(s.patterns))
+	for pattern, opts := range s.patterns {
+		ps := base.With(opts...)
+		ps.patterns = nil
+		handlers[pattern] = ps.Verify(next)
+	}
+	fallback := base.Verify(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h, ok := handlers[r.Pattern]; ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		fallback.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,43 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithPattern(t *testing.T) {
+	s := New(NewMemoryStorage(), WithScope(PatternScope), WithPattern("POST /optional", WithRequirement(Optional)))
+
+	calls := 0
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	mux := http.NewServeMux()
+	mux.Handle("POST /optional", handler)
+	mux.Handle("POST /users/{id}", handler)
+
+	tests := []struct {
+		name      string
+		path      string
+		key       string
+		wantCode  int
+		wantCalls int
+	}{
+		{name: "Optional pattern", path: "/optional", wantCode: http.StatusOK, wantCalls: 1},
+		{name: "Required by default", path: "/users/1", wantCode: http.StatusBadRequest, wantCalls: 1},
+		{name: "New key", path: "/users/1", key: "a", wantCode: http.StatusOK, wantCalls: 2},
+		{name: "Key scoped by pattern", path: "/users/2", key: "a", wantCode: http.StatusOK, wantCalls: 2},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "http://example.com"+tt.path, nil)
+		if tt.key != "" {
+			req.Header.Set(HeaderName, tt.key)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != tt.wantCode || calls != tt.wantCalls {
+			t.Errorf("%s: want %d after %d calls, got %d after %d", tt.name, tt.wantCode, tt.wantCalls, w.Code, calls)
+		}
+	}
+}
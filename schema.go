@@ -0,0 +1,38 @@
+package idempotency
+
+import "encoding/json"
+
+// SchemaVersion is the version of the records written by the storages of
+// this package. Records carry their version, so that instances running
+// different versions of the package can share a storage during a rollout:
+//   - Fields may be added without a new version, older instances ignore
+//     them when reading.
+//   - Changing the meaning or encoding of a field requires a new version,
+//     and UnmarshalStatus upgrading records of the previous versions when
+//     they are read.
+//   - Records of newer versions are read as far as they are understood.
+//
+// Records written before versions were added are version 1.
+const SchemaVersion = 1
+
+// record is the stored form of a RequestStatus.
+type record struct {
+	Version int `json:"v,omitempty"`
+	*RequestStatus
+}
+
+// MarshalStatus encodes status as a record of SchemaVersion, for storages
+// storing statuses as JSON.
+func MarshalStatus(status *RequestStatus) ([]byte, error) {
+	return json.Marshal(record{Version: SchemaVersion, RequestStatus: status})
+}
+
+// UnmarshalStatus decodes a record encoded by MarshalStatus by any version
+// of the package. There are no older versions of records to upgrade yet.
+func UnmarshalStatus(data []byte) (*RequestStatus, error) {
+	rec := record{RequestStatus: &RequestStatus{}}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return rec.RequestStatus, nil
+}
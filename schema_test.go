@@ -0,0 +1,34 @@
+package idempotency
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUnmarshalStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want RequestStatus
+	}{
+		{name: "Current", data: `{"v":1,"in_process":true,"fingerprint":"a"}`, want: RequestStatus{InProcess: true, Fingerprint: "a"}},
+		{name: "Unversioned", data: `{"in_process":false,"token":2}`, want: RequestStatus{Token: 2}},
+		{name: "Newer", data: `{"v":9,"in_process":true,"future":{"x":1}}`, want: RequestStatus{InProcess: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UnmarshalStatus([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("want no error, got %v", err)
+			}
+			if got.InProcess != tt.want.InProcess || got.Fingerprint != tt.want.Fingerprint || got.Token != tt.want.Token {
+				t.Errorf("want %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+
+	data, err := MarshalStatus(&RequestStatus{InProcess: true})
+	if err != nil || !bytes.HasPrefix(data, []byte(`{"v":1,`)) {
+		t.Errorf("want versioned record, got %s, %v", data, err)
+	}
+}
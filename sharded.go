@@ -0,0 +1,224 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+)
+
+type shard struct {
+	name    string
+	storage StatusStorage
+}
+
+type shardedStorage struct {
+	shards []shard
+}
+
+// NewShardedStorage creates a storage partitioning keys across independent
+// storages, e.g. Redis instances that are not a Redis Cluster. shards maps
+// a stable name of each storage, e.g. its address, to the storage. It panics
+// if shards is empty or holds a nil storage, as no key could be stored.
+//
+// Keys are assigned with rendezvous hashing of the names, so every instance
+// with the same shards agrees on the assignment, and adding or removing a
+// shard only moves the keys of that shard. Moved keys are treated as new
+// until they expire from their old shard.
+//
+// The storage implements the optional interfaces of the shards, returning
+// errors.ErrUnsupported if the shard of a key does not.
+func NewShardedStorage(shards map[string]StatusStorage) *shardedStorage {
+	if len(shards) == 0 {
+		panic("idempotency: NewShardedStorage requires at least one shard")
+	}
+
+	s := &shardedStorage{}
+	for name, storage := range shards {
+		if storage == nil {
+			panic(fmt.Sprintf("idempotency: NewShardedStorage got a nil storage for the shard %q", name))
+		}
+		s.shards = append(s.shards, shard{name: name, storage: storage})
+	}
+	sort.Slice(s.shards, func(i, j int) bool { return s.shards[i].name < s.shards[j].name })
+	return s
+}
+
+// shardFor returns the storage key is assigned to.
+func (s *shardedStorage) shardFor(key string) StatusStorage {
+	var best StatusStorage
+	var bestScore uint64
+	for _, sh := range s.shards {
+		h := fnv.New64a()
+		h.Write([]byte(sh.name))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		if score := mix64(h.Sum64()); best == nil || score > bestScore {
+			best, bestScore = sh.storage, score
+		}
+	}
+	return best
+}
+
+// mix64 is the finalizer of SplitMix64, spreading the FNV hashes so that
+// their maximum is uniformly distributed over the shards.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Add inserts the initial state of a request with an idempotency key.
+func (s *shardedStorage) Add(ctx context.Context, key string) (bool, error) {
+	return s.shardFor(key).Add(ctx, key)
+}
+
+// AddStatus inserts status for an idempotency key.
+func (s *shardedStorage) AddStatus(ctx context.Context, key string, status *RequestStatus, expiry time.Duration) (bool, error) {
+	return s.shardFor(key).AddStatus(ctx, key, status, expiry)
+}
+
+// AddOrGet inserts status for an idempotency key or returns its existing
+// status, atomically if the shard implements Reserver.
+func (s *shardedStorage) AddOrGet(ctx context.Context, key string, status *RequestStatus, expiry time.Duration) (*RequestStatus, bool, error) {
+	return addOrGet(ctx, s.shardFor(key), key, status, expiry)
+}
+
+// Get fetches the RequestStatus for an idempotency key.
+func (s *shardedStorage) Get(ctx context.Context, key string) (*RequestStatus, error) {
+	return s.shardFor(key).Get(ctx, key)
+}
+
+// Complete sets a request to not be in progress.
+func (s *shardedStorage) Complete(ctx context.Context, key string) error {
+	return s.shardFor(key).Complete(ctx, key)
+}
+
+// UpdateStatus replaces the RequestStatus of an idempotency key.
+func (s *shardedStorage) UpdateStatus(ctx context.Context, key string, status *RequestStatus) error {
+	return s.shardFor(key).UpdateStatus(ctx, key, status)
+}
+
+// Delete removes an idempotency key.
+func (s *shardedStorage) Delete(ctx context.Context, key string) error {
+	d, ok := s.shardFor(key).(Deleter)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return d.Delete(ctx, key)
+}
+
+// Expire sets an idempotency key to expire after expiry.
+func (s *shardedStorage) Expire(ctx context.Context, key string, expiry time.Duration) error {
+	e, ok := s.shardFor(key).(Expirer)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return e.Expire(ctx, key, expiry)
+}
+
+// Persist removes the expiry of an idempotency key.
+func (s *shardedStorage) Persist(ctx context.Context, key string) error {
+	p, ok := s.shardFor(key).(Persister)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return p.Persist(ctx, key)
+}
+
+// Increment increments the counter of key.
+func (s *shardedStorage) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	c, ok := s.shardFor(key).(Counter)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	return c.Increment(ctx, key, window)
+}
+
+// TTL returns the remaining time until an idempotency key expires.
+func (s *shardedStorage) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	t, ok := s.shardFor(key).(TTLReader)
+	if !ok {
+		return 0, false, errors.ErrUnsupported
+	}
+	return t.TTL(ctx, key)
+}
+
+// Ping checks that every shard is reachable.
+func (s *shardedStorage) Ping(ctx context.Context) error {
+	for _, sh := range s.shards {
+		p, ok := sh.storage.(Pinger)
+		if !ok {
+			continue
+		}
+		if err := p.Ping(ctx); err != nil {
+			return fmt.Errorf("shard %s: %w", sh.name, err)
+		}
+	}
+	return nil
+}
+
+// Scan calls fn for the keys of every shard.
+func (s *shardedStorage) Scan(ctx context.Context, fn func(key string, status *RequestStatus) error) error {
+	for _, sh := range s.shards {
+		sc, ok := sh.storage.(Scanner)
+		if !ok {
+			return errors.ErrUnsupported
+		}
+		if err := sc.Scan(ctx, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteByOwner removes the keys of owner from every shard.
+func (s *shardedStorage) DeleteByOwner(ctx context.Context, owner string) (int, error) {
+	total := 0
+	for _, sh := range s.shards {
+		d, ok := sh.storage.(OwnerDeleter)
+		if !ok {
+			return total, errors.ErrUnsupported
+		}
+		n, err := d.DeleteByOwner(ctx, owner)
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("shard %s: %w", sh.name, err)
+		}
+	}
+	return total, nil
+}
+
+// Purge removes expired keys from every shard.
+func (s *shardedStorage) Purge(ctx context.Context) (int, error) {
+	total := 0
+	for _, sh := range s.shards {
+		p, ok := sh.storage.(Purger)
+		if !ok {
+			return total, errors.ErrUnsupported
+		}
+		n, err := p.Purge(ctx)
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("shard %s: %w", sh.name, err)
+		}
+	}
+	return total, nil
+}
+
+// Capabilities returns the capabilities all shards support.
+func (s *shardedStorage) Capabilities() Capabilities {
+	c := Capabilities{StatusStorage: true, Reserver: true, Deleter: true, Expirer: true, Persister: true,
+		Counter: true, TTLReader: true, Scanner: true, Purger: true, OwnerDeleter: true}
+	for _, sh := range s.shards {
+		c = c.intersect(StorageCapabilities(sh.storage))
+	}
+	// Shards not implementing Pinger are assumed to be healthy.
+	c.Pinger = true
+	return c
+}
@@ -0,0 +1,59 @@
+package idempotency
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestShardedStorage(t *testing.T) {
+	stores := map[string]StatusStorage{}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		stores[name] = NewMemoryStorage()
+	}
+	sharded := NewShardedStorage(stores)
+
+	// Keys are spread over all shards.
+	counts := map[StatusStorage]int{}
+	for i := range 4000 {
+		counts[sharded.shardFor(strconv.Itoa(i))]++
+	}
+	for name, store := range stores {
+		if n := counts[store]; n < 800 || n > 1200 {
+			t.Errorf("want about 1000 keys in shard %s, got %d", name, n)
+		}
+	}
+
+	// Removing a shard only moves its keys.
+	fewer := map[string]StatusStorage{}
+	for name, store := range stores {
+		if name != "d" {
+			fewer[name] = store
+		}
+	}
+	resharded := NewShardedStorage(fewer)
+	for i := range 4000 {
+		key := strconv.Itoa(i)
+		if before := sharded.shardFor(key); before != stores["d"] && before != resharded.shardFor(key) {
+			t.Fatalf("want key %s kept in its shard", key)
+		}
+	}
+}
+
+func TestShardedStorageInvalidShards(t *testing.T) {
+	tests := map[string]map[string]StatusStorage{
+		"nil":         nil,
+		"empty":       {},
+		"nil storage": {"a": NewMemoryStorage(), "b": nil},
+	}
+
+	for name, shards := range tests {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("want panic")
+				}
+			}()
+			NewShardedStorage(shards)
+		})
+	}
+}
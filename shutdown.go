@@ -0,0 +1,69 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// inflight are the keys reserved by the states of an instance which are not
+// finished or failed yet.
+type inflight struct {
+	mu      sync.Mutex
+	keys    map[string]int64
+	flights map[string]*flight
+}
+
+func (f *inflight) add(key string, token int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.keys[key] = token
+}
+
+func (f *inflight) remove(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.keys, key)
+	if fl, ok := f.flights[key]; ok {
+		delete(f.flights, key)
+		close(fl.done)
+	}
+}
+
+// Shutdown releases the keys reserved by this instance which are still in
+// process, so that requests retried after a restart are processed instead of
+// getting a 409 Conflict until the keys expire. Queued completions of
+// WithAsyncCompletion are written first. It should be called once the HTTP
+// server has shut down, handlers still running lose their reservation. It
+// requires a storage implementing Deleter, and covers the keys reserved by s
+// and the states created from it with With.
+func (s *State) Shutdown(ctx context.Context) error {
+	if err := s.Drain(ctx); err != nil {
+		return err
+	}
+
+	s.inflight.mu.Lock()
+	keys := make(map[string]int64, len(s.inflight.keys))
+	for key, token := range s.inflight.keys {
+		keys[key] = token
+	}
+	s.inflight.mu.Unlock()
+
+	var errs []error
+	for key, token := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Keys completed or taken over since are no longer ours.
+		err := s.Reservation(key, token).Fail(ctx)
+		if err != nil && !errors.Is(err, ErrReservationLost) {
+			errs = append(errs, err)
+			continue
+		}
+		s.inflight.remove(key)
+	}
+	return errors.Join(errs...)
+}
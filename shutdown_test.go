@@ -0,0 +1,35 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+)
+
+func TestShutdown(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	s := New(storage)
+
+	for _, key := range []string{"finished", "abandoned", "taken"} {
+		if d, err := s.Reserve(ctx, key, ""); err != nil || d.Outcome != OutcomeNew {
+			t.Fatalf("want key %s reserved, got %+v, %v", key, d, err)
+		}
+	}
+	if err := s.Finish(ctx, "finished", nil); err != nil {
+		t.Fatalf("want key finished, got %v", err)
+	}
+	// Another instance took the key over, it must not be released.
+	if err := storage.UpdateStatus(ctx, "taken", &RequestStatus{InProcess: true, Token: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("want shutdown, got %v", err)
+	}
+
+	for key, want := range map[string]Outcome{"finished": OutcomeCompleted, "abandoned": OutcomeNew, "taken": OutcomeInProcess} {
+		if d, _ := s.Check(ctx, key, ""); d.Outcome != want {
+			t.Errorf("want key %s %v after shutdown, got %v", key, want, d.Outcome)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// defaultCleanupBatchSize is the number of expired keys Cleanup deletes per
+// statement by default.
+const defaultCleanupBatchSize = 1000
+
+// WithCleanupBatchSize configures how many expired keys Cleanup deletes per
+// statement, it defaults to 1000. Smaller batches hold locks for less time.
+func WithCleanupBatchSize(n int) SQLStorageOption {
+	return func(s *sqlStorage) {
+		s.cleanupBatch = n
+	}
+}
+
+// Cleanup deletes the expired keys, which databases do not remove by
+// themselves, in batches until none are left, e.g. from a cron job. It
+// returns the number of deleted keys.
+func (s *sqlStorage) Cleanup(ctx context.Context) (int, error) {
+	batch := s.cleanupBatch
+	if batch <= 0 {
+		batch = defaultCleanupBatchSize
+	}
+
+	total := 0
+	for {
+		query, args := s.dialect.Cleanup(s.table, s.clock.Now().UnixMilli(), batch)
+		res, err := s.db.ExecContext(ctx, s.query(query), args...)
+		if err != nil {
+			return total, fmt.Errorf("failed to delete expired keys from sql: %w", err)
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, fmt.Errorf("failed to delete expired keys from sql: %w", err)
+		}
+		total += int(n)
+		if n < int64(batch) {
+			return total, nil
+		}
+	}
+}
+
+// RunCleanup runs Cleanup every interval, plus a random delay of up to
+// jitter so that instances do not clean up at the same time, until ctx is
+// done. Errors are passed to onError, which may be nil, and retried on the
+// next interval.
+func (s *sqlStorage) RunCleanup(ctx context.Context, interval, jitter time.Duration, onError func(err error)) error {
+	for {
+		wait := interval
+		if jitter > 0 {
+			wait += rand.N(jitter)
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+
+		if _, err := s.Cleanup(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package idempotency
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// sqlMigrations are the versioned schema changes of the SQL storage, named
+// by their version. They must not be changed once released, changes of the
+// schema are added as new migrations.
+//
+// The migrations of dialects that need other DDL are in subdirectories, with
+// the same versions.
+//
+//go:embed migrations/*.sql migrations/mysql/*.sql migrations/mssql/*.sql
+var sqlMigrations embed.FS
+
+// Migrate creates or upgrades the tables of the storage by applying the
+// migrations embedded in the package that were not applied yet. Applied
+// versions are recorded in the table of the keys suffixed with _migrations.
+// Tables created by hand with the schema of NewSQLStorage are adopted. Every
+// migration runs in a transaction, so that concurrent calls, e.g. by
+// instances starting at the same time, fail instead of applying it twice.
+func (s *sqlStorage) Migrate(ctx context.Context) error {
+	versions := s.table + "_migrations"
+	_, err := s.db.ExecContext(ctx, s.dialect.CreateMigrations(versions))
+	if err != nil {
+		return fmt.Errorf("failed to create the migrations table in sql: %w", err)
+	}
+
+	applied, err := s.appliedMigrations(ctx, versions)
+	if err != nil {
+		return err
+	}
+
+	migrations := s.dialect.Migrations()
+	entries, err := fs.ReadDir(migrations, ".")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		prefix, _, _ := strings.Cut(entry.Name(), "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid migration %s: %w", entry.Name(), err)
+		}
+		if applied[version] {
+			continue
+		}
+
+		script, err := fs.ReadFile(migrations, entry.Name())
+		if err != nil {
+			return err
+		}
+		if err := s.applyMigration(ctx, versions, version, string(script)); err != nil {
+			return fmt.Errorf("failed to apply migration %s in sql: %w", entry.Name(), err)
+		}
+	}
+
+	if s.rowTTL {
+		return s.enableRowTTL(ctx)
+	}
+	return nil
+}
+
+// appliedMigrations returns the versions recorded in the table versions.
+func (s *sqlStorage) appliedMigrations(ctx context.Context, versions string) (map[int64]bool, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT version FROM "+versions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the applied migrations from sql: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]bool)
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("failed to read the applied migrations from sql: %w", err)
+		}
+		applied[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the applied migrations from sql: %w", err)
+	}
+	return applied, nil
+}
+
+// applyMigration runs the statements of script and records version.
+func (s *sqlStorage) applyMigration(ctx context.Context, versions string, version int64, script string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range strings.Split(strings.ReplaceAll(script, "{{table}}", s.table), ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, s.query("INSERT INTO "+versions+" (version, applied_at) VALUES (?, ?)"), version, s.clock.Now().UnixMilli())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,374 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Placeholder is the style of query parameters of a SQL database.
+type Placeholder int
+
+const (
+	// QuestionPlaceholder uses ? for parameters, e.g. for SQLite.
+	QuestionPlaceholder Placeholder = iota
+	// DollarPlaceholder uses $1, $2 and so on for parameters, e.g. for
+	// PostgreSQL.
+	DollarPlaceholder
+	// AtPlaceholder uses @p1, @p2 and so on for parameters, e.g. for SQL
+	// Server.
+	AtPlaceholder
+)
+
+// txContextKey defines which key to use for the transaction in
+// context.Context.
+const txContextKey contextKey = "idempotency-tx"
+
+// NewTxContext returns a new Context that carries tx. The SQL storage runs
+// its queries in tx for the returned context, so that reserving and
+// completing a key commits or rolls back with the writes of the application.
+func NewTxContext(ctx context.Context, tx *sql.Tx) context.Context {
+	return context.WithValue(ctx, txContextKey, tx)
+}
+
+// txEffectsContextKey defines which key to use for the deferred effects of a
+// transaction in context.Context.
+const txEffectsContextKey contextKey = "idempotency-tx-effects"
+
+// txEffects are the effects of completing keys in a transaction.
+type txEffects struct {
+	mu      sync.Mutex
+	effects []func()
+}
+
+// DeferTxEffects returns a new Context for completing keys in a transaction,
+// e.g. with NewTxContext. The effects of completing a key on this instance,
+// waking up requests waiting for it and calling the transition hooks, are
+// deferred until done is called after the transaction ended, so that they
+// are not observed for a completion that is rolled back. done runs them if
+// committed is true and discards them otherwise, the keys then stay reserved
+// until they are failed or expire.
+func DeferTxEffects(ctx context.Context) (context.Context, func(committed bool)) {
+	e := &txEffects{}
+	return context.WithValue(ctx, txEffectsContextKey, e), func(committed bool) {
+		e.mu.Lock()
+		effects := e.effects
+		e.effects = nil
+		e.mu.Unlock()
+
+		if !committed {
+			return
+		}
+		for _, effect := range effects {
+			effect()
+		}
+	}
+}
+
+// deferTxEffect defers effect until the transaction of ctx ended, if ctx was
+// created with DeferTxEffects, and reports whether it was deferred.
+func deferTxEffect(ctx context.Context, effect func()) bool {
+	e, ok := ctx.Value(txEffectsContextKey).(*txEffects)
+	if !ok {
+		return false
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.effects = append(e.effects, effect)
+	return true
+}
+
+// sqlExecutor is implemented by both *sql.DB and *sql.Tx.
+type sqlExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type sqlStorage struct {
+	db           *sql.DB
+	expiry       time.Duration
+	table        string
+	placeholder  Placeholder
+	clock        Clock
+	cleanupBatch int
+	txRetries    int
+	rowTTL       bool
+	dialect      Dialect
+}
+
+// SQLStorageOption is the signature for functional options for the SQL
+// storage.
+type SQLStorageOption func(*sqlStorage)
+
+// WithTableName configures the table of the keys, it defaults to
+// idempotency_keys.
+func WithTableName(table string) SQLStorageOption {
+	return func(s *sqlStorage) {
+		s.table = table
+	}
+}
+
+// WithPlaceholder configures the style of query parameters, it defaults to
+// QuestionPlaceholder.
+func WithPlaceholder(p Placeholder) SQLStorageOption {
+	return func(s *sqlStorage) {
+		s.placeholder = p
+	}
+}
+
+// WithSQLClock configures the Clock of expiries, it defaults to SystemClock.
+func WithSQLClock(c Clock) SQLStorageOption {
+	return func(s *sqlStorage) {
+		s.clock = c
+	}
+}
+
+// NewSQLStorage creates a SQL storage for Idempotency-Keys, storing them in a
+// table with the following schema:
+//
+//	CREATE TABLE idempotency_keys (
+//		idempotency_key VARCHAR(255) PRIMARY KEY,
+//		status TEXT NOT NULL,
+//		owner VARCHAR(255) NOT NULL DEFAULT '',
+//		expires_at BIGINT NOT NULL
+//	);
+//	CREATE INDEX idempotency_keys_owner ON idempotency_keys (owner);
+//
+// Migrate creates and upgrades the table. The SQL of statements that are not
+// portable, such as reserving keys, is configured with WithDialect, built-in
+// dialects support SQLite, PostgreSQL, MySQL and SQL Server. Queries run in
+// the transaction of the context when it is set with NewTxContext.
+func NewSQLStorage(db *sql.DB, expiry time.Duration, opts ...SQLStorageOption) *sqlStorage {
+	s := &sqlStorage{
+		db:      db,
+		expiry:  expiry,
+		table:   "idempotency_keys",
+		clock:   SystemClock,
+		dialect: SQLiteDialect,
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(s)
+		}
+	}
+
+	return s
+}
+
+// executor returns the transaction of ctx, if any, or the database.
+func (s *sqlStorage) executor(ctx context.Context) sqlExecutor {
+	if tx, ok := ctx.Value(txContextKey).(*sql.Tx); ok && tx != nil {
+		return tx
+	}
+	return s.db
+}
+
+// query replaces the ? parameters of query with the placeholder style of the
+// storage.
+func (s *sqlStorage) query(query string) string {
+	var prefix string
+	switch s.placeholder {
+	case DollarPlaceholder:
+		prefix = "$"
+	case AtPlaceholder:
+		prefix = "@p"
+	default:
+		return query
+	}
+
+	b := make([]byte, 0, len(query)+8)
+	n := 0
+	for i := 0; i < len(query); i++ {
+		if query[i] != '?' {
+			b = append(b, query[i])
+			continue
+		}
+		n++
+		b = append(b, prefix...)
+		b = strconv.AppendInt(b, int64(n), 10)
+	}
+	return string(b)
+}
+
+// Add inserts the initial state of a request with an idempotency key.
+func (s *sqlStorage) Add(ctx context.Context, key string) (bool, error) {
+	return s.AddStatus(ctx, key, &RequestStatus{InProcess: true}, 0)
+}
+
+// AddStatus inserts status for an idempotency key, expiring it after expiry
+// or the expiry of the storage if zero.
+func (s *sqlStorage) AddStatus(ctx context.Context, key string, status *RequestStatus, expiry time.Duration) (bool, error) {
+	if expiry == 0 {
+		expiry = s.expiry
+	}
+
+	value, err := MarshalStatus(status)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode the status of key %q: %w", key, err)
+	}
+
+	now := s.clock.Now()
+	var expiresAt int64
+	if expiry > 0 {
+		expiresAt = now.Add(expiry).UnixMilli()
+	}
+
+	query, args := s.dialect.Reserve(s.table, key, string(value), status.Owner, expiresAt, now.UnixMilli())
+	res, err := s.exec(ctx, s.query(query), args...)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert the key %q in sql: %w", key, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to insert the key %q in sql: %w", key, err)
+	}
+	return n > 0, nil
+}
+
+// Get fetches the RequestStatus for an idempotency key.
+func (s *sqlStorage) Get(ctx context.Context, key string) (*RequestStatus, error) {
+	var value string
+	err := s.executor(ctx).QueryRowContext(ctx, s.query("SELECT status FROM "+s.table+" WHERE idempotency_key = ? AND (expires_at = 0 OR expires_at > ?)"),
+		key, s.clock.Now().UnixMilli()).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the key %q from sql: %w", key, err)
+	}
+
+	status, err := UnmarshalStatus([]byte(value))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the key %q from sql: %w", key, err)
+	}
+	return status, nil
+}
+
+// Complete sets a request to not be in progress, it is then determined to be
+// completed and that we should serve the result we got from a previous
+// request.
+func (s *sqlStorage) Complete(ctx context.Context, key string) error {
+	return s.UpdateStatus(ctx, key, &RequestStatus{InProcess: false})
+}
+
+// UpdateStatus replaces the RequestStatus of an idempotency key, keeping its
+// expiry.
+func (s *sqlStorage) UpdateStatus(ctx context.Context, key string, status *RequestStatus) error {
+	value, err := MarshalStatus(status)
+	if err != nil {
+		return fmt.Errorf("failed to encode the status of key %q: %w", key, err)
+	}
+
+	_, err = s.exec(ctx, s.query("UPDATE "+s.table+" SET status = ? WHERE idempotency_key = ?"), string(value), key)
+	if err != nil {
+		return fmt.Errorf("failed to update the key %q in sql: %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes an idempotency key.
+func (s *sqlStorage) Delete(ctx context.Context, key string) error {
+	_, err := s.exec(ctx, s.query("DELETE FROM "+s.table+" WHERE idempotency_key = ?"), key)
+	if err != nil {
+		return fmt.Errorf("failed to delete the key %q from sql: %w", key, err)
+	}
+	return nil
+}
+
+// Expire sets an idempotency key to expire after expiry.
+func (s *sqlStorage) Expire(ctx context.Context, key string, expiry time.Duration) error {
+	_, err := s.exec(ctx, s.query("UPDATE "+s.table+" SET expires_at = ? WHERE idempotency_key = ?"), s.clock.Now().Add(expiry).UnixMilli(), key)
+	if err != nil {
+		return fmt.Errorf("failed to expire the key %q in sql: %w", key, err)
+	}
+	return nil
+}
+
+// TTL returns the remaining time until an idempotency key expires.
+func (s *sqlStorage) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	now := s.clock.Now().UnixMilli()
+
+	var expiresAt int64
+	err := s.executor(ctx).QueryRowContext(ctx, s.query("SELECT expires_at FROM "+s.table+" WHERE idempotency_key = ? AND (expires_at = 0 OR expires_at > ?)"),
+		key, now).Scan(&expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get the expiry of key %q from sql: %w", key, err)
+	}
+
+	if expiresAt == 0 {
+		return 0, true, nil
+	}
+	return time.Duration(expiresAt-now) * time.Millisecond, true, nil
+}
+
+// Scan calls fn for every idempotency key and its status.
+func (s *sqlStorage) Scan(ctx context.Context, fn func(key string, status *RequestStatus) error) error {
+	rows, err := s.db.QueryContext(ctx, s.query("SELECT idempotency_key, status FROM "+s.table+" WHERE expires_at = 0 OR expires_at > ?"), s.clock.Now().UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to scan keys in sql: %w", err)
+	}
+
+	// Read all rows first, as fn may use the storage.
+	statuses := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan keys in sql: %w", err)
+		}
+		statuses[key] = value
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to scan keys in sql: %w", err)
+	}
+
+	for key, value := range statuses {
+		status, err := UnmarshalStatus([]byte(value))
+		if err != nil {
+			return fmt.Errorf("failed to decode the key %q from sql: %w", key, err)
+		}
+		if err := fn(key, status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ping checks that the database is reachable.
+func (s *sqlStorage) Ping(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping sql: %w", err)
+	}
+	return nil
+}
+
+// Purge removes expired idempotency keys, like Cleanup.
+func (s *sqlStorage) Purge(ctx context.Context) (int, error) {
+	return s.Cleanup(ctx)
+}
+
+// DeleteByOwner removes all idempotency keys of owner.
+func (s *sqlStorage) DeleteByOwner(ctx context.Context, owner string) (int, error) {
+	res, err := s.exec(ctx, s.query("DELETE FROM "+s.table+" WHERE owner = ?"), owner)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete the keys of owner %q from sql: %w", owner, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete the keys of owner %q from sql: %w", owner, err)
+	}
+	return int(n), nil
+}
@@ -0,0 +1,279 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func newSQLiteStorage(t *testing.T, opts ...SQLStorageOption) (*sql.DB, *sqlStorage) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// Every connection to :memory: opens a different database.
+	db.SetMaxOpenConns(1)
+
+	storage := NewSQLStorage(db, time.Hour, opts...)
+	if err := storage.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return db, storage
+}
+
+func TestSQLStorage(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Now())
+	_, storage := newSQLiteStorage(t, WithSQLClock(clock))
+
+	added, err := storage.AddStatus(ctx, "key", &RequestStatus{InProcess: true, Fingerprint: "abc"}, 0)
+	if err != nil || !added {
+		t.Fatalf("want key added, got %v, %v", added, err)
+	}
+	added, err = storage.Add(ctx, "key")
+	if err != nil || added {
+		t.Fatalf("want existing key not added, got %v, %v", added, err)
+	}
+
+	if err := storage.UpdateStatus(ctx, "key", &RequestStatus{Fingerprint: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	status, err := storage.Get(ctx, "key")
+	if err != nil || status == nil || status.InProcess || status.Fingerprint != "abc" {
+		t.Fatalf("want completed status, got %+v, %v", status, err)
+	}
+
+	// Expired keys can be added again.
+	if _, err := storage.AddStatus(ctx, "expired", &RequestStatus{InProcess: true}, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Millisecond)
+	if status, _ := storage.Get(ctx, "expired"); status != nil {
+		t.Errorf("want expired key not found, got %+v", status)
+	}
+	if added, _ := storage.Add(ctx, "expired"); !added {
+		t.Errorf("want expired key added again")
+	}
+
+	if err := storage.Delete(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := storage.Get(ctx, "key"); status != nil {
+		t.Errorf("want deleted key not found, got %+v", status)
+	}
+}
+
+func TestSQLStorageTx(t *testing.T) {
+	ctx := context.Background()
+	db, storage := newSQLiteStorage(t)
+	s := New(storage)
+
+	tests := []struct {
+		name       string
+		commit     bool
+		wantStored bool
+	}{
+		{name: "Committed with the transaction", commit: true, wantStored: true},
+		{name: "Rolled back with the transaction", commit: false, wantStored: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, err = Do(NewTxContext(ctx, tx), s, test.name, func(ctx context.Context) (string, error) {
+				return "done", nil
+			})
+			if err != nil {
+				t.Fatalf("want no error, got %v", err)
+			}
+
+			if test.commit {
+				err = tx.Commit()
+			} else {
+				err = tx.Rollback()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			status, err := storage.Get(ctx, test.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := status != nil; got != test.wantStored {
+				t.Errorf("want key stored %v, got %v", test.wantStored, got)
+			}
+		})
+	}
+}
+
+func TestSQLMigrate(t *testing.T) {
+	ctx := context.Background()
+	db, storage := newSQLiteStorage(t, WithTableName("keys"))
+
+	// Migrating again applies nothing.
+	if err := storage.Migrate(ctx); err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM keys_migrations").Scan(&n); err != nil || n != 3 {
+		t.Errorf("want 3 applied migrations, got %d, %v", n, err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'keys_owner'").Scan(&n); err != nil || n != 1 {
+		t.Errorf("want owner index, got %d, %v", n, err)
+	}
+
+	if _, err := storage.AddStatus(ctx, "key", &RequestStatus{Owner: "alice"}, 0); err != nil {
+		t.Errorf("want key added to the migrated table, got %v", err)
+	}
+}
+
+func TestSQLCleanup(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Now())
+	db, storage := newSQLiteStorage(t, WithSQLClock(clock), WithCleanupBatchSize(2))
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		storage.AddStatus(ctx, key, &RequestStatus{}, time.Minute)
+	}
+	storage.AddStatus(ctx, "kept", &RequestStatus{}, time.Hour)
+	clock.Advance(2 * time.Minute)
+
+	n, err := storage.Cleanup(ctx)
+	if err != nil || n != 5 {
+		t.Fatalf("want 5 keys deleted in batches, got %d, %v", n, err)
+	}
+	var rows int
+	if err := db.QueryRow("SELECT COUNT(*) FROM idempotency_keys").Scan(&rows); err != nil || rows != 1 {
+		t.Errorf("want 1 key left, got %d, %v", rows, err)
+	}
+}
+
+// serializationError is a serialization error of a PostgreSQL driver.
+type serializationError struct{}
+
+func (serializationError) Error() string    { return "restart transaction" }
+func (serializationError) SQLState() string { return "40001" }
+
+func TestSQLReplacesExpiredKey(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Now())
+	_, storage := newSQLiteStorage(t, WithSQLClock(clock))
+
+	tests := []struct {
+		name      string
+		advance   time.Duration
+		wantAdded bool
+	}{
+		{name: "New key", wantAdded: true},
+		{name: "Existing key", wantAdded: false},
+		{name: "Expired key", advance: 2 * time.Hour, wantAdded: true},
+	}
+
+	for _, test := range tests {
+		clock.Advance(test.advance)
+		added, err := storage.AddStatus(ctx, "key", &RequestStatus{InProcess: true, Fingerprint: test.name}, 0)
+		if err != nil || added != test.wantAdded {
+			t.Errorf("%s: want added %v, got %v, %v", test.name, test.wantAdded, added, err)
+		}
+	}
+	if status, _ := storage.Get(ctx, "key"); status == nil || status.Fingerprint != "Expired key" {
+		t.Errorf("want expired key replaced, got %+v", status)
+	}
+}
+
+// deadlockError is a deadlock error of a SQL Server driver.
+type deadlockError struct{}
+
+func (deadlockError) Error() string         { return "deadlock victim" }
+func (deadlockError) SQLErrorNumber() int32 { return 1205 }
+
+func TestIsSerializationFailure(t *testing.T) {
+	if !isSerializationFailure(fmt.Errorf("insert: %w", serializationError{})) {
+		t.Error("want wrapped 40001 detected")
+	}
+	if !isSerializationFailure(fmt.Errorf("merge: %w", deadlockError{})) {
+		t.Error("want wrapped deadlock detected")
+	}
+	if isSerializationFailure(errors.New("syntax error")) {
+		t.Error("want other errors not retried")
+	}
+}
+
+func TestSQLQueryPlaceholder(t *testing.T) {
+	tests := []struct {
+		placeholder Placeholder
+		want        string
+	}{
+		{placeholder: QuestionPlaceholder, want: "SELECT ? WHERE a = ?"},
+		{placeholder: DollarPlaceholder, want: "SELECT $1 WHERE a = $2"},
+		{placeholder: AtPlaceholder, want: "SELECT @p1 WHERE a = @p2"},
+	}
+
+	for _, test := range tests {
+		s := NewSQLStorage(nil, time.Hour, WithPlaceholder(test.placeholder))
+		if got := s.query("SELECT ? WHERE a = ?"); got != test.want {
+			t.Errorf("want %q, got %q", test.want, got)
+		}
+	}
+}
+
+func TestDialects(t *testing.T) {
+	dialects := map[string]Dialect{
+		"SQLite":   SQLiteDialect,
+		"Postgres": PostgresDialect,
+		"MySQL":    MySQLDialect,
+		"MSSQL":    MSSQLDialect,
+	}
+	versions := func(migrations fs.FS) []string {
+		entries, err := fs.ReadDir(migrations, ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				names = append(names, entry.Name())
+			}
+		}
+		return names
+	}
+	want := versions(SQLiteDialect.Migrations())
+
+	for name, d := range dialects {
+		// Every parameter of a statement must have an argument.
+		query, args := d.Reserve("keys", "key", "{}", "", 0, 0)
+		if n := strings.Count(query, "?"); n != len(args) {
+			t.Errorf("%s: want %d arguments of Reserve, got %d", name, n, len(args))
+		}
+		query, args = d.Cleanup("keys", 0, 10)
+		if n := strings.Count(query, "?"); n != len(args) {
+			t.Errorf("%s: want %d arguments of Cleanup, got %d", name, n, len(args))
+		}
+		query, args = d.SelectFirst("keys", "idempotency_key", "expires_at", 10)
+		if n := strings.Count(query, "?"); n != len(args) {
+			t.Errorf("%s: want %d arguments of SelectFirst, got %d", name, n, len(args))
+		}
+
+		// Migrations are recorded by version, so all dialects must match.
+		if got := versions(d.Migrations()); !slices.Equal(want, got) {
+			t.Errorf("%s: want migrations %v, got %v", name, want, got)
+		}
+	}
+}
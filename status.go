@@ -0,0 +1,175 @@
+package idempotency
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// statusHeartbeat is how often a comment is sent on streams of a key in
+// process, so that proxies do not close them.
+const statusHeartbeat = 15 * time.Second
+
+// KeyStatus is the state of a key as reported by the status handlers.
+type KeyStatus struct {
+	// State is "in_process", "completed", "failed" or "not_found", which
+	// includes keys released after a failed request.
+	State string `json:"state"`
+	// StatusCode and Location are of the stored response, if any.
+	StatusCode int               `json:"status_code,omitempty"`
+	Location   string            `json:"location,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// keyStatus returns the KeyStatus of d.
+func keyStatus(d Decision) KeyStatus {
+	switch {
+	case d.Status == nil:
+		return KeyStatus{State: "not_found"}
+	case d.Status.InProcess:
+		return KeyStatus{State: "in_process"}
+	}
+
+	ks := KeyStatus{State: "completed", Metadata: d.Status.Metadata}
+	if d.Status.Failed {
+		ks.State = "failed"
+	}
+	if resp := d.Status.Response; resp != nil {
+		ks.StatusCode = resp.StatusCode
+		ks.Location = resp.Header.Get("Location")
+	}
+	return ks
+}
+
+// StatusKeyFunc returns the scope and the Idempotency-Key of a status
+// request, e.g. from r.PathValue. The scope is the one the key was stored in,
+// see WithScope, e.g. "POST /orders" for the default scope of Draft06, and
+// empty for unscoped keys.
+type StatusKeyFunc func(r *http.Request) (scope, key string)
+
+// statusKey returns the storage key of the key of r, or responds with an
+// error if it has none.
+func (s *State) statusKey(w http.ResponseWriter, r *http.Request, key StatusKeyFunc) (string, bool) {
+	scope, idempotencyKey := key(r)
+	if idempotencyKey == "" {
+		http.Error(w, errNoKey.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	if reservedKey(idempotencyKey) {
+		http.Error(w, errReservedKey.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return s.scopedKey(scope, idempotencyKey), true
+}
+
+// visible reports whether the status of d may be reported to r, which is
+// when r has the owner of the key, see WithOwner.
+func (s *State) visible(r *http.Request, d Decision) bool {
+	return s.owner == nil || d.Status == nil || d.Status.Owner == s.owner(r)
+}
+
+// CompletionEventsHandler returns a handler streaming the status of a key as
+// Server-Sent Events, e.g. for browser clients which got a 409 Conflict or a
+// 202 Accepted for it. An event named by the State of the KeyStatus is sent
+// with it as JSON data, and once more when a key in process is finished. The
+// key is returned by key. With WithOwner, keys of other owners are reported
+// as not found, without it the handler must only be reachable by clients
+// authorized to see all keys.
+func (s *State) CompletionEventsHandler(key StatusKeyFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		storageKey, ok := s.statusKey(w, r, key)
+		if !ok {
+			return
+		}
+		ctx := r.Context()
+
+		d, err := s.Check(ctx, storageKey, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !s.visible(r, d) {
+			d = Decision{}
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		rc := http.NewResponseController(w)
+		send := func(d Decision) error {
+			ks := keyStatus(d)
+			data, err := json.Marshal(ks)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ks.State, data); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+
+		if err := send(d); err != nil || d.Outcome != OutcomeInProcess {
+			return
+		}
+		for {
+			d = s.await(ctx, storageKey, "", statusHeartbeat, d)
+			if ctx.Err() != nil {
+				return
+			}
+			if d.Outcome == OutcomeInProcess {
+				if d, err = s.Check(ctx, storageKey, ""); err != nil {
+					return
+				}
+			}
+			if d.Outcome != OutcomeInProcess {
+				send(d)
+				return
+			}
+
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// StatusHandler returns a handler responding with the KeyStatus of a key as
+// JSON, or 404 Not Found for unknown keys. A key in process is held for up
+// to wait until it is finished, so that clients long-poll instead of
+// retrying in a tight loop, a zero wait responds immediately. The key is
+// returned by key. Like CompletionEventsHandler, keys of other owners are
+// reported as not found with WithOwner.
+func (s *State) StatusHandler(key StatusKeyFunc, wait time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		storageKey, ok := s.statusKey(w, r, key)
+		if !ok {
+			return
+		}
+		ctx := r.Context()
+
+		d, err := s.Check(ctx, storageKey, "")
+		if err == nil && !s.visible(r, d) {
+			d = Decision{}
+		}
+		if err == nil && d.Outcome == OutcomeInProcess && wait > 0 {
+			if d = s.await(ctx, storageKey, "", wait, d); d.Outcome == OutcomeInProcess && ctx.Err() == nil {
+				d, err = s.Check(ctx, storageKey, "")
+			}
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		ks := keyStatus(d)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if ks.State == "not_found" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		json.NewEncoder(w).Encode(ks)
+	})
+}
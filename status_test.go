@@ -0,0 +1,170 @@
+package idempotency
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompletionEventsHandler(t *testing.T) {
+	s := New(NewMemoryStorage(), WithResponseCapture(true))
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.Handle("POST /orders", s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Header().Set("Location", "/orders/1")
+		w.WriteHeader(http.StatusCreated)
+	})))
+	mux.Handle("GET /status/{key}", s.CompletionEventsHandler(func(r *http.Request) (string, string) {
+		return "", r.PathValue("key")
+	}))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	go func() {
+		req := httptest.NewRequest("POST", "http://example.com/orders", nil)
+		req.Header.Set(HeaderName, "key")
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	<-started
+
+	resp, err := http.Get(server.URL + "/status/key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("want event stream, got %q", got)
+	}
+
+	body := bufio.NewReader(resp.Body)
+	var first strings.Builder
+	for !strings.HasSuffix(first.String(), "\n\n") {
+		line, err := body.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		first.WriteString(line)
+	}
+	if want := "event: in_process\ndata: {\"state\":\"in_process\"}\n\n"; first.String() != want {
+		t.Errorf("want event %q, got %q", want, first.String())
+	}
+
+	close(release)
+	rest, _ := io.ReadAll(body)
+	if want := "event: completed\ndata: {\"state\":\"completed\",\"status_code\":201,\"location\":\"/orders/1\"}\n\n"; string(rest) != want {
+		t.Errorf("want event %q, got %q", want, rest)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/status/unknown", nil))
+	if got := w.Body.String(); !strings.HasPrefix(got, "event: not_found\n") {
+		t.Errorf("want not_found event, got %q", got)
+	}
+}
+
+func TestCompletionEventsHandlerOwner(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	s := New(storage, WithOwner(func(r *http.Request) string {
+		return r.Header.Get("X-User")
+	}))
+	storage.AddStatus(ctx, "POST /orders:key", &RequestStatus{Owner: "a", Response: &Response{StatusCode: http.StatusCreated}}, 0)
+	handler := s.CompletionEventsHandler(func(r *http.Request) (string, string) {
+		return "POST /orders", r.URL.Query().Get("key")
+	})
+
+	for user, want := range map[string]string{"a": "event: completed\n", "b": "event: not_found\n"} {
+		r := httptest.NewRequest("GET", "http://example.com/status?key=key", nil)
+		r.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if got := w.Body.String(); !strings.HasPrefix(got, want) {
+			t.Errorf("want %q for owner %s, got %q", want, user, got)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/status?key="+internalKey("abuse", "a"), nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("want reserved key rejected, got %v", w.Code)
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	s := New(storage)
+	storage.AddStatus(ctx, "in_process", &RequestStatus{InProcess: true}, 0)
+	storage.AddStatus(ctx, "completed", &RequestStatus{Response: &Response{StatusCode: http.StatusCreated}}, 0)
+	key := func(r *http.Request) (string, string) { return "", r.URL.Query().Get("key") }
+
+	tests := []struct {
+		name     string
+		key      string
+		wait     time.Duration
+		complete bool
+		wantCode int
+		want     KeyStatus
+	}{
+		{name: "Completed", key: "completed", wantCode: http.StatusOK, want: KeyStatus{State: "completed", StatusCode: http.StatusCreated}},
+		{name: "Unknown", key: "unknown", wantCode: http.StatusNotFound, want: KeyStatus{State: "not_found"}},
+		{name: "In process without wait", key: "in_process", wantCode: http.StatusOK, want: KeyStatus{State: "in_process"}},
+		{name: "In process until finished", key: "in_process", wait: time.Minute, complete: true, wantCode: http.StatusOK, want: KeyStatus{State: "completed"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			done := make(chan *httptest.ResponseRecorder)
+			go func() {
+				w := httptest.NewRecorder()
+				s.StatusHandler(key, test.wait).ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/status?key="+test.key, nil))
+				done <- w
+			}()
+			if test.complete {
+				time.Sleep(50 * time.Millisecond)
+				storage.UpdateStatus(ctx, test.key, &RequestStatus{})
+			}
+			w := <-done
+
+			var got KeyStatus
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != test.wantCode || !reflect.DeepEqual(got, test.want) {
+				t.Errorf("want %v %+v, got %v %+v", test.wantCode, test.want, w.Code, got)
+			}
+		})
+	}
+}
+
+func TestStatusHandlerOwner(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	s := New(storage, WithOwner(func(r *http.Request) string {
+		return r.Header.Get("X-User")
+	}))
+	storage.AddStatus(ctx, "POST /orders:key", &RequestStatus{Owner: "a", Response: &Response{StatusCode: http.StatusCreated}}, 0)
+	handler := s.StatusHandler(func(r *http.Request) (string, string) {
+		return "POST /orders", r.URL.Query().Get("key")
+	}, 0)
+
+	for user, want := range map[string]int{"a": http.StatusOK, "b": http.StatusNotFound} {
+		r := httptest.NewRequest("GET", "http://example.com/status?key=key", nil)
+		r.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("want %v for owner %s, got %v %s", want, user, w.Code, w.Body)
+		}
+	}
+}
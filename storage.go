@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,31 +20,179 @@ type Storage interface {
 	Complete(ctx context.Context, key string) error
 }
 
+// StatusStorage is implemented by storages that can persist the full
+// RequestStatus of a key and not only whether it is in process. The middleware
+// uses it when available to store fingerprints and per route expiries.
+type StatusStorage interface {
+	Storage
+	// AddStatus sets the key to status, if it was already set the return
+	// value will be false. A zero expiry uses the default of the storage.
+	AddStatus(ctx context.Context, key string, status *RequestStatus, expiry time.Duration) (bool, error)
+	// UpdateStatus replaces the status of an existing key, keeping its
+	// expiry. The status and its captured response must be written in a
+	// single operation, so that a key is never completed without its
+	// response.
+	UpdateStatus(ctx context.Context, key string, status *RequestStatus) error
+}
+
+// Deleter is implemented by storages that can delete keys, which is used to
+// release a key when a request failed so that it can be retried.
+type Deleter interface {
+	Delete(ctx context.Context, key string) error
+}
+
+// Counter is implemented by storages that can count events per key within a
+// time window, which is used to rate limit the creation of new keys.
+type Counter interface {
+	// Increment increments the counter of key and returns the new count.
+	// The counter is reset window after the first increment.
+	Increment(ctx context.Context, key string, window time.Duration) (int64, error)
+}
+
+// Reserver is implemented by storages that can add a key or get its
+// existing status atomically, which saves round trips when reserving keys.
+type Reserver interface {
+	StatusStorage
+	// AddOrGet sets the key to status and returns true if it was not set,
+	// otherwise it returns the existing status.
+	AddOrGet(ctx context.Context, key string, status *RequestStatus, expiry time.Duration) (*RequestStatus, bool, error)
+}
+
+// Expirer is implemented by storages that can change the expiry of keys,
+// which is used to extend reservations.
+type Expirer interface {
+	// Expire sets key to expire after expiry.
+	Expire(ctx context.Context, key string, expiry time.Duration) error
+}
+
+// Persister is implemented by storages that can remove the expiry of keys,
+// which is used to import keys exported without expiry.
+type Persister interface {
+	// Persist removes the expiry of key.
+	Persist(ctx context.Context, key string) error
+}
+
+// TTLReader is implemented by storages that can report how long keys are
+// retained, which is used to compute Retry-After values.
+type TTLReader interface {
+	// TTL returns the remaining time until key expires, zero if it does not
+	// expire, and false if the key does not exist.
+	TTL(ctx context.Context, key string) (time.Duration, bool, error)
+}
+
+// Scanner is implemented by storages that can iterate over their keys,
+// which is used to recover keys of instances that died.
+type Scanner interface {
+	// Scan calls fn for every stored key and its status, stopping at the
+	// first error of fn. Keys added or removed during the scan may be
+	// missed.
+	Scan(ctx context.Context, fn func(key string, status *RequestStatus) error) error
+}
+
+type memoryEntry struct {
+	status    RequestStatus
+	expiresAt time.Time
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+type memoryCounter struct {
+	count     int64
+	expiresAt time.Time
+}
+
 type memoryStorage struct {
-	storage map[string]*RequestStatus
-	mu      sync.RWMutex
+	storage  map[string]*memoryEntry
+	counters map[string]*memoryCounter
+	clock    Clock
+	mu       sync.RWMutex
+}
+
+// MemoryStorageOption is the signature for functional options for the memory
+// storage.
+type MemoryStorageOption func(*memoryStorage)
+
+// WithMemoryClock configures the Clock of expiries, it defaults to
+// SystemClock.
+func WithMemoryClock(c Clock) MemoryStorageOption {
+	return func(m *memoryStorage) {
+		m.clock = c
+	}
 }
 
 // NewMemoryStorage creates a memory storage for Idempotency-Keys to be able
 // to provide stateful functionality.
-func NewMemoryStorage() *memoryStorage {
-	return &memoryStorage{
-		storage: make(map[string]*RequestStatus),
+func NewMemoryStorage(opts ...MemoryStorageOption) *memoryStorage {
+	m := &memoryStorage{
+		storage:  make(map[string]*memoryEntry),
+		counters: make(map[string]*memoryCounter),
+		clock:    SystemClock,
 	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(m)
+		}
+	}
+
+	return m
 }
 
 // Add inserts the initial state of a request with an idempotency key.
 func (m *memoryStorage) Add(ctx context.Context, key string) (bool, error) {
+	return m.AddStatus(ctx, key, &RequestStatus{InProcess: true}, 0)
+}
+
+// AddStatus inserts status for an idempotency key, expiring it after expiry
+// unless expiry is zero.
+func (m *memoryStorage) AddStatus(ctx context.Context, key string, status *RequestStatus, expiry time.Duration) (bool, error) {
+	_, added, err := m.AddOrGet(ctx, key, status, expiry)
+	return added, err
+}
+
+// AddOrGet inserts status for an idempotency key, expiring it after expiry
+// unless expiry is zero. If the key exists its status is returned instead.
+func (m *memoryStorage) AddOrGet(ctx context.Context, key string, status *RequestStatus, expiry time.Duration) (*RequestStatus, bool, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	_, ok := m.storage[key]
-	if ok {
-		return false, nil
+	now := m.clock.Now()
+	e, ok := m.storage[key]
+	if ok && !e.expired(now) {
+		existing := e.status
+		return &existing, false, nil
 	}
 
-	m.storage[key] = &RequestStatus{InProcess: true}
-	return true, nil
+	e = &memoryEntry{status: *status}
+	if expiry > 0 {
+		e.expiresAt = now.Add(expiry)
+	}
+	m.storage[key] = e
+	return nil, true, nil
+}
+
+// Purge removes expired keys and counters, which are otherwise only
+// replaced when they are added again.
+func (m *memoryStorage) Purge(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	n := 0
+	for key, e := range m.storage {
+		if e.expired(now) {
+			delete(m.storage, key)
+			n++
+		}
+	}
+	for key, c := range m.counters {
+		if !now.Before(c.expiresAt) {
+			delete(m.counters, key)
+		}
+	}
+	return n, nil
 }
 
 // Get fetches the RequestStatus for an idempotency key.
@@ -51,25 +200,155 @@ func (m *memoryStorage) Get(ctx context.Context, key string) (*RequestStatus, er
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	return m.storage[key], nil
+	e, ok := m.storage[key]
+	if !ok || e.expired(m.clock.Now()) {
+		return nil, nil
+	}
+
+	status := e.status
+	return &status, nil
 }
 
 // Complete sets a request to not be in progress, it is then determined to be
 // completed and that we should serve the result we got from a previous
 // request.
 func (m *memoryStorage) Complete(ctx context.Context, key string) error {
+	return m.UpdateStatus(ctx, key, &RequestStatus{InProcess: false})
+}
+
+// UpdateStatus replaces the RequestStatus of an idempotency key, keeping its
+// expiry. Missing and expired keys are not written, like the XX of Redis.
+func (m *memoryStorage) UpdateStatus(ctx context.Context, key string, status *RequestStatus) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.storage[key] = &RequestStatus{InProcess: false}
+	e, ok := m.storage[key]
+	if !ok || e.expired(m.clock.Now()) {
+		return nil
+	}
+	e.status = *status
 
 	return nil
 }
 
+// Delete removes an idempotency key.
+func (m *memoryStorage) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.storage, key)
+
+	return nil
+}
+
+// Expire sets an idempotency key to expire after expiry.
+func (m *memoryStorage) Expire(ctx context.Context, key string, expiry time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.storage[key]
+	if !ok {
+		return nil
+	}
+	e.expiresAt = m.clock.Now().Add(expiry)
+
+	return nil
+}
+
+// Persist removes the expiry of an idempotency key.
+func (m *memoryStorage) Persist(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.storage[key]
+	if !ok || e.expired(m.clock.Now()) {
+		return nil
+	}
+	e.expiresAt = time.Time{}
+
+	return nil
+}
+
+// TTL returns the remaining time until an idempotency key expires.
+func (m *memoryStorage) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := m.clock.Now()
+	e, ok := m.storage[key]
+	if !ok || e.expired(now) {
+		return 0, false, nil
+	}
+	if e.expiresAt.IsZero() {
+		return 0, true, nil
+	}
+	return e.expiresAt.Sub(now), true, nil
+}
+
+// Scan calls fn for every idempotency key and its status.
+func (m *memoryStorage) Scan(ctx context.Context, fn func(key string, status *RequestStatus) error) error {
+	m.mu.RLock()
+	now := m.clock.Now()
+	statuses := make(map[string]RequestStatus, len(m.storage))
+	for key, e := range m.storage {
+		if !e.expired(now) {
+			statuses[key] = e.status
+		}
+	}
+	m.mu.RUnlock()
+
+	for key, status := range statuses {
+		if err := fn(key, &status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ping always succeeds, the memory storage has no backend.
+func (m *memoryStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+// DeleteByOwner removes all idempotency keys of owner.
+func (m *memoryStorage) DeleteByOwner(ctx context.Context, owner string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for key, e := range m.storage {
+		if e.status.Owner == owner {
+			delete(m.storage, key)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// Increment increments the counter of key within window.
+func (m *memoryStorage) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	c, ok := m.counters[key]
+	if !ok || !now.Before(c.expiresAt) {
+		c = &memoryCounter{expiresAt: now.Add(window)}
+		m.counters[key] = c
+	}
+	c.count++
+
+	return c.count, nil
+}
+
 type redisStorage struct {
-	client    redis.UniversalClient
-	expiry    time.Duration
-	keyPrefix string
+	client       redis.UniversalClient
+	expiry       time.Duration
+	keyPrefix    string
+	waitReplicas int
+	waitTimeout  time.Duration
+	primaryReads bool
 }
 
 // RedisStorageOption is the signature for functional options for the Redis
@@ -82,6 +361,29 @@ func WithKeyPrefix(prefix string) RedisStorageOption {
 	}
 }
 
+// WithReplicaWait makes reservations and completions wait until at least
+// replicas replicas acknowledged them, using WAIT with timeout, so that a
+// failover or a read from a replica right after the write cannot miss the
+// key and execute a request twice. Writes which are not acknowledged in time
+// return an error, and are not rolled back. The timeout should be below the
+// read timeout of the client.
+func WithReplicaWait(replicas int, timeout time.Duration) RedisStorageOption {
+	return func(rs *redisStorage) {
+		rs.waitReplicas = replicas
+		rs.waitTimeout = timeout
+	}
+}
+
+// WithPrimaryReads configures whether keys are read with a script, which
+// clients routing read-only commands to replicas, e.g. a cluster client with
+// ReadOnly, send to the primary. Reservations are always checked on the
+// primary, this covers the other reads such as completing keys.
+func WithPrimaryReads(enabled bool) RedisStorageOption {
+	return func(rs *redisStorage) {
+		rs.primaryReads = enabled
+	}
+}
+
 // NewRedisStorage creates a Redis storage for Idempotency-Keys to be able
 // to provide a distributed state of the keys.
 func NewRedisStorage(client redis.UniversalClient, expiry time.Duration, opts ...RedisStorageOption) *redisStorage {
@@ -102,39 +404,361 @@ func NewRedisStorage(client redis.UniversalClient, expiry time.Duration, opts ..
 
 // Add inserts the initial state of a request with an idempotency key.
 func (s *redisStorage) Add(ctx context.Context, key string) (bool, error) {
-	// We use SETNX in order to handle a race condition where the keys can be
-	// checked by two processes and find that they do not exist, after which both
-	// try to write the key.
-	res, err := s.client.SetNX(ctx, s.keyPrefix+key, "in-process", s.expiry).Result()
+	return s.AddStatus(ctx, key, &RequestStatus{InProcess: true}, 0)
+}
+
+// AddStatus inserts status for an idempotency key, expiring it after expiry
+// or the expiry of the storage if zero.
+func (s *redisStorage) AddStatus(ctx context.Context, key string, status *RequestStatus, expiry time.Duration) (bool, error) {
+	_, added, err := s.AddOrGet(ctx, key, status, expiry)
+	return added, err
+}
+
+// addScript sets a key unless it exists, returning the existing value. It
+// replaces a GET followed by a SETNX with a single atomic round trip.
+var addScript = redis.NewScript(`
+local existing = redis.call("GET", KEYS[1])
+if existing then
+	return existing
+end
+local expiry = tonumber(ARGV[2])
+if expiry > 0 then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", expiry)
+else
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+return false
+`)
+
+// AddOrGet inserts status for an idempotency key, expiring it after expiry
+// or the expiry of the storage if zero. If the key exists its status is
+// returned instead.
+func (s *redisStorage) AddOrGet(ctx context.Context, key string, status *RequestStatus, expiry time.Duration) (*RequestStatus, bool, error) {
+	if expiry == 0 {
+		expiry = s.expiry
+	}
+
+	value, err := MarshalStatus(status)
 	if err != nil {
-		return false, fmt.Errorf("failed to set the key %q in redis: %w", key, err)
+		return nil, false, fmt.Errorf("failed to encode the status of key %q: %w", key, err)
+	}
+
+	// The script handles the race condition where the key is checked by two
+	// processes and found not to exist, after which both try to write it.
+	keys := []string{s.keyPrefix + key}
+	var res string
+	if s.waitReplicas > 0 {
+		var cmd *redis.Cmd
+		err = s.replicated(ctx, func(pipe redis.Pipeliner) {
+			cmd = addScript.Eval(ctx, pipe, keys, value, expiry.Milliseconds())
+		})
+		if err == nil {
+			res, err = cmd.Text()
+		}
+	} else {
+		res, err = addScript.Run(ctx, s.client, keys, value, expiry.Milliseconds()).Text()
+	}
+	if err == nil {
+		existing, err := s.decode(key, res)
+		return existing, false, err
+	}
+	if !errors.Is(err, redis.Nil) {
+		return nil, false, fmt.Errorf("failed to set the key %q in redis: %w", key, err)
+	}
+
+	// The index is written separately, as it may be in another slot of a
+	// cluster. A key which cannot be indexed is released, so that it is not
+	// left in process by a reservation which failed.
+	if status.Owner != "" {
+		err := indexOwnerScript.Run(ctx, s.client, []string{s.ownerKey(status.Owner)}, key, expiry.Milliseconds()).Err()
+		if err != nil {
+			if derr := s.client.Del(ctx, s.keyPrefix+key).Err(); derr != nil {
+				err = errors.Join(err, derr)
+			}
+			return nil, false, fmt.Errorf("failed to index the key %q in redis: %w", key, err)
+		}
+	}
+	return nil, true, nil
+}
+
+// getScript reads a key, as a script it is sent to the primary.
+var getScript = redis.NewScript(`return redis.call("GET", KEYS[1])`)
+
+// replicated runs the commands queued by write in a pipeline followed by
+// WAIT, so that it waits for the writes of its connection.
+func (s *redisStorage) replicated(ctx context.Context, write func(pipe redis.Pipeliner)) error {
+	var wait *redis.Cmd
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		write(pipe)
+		wait = pipe.Do(ctx, "WAIT", s.waitReplicas, s.waitTimeout.Milliseconds())
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
 	}
 
-	// res - true = the key was actually set
-	return res, nil
+	n, err := wait.Int()
+	if err != nil {
+		return fmt.Errorf("failed to wait for replicas: %w", err)
+	}
+	if n < s.waitReplicas {
+		return fmt.Errorf("only %d of %d replicas acknowledged the write", n, s.waitReplicas)
+	}
+	return nil
 }
 
 // Get fetches the RequestStatus for an idempotency key.
 func (s *redisStorage) Get(ctx context.Context, key string) (*RequestStatus, error) {
-	res, err := s.client.Get(ctx, s.keyPrefix+key).Result()
+	var res string
+	var err error
+	if s.primaryReads {
+		res, err = getScript.Run(ctx, s.client, []string{s.keyPrefix + key}).Text()
+	} else {
+		res, err = s.client.Get(ctx, s.keyPrefix+key).Result()
+	}
 	if errors.Is(err, redis.Nil) {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get the key %q from redis: %w", key, err)
 	}
-	return &RequestStatus{
-		InProcess: res == "in-process",
-	}, nil
+	return s.decode(key, res)
+}
+
+// decode decodes the stored value of key.
+func (s *redisStorage) decode(key, res string) (*RequestStatus, error) {
+	// Keys written by earlier versions only hold the plain state.
+	switch res {
+	case "in-process":
+		return &RequestStatus{InProcess: true}, nil
+	case "done":
+		return &RequestStatus{InProcess: false}, nil
+	}
+
+	status, err := UnmarshalStatus([]byte(res))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the key %q from redis: %w", key, err)
+	}
+	return status, nil
 }
 
 // Complete sets a request to not be in progress, it is then determined to be
 // completed and that we should serve the result we got from a previous
 // request.
 func (s *redisStorage) Complete(ctx context.Context, key string) error {
-	_, err := s.client.Set(ctx, s.keyPrefix+key, "done", redis.KeepTTL).Result()
+	return s.UpdateStatus(ctx, key, &RequestStatus{InProcess: false})
+}
+
+// UpdateStatus replaces the RequestStatus of an idempotency key, keeping its
+// expiry. The captured response is part of the stored value, so completing a
+// key is a single SET.
+func (s *redisStorage) UpdateStatus(ctx context.Context, key string, status *RequestStatus) error {
+	value, err := MarshalStatus(status)
 	if err != nil {
+		return fmt.Errorf("failed to encode the status of key %q: %w", key, err)
+	}
+
+	// Keys which expired before they were updated are not recreated, as they
+	// would never expire.
+	args := redis.SetArgs{Mode: "XX", KeepTTL: true}
+	if s.waitReplicas > 0 {
+		err = s.replicated(ctx, func(pipe redis.Pipeliner) {
+			pipe.SetArgs(ctx, s.keyPrefix+key, value, args)
+		})
+	} else {
+		err = s.client.SetArgs(ctx, s.keyPrefix+key, value, args).Err()
+	}
+	if err != nil && !errors.Is(err, redis.Nil) {
 		return fmt.Errorf("failed to update the key %q in redis: %w", key, err)
 	}
 	return nil
 }
+
+// UpdateStatuses replaces the RequestStatus of several existing idempotency
+// keys, keeping their expiry, in one pipeline.
+func (s *redisStorage) UpdateStatuses(ctx context.Context, statuses map[string]*RequestStatus) error {
+	cmds, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, status := range statuses {
+			value, err := MarshalStatus(status)
+			if err != nil {
+				return fmt.Errorf("failed to encode the status of key %q: %w", key, err)
+			}
+			// Keys which expired before the flush are not recreated.
+			pipe.SetArgs(ctx, s.keyPrefix+key, value, redis.SetArgs{Mode: "XX", KeepTTL: true})
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("failed to update keys in redis: %w", err)
+	}
+	// Keys which were not recreated report redis.Nil.
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil && !errors.Is(err, redis.Nil) {
+			return fmt.Errorf("failed to update keys in redis: %w", err)
+		}
+	}
+	return nil
+}
+
+// Delete removes an idempotency key.
+func (s *redisStorage) Delete(ctx context.Context, key string) error {
+	err := s.client.Del(ctx, s.keyPrefix+key).Err()
+	if err != nil {
+		return fmt.Errorf("failed to delete the key %q from redis: %w", key, err)
+	}
+	return nil
+}
+
+// Expire sets an idempotency key to expire after expiry.
+func (s *redisStorage) Expire(ctx context.Context, key string, expiry time.Duration) error {
+	err := s.client.PExpire(ctx, s.keyPrefix+key, expiry).Err()
+	if err != nil {
+		return fmt.Errorf("failed to expire the key %q in redis: %w", key, err)
+	}
+	return nil
+}
+
+// Persist removes the expiry of an idempotency key.
+func (s *redisStorage) Persist(ctx context.Context, key string) error {
+	err := s.client.Persist(ctx, s.keyPrefix+key).Err()
+	if err != nil {
+		return fmt.Errorf("failed to persist the key %q in redis: %w", key, err)
+	}
+	return nil
+}
+
+// TTL returns the remaining time until an idempotency key expires.
+func (s *redisStorage) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	ttl, err := s.client.PTTL(ctx, s.keyPrefix+key).Result()
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get the expiry of key %q from redis: %w", key, err)
+	}
+
+	// PTTL replies -2 for missing keys and -1 for keys without expiry.
+	switch ttl {
+	case -2:
+		return 0, false, nil
+	case -1:
+		return 0, true, nil
+	}
+	return ttl, true, nil
+}
+
+// Scan calls fn for every idempotency key and its status. Values which are
+// not statuses, like counters and owner indexes, are skipped.
+func (s *redisStorage) Scan(ctx context.Context, fn func(key string, status *RequestStatus) error) error {
+	iter := s.client.Scan(ctx, 0, s.keyPrefix+"*", 100).Iterator()
+
+	batch := make([]string, 0, 100)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		defer func() { batch = batch[:0] }()
+
+		values, err := s.client.MGet(ctx, batch...).Result()
+		if err != nil {
+			return fmt.Errorf("failed to scan keys in redis: %w", err)
+		}
+		for i, v := range values {
+			value, ok := v.(string)
+			if !ok || !strings.HasPrefix(value, "{") {
+				continue
+			}
+
+			key := strings.TrimPrefix(batch[i], s.keyPrefix)
+			status, err := s.decode(key, value)
+			if err != nil {
+				return err
+			}
+			if err := fn(key, status); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == cap(batch) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to scan keys in redis: %w", err)
+	}
+	return flush()
+}
+
+// Ping checks that Redis is reachable.
+func (s *redisStorage) Ping(ctx context.Context) error {
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("failed to ping redis: %w", err)
+	}
+	return nil
+}
+
+// indexOwnerScript adds a key to the index of its owner, extending the expiry
+// of the index so that it outlives all of its keys.
+var indexOwnerScript = redis.NewScript(`
+local ttl = redis.call("PTTL", KEYS[1])
+redis.call("SADD", KEYS[1], ARGV[1])
+local expiry = tonumber(ARGV[2])
+if expiry <= 0 then
+	redis.call("PERSIST", KEYS[1])
+elseif ttl ~= -1 and ttl < expiry then
+	redis.call("PEXPIRE", KEYS[1], expiry)
+end
+return 1
+`)
+
+// ownerKey returns the key of the index of the keys of owner.
+func (s *redisStorage) ownerKey(owner string) string {
+	return s.keyPrefix + internalKey("owner", owner)
+}
+
+// DeleteByOwner removes all idempotency keys of owner and the index of them.
+func (s *redisStorage) DeleteByOwner(ctx context.Context, owner string) (int, error) {
+	keys, err := s.client.SMembers(ctx, s.ownerKey(owner)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the keys of owner %q from redis: %w", owner, err)
+	}
+
+	fullKeys := make([]string, 0, len(keys)+1)
+	for _, key := range keys {
+		fullKeys = append(fullKeys, s.keyPrefix+key)
+	}
+	fullKeys = append(fullKeys, s.ownerKey(owner))
+
+	n, err := s.client.Del(ctx, fullKeys...).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete the keys of owner %q from redis: %w", owner, err)
+	}
+
+	// Don't count the index, keys which expired are not counted either.
+	if len(keys) > 0 {
+		n--
+	}
+	return int(n), nil
+}
+
+// incrementScript increments a counter and starts its window if it has
+// none, atomically so that a counter is never left without an expiry.
+var incrementScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if redis.call("PTTL", KEYS[1]) == -1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// Increment increments the counter of key within window.
+func (s *redisStorage) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	count, err := incrementScript.Run(ctx, s.client, []string{s.keyPrefix + key}, window.Milliseconds()).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment the key %q in redis: %w", key, err)
+	}
+	return count, nil
+}
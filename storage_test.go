@@ -0,0 +1,139 @@
+package idempotency
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisStorageConsistency(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		opts    []RedisStorageOption
+		wantErr string
+	}{
+		{name: "Default"},
+		{name: "Primary reads", opts: []RedisStorageOption{WithPrimaryReads(true)}},
+		// miniredis has no replicas to acknowledge the writes.
+		{name: "Replica wait", opts: []RedisStorageOption{WithReplicaWait(1, 10*time.Millisecond)}, wantErr: "only 0 of 1 replicas"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			storage := NewRedisStorage(redis.NewClient(&redis.Options{Addr: mr.Addr()}), time.Hour, test.opts...)
+
+			_, added, err := storage.AddOrGet(ctx, "key", &RequestStatus{InProcess: true}, 0)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("want error %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil || !added {
+				t.Fatalf("want key added, got %v, %v", added, err)
+			}
+
+			if err := storage.UpdateStatus(ctx, "key", &RequestStatus{Fingerprint: "abc"}); err != nil {
+				t.Fatalf("want key updated, got %v", err)
+			}
+			status, err := storage.Get(ctx, "key")
+			if err != nil || status == nil || status.Fingerprint != "abc" {
+				t.Errorf("want updated status, got %+v, %v", status, err)
+			}
+			if status, err := storage.Get(ctx, "missing"); err != nil || status != nil {
+				t.Errorf("want missing key, got %+v, %v", status, err)
+			}
+		})
+	}
+}
+
+func TestRedisStorageOwnerIndexError(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	storage := NewRedisStorage(redis.NewClient(&redis.Options{Addr: mr.Addr()}), time.Hour)
+
+	// An index of the wrong type makes indexing fail.
+	mr.Set(storage.ownerKey("a"), "x")
+
+	_, added, err := storage.AddOrGet(ctx, "key", &RequestStatus{InProcess: true, Owner: "a"}, 0)
+	if err == nil || added {
+		t.Fatalf("want indexing error, got %v, %v", added, err)
+	}
+	if status, err := storage.Get(ctx, "key"); err != nil || status != nil {
+		t.Errorf("want key released, got %+v, %v", status, err)
+	}
+}
+
+func TestRedisStorageIncrement(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	storage := NewRedisStorage(redis.NewClient(&redis.Options{Addr: mr.Addr()}), time.Hour)
+
+	// A counter left without a window gets one.
+	mr.Set(storage.keyPrefix+"counter", "1")
+
+	for want := int64(2); want <= 3; want++ {
+		n, err := storage.Increment(ctx, "counter", time.Minute)
+		if err != nil || n != want {
+			t.Fatalf("want %d, got %d, %v", want, n, err)
+		}
+	}
+	if ttl := mr.TTL(storage.keyPrefix + "counter"); ttl != time.Minute {
+		t.Errorf("want the window started, got a TTL of %v", ttl)
+	}
+
+	mr.FastForward(time.Minute)
+	if n, err := storage.Increment(ctx, "counter", time.Minute); err != nil || n != 1 {
+		t.Errorf("want the counter reset after its window, got %d, %v", n, err)
+	}
+}
+
+func TestMemoryStorageUpdateStatus(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Now())
+	storage := NewMemoryStorage(WithMemoryClock(clock))
+
+	storage.AddStatus(ctx, "expired", &RequestStatus{InProcess: true}, time.Minute)
+	clock.Advance(time.Minute)
+
+	// Late completions do not bring back keys, which would never expire.
+	for _, key := range []string{"expired", "missing"} {
+		if err := storage.UpdateStatus(ctx, key, &RequestStatus{}); err != nil {
+			t.Fatalf("want no error, got %v", err)
+		}
+		clock.Advance(time.Hour)
+		if status, _ := storage.Get(ctx, key); status != nil {
+			t.Errorf("want key %s not written, got %+v", key, status)
+		}
+	}
+}
+
+func TestRedisStorageUpdateStatus(t *testing.T) {
+	ctx := context.Background()
+
+	for _, opts := range [][]RedisStorageOption{nil, {WithReplicaWait(1, 0)}} {
+		mr := miniredis.RunT(t)
+		storage := NewRedisStorage(redis.NewClient(&redis.Options{Addr: mr.Addr()}), time.Hour, opts...)
+
+		storage.AddStatus(ctx, "expired", &RequestStatus{InProcess: true}, time.Minute)
+		mr.FastForward(time.Minute)
+
+		// Late completions do not bring back keys, which would never expire.
+		for _, key := range []string{"expired", "missing"} {
+			err := storage.UpdateStatus(ctx, key, &RequestStatus{})
+			if err != nil && !strings.Contains(err.Error(), "replicas") {
+				t.Fatalf("want no error, got %v", err)
+			}
+			if mr.Exists(storage.keyPrefix + key) {
+				t.Errorf("want key %s not written", key)
+			}
+		}
+	}
+}
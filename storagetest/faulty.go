@@ -0,0 +1,354 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// Op names a storage operation.
+type Op string
+
+// The operations of the storage interfaces.
+const (
+	OpAdd           Op = "Add"
+	OpGet           Op = "Get"
+	OpComplete      Op = "Complete"
+	OpAddStatus     Op = "AddStatus"
+	OpUpdateStatus  Op = "UpdateStatus"
+	OpDelete        Op = "Delete"
+	OpIncrement     Op = "Increment"
+	OpExpire        Op = "Expire"
+	OpDeleteByOwner Op = "DeleteByOwner"
+	OpTTL           Op = "TTL"
+	OpPing          Op = "Ping"
+	OpScan          Op = "Scan"
+	OpPurge         Op = "Purge"
+)
+
+// ErrInjected is the default error of a Fault.
+var ErrInjected = errors.New("storagetest: injected fault")
+
+// Fault describes a failure injected into calls of a FaultyStorage.
+type Fault struct {
+	// Ops are the operations the fault applies to, all operations if empty.
+	Ops []Op
+	// Err is returned instead of calling the storage. If Err and Drop are
+	// both unset, ErrInjected is returned unless Latency is set.
+	Err error
+	// Drop skips calling the storage and reports success, e.g. to lose
+	// completions with OpComplete and OpUpdateStatus.
+	Drop bool
+	// Latency delays the call, or returns the error of the context if it is
+	// done first.
+	Latency time.Duration
+	// Every applies the fault to every nth matching call, starting with the
+	// nth, and to every call if zero.
+	Every int
+	// Probability applies the fault to a matching call with the given
+	// probability, and to every call if zero.
+	Probability float64
+}
+
+// FaultyStorage is an idempotency.Storage decorator injecting faults into
+// calls of the wrapped storage, to test how applications handle storage
+// failures. It implements all optional storage interfaces and returns
+// errors.ErrUnsupported for the ones the wrapped storage does not implement,
+// so it should wrap a storage implementing all of them, like the memory
+// storage.
+type FaultyStorage struct {
+	storage idempotency.Storage
+
+	mu     sync.Mutex
+	faults []Fault
+	calls  map[Op]int
+	rand   *rand.Rand
+	clock  idempotency.Clock
+}
+
+// FaultOption is the functional option signature for configuring the
+// FaultyStorage.
+type FaultOption func(*FaultyStorage)
+
+// WithFault adds a fault, faults are checked in the order they are added and
+// the first applying fault is injected.
+func WithFault(f Fault) FaultOption {
+	return func(s *FaultyStorage) {
+		s.faults = append(s.faults, f)
+	}
+}
+
+// WithSeed seeds the random source of probabilistic faults, it defaults to 1
+// so that runs are deterministic.
+func WithSeed(seed int64) FaultOption {
+	return func(s *FaultyStorage) {
+		s.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithClock configures the Clock of injected latency, it defaults to
+// idempotency.SystemClock.
+func WithClock(c idempotency.Clock) FaultOption {
+	return func(s *FaultyStorage) {
+		s.clock = c
+	}
+}
+
+// NewFaultyStorage creates a FaultyStorage wrapping storage.
+func NewFaultyStorage(storage idempotency.Storage, opts ...FaultOption) *FaultyStorage {
+	s := &FaultyStorage{
+		storage: storage,
+		calls:   make(map[Op]int),
+		rand:    rand.New(rand.NewSource(1)),
+		clock:   idempotency.SystemClock,
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(s)
+		}
+	}
+
+	return s
+}
+
+// Inject adds faults, like WithFault.
+func (s *FaultyStorage) Inject(faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = append(s.faults, faults...)
+}
+
+// Reset removes all faults and resets the call counts.
+func (s *FaultyStorage) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = nil
+	s.calls = make(map[Op]int)
+}
+
+// Calls returns how many times op was called.
+func (s *FaultyStorage) Calls(op Op) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls[op]
+}
+
+// fault counts a call of op and returns the fault to inject, if any.
+func (s *FaultyStorage) fault(op Op) (Fault, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls[op]++
+	n := s.calls[op]
+
+	for _, f := range s.faults {
+		if len(f.Ops) > 0 && !containsOp(f.Ops, op) {
+			continue
+		}
+		if f.Every > 0 && n%f.Every != 0 {
+			continue
+		}
+		if f.Probability > 0 && s.rand.Float64() >= f.Probability {
+			continue
+		}
+		return f, true
+	}
+	return Fault{}, false
+}
+
+func containsOp(ops []Op, op Op) bool {
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// inject applies the fault for op, if any. It returns whether the call
+// should be skipped and the error to return.
+func (s *FaultyStorage) inject(ctx context.Context, op Op) (bool, error) {
+	f, ok := s.fault(op)
+	if !ok {
+		return false, nil
+	}
+
+	if f.Latency > 0 {
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case <-s.clock.After(f.Latency):
+		}
+	}
+
+	switch {
+	case f.Err != nil:
+		return true, f.Err
+	case f.Drop:
+		return true, nil
+	case f.Latency == 0:
+		return true, ErrInjected
+	}
+	return false, nil
+}
+
+// Add implements idempotency.Storage.
+func (s *FaultyStorage) Add(ctx context.Context, key string) (bool, error) {
+	if skip, err := s.inject(ctx, OpAdd); skip {
+		return err == nil, err
+	}
+	return s.storage.Add(ctx, key)
+}
+
+// Get implements idempotency.Storage.
+func (s *FaultyStorage) Get(ctx context.Context, key string) (*idempotency.RequestStatus, error) {
+	if skip, err := s.inject(ctx, OpGet); skip {
+		return nil, err
+	}
+	return s.storage.Get(ctx, key)
+}
+
+// Complete implements idempotency.Storage.
+func (s *FaultyStorage) Complete(ctx context.Context, key string) error {
+	if skip, err := s.inject(ctx, OpComplete); skip {
+		return err
+	}
+	return s.storage.Complete(ctx, key)
+}
+
+// AddStatus implements idempotency.StatusStorage.
+func (s *FaultyStorage) AddStatus(ctx context.Context, key string, status *idempotency.RequestStatus, expiry time.Duration) (bool, error) {
+	ss, ok := s.storage.(idempotency.StatusStorage)
+	if !ok {
+		return false, errors.ErrUnsupported
+	}
+	if skip, err := s.inject(ctx, OpAddStatus); skip {
+		return err == nil, err
+	}
+	return ss.AddStatus(ctx, key, status, expiry)
+}
+
+// UpdateStatus implements idempotency.StatusStorage.
+func (s *FaultyStorage) UpdateStatus(ctx context.Context, key string, status *idempotency.RequestStatus) error {
+	ss, ok := s.storage.(idempotency.StatusStorage)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	if skip, err := s.inject(ctx, OpUpdateStatus); skip {
+		return err
+	}
+	return ss.UpdateStatus(ctx, key, status)
+}
+
+// Delete implements idempotency.Deleter.
+func (s *FaultyStorage) Delete(ctx context.Context, key string) error {
+	d, ok := s.storage.(idempotency.Deleter)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	if skip, err := s.inject(ctx, OpDelete); skip {
+		return err
+	}
+	return d.Delete(ctx, key)
+}
+
+// Increment implements idempotency.Counter.
+func (s *FaultyStorage) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	c, ok := s.storage.(idempotency.Counter)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	if skip, err := s.inject(ctx, OpIncrement); skip {
+		return 0, err
+	}
+	return c.Increment(ctx, key, window)
+}
+
+// Expire implements idempotency.Expirer.
+func (s *FaultyStorage) Expire(ctx context.Context, key string, expiry time.Duration) error {
+	e, ok := s.storage.(idempotency.Expirer)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	if skip, err := s.inject(ctx, OpExpire); skip {
+		return err
+	}
+	return e.Expire(ctx, key, expiry)
+}
+
+// DeleteByOwner implements idempotency.OwnerDeleter.
+func (s *FaultyStorage) DeleteByOwner(ctx context.Context, owner string) (int, error) {
+	d, ok := s.storage.(idempotency.OwnerDeleter)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	if skip, err := s.inject(ctx, OpDeleteByOwner); skip {
+		return 0, err
+	}
+	return d.DeleteByOwner(ctx, owner)
+}
+
+// TTL implements idempotency.TTLReader.
+func (s *FaultyStorage) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	t, ok := s.storage.(idempotency.TTLReader)
+	if !ok {
+		return 0, false, errors.ErrUnsupported
+	}
+	if skip, err := s.inject(ctx, OpTTL); skip {
+		return 0, false, err
+	}
+	return t.TTL(ctx, key)
+}
+
+// Ping implements idempotency.Pinger.
+func (s *FaultyStorage) Ping(ctx context.Context) error {
+	p, ok := s.storage.(idempotency.Pinger)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	if skip, err := s.inject(ctx, OpPing); skip {
+		return err
+	}
+	return p.Ping(ctx)
+}
+
+// Scan implements idempotency.Scanner.
+func (s *FaultyStorage) Scan(ctx context.Context, fn func(key string, status *idempotency.RequestStatus) error) error {
+	sc, ok := s.storage.(idempotency.Scanner)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	if skip, err := s.inject(ctx, OpScan); skip {
+		return err
+	}
+	return sc.Scan(ctx, fn)
+}
+
+// Purge implements idempotency.Purger.
+func (s *FaultyStorage) Purge(ctx context.Context) (int, error) {
+	p, ok := s.storage.(idempotency.Purger)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	if skip, err := s.inject(ctx, OpPurge); skip {
+		return 0, err
+	}
+	return p.Purge(ctx)
+}
+
+// Capabilities implements idempotency.CapabilityReporter with the
+// capabilities of the wrapped storage.
+func (s *FaultyStorage) Capabilities() idempotency.Capabilities {
+	c := idempotency.StorageCapabilities(s.storage)
+	c.Reserver = false
+	c.BatchUpdater = false
+	return c
+}
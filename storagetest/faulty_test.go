@@ -0,0 +1,51 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+func TestFaultyStorageWithoutFaults(t *testing.T) {
+	RunConformanceTests(t, func(t *testing.T) idempotency.Storage {
+		return NewFaultyStorage(idempotency.NewMemoryStorage())
+	})
+}
+
+func TestFaultyStorage(t *testing.T) {
+	ctx := context.Background()
+	storage := NewFaultyStorage(idempotency.NewMemoryStorage(),
+		WithFault(Fault{Ops: []Op{OpGet}, Every: 2}),
+	)
+
+	for i, wantErr := range []bool{false, true, false, true} {
+		_, err := storage.Get(ctx, "key")
+		if got := errors.Is(err, ErrInjected); got != wantErr {
+			t.Errorf("call %d: want injected error %v, got %v", i+1, wantErr, err)
+		}
+	}
+	if n := storage.Calls(OpGet); n != 4 {
+		t.Errorf("want 4 calls, got %d", n)
+	}
+
+	// Dropped completions leave the key in process.
+	storage.Reset()
+	storage.Inject(Fault{Ops: []Op{OpComplete, OpUpdateStatus}, Drop: true})
+
+	handler := idempotency.New(storage).Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, want := range []int{http.StatusOK, http.StatusConflict} {
+		req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+		req.Header.Set(idempotency.HeaderName, "key")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != want {
+			t.Errorf("want status code %v, got %v", want, w.Code)
+		}
+	}
+}
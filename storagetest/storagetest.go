@@ -0,0 +1,464 @@
+// Package storagetest provides conformance tests for implementations of
+// idempotency.Storage, so that third party storages can verify that they
+// behave like the storages of this module.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+// Option is the functional option signature for configuring the conformance
+// tests.
+type Option func(*config)
+
+type config struct {
+	sleep func(d time.Duration)
+}
+
+// WithSleep configures how the tests wait for keys to expire, it defaults to
+// time.Sleep. It allows testing storages with a fake clock, e.g. with the
+// FastForward of miniredis.
+func WithSleep(sleep func(d time.Duration)) Option {
+	return func(c *config) {
+		c.sleep = sleep
+	}
+}
+
+// RunConformanceTests runs the conformance tests against storages created by
+// newStorage, which is called once per test and must return an empty storage.
+// Tests of optional interfaces such as idempotency.StatusStorage are skipped
+// when the storage does not implement them.
+func RunConformanceTests(t *testing.T, newStorage func(t *testing.T) idempotency.Storage, opts ...Option) {
+	c := &config{sleep: time.Sleep}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(c)
+		}
+	}
+
+	t.Run("AddGetComplete", func(t *testing.T) { testAddGetComplete(t, newStorage(t)) })
+	t.Run("ConcurrentAdd", func(t *testing.T) { testConcurrentAdd(t, newStorage(t)) })
+	t.Run("Status", func(t *testing.T) { testStatus(t, newStorage(t)) })
+	t.Run("AddOrGet", func(t *testing.T) { testAddOrGet(t, newStorage(t)) })
+	t.Run("Expiry", func(t *testing.T) { c.testExpiry(t, newStorage(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newStorage(t)) })
+	t.Run("Increment", func(t *testing.T) { c.testIncrement(t, newStorage(t)) })
+	t.Run("Expire", func(t *testing.T) { c.testExpire(t, newStorage(t)) })
+	t.Run("DeleteByOwner", func(t *testing.T) { testDeleteByOwner(t, newStorage(t)) })
+	t.Run("TTL", func(t *testing.T) { c.testTTL(t, newStorage(t)) })
+	t.Run("Ping", func(t *testing.T) { testPing(t, newStorage(t)) })
+	t.Run("Scan", func(t *testing.T) { testScan(t, newStorage(t)) })
+	t.Run("Purge", func(t *testing.T) { c.testPurge(t, newStorage(t)) })
+}
+
+func testAddGetComplete(t *testing.T, s idempotency.Storage) {
+	ctx := context.Background()
+
+	status, err := s.Get(ctx, "key")
+	if err != nil || status != nil {
+		t.Fatalf("Get of a missing key: want nil, got %+v, %v", status, err)
+	}
+
+	added, err := s.Add(ctx, "key")
+	if err != nil || !added {
+		t.Fatalf("Add of a new key: want true, got %v, %v", added, err)
+	}
+	added, err = s.Add(ctx, "key")
+	if err != nil || added {
+		t.Fatalf("Add of an existing key: want false, got %v, %v", added, err)
+	}
+
+	status, err = s.Get(ctx, "key")
+	if err != nil || status == nil || !status.InProcess {
+		t.Fatalf("Get of an added key: want in process, got %+v, %v", status, err)
+	}
+
+	if err := s.Complete(ctx, "key"); err != nil {
+		t.Fatalf("Complete: want no error, got %v", err)
+	}
+	status, err = s.Get(ctx, "key")
+	if err != nil || status == nil || status.InProcess {
+		t.Fatalf("Get of a completed key: want completed, got %+v, %v", status, err)
+	}
+
+	added, err = s.Add(ctx, "key")
+	if err != nil || added {
+		t.Fatalf("Add of a completed key: want false, got %v, %v", added, err)
+	}
+}
+
+func testConcurrentAdd(t *testing.T, s idempotency.Storage) {
+	ctx := context.Background()
+
+	const workers = 16
+	var (
+		wg    sync.WaitGroup
+		added atomic.Int32
+		errs  = make(chan error, workers)
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := s.Add(ctx, "key")
+			if err != nil {
+				errs <- err
+				return
+			}
+			if ok {
+				added.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("Add: want no error, got %v", err)
+	}
+	if n := added.Load(); n != 1 {
+		t.Errorf("concurrent Add of the same key: want exactly one added, got %d", n)
+	}
+}
+
+func testStatus(t *testing.T, s idempotency.Storage) {
+	ss, ok := s.(idempotency.StatusStorage)
+	if !ok {
+		t.Skip("storage does not implement idempotency.StatusStorage")
+	}
+	ctx := context.Background()
+
+	want := &idempotency.RequestStatus{InProcess: true, Fingerprint: "fingerprint", Owner: "owner", Token: 42}
+	added, err := ss.AddStatus(ctx, "key", want, time.Hour)
+	if err != nil || !added {
+		t.Fatalf("AddStatus of a new key: want true, got %v, %v", added, err)
+	}
+	added, err = ss.AddStatus(ctx, "key", want, time.Hour)
+	if err != nil || added {
+		t.Fatalf("AddStatus of an existing key: want false, got %v, %v", added, err)
+	}
+
+	got, err := ss.Get(ctx, "key")
+	if err != nil {
+		t.Fatalf("Get: want no error, got %v", err)
+	}
+	if err := equalStatus(want, got); err != nil {
+		t.Errorf("Get of an added status: %v", err)
+	}
+
+	want = &idempotency.RequestStatus{
+		Fingerprint: "fingerprint",
+		Owner:       "owner",
+		Token:       42,
+		Response: &idempotency.Response{
+			StatusCode: 201,
+			Header:     map[string][]string{"Content-Type": {"application/json"}},
+			Body:       []byte(`{"id":1}`),
+		},
+	}
+	if err := ss.UpdateStatus(ctx, "key", want); err != nil {
+		t.Fatalf("UpdateStatus: want no error, got %v", err)
+	}
+
+	got, err = ss.Get(ctx, "key")
+	if err != nil {
+		t.Fatalf("Get: want no error, got %v", err)
+	}
+	if err := equalStatus(want, got); err != nil {
+		t.Errorf("Get of an updated status: %v", err)
+	}
+}
+
+func testAddOrGet(t *testing.T, s idempotency.Storage) {
+	r, ok := s.(idempotency.Reserver)
+	if !ok {
+		t.Skip("storage does not implement idempotency.Reserver")
+	}
+	ctx := context.Background()
+
+	want := &idempotency.RequestStatus{InProcess: true, Fingerprint: "fingerprint", Token: 42}
+	existing, added, err := r.AddOrGet(ctx, "key", want, time.Hour)
+	if err != nil || !added || existing != nil {
+		t.Fatalf("AddOrGet of a new key: want added, got %+v, %v, %v", existing, added, err)
+	}
+
+	existing, added, err = r.AddOrGet(ctx, "key", &idempotency.RequestStatus{InProcess: true}, time.Hour)
+	if err != nil || added {
+		t.Fatalf("AddOrGet of an existing key: want not added, got %v, %v", added, err)
+	}
+	if err := equalStatus(want, existing); err != nil {
+		t.Errorf("AddOrGet of an existing key: %v", err)
+	}
+}
+
+func (c *config) testExpiry(t *testing.T, s idempotency.Storage) {
+	ss, ok := s.(idempotency.StatusStorage)
+	if !ok {
+		t.Skip("storage does not implement idempotency.StatusStorage")
+	}
+	ctx := context.Background()
+
+	added, err := ss.AddStatus(ctx, "key", &idempotency.RequestStatus{InProcess: true}, 50*time.Millisecond)
+	if err != nil || !added {
+		t.Fatalf("AddStatus: want true, got %v, %v", added, err)
+	}
+	if err := ss.UpdateStatus(ctx, "key", &idempotency.RequestStatus{}); err != nil {
+		t.Fatalf("UpdateStatus: want no error, got %v", err)
+	}
+
+	c.sleep(100 * time.Millisecond)
+
+	status, err := ss.Get(ctx, "key")
+	if err != nil || status != nil {
+		t.Fatalf("Get of an expired key: want nil, got %+v, %v", status, err)
+	}
+	added, err = ss.AddStatus(ctx, "key", &idempotency.RequestStatus{InProcess: true}, time.Hour)
+	if err != nil || !added {
+		t.Fatalf("AddStatus of an expired key: want true, got %v, %v", added, err)
+	}
+}
+
+func testDelete(t *testing.T, s idempotency.Storage) {
+	d, ok := s.(idempotency.Deleter)
+	if !ok {
+		t.Skip("storage does not implement idempotency.Deleter")
+	}
+	ctx := context.Background()
+
+	if err := d.Delete(ctx, "missing"); err != nil {
+		t.Fatalf("Delete of a missing key: want no error, got %v", err)
+	}
+
+	if _, err := s.Add(ctx, "key"); err != nil {
+		t.Fatalf("Add: want no error, got %v", err)
+	}
+	if err := d.Delete(ctx, "key"); err != nil {
+		t.Fatalf("Delete: want no error, got %v", err)
+	}
+
+	status, err := s.Get(ctx, "key")
+	if err != nil || status != nil {
+		t.Fatalf("Get of a deleted key: want nil, got %+v, %v", status, err)
+	}
+	added, err := s.Add(ctx, "key")
+	if err != nil || !added {
+		t.Fatalf("Add of a deleted key: want true, got %v, %v", added, err)
+	}
+}
+
+func (c *config) testIncrement(t *testing.T, s idempotency.Storage) {
+	counter, ok := s.(idempotency.Counter)
+	if !ok {
+		t.Skip("storage does not implement idempotency.Counter")
+	}
+	ctx := context.Background()
+
+	for want := int64(1); want <= 3; want++ {
+		got, err := counter.Increment(ctx, "counter", 50*time.Millisecond)
+		if err != nil || got != want {
+			t.Fatalf("Increment: want %d, got %d, %v", want, got, err)
+		}
+	}
+
+	c.sleep(100 * time.Millisecond)
+
+	got, err := counter.Increment(ctx, "counter", time.Hour)
+	if err != nil || got != 1 {
+		t.Fatalf("Increment after the window: want 1, got %d, %v", got, err)
+	}
+}
+
+func (c *config) testExpire(t *testing.T, s idempotency.Storage) {
+	e, ok := s.(idempotency.Expirer)
+	ss, isStatus := s.(idempotency.StatusStorage)
+	if !ok || !isStatus {
+		t.Skip("storage does not implement idempotency.Expirer and idempotency.StatusStorage")
+	}
+	ctx := context.Background()
+
+	if _, err := ss.AddStatus(ctx, "key", &idempotency.RequestStatus{InProcess: true}, 50*time.Millisecond); err != nil {
+		t.Fatalf("AddStatus: want no error, got %v", err)
+	}
+	if err := e.Expire(ctx, "key", time.Hour); err != nil {
+		t.Fatalf("Expire: want no error, got %v", err)
+	}
+
+	c.sleep(100 * time.Millisecond)
+
+	status, err := ss.Get(ctx, "key")
+	if err != nil || status == nil {
+		t.Fatalf("Get of an extended key: want status, got %+v, %v", status, err)
+	}
+}
+
+func testDeleteByOwner(t *testing.T, s idempotency.Storage) {
+	d, ok := s.(idempotency.OwnerDeleter)
+	ss, isStatus := s.(idempotency.StatusStorage)
+	if !ok || !isStatus {
+		t.Skip("storage does not implement idempotency.OwnerDeleter and idempotency.StatusStorage")
+	}
+	ctx := context.Background()
+
+	for _, key := range []struct{ key, owner string }{{"1", "a"}, {"2", "a"}, {"3", "b"}} {
+		if _, err := ss.AddStatus(ctx, key.key, &idempotency.RequestStatus{InProcess: true, Owner: key.owner}, time.Hour); err != nil {
+			t.Fatalf("AddStatus: want no error, got %v", err)
+		}
+	}
+
+	n, err := d.DeleteByOwner(ctx, "a")
+	if err != nil || n != 2 {
+		t.Fatalf("DeleteByOwner: want 2, got %d, %v", n, err)
+	}
+
+	for key, want := range map[string]bool{"1": false, "2": false, "3": true} {
+		status, err := ss.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get: want no error, got %v", err)
+		}
+		if got := status != nil; got != want {
+			t.Errorf("Get of key %s after DeleteByOwner: want stored %v, got %v", key, want, got)
+		}
+	}
+}
+
+func (c *config) testTTL(t *testing.T, s idempotency.Storage) {
+	r, ok := s.(idempotency.TTLReader)
+	ss, isStatus := s.(idempotency.StatusStorage)
+	if !ok || !isStatus {
+		t.Skip("storage does not implement idempotency.TTLReader and idempotency.StatusStorage")
+	}
+	ctx := context.Background()
+
+	if _, exists, err := r.TTL(ctx, "missing"); err != nil || exists {
+		t.Fatalf("TTL of a missing key: want false, got %v, %v", exists, err)
+	}
+
+	if _, err := ss.AddStatus(ctx, "key", &idempotency.RequestStatus{InProcess: true}, time.Hour); err != nil {
+		t.Fatalf("AddStatus: want no error, got %v", err)
+	}
+	if err := ss.UpdateStatus(ctx, "key", &idempotency.RequestStatus{}); err != nil {
+		t.Fatalf("UpdateStatus: want no error, got %v", err)
+	}
+
+	ttl, exists, err := r.TTL(ctx, "key")
+	if err != nil || !exists || ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("TTL: want at most an hour, got %v, %v, %v", ttl, exists, err)
+	}
+
+	if _, err := ss.AddStatus(ctx, "short", &idempotency.RequestStatus{InProcess: true}, 50*time.Millisecond); err != nil {
+		t.Fatalf("AddStatus: want no error, got %v", err)
+	}
+
+	c.sleep(100 * time.Millisecond)
+
+	if _, exists, err := r.TTL(ctx, "short"); err != nil || exists {
+		t.Fatalf("TTL of an expired key: want false, got %v, %v", exists, err)
+	}
+}
+
+func testPing(t *testing.T, s idempotency.Storage) {
+	p, ok := s.(idempotency.Pinger)
+	if !ok {
+		t.Skip("storage does not implement idempotency.Pinger")
+	}
+
+	if err := p.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: want no error, got %v", err)
+	}
+}
+
+func testScan(t *testing.T, s idempotency.Storage) {
+	sc, ok := s.(idempotency.Scanner)
+	ss, isStatus := s.(idempotency.StatusStorage)
+	if !ok || !isStatus {
+		t.Skip("storage does not implement idempotency.Scanner and idempotency.StatusStorage")
+	}
+	ctx := context.Background()
+
+	want := map[string]*idempotency.RequestStatus{
+		"1": {InProcess: true, Instance: "a", Token: 1},
+		"2": {Fingerprint: "abc"},
+	}
+	for key, status := range want {
+		if _, err := ss.AddStatus(ctx, key, status, time.Hour); err != nil {
+			t.Fatalf("AddStatus: want no error, got %v", err)
+		}
+	}
+	if counter, ok := s.(idempotency.Counter); ok {
+		if _, err := counter.Increment(ctx, "counter", time.Hour); err != nil {
+			t.Fatalf("Increment: want no error, got %v", err)
+		}
+	}
+
+	got := make(map[string]*idempotency.RequestStatus)
+	err := sc.Scan(ctx, func(key string, status *idempotency.RequestStatus) error {
+		got[key] = status
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Scan: want no error, got %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Scan: want keys %v, got %v", want, got)
+	}
+	for key, status := range want {
+		if err := equalStatus(status, got[key]); err != nil {
+			t.Errorf("Scan of key %s: %v", key, err)
+		}
+	}
+}
+
+// equalStatus returns an error describing how got differs from want.
+func equalStatus(want, got *idempotency.RequestStatus) error {
+	if got == nil {
+		return fmt.Errorf("want %+v, got nil", want)
+	}
+	if got.InProcess != want.InProcess || got.Fingerprint != want.Fingerprint || got.Owner != want.Owner || got.Token != want.Token ||
+		got.Instance != want.Instance || got.StartedAt != want.StartedAt || got.Heartbeat != want.Heartbeat {
+		return fmt.Errorf("want %+v, got %+v", want, got)
+	}
+	if (got.Response == nil) != (want.Response == nil) {
+		return fmt.Errorf("want response %+v, got %+v", want.Response, got.Response)
+	}
+	if want.Response == nil {
+		return nil
+	}
+	if got.Response.StatusCode != want.Response.StatusCode || string(got.Response.Body) != string(want.Response.Body) ||
+		fmt.Sprint(got.Response.Header) != fmt.Sprint(want.Response.Header) {
+		return fmt.Errorf("want response %+v, got %+v", want.Response, got.Response)
+	}
+	return nil
+}
+
+func (c *config) testPurge(t *testing.T, s idempotency.Storage) {
+	p, ok := s.(idempotency.Purger)
+	if !ok || !idempotency.StorageCapabilities(s).Purger {
+		t.Skip("storage does not implement idempotency.Purger")
+	}
+	ss := s.(idempotency.StatusStorage)
+	ctx := context.Background()
+
+	if _, err := ss.AddStatus(ctx, "expired", &idempotency.RequestStatus{}, 50*time.Millisecond); err != nil {
+		t.Fatalf("AddStatus: want no error, got %v", err)
+	}
+	if _, err := ss.AddStatus(ctx, "key", &idempotency.RequestStatus{}, time.Hour); err != nil {
+		t.Fatalf("AddStatus: want no error, got %v", err)
+	}
+
+	c.sleep(100 * time.Millisecond)
+
+	n, err := p.Purge(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("Purge: want 1 key purged, got %d, %v", n, err)
+	}
+	if status, err := ss.Get(ctx, "key"); err != nil || status == nil {
+		t.Errorf("Get of a key that did not expire: want status, got %+v, %v", status, err)
+	}
+}
@@ -0,0 +1,121 @@
+package storagetest
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	_ "modernc.org/sqlite"
+
+	"github.com/Preciselyco/idempotency"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	RunConformanceTests(t, func(t *testing.T) idempotency.Storage {
+		return idempotency.NewMemoryStorage()
+	})
+}
+
+func TestSQLStorage(t *testing.T) {
+	RunConformanceTests(t, func(t *testing.T) idempotency.Storage {
+		db, err := sql.Open("sqlite", ":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		// Every connection to :memory: opens a different database.
+		db.SetMaxOpenConns(1)
+
+		_, err = db.Exec(`CREATE TABLE idempotency_keys (
+			idempotency_key VARCHAR(255) PRIMARY KEY,
+			status TEXT NOT NULL,
+			owner VARCHAR(255) NOT NULL DEFAULT '',
+			expires_at BIGINT NOT NULL
+		)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return idempotency.NewSQLStorage(db, time.Hour)
+	})
+}
+
+func TestRedisStorage(t *testing.T) {
+	var mr *miniredis.Miniredis
+	RunConformanceTests(t, func(t *testing.T) idempotency.Storage {
+		mr = miniredis.RunT(t)
+		return idempotency.NewRedisStorage(redis.NewClient(&redis.Options{Addr: mr.Addr()}), time.Hour)
+	}, WithSleep(func(d time.Duration) {
+		mr.FastForward(d)
+	}))
+}
+
+func TestWriteBehindStorage(t *testing.T) {
+	t.Run("Memory", func(t *testing.T) {
+		RunConformanceTests(t, func(t *testing.T) idempotency.Storage {
+			s := idempotency.NewWriteBehindStorage(idempotency.NewMemoryStorage(), idempotency.WithFlushInterval(time.Millisecond))
+			t.Cleanup(func() { s.Close(context.Background()) })
+			return s
+		})
+	})
+
+	// The Redis storage flushes with a pipeline.
+	t.Run("Redis", func(t *testing.T) {
+		var mr *miniredis.Miniredis
+		var s interface{ Flush(context.Context) error }
+		RunConformanceTests(t, func(t *testing.T) idempotency.Storage {
+			mr = miniredis.RunT(t)
+			wb := idempotency.NewWriteBehindStorage(idempotency.NewRedisStorage(redis.NewClient(&redis.Options{Addr: mr.Addr()}), time.Hour),
+				idempotency.WithFlushInterval(time.Millisecond))
+			t.Cleanup(func() { wb.Close(context.Background()) })
+			s = wb
+			return wb
+		}, WithSleep(func(d time.Duration) {
+			// Buffered writes are flushed before the fake time passes.
+			s.Flush(context.Background())
+			mr.FastForward(d)
+		}))
+	})
+}
+
+func TestShardedStorage(t *testing.T) {
+	var servers []*miniredis.Miniredis
+	RunConformanceTests(t, func(t *testing.T) idempotency.Storage {
+		servers = nil
+		shards := map[string]idempotency.StatusStorage{}
+		for range 3 {
+			mr := miniredis.RunT(t)
+			servers = append(servers, mr)
+			shards[mr.Addr()] = idempotency.NewRedisStorage(redis.NewClient(&redis.Options{Addr: mr.Addr()}), time.Hour)
+		}
+		return idempotency.NewShardedStorage(shards)
+	}, WithSleep(func(d time.Duration) {
+		for _, mr := range servers {
+			mr.FastForward(d)
+		}
+	}))
+}
+
+func TestChainStorage(t *testing.T) {
+	RunConformanceTests(t, func(t *testing.T) idempotency.Storage {
+		block, err := aes.NewCipher(make([]byte, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return idempotency.ChainStorage(idempotency.NewMemoryStorage(),
+			idempotency.InstrumentStorage(func(op string, d time.Duration, err error) {}),
+			idempotency.BreakerStorage(5, time.Second),
+			idempotency.RetryStorage(3, time.Millisecond),
+			idempotency.EncryptStorage(aead),
+		)
+	})
+}
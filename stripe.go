@@ -0,0 +1,58 @@
+package idempotency
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// StripeRetention is the retention of keys in the Stripe compatible mode.
+const StripeRetention = 24 * time.Hour
+
+// StripeCompatible returns an Option matching the documented idempotency
+// behavior of the Stripe API, which many client SDKs are written against:
+// * keys are optional and retained for 24 hours,
+// * responses are captured and replayed with their original status,
+// * concurrent requests with an in process key get a 409 Conflict,
+// * reusing a key with different parameters gets a 400 Bad Request,
+// * errors are rendered as Stripe error objects.
+//
+// Options passed after it to New override the respective behavior.
+func StripeCompatible() Option {
+	return func(s *State) {
+		s.requirement = Optional
+		s.ttl = StripeRetention
+		s.capture = true
+		s.fingerprint = ContentKey().GenerateKey
+		s.errResponder = stripeErrorResponder
+	}
+}
+
+// stripeErrorResponder renders errors as Stripe error objects.
+func stripeErrorResponder(err error, status int, w http.ResponseWriter, r *http.Request) {
+	errType := "invalid_request_error"
+	switch {
+	case status == http.StatusUnprocessableEntity:
+		// Stripe uses a 400 Bad Request for keys reused with different
+		// parameters.
+		status = http.StatusBadRequest
+		errType = "idempotency_error"
+	case status == http.StatusConflict:
+		errType = "idempotency_error"
+	case status >= http.StatusInternalServerError:
+		errType = "api_error"
+	}
+
+	var body struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	body.Error.Type = errType
+	body.Error.Message = err.Error()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,68 @@
+package idempotency
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStripeCompatible(t *testing.T) {
+	s := New(NewMemoryStorage(), StripeCompatible())
+
+	calls := 0
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusPaymentRequired)
+		w.Write([]byte(`{"error":{"type":"card_error"}}`))
+	}))
+
+	tests := []struct {
+		name           string
+		key            string
+		body           string
+		wantHTTPStatus int
+		wantReplayed   bool
+		wantErrorType  string
+	}{
+		{name: "First request, pass through to handler", key: "deadbeef", body: "amount=1", wantHTTPStatus: http.StatusPaymentRequired, wantErrorType: "card_error"},
+		{name: "Repeated request replays the original status", key: "deadbeef", body: "amount=1", wantHTTPStatus: http.StatusPaymentRequired, wantReplayed: true, wantErrorType: "card_error"},
+		{name: "Different parameters is a bad request", key: "deadbeef", body: "amount=2", wantHTTPStatus: http.StatusBadRequest, wantErrorType: "idempotency_error"},
+		{name: "No key is passed through", body: "amount=1", wantHTTPStatus: http.StatusPaymentRequired, wantErrorType: "card_error"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://example.com/v1/charges", strings.NewReader(test.body))
+			if test.key != "" {
+				req.Header.Set(HeaderName, test.key)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != test.wantHTTPStatus {
+				t.Errorf("want status code %v, got %v", test.wantHTTPStatus, w.Code)
+			}
+			if got := w.Header().Get(ReplayedHeaderName) == "true"; got != test.wantReplayed {
+				t.Errorf("want replayed = %v, got %v", test.wantReplayed, got)
+			}
+
+			var body struct {
+				Error struct {
+					Type string `json:"type"`
+				} `json:"error"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &body)
+			if body.Error.Type != test.wantErrorType {
+				t.Errorf("want error type %v, got %v", test.wantErrorType, body.Error.Type)
+			}
+		})
+	}
+
+	if calls != 2 {
+		t.Errorf("want 2 calls, got %d", calls)
+	}
+}
@@ -0,0 +1,35 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+)
+
+// StorageTimeouts are deadlines of the storage calls of the middleware, which
+// apply in addition to the deadline of the request. A zero timeout leaves
+// the deadline of the request.
+type StorageTimeouts struct {
+	// Get applies to reading the status of keys.
+	Get time.Duration
+	// Add applies to reserving keys.
+	Add time.Duration
+	// Complete applies to storing the result of keys.
+	Complete time.Duration
+}
+
+// WithStorageTimeout configures deadlines of storage calls, so that a slow
+// storage fails a request with an error soon instead of holding it until the
+// request times out.
+func WithStorageTimeout(t StorageTimeouts) Option {
+	return func(s *State) {
+		s.timeouts = t
+	}
+}
+
+// withTimeout derives a context of ctx with timeout d, if it is set.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d)
+}
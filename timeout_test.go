@@ -0,0 +1,63 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// stallingStorage stalls its calls of op until their context is done.
+type stallingStorage struct {
+	*memoryStorage
+	op string
+}
+
+func (s *stallingStorage) stall(ctx context.Context, op string) error {
+	if s.op != op {
+		return nil
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (s *stallingStorage) AddOrGet(ctx context.Context, key string, status *RequestStatus, expiry time.Duration) (*RequestStatus, bool, error) {
+	if err := s.stall(ctx, "Add"); err != nil {
+		return nil, false, err
+	}
+	return s.memoryStorage.AddOrGet(ctx, key, status, expiry)
+}
+
+func (s *stallingStorage) UpdateStatus(ctx context.Context, key string, status *RequestStatus) error {
+	if err := s.stall(ctx, "Complete"); err != nil {
+		return err
+	}
+	return s.memoryStorage.UpdateStatus(ctx, key, status)
+}
+
+func TestWithStorageTimeout(t *testing.T) {
+	for _, op := range []string{"Add", "Complete"} {
+		t.Run(op, func(t *testing.T) {
+			var got error
+			s := New(&stallingStorage{memoryStorage: NewMemoryStorage(), op: op},
+				WithStorageTimeout(StorageTimeouts{Get: time.Millisecond, Add: time.Millisecond, Complete: time.Millisecond}),
+				WithHook(func(r *http.Request, e Event) {
+					if e.Err != nil {
+						got = e.Err
+					}
+				}))
+			handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+			req.Header.Set(HeaderName, "key")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusInternalServerError || !errors.Is(got, context.DeadlineExceeded) {
+				t.Errorf("want storage call timed out, got %d and %v", w.Code, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+)
+
+// TransitionKind is a change of the state of an idempotency key.
+type TransitionKind int
+
+const (
+	// TransitionReserved means a new key was reserved for processing.
+	TransitionReserved TransitionKind = iota
+	// TransitionCompleted means a reserved key was completed.
+	TransitionCompleted
+	// TransitionReplayed means the result of a completed key was returned
+	// again.
+	TransitionReplayed
+	// TransitionFailed means a reserved key was released so that its
+	// request can be retried, or failed permanently once its attempts were
+	// exhausted.
+	TransitionFailed
+)
+
+// String returns the name of the transition.
+func (k TransitionKind) String() string {
+	switch k {
+	case TransitionReserved:
+		return "reserved"
+	case TransitionCompleted:
+		return "completed"
+	case TransitionReplayed:
+		return "replayed"
+	case TransitionFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("TransitionKind(%d)", int(k))
+}
+
+// Transition describes a change of the state of an idempotency key.
+type Transition struct {
+	// Key is the key in the storage, the idempotency key of a request is
+	// available with FromContext.
+	Key  string
+	Kind TransitionKind
+	// Status is the status of the key after the transition, it is nil for
+	// released keys and when the storage does not store statuses.
+	Status *RequestStatus
+}
+
+// WithTransitionHook adds a function called with every Transition of a key,
+// by the middleware, Do or a Reservation, e.g. to keep an audit trail of
+// keys. Hooks are called synchronously and should not block.
+func WithTransitionHook(f func(ctx context.Context, t Transition)) Option {
+	return func(s *State) {
+		// Copy the hooks so that states created by With do not share them.
+		s.transitionHooks = append(s.transitionHooks[:len(s.transitionHooks):len(s.transitionHooks)], f)
+	}
+}
+
+// transition calls the transition hooks with key, kind and status.
+func (s *State) transition(ctx context.Context, key string, kind TransitionKind, status *RequestStatus) {
+	for _, hook := range s.transitionHooks {
+		hook(ctx, Transition{Key: key, Kind: kind, Status: status})
+	}
+}
@@ -0,0 +1,41 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestTransitionHook(t *testing.T) {
+	var kinds []TransitionKind
+	s := New(NewMemoryStorage(), WithTransitionHook(func(ctx context.Context, tr Transition) {
+		if key, _ := FromContext(ctx); key != "deadbeef" && key != "job" {
+			t.Errorf("want idempotency key in context, got %q", key)
+		}
+		kinds = append(kinds, tr.Kind)
+	}))
+
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	for range 2 {
+		req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+		req.Header.Set(HeaderName, "deadbeef")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	_, _, err := Do(context.Background(), s, "job", func(ctx context.Context) (int, error) {
+		return 0, errors.New("failed")
+	})
+	if err == nil {
+		t.Fatal("want error of failed job")
+	}
+
+	want := []TransitionKind{TransitionReserved, TransitionCompleted, TransitionReplayed, TransitionReserved, TransitionFailed}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("want transitions %v, got %v", want, kinds)
+	}
+}
@@ -0,0 +1,62 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// WithRetryAfter sets a Retry-After header on 409 Conflict responses for
+// keys in process, with the time until the reservation of the key expires
+// but at most max. It requires a storage implementing TTLReader.
+func WithRetryAfter(max time.Duration) Option {
+	return func(s *State) {
+		s.retryAfter = max
+	}
+}
+
+// TTL returns the remaining time until key expires, zero if it does not
+// expire, and false if the key does not exist. It requires a storage
+// implementing TTLReader.
+func (s *State) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	r, ok := s.storage.(TTLReader)
+	if !ok || !s.caps.TTLReader {
+		return 0, false, errors.New("storage does not support reading expiries")
+	}
+
+	ttl, exists, err := r.TTL(ctx, key)
+	if err != nil {
+		return 0, false, fmt.Errorf("could not get the expiry of Idempotency-Key: %w", err)
+	}
+	return ttl, exists, nil
+}
+
+// retryDelay returns the time until the reservation of key in process
+// expires, at most the maximum of WithRetryAfter. It returns false if it is
+// not configured or the expiry could not be read.
+func (s *State) retryDelay(ctx context.Context, key string) (time.Duration, bool) {
+	if s.retryAfter <= 0 {
+		return 0, false
+	}
+	if !s.caps.TTLReader {
+		return 0, false
+	}
+
+	ttl, exists, err := s.TTL(ctx, key)
+	if err != nil || !exists {
+		return 0, false
+	}
+	if ttl == 0 || ttl > s.retryAfter {
+		ttl = s.retryAfter
+	}
+	return ttl, true
+}
+
+// setRetryAfter sets the Retry-After header to delay, rounded up to seconds.
+func setRetryAfter(w http.ResponseWriter, delay time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+}
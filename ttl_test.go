@@ -0,0 +1,53 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           []Option
+		wantRetryAfter string
+	}{
+		{name: "Not configured", wantRetryAfter: ""},
+		{name: "Remaining TTL", opts: []Option{WithRetryAfter(5 * time.Minute)}, wantRetryAfter: "60"},
+		{name: "Capped TTL", opts: []Option{WithRetryAfter(10 * time.Second)}, wantRetryAfter: "10"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := NewFakeClock(time.Now())
+			opts := append([]Option{WithTTL(90 * time.Second), WithClock(clock)}, test.opts...)
+
+			var handler http.Handler
+			inner := httptest.NewRecorder()
+			handler = New(NewMemoryStorage(WithMemoryClock(clock)), opts...).Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("X-Inner") != "" {
+					return
+				}
+				clock.Advance(30 * time.Second)
+
+				// Repeat the request while it is in process.
+				req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+				req.Header.Set(HeaderName, "key")
+				req.Header.Set("X-Inner", "true")
+				handler.ServeHTTP(inner, req)
+			}))
+
+			req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+			req.Header.Set(HeaderName, "key")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if inner.Code != http.StatusConflict {
+				t.Fatalf("want status code %v, got %v", http.StatusConflict, inner.Code)
+			}
+			if got := inner.Header().Get("Retry-After"); got != test.wantRetryAfter {
+				t.Errorf("want Retry-After %q, got %q", test.wantRetryAfter, got)
+			}
+		})
+	}
+}
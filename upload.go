@@ -0,0 +1,87 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// uploadContextKey defines which key to use for the completion of an upload
+// in context.Context.
+const uploadContextKey contextKey = "idempotency-upload"
+
+// CompleteUpload tells VerifyUpload that the current chunk completed the
+// upload, so that its response is replayed to all further requests of the
+// upload. It has no effect on contexts not created by VerifyUpload.
+func CompleteUpload(ctx context.Context) {
+	if completed, ok := ctx.Value(uploadContextKey).(*atomic.Bool); ok {
+		completed.Store(true)
+	}
+}
+
+// VerifyUpload verifies the chunks of an upload, e.g. a resumable or
+// multipart upload, whose requests share the Idempotency-Key of the upload.
+// Every chunk is verified like by Verify under a sub-key of its ID returned
+// by chunk, e.g. its Content-Range or part number, so that a retried chunk is
+// replayed instead of appended again. Once the handler calls CompleteUpload
+// the response is stored for the upload, and replayed to all further
+// requests with its key. It requires a storage implementing StatusStorage.
+func (s *State) VerifyUpload(chunk func(r *http.Request) string, next http.Handler) http.Handler {
+	chunks := s.With(WithScope(func(r *http.Request) string {
+		return ItemKey(s.scopeOf(r), chunk(r))
+	}))
+
+	upload := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		completed := &atomic.Bool{}
+		r = r.WithContext(context.WithValue(r.Context(), uploadContextKey, completed))
+
+		cw := newCaptureWriter(w)
+		defer cw.release()
+		next.ServeHTTP(cw, r)
+		if !completed.Load() {
+			return
+		}
+
+		key, _ := FromContext(r.Context())
+		if err := s.completeUpload(r.Context(), s.storageKey(r, key), cw.response()); err != nil {
+			s.emit(r, Event{Key: key, Outcome: OutcomeError, Err: err})
+		}
+	})
+	verify := chunks.Verify(upload)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := s.parseKey(r.Header.Get(HeaderName))
+		if err != nil || key == "" {
+			verify.ServeHTTP(w, r)
+			return
+		}
+
+		// Replay the response of a completed upload to any of its chunks.
+		d, err := s.Check(r.Context(), s.storageKey(r, key), "")
+		if err == nil && d.Outcome == OutcomeCompleted && d.Status.Response != nil {
+			s.emit(r, Event{Key: key, Outcome: OutcomeCompleted, Metadata: d.Status.Metadata})
+			writeResponse(w, r, d.Status.Response)
+			return
+		}
+		verify.ServeHTTP(w, r)
+	})
+}
+
+// completeUpload stores resp as the response of the upload with key.
+func (s *State) completeUpload(ctx context.Context, key string, resp *Response) error {
+	ss, ok := s.storage.(StatusStorage)
+	if !ok {
+		return fmt.Errorf("idempotency: uploads require a storage implementing StatusStorage, got %T", s.storage)
+	}
+
+	status := &RequestStatus{Response: resp}
+	added, err := ss.AddStatus(ctx, key, status, s.ttl)
+	if err == nil && !added {
+		err = ss.UpdateStatus(ctx, key, status)
+	}
+	if err != nil {
+		return fmt.Errorf("could not complete upload: %w", err)
+	}
+	return nil
+}
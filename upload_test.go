@@ -0,0 +1,66 @@
+package idempotency
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVerifyUpload(t *testing.T) {
+	s := New(NewMemoryStorage(), WithResponseCapture(true))
+
+	var appended []string
+	handler := s.VerifyUpload(func(r *http.Request) string {
+		return r.Header.Get("Upload-Part")
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		appended = append(appended, string(body))
+		if r.Header.Get("Upload-Complete") != "" {
+			CompleteUpload(r.Context())
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, strings.Join(appended, ""))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	send := func(part, body string, complete bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "http://example.com/upload", strings.NewReader(body))
+		req.Header.Set(HeaderName, `"upload"`)
+		req.Header.Set("Upload-Part", part)
+		if complete {
+			req.Header.Set("Upload-Complete", "true")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name         string
+		part         string
+		complete     bool
+		wantCode     int
+		wantReplayed bool
+	}{
+		{name: "First chunk", part: "1", wantCode: http.StatusAccepted},
+		{name: "Retried chunk", part: "1", wantCode: http.StatusAccepted, wantReplayed: true},
+		{name: "Last chunk", part: "2", complete: true, wantCode: http.StatusCreated},
+		{name: "Retried chunk of completed upload", part: "1", wantCode: http.StatusCreated, wantReplayed: true},
+	}
+
+	for _, test := range tests {
+		w := send(test.part, "part"+test.part, test.complete)
+		if w.Code != test.wantCode {
+			t.Errorf("%s: want status code %v, got %v", test.name, test.wantCode, w.Code)
+		}
+		if got := w.Header().Get(ReplayedHeaderName) == "true"; got != test.wantReplayed {
+			t.Errorf("%s: want replayed %v, got %v", test.name, test.wantReplayed, got)
+		}
+	}
+	if got := strings.Join(appended, ","); got != "part1,part2" {
+		t.Errorf("want every chunk appended once, got %q", got)
+	}
+}
@@ -0,0 +1,306 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BatchUpdater is implemented by storages that can update the statuses of
+// several keys in one round trip, which is used by the write-behind storage
+// to flush its writes.
+type BatchUpdater interface {
+	// UpdateStatuses replaces the statuses of existing keys, keeping their
+	// expiry, like UpdateStatus.
+	UpdateStatuses(ctx context.Context, statuses map[string]*RequestStatus) error
+}
+
+type writeBehindStorage struct {
+	storage  StatusStorage
+	interval time.Duration
+	size     int
+	onError  func(err error)
+
+	mu      sync.Mutex
+	pending map[string]*RequestStatus
+	// flushMu serializes flushes and deletes, so that a flush does not write
+	// back or buffer again a key deleted while it was in progress.
+	flushMu sync.Mutex
+
+	flush  chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+	closed sync.Once
+}
+
+// WriteBehindOption is the signature for functional options for the
+// write-behind storage.
+type WriteBehindOption func(*writeBehindStorage)
+
+// WithFlushInterval configures how often buffered writes are flushed, it
+// defaults to 100ms.
+func WithFlushInterval(d time.Duration) WriteBehindOption {
+	return func(s *writeBehindStorage) {
+		s.interval = d
+	}
+}
+
+// WithFlushSize configures how many keys are buffered before they are
+// flushed without waiting for the interval, it defaults to 100.
+func WithFlushSize(n int) WriteBehindOption {
+	return func(s *writeBehindStorage) {
+		s.size = n
+	}
+}
+
+// WithFlushError configures a function receiving the errors of background
+// flushes. Writes failing to flush are retried with the next flush.
+func WithFlushError(f func(err error)) WriteBehindOption {
+	return func(s *writeBehindStorage) {
+		s.onError = f
+	}
+}
+
+// NewWriteBehindStorage creates a storage decorator buffering completions,
+// i.e. Complete and UpdateStatus, and flushing them in batches periodically
+// or by size. It saves storage round trips in gateways with many requests,
+// at the cost of losing completions buffered when the process dies, and of
+// other instances seeing keys in process until they are flushed. Reads of
+// this instance see its buffered writes, even of keys which expired before
+// the flush. Keys are still reserved directly in the storage. Close must be
+// called on shutdown to flush the buffer.
+//
+// Batches are written with one call when the storage implements
+// BatchUpdater. The decorator implements the optional interfaces Deleter,
+// Expirer, Counter, TTLReader and Pinger, and returns errors.ErrUnsupported
+// for the ones the storage does not implement. It should not be combined with
+// WithLocker, as locks are released before completions are flushed.
+func NewWriteBehindStorage(storage StatusStorage, opts ...WriteBehindOption) *writeBehindStorage {
+	s := &writeBehindStorage{
+		storage:  storage,
+		interval: 100 * time.Millisecond,
+		size:     100,
+		pending:  make(map[string]*RequestStatus),
+		flush:    make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(s)
+		}
+	}
+
+	go s.run()
+
+	return s
+}
+
+func (s *writeBehindStorage) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		case <-s.flush:
+		}
+
+		if err := s.Flush(context.Background()); err != nil && s.onError != nil {
+			s.onError(err)
+		}
+	}
+}
+
+// Flush writes the buffered completions to the storage. Writes which fail
+// stay buffered unless the key was written again in the meantime.
+func (s *writeBehindStorage) Flush(ctx context.Context) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	batch := s.pending
+	s.pending = make(map[string]*RequestStatus)
+	s.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	var err error
+	if b, ok := s.storage.(BatchUpdater); ok {
+		err = b.UpdateStatuses(ctx, batch)
+		if err == nil {
+			return nil
+		}
+	} else {
+		var errs []error
+		for key, status := range batch {
+			if err := s.storage.UpdateStatus(ctx, key, status); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			delete(batch, key)
+		}
+		err = errors.Join(errs...)
+		if err == nil {
+			return nil
+		}
+	}
+
+	// Buffer the failed writes again, unless they were superseded.
+	s.mu.Lock()
+	for key, status := range batch {
+		if _, ok := s.pending[key]; !ok {
+			s.pending[key] = status
+		}
+	}
+	s.mu.Unlock()
+
+	return fmt.Errorf("failed to flush completions: %w", err)
+}
+
+// Close stops the background flushes and flushes the buffer, it must be
+// called on shutdown so that buffered completions are not lost.
+func (s *writeBehindStorage) Close(ctx context.Context) error {
+	s.closed.Do(func() { close(s.stop) })
+
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return s.Flush(ctx)
+}
+
+// buffer adds a write of status for key to the buffer.
+func (s *writeBehindStorage) buffer(key string, status *RequestStatus) {
+	s.mu.Lock()
+	s.pending[key] = status
+	full := len(s.pending) >= s.size
+	s.mu.Unlock()
+
+	if full {
+		select {
+		case s.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Add inserts the initial state of a request with an idempotency key.
+func (s *writeBehindStorage) Add(ctx context.Context, key string) (bool, error) {
+	return s.AddStatus(ctx, key, &RequestStatus{InProcess: true}, 0)
+}
+
+// AddStatus inserts status for an idempotency key in the storage, it is not
+// buffered.
+func (s *writeBehindStorage) AddStatus(ctx context.Context, key string, status *RequestStatus, expiry time.Duration) (bool, error) {
+	s.mu.Lock()
+	_, ok := s.pending[key]
+	s.mu.Unlock()
+	if ok {
+		return false, nil
+	}
+	return s.storage.AddStatus(ctx, key, status, expiry)
+}
+
+// Get fetches the RequestStatus for an idempotency key, preferring the
+// buffered one.
+func (s *writeBehindStorage) Get(ctx context.Context, key string) (*RequestStatus, error) {
+	s.mu.Lock()
+	status, ok := s.pending[key]
+	s.mu.Unlock()
+	if ok {
+		st := *status
+		return &st, nil
+	}
+	return s.storage.Get(ctx, key)
+}
+
+// Complete buffers setting a request to not be in progress.
+func (s *writeBehindStorage) Complete(ctx context.Context, key string) error {
+	return s.UpdateStatus(ctx, key, &RequestStatus{InProcess: false})
+}
+
+// UpdateStatus buffers replacing the RequestStatus of an idempotency key.
+func (s *writeBehindStorage) UpdateStatus(ctx context.Context, key string, status *RequestStatus) error {
+	st := *status
+	s.buffer(key, &st)
+	return nil
+}
+
+// Delete removes an idempotency key and its buffered write. It waits for a
+// flush in progress, which may write the key.
+func (s *writeBehindStorage) Delete(ctx context.Context, key string) error {
+	d, ok := s.storage.(Deleter)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	delete(s.pending, key)
+	s.mu.Unlock()
+
+	return d.Delete(ctx, key)
+}
+
+// Expire sets an idempotency key to expire after expiry.
+func (s *writeBehindStorage) Expire(ctx context.Context, key string, expiry time.Duration) error {
+	e, ok := s.storage.(Expirer)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return e.Expire(ctx, key, expiry)
+}
+
+// Increment increments the counter of key within window.
+func (s *writeBehindStorage) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	c, ok := s.storage.(Counter)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	return c.Increment(ctx, key, window)
+}
+
+// TTL returns the remaining time until an idempotency key expires.
+func (s *writeBehindStorage) TTL(ctx context.Context, key string) (time.Duration, bool, error) {
+	t, ok := s.storage.(TTLReader)
+	if !ok {
+		return 0, false, errors.ErrUnsupported
+	}
+	return t.TTL(ctx, key)
+}
+
+// Ping checks the health of the storage.
+func (s *writeBehindStorage) Ping(ctx context.Context) error {
+	p, ok := s.storage.(Pinger)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return p.Ping(ctx)
+}
+
+// Capabilities returns the capabilities of the wrapped storage that the
+// write-behind storage passes through.
+func (s *writeBehindStorage) Capabilities() Capabilities {
+	c := StorageCapabilities(s.storage)
+	return Capabilities{
+		StatusStorage: true,
+		Deleter:       c.Deleter,
+		Expirer:       c.Expirer,
+		Counter:       c.Counter,
+		TTLReader:     c.TTLReader,
+		Pinger:        c.Pinger,
+	}
+}
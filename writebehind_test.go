@@ -0,0 +1,117 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWriteBehindStorage(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	wb := NewWriteBehindStorage(storage, WithFlushInterval(time.Hour), WithFlushSize(3))
+	s := New(wb, WithResponseCapture(true))
+
+	calls := 0
+	handler := s.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("done"))
+	}))
+	serve := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "http://example.com/foo", nil)
+		req.Header.Set(HeaderName, key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// The completion is buffered, but replayed by this instance.
+	serve("key")
+	if w := serve("key"); w.Header().Get(ReplayedHeaderName) != "true" || calls != 1 {
+		t.Errorf("want buffered completion replayed, got %d calls", calls)
+	}
+	if status, _ := storage.Get(ctx, "key"); status == nil || !status.InProcess {
+		t.Errorf("want key in process in the storage, got %+v", status)
+	}
+
+	// Buffering enough keys flushes them in the background.
+	for i := 0; i < 3; i++ {
+		serve(strconv.Itoa(i))
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if status, _ := storage.Get(ctx, "key"); status != nil && !status.InProcess {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("want completions flushed by size")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	serve("last")
+	if err := wb.Close(ctx); err != nil {
+		t.Fatalf("want closed, got %v", err)
+	}
+	if status, _ := storage.Get(ctx, "last"); status == nil || status.InProcess || status.Response == nil {
+		t.Errorf("want completion flushed on close, got %+v", status)
+	}
+}
+
+// stalledStorage fails updates once released, and reports deletes.
+type stalledStorage struct {
+	*memoryStorage
+	started  chan struct{}
+	release  chan struct{}
+	deleting chan struct{}
+}
+
+func (s stalledStorage) Delete(ctx context.Context, key string) error {
+	s.deleting <- struct{}{}
+	return s.memoryStorage.Delete(ctx, key)
+}
+
+func (s stalledStorage) UpdateStatus(ctx context.Context, key string, status *RequestStatus) error {
+	close(s.started)
+	<-s.release
+	return errors.New("unavailable")
+}
+
+func TestWriteBehindStorageDeleteDuringFlush(t *testing.T) {
+	ctx := context.Background()
+	storage := stalledStorage{NewMemoryStorage(), make(chan struct{}), make(chan struct{}), make(chan struct{}, 1)}
+	wb := NewWriteBehindStorage(storage, WithFlushInterval(time.Hour))
+	defer close(wb.stop)
+
+	storage.AddStatus(ctx, "key", &RequestStatus{InProcess: true}, time.Hour)
+	wb.UpdateStatus(ctx, "key", &RequestStatus{})
+
+	flushed := make(chan error)
+	go func() { flushed <- wb.Flush(ctx) }()
+	<-storage.started
+
+	// The failed flush must not buffer the deleted key again.
+	deleted := make(chan error)
+	go func() { deleted <- wb.Delete(ctx, "key") }()
+	// Give the delete the chance to run during the flush, it must wait.
+	select {
+	case <-storage.deleting:
+		t.Error("want delete waiting for the flush")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(storage.release)
+	if err := <-flushed; err == nil {
+		t.Error("want flush failed")
+	}
+	if err := <-deleted; err != nil {
+		t.Fatalf("want deleted, got %v", err)
+	}
+
+	if status, _ := wb.Get(ctx, "key"); status != nil {
+		t.Errorf("want key deleted, got %+v", status)
+	}
+}